@@ -0,0 +1,52 @@
+package resource
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseAge parses a duration, which may also be given in days or weeks, e.g.
+// 90d or 2w, as well as anything time.ParseDuration accepts, e.g. 36h.
+func ParseAge(age string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if strings.HasSuffix(age, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(age, suffix))
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age %q", age)
+			}
+
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	duration, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+
+	return duration, nil
+}
+
+// CheckImageAge checks that an image created at the given time is no older
+// than the source's max_age at now, if it has one.
+func (source *Source) CheckImageAge(created time.Time, now time.Time) error {
+	if source.MaxAge == "" {
+		return nil
+	}
+
+	maxAge, err := ParseAge(source.MaxAge)
+	if err != nil {
+		return fmt.Errorf("invalid max_age: %s", err)
+	}
+
+	if now.Sub(created) > maxAge {
+		return fmt.Errorf("image was created at %s, more than max_age of %s ago", created.UTC().Format(time.RFC3339), source.MaxAge)
+	}
+
+	return nil
+}
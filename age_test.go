@@ -0,0 +1,39 @@
+package resource_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Image age", func() {
+	It("should parse ages in days, weeks, and Go durations", func() {
+		Expect(resource.ParseAge("90d")).To(Equal(90 * 24 * time.Hour))
+		Expect(resource.ParseAge("2w")).To(Equal(14 * 24 * time.Hour))
+		Expect(resource.ParseAge("36h")).To(Equal(36 * time.Hour))
+
+		_, err := resource.ParseAge("ninety days")
+		Expect(err).To(MatchError(`invalid age "ninety days"`))
+	})
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	It("should allow images of any age without max_age", func() {
+		Expect((&resource.Source{}).CheckImageAge(time.Time{}, now)).To(Succeed())
+	})
+
+	It("should reject images older than max_age", func() {
+		source := resource.Source{MaxAge: "90d"}
+
+		Expect(source.CheckImageAge(now.Add(-89*24*time.Hour), now)).To(Succeed())
+		Expect(source.CheckImageAge(now.Add(-91*24*time.Hour), now)).To(MatchError("image was created at 2020-03-02T00:00:00Z, more than max_age of 90d ago"))
+	})
+
+	It("should reject an invalid max_age", func() {
+		source := resource.Source{MaxAge: "old"}
+		Expect(source.CheckImageAge(now, now)).To(MatchError(ContainSubstring("invalid max_age")))
+	})
+})
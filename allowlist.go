@@ -0,0 +1,70 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// AllowRegistry checks that the repository is in one of the source's
+// allowed_registries, if any are configured. Registries are matched by host,
+// e.g. registry.example.com:5000, or by domain with a wildcard, e.g.
+// *.dkr.ecr.eu-west-1.amazonaws.com.
+func (source *Source) AllowRegistry(repository string) error {
+	if len(source.AllowedRegistries) == 0 {
+		return nil
+	}
+
+	repo, err := name.NewRepository(repository, name.WeakValidation)
+	if err != nil {
+		return fmt.Errorf("could not resolve repository %s: %s", repository, err)
+	}
+
+	return source.allowHost(repo.RegistryStr(), repository)
+}
+
+// CheckAllowedRegistries checks that the source's repository and registry
+// mirrors are all in its allowed_registries.
+func (source *Source) CheckAllowedRegistries() error {
+	err := source.AllowRegistry(source.Repository)
+	if err != nil {
+		return err
+	}
+
+	if len(source.AllowedRegistries) == 0 {
+		return nil
+	}
+
+	for _, mirror := range source.RegistryMirrors {
+		err := source.allowHost(mirrorHost(mirror), "registry mirror "+mirror)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (source *Source) allowHost(host string, what string) error {
+	for _, allowed := range source.AllowedRegistries {
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return nil
+			}
+
+			continue
+		}
+
+		registry, err := name.NewRegistry(allowed, name.WeakValidation)
+		if err != nil {
+			return fmt.Errorf("invalid allowed registry %q: %s", allowed, err)
+		}
+
+		if registry.RegistryStr() == host {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is in registry %s, which is not one of the allowed_registries (%s)", what, host, strings.Join(source.AllowedRegistries, ", "))
+}
@@ -0,0 +1,76 @@
+package resource_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("AllowedRegistries", func() {
+	It("should allow any registry if none are configured", func() {
+		source := resource.Source{Repository: "alpine"}
+		Expect(source.CheckAllowedRegistries()).To(Succeed())
+	})
+
+	It("should allow repositories in the allowed registries", func() {
+		source := resource.Source{
+			Repository:        "registry.example.com:5000/some/repo",
+			AllowedRegistries: []string{"ghcr.io", "registry.example.com:5000"},
+		}
+
+		Expect(source.CheckAllowedRegistries()).To(Succeed())
+	})
+
+	It("should reject repositories in other registries", func() {
+		source := resource.Source{
+			Repository:        "registry.example.com/some/repo",
+			AllowedRegistries: []string{"ghcr.io", "registry.example.com:5000"},
+		}
+
+		Expect(source.CheckAllowedRegistries()).To(MatchError("registry.example.com/some/repo is in registry registry.example.com, which is not one of the allowed_registries (ghcr.io, registry.example.com:5000)"))
+	})
+
+	It("should treat docker.io as Docker Hub", func() {
+		source := resource.Source{Repository: "alpine", AllowedRegistries: []string{"docker.io"}}
+		Expect(source.CheckAllowedRegistries()).To(Succeed())
+
+		source = resource.Source{Repository: "alpine", AllowedRegistries: []string{"registry.example.com"}}
+		Expect(source.CheckAllowedRegistries()).To(MatchError(ContainSubstring("is in registry index.docker.io")))
+	})
+
+	It("should match wildcard domains", func() {
+		source := resource.Source{
+			Repository:        "123456789012.dkr.ecr.eu-west-1.amazonaws.com/some/repo",
+			AllowedRegistries: []string{"*.amazonaws.com"},
+		}
+
+		Expect(source.CheckAllowedRegistries()).To(Succeed())
+
+		source.Repository = "amazonaws.com.example.com/some/repo"
+		Expect(source.CheckAllowedRegistries()).ToNot(Succeed())
+	})
+
+	It("should reject registry mirrors in other registries", func() {
+		source := resource.Source{
+			Repository:        "registry.example.com/some/repo",
+			RegistryMirrors:   []string{"https://mirror.example.com/"},
+			AllowedRegistries: []string{"registry.example.com"},
+		}
+
+		Expect(source.CheckAllowedRegistries()).To(MatchError(ContainSubstring("registry mirror https://mirror.example.com/ is in registry mirror.example.com")))
+
+		source.AllowedRegistries = append(source.AllowedRegistries, "mirror.example.com")
+		Expect(source.CheckAllowedRegistries()).To(Succeed())
+	})
+
+	It("should check other repositories against the source's allowed registries", func() {
+		source := resource.Source{
+			Repository:        "registry.example.com/some/repo",
+			AllowedRegistries: []string{"registry.example.com"},
+		}
+
+		Expect(source.AllowRegistry("registry.example.com/other/repo")).To(Succeed())
+		Expect(source.AllowRegistry("busybox")).To(MatchError(ContainSubstring("busybox is in registry index.docker.io")))
+	})
+})
@@ -0,0 +1,265 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ValueOrFile is a value given either literally or as the path to a file
+// containing it, i.e. as "value" or as {"file": "path"}.
+type ValueOrFile struct {
+	Value string
+	File  string
+}
+
+// UnmarshalJSON accepts a string or an object with a file field.
+func (value *ValueOrFile) UnmarshalJSON(b []byte) error {
+	var literal string
+	err := json.Unmarshal(b, &literal)
+	if err == nil {
+		*value = ValueOrFile{Value: literal}
+		return nil
+	}
+
+	var file struct {
+		File string `json:"file"`
+	}
+
+	err = json.Unmarshal(b, &file)
+	if err != nil || file.File == "" {
+		return fmt.Errorf("value must be a string or an object with a file field")
+	}
+
+	*value = ValueOrFile{File: file.File}
+
+	return nil
+}
+
+// MarshalJSON marshals the file, or else the literal value.
+func (value ValueOrFile) MarshalJSON() ([]byte, error) {
+	if value.File != "" {
+		return json.Marshal(map[string]string{"file": value.File})
+	}
+
+	return json.Marshal(value.Value)
+}
+
+// ResolveValues returns the values, reading those given as files relative to
+// src. Surrounding whitespace, e.g. a trailing newline, is trimmed from the
+// files' content.
+func ResolveValues(src string, values map[string]ValueOrFile) (map[string]string, error) {
+	resolved := map[string]string{}
+	for key, value := range values {
+		if value.File == "" {
+			resolved[key] = value.Value
+			continue
+		}
+
+		path := filepath.Join(src, value.File)
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read value of %s from %q: %s", key, path, err)
+		}
+
+		resolved[key] = strings.TrimSpace(string(content))
+	}
+
+	return resolved, nil
+}
+
+// WithLabels returns the image with the labels added to its config,
+// overriding any existing labels with the same keys. The config is otherwise
+// kept as is, as are the layers and their media types.
+func WithLabels(image v1.Image, labels map[string]string) (v1.Image, error) {
+	if len(labels) == 0 {
+		return image, nil
+	}
+
+	rawConfig, err := image.RawConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %s", err)
+	}
+
+	var config map[string]json.RawMessage
+	err = json.Unmarshal(rawConfig, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %s", err)
+	}
+
+	var containerConfig map[string]json.RawMessage
+	if raw, found := config["config"]; found && string(raw) != "null" {
+		err = json.Unmarshal(raw, &containerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config: %s", err)
+		}
+	}
+
+	if containerConfig == nil {
+		containerConfig = map[string]json.RawMessage{}
+	}
+
+	existing := map[string]string{}
+	if raw, found := containerConfig["Labels"]; found && string(raw) != "null" {
+		err = json.Unmarshal(raw, &existing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config labels: %s", err)
+		}
+	}
+
+	for key, value := range labels {
+		existing[key] = value
+	}
+
+	containerConfig["Labels"], err = json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	config["config"], err = json.Marshal(containerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	rawConfig, err = json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	configDigest, _, err := v1.SHA256(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := rawManifestFields(image)
+	if err != nil {
+		return nil, err
+	}
+
+	var configDesc map[string]json.RawMessage
+	err = json.Unmarshal(manifest["config"], &configDesc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest config: %s", err)
+	}
+
+	configDesc["digest"], err = json.Marshal(configDigest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	configDesc["size"], err = json.Marshal(len(rawConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest["config"], err = json.Marshal(configDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	rawManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(&rewrittenImage{
+		base:        image,
+		rawConfig:   rawConfig,
+		rawManifest: rawManifest,
+	})
+}
+
+// WithAnnotations returns the image, or image index, with the annotations
+// added to its manifest, overriding any existing annotations with the same
+// keys.
+func WithAnnotations(image v1.Image, annotations map[string]string) (v1.Image, error) {
+	if len(annotations) == 0 {
+		return image, nil
+	}
+
+	manifest, err := rawManifestFields(image)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]string{}
+	if raw, found := manifest["annotations"]; found && string(raw) != "null" {
+		err = json.Unmarshal(raw, &existing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse manifest annotations: %s", err)
+		}
+	}
+
+	for key, value := range annotations {
+		existing[key] = value
+	}
+
+	manifest["annotations"], err = json.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	rawManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(&rewrittenImage{
+		base:        image,
+		rawManifest: rawManifest,
+	})
+}
+
+// rawManifestFields returns the fields of the image's manifest, leaving their
+// values as is.
+func rawManifestFields(image v1.Image) (map[string]json.RawMessage, error) {
+	raw, err := image.RawManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %s", err)
+	}
+
+	var manifest map[string]json.RawMessage
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %s", err)
+	}
+
+	return manifest, nil
+}
+
+// rewrittenImage implements partial.CompressedImageCore for an image whose
+// manifest, and possibly config, have been rewritten, but whose layers have
+// not.
+type rewrittenImage struct {
+	base        v1.Image
+	rawConfig   []byte
+	rawManifest []byte
+}
+
+func (image *rewrittenImage) RawConfigFile() ([]byte, error) {
+	if image.rawConfig != nil {
+		return image.rawConfig, nil
+	}
+
+	return image.base.RawConfigFile()
+}
+
+func (image *rewrittenImage) MediaType() (types.MediaType, error) {
+	return image.base.MediaType()
+}
+
+func (image *rewrittenImage) RawManifest() ([]byte, error) {
+	return image.rawManifest, nil
+}
+
+func (image *rewrittenImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	return image.base.LayerByDigest(digest)
+}
@@ -0,0 +1,121 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ResolveValues", func() {
+	It("should read values from literals or files", func() {
+		dir, err := ioutil.TempDir("", "values")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer os.RemoveAll(dir)
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "ref"), []byte("abc123\n"), 0644)).To(Succeed())
+
+		var values map[string]resource.ValueOrFile
+		Expect(json.Unmarshal([]byte(`{"team":"some-team","revision":{"file":"ref"}}`), &values)).To(Succeed())
+
+		resolved, err := resource.ResolveValues(dir, values)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved).To(Equal(map[string]string{
+			"team":     "some-team",
+			"revision": "abc123",
+		}))
+	})
+
+	It("should reject other values", func() {
+		var values map[string]resource.ValueOrFile
+		err := json.Unmarshal([]byte(`{"team":{"name":"some-team"}}`), &values)
+		Expect(err).To(MatchError(ContainSubstring("must be a string or an object with a file field")))
+	})
+})
+
+var _ = Describe("WithLabels", func() {
+	It("should add the labels to the config without changing the layers", func() {
+		image, err := random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		labeled, err := resource.WithLabels(image, map[string]string{"team": "some-team"})
+		Expect(err).ToNot(HaveOccurred())
+
+		config, err := labeled.ConfigFile()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Config.Labels).To(Equal(map[string]string{"team": "some-team"}))
+
+		manifest, err := labeled.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		configName, err := labeled.ConfigName()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Config.Digest).To(Equal(configName))
+
+		original, err := image.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Layers).To(Equal(original.Layers))
+
+		layers, err := labeled.Layers()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layers).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("WithAnnotations", func() {
+	It("should add the annotations to the manifest", func() {
+		image, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		annotated, err := resource.WithAnnotations(image, map[string]string{"org.opencontainers.image.revision": "abc123"})
+		Expect(err).ToNot(HaveOccurred())
+
+		raw, err := annotated.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		var manifest struct {
+			Annotations map[string]string `json:"annotations"`
+		}
+
+		Expect(json.Unmarshal(raw, &manifest)).To(Succeed())
+		Expect(manifest.Annotations).To(Equal(map[string]string{"org.opencontainers.image.revision": "abc123"}))
+
+		originalDigest, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := annotated.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest).ToNot(Equal(originalDigest))
+	})
+
+	It("should add the annotations to an image index", func() {
+		image, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		index, err := resource.NewIndex(map[resource.Platform]v1.Image{
+			{OS: "linux", Architecture: "amd64"}: platformConfigImage{image, &v1.ConfigFile{OS: "linux", Architecture: "amd64"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		annotated, err := resource.WithAnnotations(index, map[string]string{"team": "some-team"})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.IsIndex(annotated)).To(BeTrue())
+
+		raw, err := annotated.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		var manifest v1.IndexManifest
+		Expect(json.Unmarshal(raw, &manifest)).To(Succeed())
+		Expect(manifest.Annotations).To(Equal(map[string]string{"team": "some-team"}))
+		Expect(manifest.Manifests).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,77 @@
+package resource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// DefaultArtifactFileMediaType is the media type of an artifact's files by
+// default, as with oras push.
+const DefaultArtifactFileMediaType = "application/vnd.oci.image.layer.v1.tar"
+
+// ArtifactFile is a file to push as part of a generic OCI artifact, e.g. a
+// Helm chart or a WASM module.
+type ArtifactFile struct {
+	Path      string `json:"path"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// ArtifactBlob is the content of one of an OCI artifact's blobs.
+type ArtifactBlob struct {
+	MediaType   string
+	Content     []byte
+	Annotations map[string]string
+}
+
+// NewFilesArtifact returns an OCI artifact of the type with each of the
+// files, relative to dir, as a layer titled with its file name, as oras push
+// does. The config file, if any, is the artifact's config, e.g. a Helm
+// chart's metadata; otherwise the config is empty.
+func NewFilesArtifact(dir string, artifactType string, config *ArtifactFile, files []ArtifactFile, annotations map[string]string) (v1.Image, error) {
+	if artifactType == "" && config == nil {
+		return nil, fmt.Errorf("artifacts require an artifact type or a config")
+	}
+
+	var configBlob *ArtifactBlob
+	if config != nil {
+		if config.MediaType == "" {
+			return nil, fmt.Errorf("artifact config %s requires a media_type", config.Path)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, config.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifact config: %s", err)
+		}
+
+		configBlob = &ArtifactBlob{
+			MediaType: config.MediaType,
+			Content:   content,
+		}
+	}
+
+	var layers []ArtifactBlob
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifact file: %s", err)
+		}
+
+		mediaType := file.MediaType
+		if mediaType == "" {
+			mediaType = DefaultArtifactFileMediaType
+		}
+
+		layers = append(layers, ArtifactBlob{
+			MediaType: mediaType,
+			Content:   content,
+			Annotations: map[string]string{
+				OCITitleAnnotation: filepath.Base(file.Path),
+			},
+		})
+	}
+
+	return newArtifact(artifactType, configBlob, layers, nil, annotations)
+}
@@ -0,0 +1,134 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("NewFilesArtifact", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "artifact")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "chart.tgz"), []byte("some-chart"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "Chart.json"), []byte(`{"name":"some-chart","version":"1.2.3"}`), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# some-chart"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	type descriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Annotations map[string]string `json:"annotations"`
+	}
+
+	manifestOf := func(raw []byte) (manifest struct {
+		ArtifactType string            `json:"artifactType"`
+		Config       descriptor        `json:"config"`
+		Layers       []descriptor      `json:"layers"`
+		Annotations  map[string]string `json:"annotations"`
+	}) {
+		Expect(json.Unmarshal(raw, &manifest)).To(Succeed())
+		return manifest
+	}
+
+	It("should push each file as a titled layer", func() {
+		artifact, err := resource.NewFilesArtifact(dir, "application/vnd.example.docs", nil, []resource.ArtifactFile{
+			{Path: "README.md", MediaType: "text/markdown"},
+			{Path: "chart.tgz"},
+		}, map[string]string{"team": "some-team"})
+		Expect(err).ToNot(HaveOccurred())
+
+		raw, err := artifact.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		manifest := manifestOf(raw)
+		Expect(manifest.ArtifactType).To(Equal("application/vnd.example.docs"))
+		Expect(manifest.Config.MediaType).To(Equal(resource.OCIEmptyMediaType))
+		Expect(manifest.Annotations).To(Equal(map[string]string{"team": "some-team"}))
+		Expect(manifest.Layers).To(Equal([]descriptor{
+			{MediaType: "text/markdown", Annotations: map[string]string{resource.OCITitleAnnotation: "README.md"}},
+			{MediaType: resource.DefaultArtifactFileMediaType, Annotations: map[string]string{resource.OCITitleAnnotation: "chart.tgz"}},
+		}))
+	})
+
+	It("should use the config file as the artifact's config", func() {
+		artifact, err := resource.NewFilesArtifact(dir, "", &resource.ArtifactFile{
+			Path:      "Chart.json",
+			MediaType: "application/vnd.cncf.helm.config.v1+json",
+		}, []resource.ArtifactFile{
+			{Path: "chart.tgz", MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip"},
+		}, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		raw, err := artifact.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifestOf(raw).Config.MediaType).To(Equal("application/vnd.cncf.helm.config.v1+json"))
+
+		config, err := artifact.RawConfigFile()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(config)).To(Equal(`{"name":"some-chart","version":"1.2.3"}`))
+	})
+
+	It("should require an artifact type or config", func() {
+		_, err := resource.NewFilesArtifact(dir, "", nil, []resource.ArtifactFile{{Path: "chart.tgz"}}, nil)
+		Expect(err).To(MatchError("artifacts require an artifact type or a config"))
+
+		_, err = resource.NewFilesArtifact(dir, "", &resource.ArtifactFile{Path: "Chart.json"}, []resource.ArtifactFile{{Path: "chart.tgz"}}, nil)
+		Expect(err).To(MatchError("artifact config Chart.json requires a media_type"))
+	})
+
+	It("should fail if a file is missing", func() {
+		_, err := resource.NewFilesArtifact(dir, "application/vnd.example", nil, []resource.ArtifactFile{{Path: "missing.wasm"}}, nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to read artifact file")))
+	})
+
+	It("should round-trip through a registry", func() {
+		registry := newFakeRegistry()
+		defer registry.Close()
+
+		artifact, err := resource.NewFilesArtifact(dir, "application/vnd.example.docs", nil, []resource.ArtifactFile{
+			{Path: "README.md", MediaType: "text/markdown"},
+			{Path: "chart.tgz"},
+		}, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		ref, err := name.NewTag(registry.Host()+"/some/artifact:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr := resource.NewTokenTransport(ref.Registry, authn.Anonymous, http.DefaultTransport, []string{
+			ref.Context().Scope(transport.PushScope),
+		})
+		Expect(resource.Write(ref, artifact, tr)).To(Succeed())
+
+		pulled, err := resource.RemoteImage(ref)
+		Expect(err).ToNot(HaveOccurred())
+
+		out := filepath.Join(dir, "out")
+		Expect(resource.SaveArtifact(out, pulled)).To(Succeed())
+
+		content, err := ioutil.ReadFile(filepath.Join(out, "README.md"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("# some-chart"))
+
+		content, err = ioutil.ReadFile(filepath.Join(out, "chart.tgz"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal("some-chart"))
+	})
+})
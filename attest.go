@@ -0,0 +1,267 @@
+package resource
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// InTotoPayloadType is the DSSE payload type of in-toto attestations.
+const InTotoPayloadType = "application/vnd.in-toto+json"
+
+// SLSA provenance predicate types.
+const (
+	SLSAProvenanceV02 = "https://slsa.dev/provenance/v0.2"
+	SLSAProvenanceV1  = "https://slsa.dev/provenance/v1"
+)
+
+// AttestationPolicy configures the verification of in-toto attestations, e.g.
+// SLSA provenance, attached to an image by cosign. The attestations must be
+// signed as configured by the embedded CosignConfig.
+type AttestationPolicy struct {
+	CosignConfig
+
+	// PredicateType is the type of attestation required. If empty, SLSA
+	// provenance of either version is required.
+	PredicateType string `json:"predicate_type,omitempty"`
+
+	// BuilderID is the ID of the builder that must have built the image,
+	// going by its SLSA provenance.
+	BuilderID string `json:"builder_id,omitempty"`
+
+	// SourceRepository is the repository that the image must have been built
+	// from, going by its SLSA provenance, e.g. github.com/org/repo.
+	SourceRepository string `json:"source_repository,omitempty"`
+}
+
+// CosignAttestationTag returns the tag cosign stores an image's attestations
+// under.
+func CosignAttestationTag(digest v1.Hash) string {
+	return digest.Algorithm + "-" + digest.Hex + ".att"
+}
+
+// VerifyAttestations verifies that the image with the digest has an
+// attestation in the source's repository that satisfies the source's policy.
+func (source *Source) VerifyAttestations(digest v1.Hash, opts ...remote.ImageOption) error {
+	attestations, err := source.cosignImage(CosignAttestationTag(digest), opts...)
+	if err != nil {
+		return err
+	}
+
+	return source.Attestations.Verify(attestations, digest)
+}
+
+// Verify verifies that the attestation image, as stored by cosign, has at
+// least one validly signed attestation of the digest that satisfies the
+// policy.
+func (policy *AttestationPolicy) Verify(attestations v1.Image, digest v1.Hash) error {
+	err := policy.validate()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := attestations.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get attestation manifest: %s", err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return fmt.Errorf("no attestations found for %s", digest)
+	}
+
+	var failures []string
+	for _, desc := range manifest.Layers {
+		err := policy.verifyLayer(attestations, desc, digest)
+		if err == nil {
+			return nil
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %s", desc.Digest, err))
+	}
+
+	return fmt.Errorf("no attestation of %s satisfies the policy (%s)", digest, strings.Join(failures, "; "))
+}
+
+// dsseEnvelope is a signed attestation.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// inTotoStatement is an attestation's payload. Only the predicate fields the
+// policy can constrain are decoded.
+type inTotoStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	Predicate struct {
+		// SLSA v0.2
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Invocation struct {
+			ConfigSource struct {
+				URI string `json:"uri"`
+			} `json:"configSource"`
+		} `json:"invocation"`
+
+		// SLSA v1
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+		BuildDefinition struct {
+			ResolvedDependencies []struct {
+				URI string `json:"uri"`
+			} `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+	} `json:"predicate"`
+}
+
+func (policy *AttestationPolicy) verifyLayer(attestations v1.Image, desc v1.Descriptor, digest v1.Hash) error {
+	content, err := readBlob(attestations, desc)
+	if err != nil {
+		return err
+	}
+
+	var envelope dsseEnvelope
+	err = json.Unmarshal(content, &envelope)
+	if err != nil {
+		return fmt.Errorf("invalid envelope: %s", err)
+	}
+
+	if envelope.PayloadType != InTotoPayloadType {
+		return fmt.Errorf("unknown payload type %q", envelope.PayloadType)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %s", err)
+	}
+
+	message := preAuthEncoding(envelope.PayloadType, payload)
+
+	err = errors.New("no signatures")
+	for _, signature := range envelope.Signatures {
+		var sig []byte
+		sig, err = base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+
+		var key crypto.PublicKey
+		key, err = policy.signingKey(desc.Annotations, message, sig)
+		if err != nil {
+			continue
+		}
+
+		err = verifySignature(key, message, sig)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var statement inTotoStatement
+	err = json.Unmarshal(payload, &statement)
+	if err != nil {
+		return fmt.Errorf("invalid statement: %s", err)
+	}
+
+	return policy.check(statement, digest)
+}
+
+// check verifies that the statement is about the digest and satisfies the
+// policy.
+func (policy *AttestationPolicy) check(statement inTotoStatement, digest v1.Hash) error {
+	var subject bool
+	for _, s := range statement.Subject {
+		if s.Digest[digest.Algorithm] == digest.Hex {
+			subject = true
+			break
+		}
+	}
+
+	if !subject {
+		return fmt.Errorf("attestation is not about %s", digest)
+	}
+
+	switch {
+	case policy.PredicateType != "":
+		if statement.PredicateType != policy.PredicateType {
+			return fmt.Errorf("predicate type is %s, not %s", statement.PredicateType, policy.PredicateType)
+		}
+
+	case statement.PredicateType != SLSAProvenanceV02 && statement.PredicateType != SLSAProvenanceV1:
+		return fmt.Errorf("predicate type %s is not SLSA provenance", statement.PredicateType)
+	}
+
+	builderID := statement.Predicate.Builder.ID
+	sources := []string{statement.Predicate.Invocation.ConfigSource.URI}
+	if statement.PredicateType == SLSAProvenanceV1 {
+		builderID = statement.Predicate.RunDetails.Builder.ID
+
+		sources = nil
+		for _, dep := range statement.Predicate.BuildDefinition.ResolvedDependencies {
+			sources = append(sources, dep.URI)
+		}
+	}
+
+	if policy.BuilderID != "" && builderID != policy.BuilderID {
+		return fmt.Errorf("built by %q, not %s", builderID, policy.BuilderID)
+	}
+
+	if policy.SourceRepository != "" {
+		var found bool
+		for _, uri := range sources {
+			if sourceRepository(uri) == sourceRepository(policy.SourceRepository) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("not built from %s (sources: %s)", policy.SourceRepository, strings.Join(sources, ", "))
+		}
+	}
+
+	return nil
+}
+
+// sourceRepository normalizes a source URI, e.g.
+// git+https://github.com/org/repo.git@refs/heads/main, to the repository it
+// refers to, e.g. github.com/org/repo.
+func sourceRepository(uri string) string {
+	uri = strings.TrimPrefix(uri, "git+")
+
+	if i := strings.Index(uri, "://"); i != -1 {
+		uri = uri[i+3:]
+	}
+
+	if i := strings.Index(uri, "@"); i != -1 {
+		uri = uri[:i]
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".git")
+}
+
+// preAuthEncoding returns the bytes a DSSE signature is made over.
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
@@ -0,0 +1,127 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("AttestationPolicy", func() {
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+
+	var key *ecdsa.PrivateKey
+	var policy resource.AttestationPolicy
+
+	BeforeEach(func() {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		policy = resource.AttestationPolicy{
+			CosignConfig: resource.CosignConfig{
+				PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			},
+			BuilderID:        "https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_container_slsa3.yml@refs/tags/v1.9.0",
+			SourceRepository: "github.com/some-org/some-repo",
+		}
+	})
+
+	attest := func(statement string) signatureImage {
+		payloadType := "application/vnd.in-toto+json"
+		pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(statement), statement)
+		hash := sha256.Sum256([]byte(pae))
+
+		sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		Expect(err).ToNot(HaveOccurred())
+
+		envelope, err := json.Marshal(map[string]interface{}{
+			"payloadType": payloadType,
+			"payload":     base64.StdEncoding.EncodeToString([]byte(statement)),
+			"signatures":  []map[string]string{{"sig": base64.StdEncoding.EncodeToString(sig)}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		return signatureImage{payload: envelope}
+	}
+
+	provenance := func(subject string, builder string, source string) string {
+		return fmt.Sprintf(`{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"subject": [{"name": "some/repo", "digest": {"sha256": %q}}],
+			"predicate": {
+				"builder": {"id": %q},
+				"invocation": {"configSource": {"uri": %q}}
+			}
+		}`, subject, builder, source)
+	}
+
+	verify := func(image signatureImage) error {
+		attestations, err := partial.CompressedToImage(image)
+		Expect(err).ToNot(HaveOccurred())
+		return policy.Verify(attestations, digest)
+	}
+
+	It("should accept provenance that satisfies the policy", func() {
+		Expect(verify(attest(provenance(digest.Hex, policy.BuilderID, "git+https://github.com/some-org/some-repo@refs/heads/main")))).To(Succeed())
+	})
+
+	It("should accept SLSA v1 provenance", func() {
+		Expect(verify(attest(fmt.Sprintf(`{
+			"_type": "https://in-toto.io/Statement/v1",
+			"predicateType": "https://slsa.dev/provenance/v1",
+			"subject": [{"digest": {"sha256": %q}}],
+			"predicate": {
+				"runDetails": {"builder": {"id": %q}},
+				"buildDefinition": {"resolvedDependencies": [{"uri": "git+https://github.com/some-org/some-repo.git@refs/tags/v1.0.0"}]}
+			}
+		}`, digest.Hex, policy.BuilderID)))).To(Succeed())
+	})
+
+	It("should reject provenance from another builder", func() {
+		err := verify(attest(provenance(digest.Hex, "https://some-other-builder", "git+https://github.com/some-org/some-repo@refs/heads/main")))
+		Expect(err).To(MatchError(ContainSubstring(`built by "https://some-other-builder"`)))
+	})
+
+	It("should reject provenance from another repository", func() {
+		err := verify(attest(provenance(digest.Hex, policy.BuilderID, "git+https://github.com/some-org/some-fork@refs/heads/main")))
+		Expect(err).To(MatchError(ContainSubstring("not built from github.com/some-org/some-repo")))
+	})
+
+	It("should reject provenance of another image", func() {
+		err := verify(attest(provenance(strings.Repeat("b", 64), policy.BuilderID, "git+https://github.com/some-org/some-repo@refs/heads/main")))
+		Expect(err).To(MatchError(ContainSubstring("attestation is not about")))
+	})
+
+	It("should reject attestations signed with another key", func() {
+		image := attest(provenance(digest.Hex, policy.BuilderID, "git+https://github.com/some-org/some-repo@refs/heads/main"))
+
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		policy.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+		Expect(verify(image)).To(MatchError(ContainSubstring("invalid signature")))
+	})
+})
@@ -0,0 +1,164 @@
+package resource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sirupsen/logrus"
+)
+
+// acrUsername is the username ACR expects alongside a refresh token.
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrTokenRefreshMargin is how long before its expiry an ACR refresh token is
+// considered stale.
+const acrTokenRefreshMargin = 5 * time.Minute
+
+// aadResource is the resource AAD access tokens are requested for.
+const aadResource = "https://management.azure.com/"
+
+// AADEndpoint is where Azure service principals' credentials are exchanged
+// for AAD access tokens.
+var AADEndpoint = "https://login.microsoftonline.com"
+
+// ACRAuthenticator exchanges an Azure service principal's credentials for ACR
+// refresh tokens, refreshing them whenever they are about to expire.
+type ACRAuthenticator struct {
+	registry     string
+	tenantID     string
+	clientID     string
+	clientSecret string
+
+	lock      sync.Mutex
+	basic     *authn.Basic
+	expiresAt time.Time
+}
+
+// NewACRAuthenticator fetches an initial ACR refresh token for the source's
+// registry using its service principal credentials.
+func NewACRAuthenticator(source *Source) (*ACRAuthenticator, error) {
+	auth := &ACRAuthenticator{
+		registry:     strings.SplitN(source.Repository, "/", 2)[0],
+		tenantID:     source.AzureTenantId,
+		clientID:     source.AzureClientId,
+		clientSecret: source.AzureClientSecret,
+	}
+
+	err := auth.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// Authorization implements authn.Authenticator.
+func (auth *ACRAuthenticator) Authorization() (string, error) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if time.Now().Add(acrTokenRefreshMargin).After(auth.expiresAt) {
+		logrus.Debug("refreshing ACR refresh token")
+
+		err := auth.refresh()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return auth.basic.Authorization()
+}
+
+func (auth *ACRAuthenticator) refresh() error {
+	var aad struct {
+		AccessToken string `json:"access_token"`
+	}
+
+	err := postForm(AADEndpoint+"/"+auth.tenantID+"/oauth2/token", url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {auth.clientID},
+		"client_secret": {auth.clientSecret},
+		"resource":      {aadResource},
+	}, &aad)
+	if err != nil {
+		return fmt.Errorf("failed to get AAD access token: %s", err)
+	}
+
+	var acr struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err = postForm("https://"+auth.registry+"/oauth2/exchange", url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {auth.registry},
+		"tenant":       {auth.tenantID},
+		"access_token": {aad.AccessToken},
+	}, &acr)
+	if err != nil {
+		return fmt.Errorf("failed to exchange AAD access token for ACR refresh token: %s", err)
+	}
+
+	expiresAt, err := jwtExpiry(acr.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("malformed ACR refresh token: %s", err)
+	}
+
+	auth.basic = &authn.Basic{
+		Username: acrUsername,
+		Password: acr.RefreshToken,
+	}
+	auth.expiresAt = expiresAt
+
+	return nil
+}
+
+// postForm posts the form to the endpoint through RetryTransport, so that the
+// registry is talked to with the source's ca_certs, proxies, and headers.
+func postForm(endpoint string, form url.Values, dest interface{}) error {
+	client := &http.Client{Transport: RetryTransport}
+
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %s: %s", endpoint, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// jwtExpiry returns the time described by a JWT's "exp" claim. The token's
+// signature is not verified; it is only used to know when to refresh.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("expected 3 parts, got %d", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
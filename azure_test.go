@@ -0,0 +1,139 @@
+package resource_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ACRAuthenticator", func() {
+	var aad, registry *httptest.Server
+	var exchanges int
+	var expiresIn time.Duration
+	var refreshToken func() string
+
+	var source resource.Source
+	var originalEndpoint string
+
+	jwt := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+
+	BeforeEach(func() {
+		exchanges = 0
+		expiresIn = time.Hour
+		refreshToken = func() string {
+			return jwt(fmt.Sprintf(`{"exp":%d}`, time.Now().Add(expiresIn).Unix()))
+		}
+
+		aad = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/some-tenant/oauth2/token"))
+			Expect(r.ParseForm()).To(Succeed())
+			Expect(r.PostForm.Get("client_id")).To(Equal("some-client"))
+			Expect(r.PostForm.Get("client_secret")).To(Equal("some-secret"))
+
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "some-access-token"})
+		}))
+
+		registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/oauth2/exchange"))
+			Expect(r.ParseForm()).To(Succeed())
+			Expect(r.PostForm.Get("access_token")).To(Equal("some-access-token"))
+			Expect(r.Header.Get("X-Some-Header")).To(Equal("some-value"))
+
+			exchanges++
+
+			json.NewEncoder(w).Encode(map[string]string{"refresh_token": refreshToken()})
+		}))
+
+		originalEndpoint = resource.AADEndpoint
+		resource.AADEndpoint = aad.URL
+
+		// the plain HTTP registry is only reachable through the insecure
+		// transport that ConfigureTransport sets up for it
+		source = resource.Source{
+			Repository:        strings.TrimPrefix(registry.URL, "http://") + "/some/repo",
+			Insecure:          true,
+			ExtraHeaders:      map[string]string{"X-Some-Header": "some-value"},
+			AzureTenantId:     "some-tenant",
+			AzureClientId:     "some-client",
+			AzureClientSecret: "some-secret",
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+	})
+
+	AfterEach(func() {
+		resource.AADEndpoint = originalEndpoint
+
+		resource.InsecureRegistries.Hosts = nil
+		resource.ExtraHeaders.Hosts = nil
+		resource.ExtraHeaders.Headers = nil
+
+		aad.Close()
+		registry.Close()
+	})
+
+	It("should authenticate with the refresh token through the configured transport", func() {
+		auth, err := resource.NewACRAuthenticator(&source)
+		Expect(err).ToNot(HaveOccurred())
+
+		authorization, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorization).To(HavePrefix("Basic "))
+
+		credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(credentials)).To(HavePrefix("00000000-0000-0000-0000-000000000000:e30."))
+	})
+
+	It("should reuse the refresh token until it nears expiry", func() {
+		auth, err := resource.NewACRAuthenticator(&source)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exchanges).To(Equal(1))
+	})
+
+	It("should refresh the refresh token within the expiry margin", func() {
+		expiresIn = time.Minute
+
+		auth, err := resource.NewACRAuthenticator(&source)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exchanges).To(Equal(3))
+	})
+
+	It("should fail on refresh tokens without three parts", func() {
+		refreshToken = func() string { return "not-a-jwt" }
+
+		_, err := resource.NewACRAuthenticator(&source)
+		Expect(err).To(MatchError("malformed ACR refresh token: expected 3 parts, got 1"))
+	})
+
+	It("should fail on refresh tokens with malformed claims", func() {
+		refreshToken = func() string { return "e30.!!!.c2ln" }
+
+		_, err := resource.NewACRAuthenticator(&source)
+		Expect(err).To(MatchError(ContainSubstring("malformed ACR refresh token")))
+	})
+})
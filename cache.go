@@ -0,0 +1,205 @@
+package resource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// BlobCache is a content-addressable cache of blobs, e.g. compressed layers,
+// keyed by digest. When it grows beyond its maximum size, the least recently
+// used blobs are evicted.
+type BlobCache struct {
+	Dir string
+
+	// MaxSize is the size in bytes the cache is trimmed to by Evict. If 0, the
+	// cache grows without bound.
+	MaxSize int64
+}
+
+// BlobCache returns the source's blob cache, or nil if it has none.
+func (source *Source) BlobCache() (*BlobCache, error) {
+	if source.CacheDir == "" {
+		return nil, nil
+	}
+
+	var maxSize int64
+	if source.CacheMaxSize != "" {
+		var err error
+		maxSize, err = ParseSize(source.CacheMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache_max_size: %s", err)
+		}
+	}
+
+	return &BlobCache{
+		Dir:     source.CacheDir,
+		MaxSize: maxSize,
+	}, nil
+}
+
+// Fetch returns the path to the cached blob with the digest, calling fetch to
+// populate the cache if it's missing. Whether the blob was already cached is
+// also returned.
+func (cache *BlobCache) Fetch(digest v1.Hash, fetch func() (io.ReadCloser, error)) (string, bool, error) {
+	if digest.Algorithm != "sha256" {
+		return "", false, fmt.Errorf("unsupported digest algorithm: %s", digest.Algorithm)
+	}
+
+	dir := filepath.Join(cache.Dir, digest.Algorithm)
+	path := filepath.Join(dir, digest.Hex)
+
+	if _, err := os.Stat(path); err == nil {
+		// mark the blob as recently used
+		now := time.Now()
+		err = os.Chtimes(path, now, now)
+		if err != nil {
+			return "", false, err
+		}
+
+		return path, true, nil
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", false, err
+	}
+
+	blob, err := fetch()
+	if err != nil {
+		return "", false, err
+	}
+
+	defer blob.Close()
+
+	tmp, err := ioutil.TempFile(dir, digest.Hex+".tmp")
+	if err != nil {
+		return "", false, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tmp, hash), blob)
+	if err != nil {
+		tmp.Close()
+		return "", false, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return "", false, err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != digest.Hex {
+		return "", false, fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, actual)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", false, err
+	}
+
+	return path, false, nil
+}
+
+// Evict removes the least recently used blobs until the cache is no larger
+// than its maximum size.
+func (cache *BlobCache) Evict() error {
+	if cache.MaxSize == 0 {
+		return nil
+	}
+
+	var blobs []os.FileInfo
+	var paths []string
+	var total int64
+
+	err := filepath.Walk(cache.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			blobs = append(blobs, info)
+			paths = append(paths, path)
+			total += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, len(blobs))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return blobs[order[i]].ModTime().Before(blobs[order[j]].ModTime())
+	})
+
+	for _, i := range order {
+		if total <= cache.MaxSize {
+			break
+		}
+
+		logrus.Debugf("evicting %s from cache", paths[i])
+
+		err := os.Remove(paths[i])
+		if err != nil {
+			return err
+		}
+
+		total -= blobs[i].Size()
+	}
+
+	return nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes, optionally with a unit, e.g. 512MB or
+// 10GB. Units are powers of 1024.
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,109 @@
+package resource_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("BlobCache", func() {
+	var cache *resource.BlobCache
+
+	blob := func(content string) (v1.Hash, func() (io.ReadCloser, error)) {
+		digest, _, err := v1.SHA256(strings.NewReader(content))
+		Expect(err).ToNot(HaveOccurred())
+
+		return digest, func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewBufferString(content)), nil
+		}
+	}
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "blob-cache")
+		Expect(err).ToNot(HaveOccurred())
+
+		cache = &resource.BlobCache{Dir: dir}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cache.Dir)).To(Succeed())
+	})
+
+	It("should only fetch missing blobs", func() {
+		digest, fetch := blob("some-blob")
+
+		path, cached, err := cache.Fetch(digest, fetch)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cached).To(BeFalse())
+		Expect(cat(path)).To(Equal("some-blob"))
+
+		path, cached, err = cache.Fetch(digest, func() (io.ReadCloser, error) {
+			Fail("should not fetch a cached blob")
+			return nil, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cached).To(BeTrue())
+		Expect(cat(path)).To(Equal("some-blob"))
+	})
+
+	It("should not cache blobs which don't match their digest", func() {
+		digest, _ := blob("some-blob")
+		_, fetch := blob("some-other-blob")
+
+		_, _, err := cache.Fetch(digest, fetch)
+		Expect(err).To(MatchError(ContainSubstring("digest mismatch")))
+
+		Expect(filepath.Join(cache.Dir, "sha256", digest.Hex)).ToNot(BeAnExistingFile())
+	})
+
+	It("should evict the least recently used blobs", func() {
+		var paths []string
+		for i, content := range []string{"aaaa", "bbbb", "cccc"} {
+			digest, fetch := blob(content)
+
+			path, _, err := cache.Fetch(digest, fetch)
+			Expect(err).ToNot(HaveOccurred())
+
+			at := time.Now().Add(time.Duration(i-10) * time.Minute)
+			Expect(os.Chtimes(path, at, at)).To(Succeed())
+
+			paths = append(paths, path)
+		}
+
+		// use the oldest blob again
+		digest, fetch := blob("aaaa")
+		_, _, err := cache.Fetch(digest, fetch)
+		Expect(err).ToNot(HaveOccurred())
+
+		cache.MaxSize = 8
+		Expect(cache.Evict()).To(Succeed())
+
+		Expect(paths[0]).To(BeAnExistingFile())
+		Expect(paths[1]).ToNot(BeAnExistingFile())
+		Expect(paths[2]).To(BeAnExistingFile())
+	})
+})
+
+var _ = Describe("ParseSize", func() {
+	It("should parse sizes with units", func() {
+		Expect(resource.ParseSize("512")).To(Equal(int64(512)))
+		Expect(resource.ParseSize("10KB")).To(Equal(int64(10 << 10)))
+		Expect(resource.ParseSize("2 GB")).To(Equal(int64(2 << 30)))
+		Expect(resource.ParseSize("1mb")).To(Equal(int64(1 << 20)))
+	})
+
+	It("should fail with an invalid size", func() {
+		_, err := resource.ParseSize("lots")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -57,7 +57,7 @@ var _ = Describe("Check", func() {
 
 		It("returns the current digest", func() {
 			Expect(res).To(Equal([]resource.Version{
-				{Digest: LATEST_STATIC_DIGEST},
+				{Tag: "latest", Digest: LATEST_STATIC_DIGEST},
 			}))
 		})
 
@@ -76,7 +76,7 @@ var _ = Describe("Check", func() {
 
 			It("returns the current digest", func() {
 				Expect(res).To(Equal([]resource.Version{
-					{Digest: PRIVATE_LATEST_STATIC_DIGEST},
+					{Tag: "latest", Digest: PRIVATE_LATEST_STATIC_DIGEST},
 				}))
 			})
 		})
@@ -90,13 +90,14 @@ var _ = Describe("Check", func() {
 			}
 
 			req.Version = &resource.Version{
+				Tag:    "latest",
 				Digest: LATEST_STATIC_DIGEST,
 			}
 		})
 
 		It("returns the given digest", func() {
 			Expect(res).To(Equal([]resource.Version{
-				{Digest: LATEST_STATIC_DIGEST},
+				{Tag: "latest", Digest: LATEST_STATIC_DIGEST},
 			}))
 		})
 
@@ -113,13 +114,14 @@ var _ = Describe("Check", func() {
 				checkDockerPrivateUserConfigured()
 
 				req.Version = &resource.Version{
+					Tag:    "latest",
 					Digest: PRIVATE_LATEST_STATIC_DIGEST,
 				}
 			})
 
 			It("returns the current digest", func() {
 				Expect(res).To(Equal([]resource.Version{
-					{Digest: PRIVATE_LATEST_STATIC_DIGEST},
+					{Tag: "latest", Digest: PRIVATE_LATEST_STATIC_DIGEST},
 				}))
 			})
 		})
@@ -134,14 +136,15 @@ var _ = Describe("Check", func() {
 
 			req.Version = &resource.Version{
 				// this was previously pushed to the 'latest' tag
+				Tag:    "latest",
 				Digest: OLDER_STATIC_DIGEST,
 			}
 		})
 
 		It("returns the previous digest and the current digest", func() {
 			Expect(res).To(Equal([]resource.Version{
-				{Digest: OLDER_STATIC_DIGEST},
-				{Digest: LATEST_STATIC_DIGEST},
+				{Tag: "latest", Digest: OLDER_STATIC_DIGEST},
+				{Tag: "latest", Digest: LATEST_STATIC_DIGEST},
 			}))
 		})
 
@@ -159,14 +162,15 @@ var _ = Describe("Check", func() {
 
 				req.Version = &resource.Version{
 					// this was previously pushed to the 'latest' tag
+					Tag:    "latest",
 					Digest: PRIVATE_OLDER_STATIC_DIGEST,
 				}
 			})
 
 			It("returns the current digest", func() {
 				Expect(res).To(Equal([]resource.Version{
-					{Digest: PRIVATE_OLDER_STATIC_DIGEST},
-					{Digest: PRIVATE_LATEST_STATIC_DIGEST},
+					{Tag: "latest", Digest: PRIVATE_OLDER_STATIC_DIGEST},
+					{Tag: "latest", Digest: PRIVATE_LATEST_STATIC_DIGEST},
 				}))
 			})
 		})
@@ -180,13 +184,14 @@ var _ = Describe("Check", func() {
 			}
 
 			req.Version = &resource.Version{
+				Tag:    "latest",
 				Digest: "sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef",
 			}
 		})
 
 		It("returns only the current digest", func() {
 			Expect(res).To(Equal([]resource.Version{
-				{Digest: LATEST_STATIC_DIGEST},
+				{Tag: "latest", Digest: LATEST_STATIC_DIGEST},
 			}))
 		})
 
@@ -205,12 +210,30 @@ var _ = Describe("Check", func() {
 
 			It("returns the current digest", func() {
 				Expect(res).To(Equal([]resource.Version{
-					{Digest: PRIVATE_LATEST_STATIC_DIGEST},
+					{Tag: "latest", Digest: PRIVATE_LATEST_STATIC_DIGEST},
 				}))
 			})
 		})
 	})
 
+	Context("when the source is pinned to a digest", func() {
+		BeforeEach(func() {
+			req.Source = resource.Source{
+				Repository: "concourse/test-image-static@" + LATEST_STATIC_DIGEST,
+			}
+			req.Version = &resource.Version{
+				Tag:    "latest",
+				Digest: OLDER_STATIC_DIGEST,
+			}
+		})
+
+		It("returns only the pinned digest", func() {
+			Expect(res).To(Equal([]resource.Version{
+				{Digest: LATEST_STATIC_DIGEST},
+			}))
+		})
+	})
+
 	Context("when invoked with not exist image", func() {
 		BeforeEach(func() {
 			req.Source = resource.Source{
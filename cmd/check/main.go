@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
+	"time"
 
 	resource "github.com/concourse/registry-image-resource"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -35,41 +38,194 @@ func main() {
 		return
 	}
 
-	n, err := name.ParseReference(req.Source.Name(), name.WeakValidation)
+	err = req.Source.ConfigureLogging()
 	if err != nil {
-		logrus.Errorf("could not resolve repository/tag reference: %s", err)
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.Interpolate(os.LookupEnv)
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.ValidateSortBy()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.CheckAllowedRegistries()
+	if err != nil {
+		logrus.Errorf("registry not allowed: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.ConfigureTransport()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.Metrics != nil {
+		err = req.Source.Metrics.Validate()
+		if err != nil {
+			logrus.Errorf("invalid metrics: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Source.Tracing != nil {
+		err = req.Source.Tracing.Validate()
+		if err != nil {
+			logrus.Errorf("invalid tracing: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	metrics := req.Source.Metrics.Start("check")
+	trace := req.Source.Tracing.Start("check", os.Getenv)
+
+	timeout, err := req.Source.Timeout()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			logrus.Errorf("check timed out after %s", timeout)
+			os.Exit(1)
+		})
+	}
+
+	err = req.Source.PinDigest()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if pinned := req.Source.PinnedVersion(); pinned != nil {
+		metrics.Succeeded()
+		trace.Succeeded()
+
+		// the source always refers to the same image
+		json.NewEncoder(os.Stdout).Encode(CheckResponse{*pinned})
+		return
+	}
+
+	auth, err := req.Source.Authenticator()
+	if err != nil {
+		logrus.Errorf("failed to configure registry credentials: %s", err)
 		os.Exit(1)
 		return
 	}
 
-	auth := &authn.Basic{
-		Username: req.Source.Username,
-		Password: req.Source.Password,
+	if req.Source.IsGHCR() {
+		repo, err := name.NewRepository(req.Source.Repository, name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve repository: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		auth = resource.PullAuthenticator(repo, auth, resource.RetryTransport)
 	}
 
 	imageOpts := []remote.ImageOption{
 		remote.WithTransport(resource.RetryTransport),
+		remote.WithAuth(auth),
+	}
+
+	var response CheckResponse
+	if req.Source.WebhookHint {
+		response = checkHead(req, auth, imageOpts)
+	} else if req.Source.TracksTags() {
+		response = checkTags(req, auth, imageOpts)
+	} else {
+		response = checkTag(req, auth, imageOpts)
 	}
 
-	if auth.Username != "" && auth.Password != "" {
-		imageOpts = append(imageOpts, remote.WithAuth(auth))
+	if limit := resource.RateLimiter.Limit(); limit != nil {
+		logrus.Infof("rate limit: %d of %d requests remaining", limit.Remaining, limit.Limit)
 	}
 
-	image, err := remote.Image(n, imageOpts...)
+	metrics.Succeeded()
+	trace.Succeeded()
+
+	json.NewEncoder(os.Stdout).Encode(response)
+}
+
+func checkTag(req CheckRequest, auth authn.Authenticator, imageOpts []remote.ImageOption) CheckResponse {
+	n, err := name.ParseReference(req.Source.Name(), name.WeakValidation)
+	if err != nil {
+		logrus.Errorf("could not resolve repository/tag reference: %s", err)
+		os.Exit(1)
+		return nil
+	}
+
+	// an unchanged image can still have become too old
+	if req.Version != nil && req.Source.MaxAge == "" && unchanged(req, auth) {
+		return CheckResponse{*req.Version}
+	}
+
+	image, err := req.Source.MirroredImage(n, imageOpts...)
 	if err != nil {
 		logrus.Errorf("failed to get remote image: %s", err)
 		os.Exit(1)
-		return
+		return nil
 	}
 
 	var missingTag bool
 	digest, err := image.Digest()
 	if err != nil {
-		missingTag = checkMissingManifest(err)
+		missingTag = checkMissingManifest(err) ||
+			(req.Source.InitialVersion() != nil && checkMissingRepository(err))
 		if !missingTag {
 			logrus.Errorf("failed to get cursor image digest: %s", err)
 			os.Exit(1)
-			return
+			return nil
+		}
+	}
+
+	if !missingTag && req.Source.ContentTrust != nil {
+		verifyTrust(req, n.(name.Tag), auth, digest.String())
+	}
+
+	if !missingTag && (req.Source.MaxAge != "" || len(req.Source.LabelFilters) > 0) {
+		cfg, err := platformConfig(req, image, imageOpts)
+		if err != nil {
+			logrus.Errorf("failed to get image config: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		err = req.Source.CheckImageAge(cfg.Created.Time, time.Now())
+		if err != nil {
+			logrus.Infof("skipping %s: %s", n, err)
+			missingTag = true
+		}
+
+		matched, err := req.Source.MatchLabels(cfg.Config.Labels)
+		if err != nil {
+			logrus.Errorf("invalid source: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		if !missingTag && !matched {
+			logrus.Infof("skipping %s: its labels don't match label_filters", n)
+			missingTag = true
 		}
 	}
 
@@ -79,29 +235,24 @@ func main() {
 		if err != nil {
 			logrus.Errorf("could not resolve repository/digest reference: %s", err)
 			os.Exit(1)
-			return
+			return nil
 		}
 
-		var digestImage v1.Image
-		if auth.Username != "" && auth.Password != "" {
-			digestImage, err = remote.Image(digestRef, remote.WithAuth(auth))
-		} else {
-			digestImage, err = remote.Image(digestRef)
-		}
+		digestImage, err := req.Source.MirroredImage(digestRef, imageOpts...)
 		if err != nil {
 			logrus.Errorf("failed to get remote image: %s", err)
 			os.Exit(1)
-			return
+			return nil
 		}
 
 		var missingDigest bool
 		_, err = digestImage.Digest()
 		if err != nil {
-			missingDigest = checkMissingManifest(err)
+			missingDigest = checkMissingManifest(err) || checkMissingRepository(err)
 			if !missingDigest {
 				logrus.Errorf("failed to get cursor image digest: %s", err)
 				os.Exit(1)
-				return
+				return nil
 			}
 		}
 
@@ -112,11 +263,355 @@ func main() {
 
 	if !missingTag {
 		response = append(response, resource.Version{
+			Tag:    req.Source.Tag(),
 			Digest: digest.String(),
 		})
 	}
 
-	json.NewEncoder(os.Stdout).Encode(response)
+	if len(response) == 0 {
+		return initialVersion(req.Source)
+	}
+
+	return response
+}
+
+// unchanged determines whether the source's tag still refers to the current
+// version with a conditional HEAD request, which doesn't count as a pull
+// against registry rate limits. Any failure is left for the full check to
+// report.
+func unchanged(req CheckRequest, auth authn.Authenticator) bool {
+	tag, err := name.NewTag(req.Source.Name(), name.WeakValidation)
+	if err != nil {
+		return false
+	}
+
+	digest, err := resource.HeadManifest(tag, auth, resource.RetryTransport, req.Version.Digest)
+	if err != nil {
+		logrus.Debugf("failed to check for changes to %s: %s", tag, err)
+		return false
+	}
+
+	return digest.String() == req.Version.Digest
+}
+
+// verifyTrust fails the check unless the digest is the one signed for the tag
+// in the content trust server.
+func verifyTrust(req CheckRequest, tag name.Tag, auth authn.Authenticator, digest string) {
+	err := req.Source.ContentTrust.VerifyDigest(tag, auth, digest)
+	if err != nil {
+		logrus.Errorf("failed to verify content trust: %s", err)
+		os.Exit(1)
+	}
+}
+
+// checkHead resolves the digest of the source's tag without listing tags or
+// fetching the manifest, unless its config is needed for max_age or
+// label_filters.
+func checkHead(req CheckRequest, auth authn.Authenticator, imageOpts []remote.ImageOption) CheckResponse {
+	tag, err := name.NewTag(req.Source.Name(), name.WeakValidation)
+	if err != nil {
+		logrus.Errorf("could not resolve repository/tag reference: %s", err)
+		os.Exit(1)
+		return nil
+	}
+
+	var known string
+	if req.Version != nil {
+		known = req.Version.Digest
+	}
+
+	digest, err := resource.HeadManifest(tag, auth, resource.RetryTransport, known)
+	if err != nil {
+		if checkMissingManifest(err) {
+			return initialVersion(req.Source)
+		}
+
+		logrus.Errorf("failed to get image digest: %s", err)
+		os.Exit(1)
+		return nil
+	}
+
+	// an unchanged image can still have become too old
+	if req.Version != nil && req.Version.Digest == digest.String() && req.Source.MaxAge == "" {
+		return CheckResponse{*req.Version}
+	}
+
+	if req.Source.ContentTrust != nil {
+		verifyTrust(req, tag, auth, digest.String())
+	}
+
+	if req.Source.MaxAge != "" || len(req.Source.LabelFilters) > 0 {
+		digestRef, err := name.NewDigest(req.Source.Repository+"@"+digest.String(), name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve repository/digest reference: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		image, err := req.Source.MirroredImage(digestRef, imageOpts...)
+		if err != nil {
+			logrus.Errorf("failed to get remote image: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		cfg, err := platformConfig(req, image, imageOpts)
+		if err != nil {
+			logrus.Errorf("failed to get image config: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		skip := false
+
+		err = req.Source.CheckImageAge(cfg.Created.Time, time.Now())
+		if err != nil {
+			logrus.Infof("skipping %s: %s", tag, err)
+			skip = true
+		}
+
+		matched, err := req.Source.MatchLabels(cfg.Config.Labels)
+		if err != nil {
+			logrus.Errorf("invalid source: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		if !skip && !matched {
+			logrus.Infof("skipping %s: its labels don't match label_filters", tag)
+			skip = true
+		}
+
+		if skip {
+			if req.Version != nil && req.Version.Digest != digest.String() {
+				return CheckResponse{*req.Version}
+			}
+
+			return initialVersion(req.Source)
+		}
+	}
+
+	return CheckResponse{{
+		Tag:    req.Source.Tag(),
+		Digest: digest.String(),
+	}}
+}
+
+func checkTags(req CheckRequest, auth authn.Authenticator, imageOpts []remote.ImageOption) CheckResponse {
+	repo, err := name.NewRepository(req.Source.Repository, name.WeakValidation)
+	if err != nil {
+		logrus.Errorf("could not resolve repository: %s", err)
+		os.Exit(1)
+		return nil
+	}
+
+	listTags := resource.ListTags
+	if req.Source.IsQuay() {
+		listTags = resource.ListQuayTags
+	}
+
+	// filter each page as it arrives so that only matching tags are retained
+	var tags []string
+	err = listTags(repo, auth, resource.RetryTransport, req.Source.TagPageSize, func(page []string) error {
+		matching, err := req.Source.FilterTags(page)
+		if err != nil {
+			return err
+		}
+
+		tags = append(tags, matching...)
+
+		return nil
+	})
+	if err != nil {
+		if req.Source.InitialVersion() != nil && checkMissingRepository(err) {
+			return initialVersion(req.Source)
+		}
+
+		logrus.Errorf("failed to list repository tags: %s", err)
+		os.Exit(1)
+		return nil
+	}
+
+	// order the matches from all pages
+	tags, err = req.Source.FilterTags(tags)
+	if err != nil {
+		logrus.Errorf("failed to filter tags: %s", err)
+		os.Exit(1)
+		return nil
+	}
+
+	if req.Source.SortBy == "" {
+		// tags are already in order, so only the cursor's tag and those after
+		// it need to be resolved
+		tags = tagsSince(tags, req.Version)
+	}
+
+	var versions CheckResponse
+	var created []time.Time
+	for _, tag := range tags {
+		tagRef, err := name.ParseReference(req.Source.Repository+":"+tag, name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve repository/tag reference: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		image, err := req.Source.MirroredImage(tagRef, imageOpts...)
+		if err != nil {
+			logrus.Errorf("failed to get remote image: %s", err)
+			os.Exit(1)
+			return nil
+		}
+
+		digest, err := image.Digest()
+		if err != nil {
+			if checkMissingManifest(err) {
+				// tag was removed while we were checking
+				continue
+			}
+
+			logrus.Errorf("failed to get image digest for tag %s: %s", tag, err)
+			os.Exit(1)
+			return nil
+		}
+
+		if req.Source.SortBy == resource.SortByCreationDate || req.Source.MaxAge != "" || len(req.Source.LabelFilters) > 0 {
+			cfg, err := platformConfig(req, image, imageOpts)
+			if err != nil {
+				logrus.Errorf("failed to get image config for tag %s: %s", tag, err)
+				os.Exit(1)
+				return nil
+			}
+
+			err = req.Source.CheckImageAge(cfg.Created.Time, time.Now())
+			if err != nil {
+				logrus.Infof("skipping tag %s: %s", tag, err)
+				continue
+			}
+
+			matched, err := req.Source.MatchLabels(cfg.Config.Labels)
+			if err != nil {
+				logrus.Errorf("invalid source: %s", err)
+				os.Exit(1)
+				return nil
+			}
+
+			if !matched {
+				logrus.Debugf("skipping tag %s: its labels don't match label_filters", tag)
+				continue
+			}
+
+			created = append(created, cfg.Created.Time)
+		}
+
+		versions = append(versions, resource.Version{
+			Tag:    tag,
+			Digest: digest.String(),
+		})
+	}
+
+	if req.Source.SortBy == resource.SortByCreationDate {
+		sort.Stable(byCreationDate{versions, created})
+	}
+
+	if len(versions) == 0 {
+		return initialVersion(req.Source)
+	}
+
+	return versionsSince(versions, req.Version)
+}
+
+// platformConfig returns the config of the image for the source's platform.
+func platformConfig(req CheckRequest, image v1.Image, imageOpts []remote.ImageOption) (*v1.ConfigFile, error) {
+	image, err := resource.ResolvePlatform(image, req.Source.PlatformOrDefault(), func(digest v1.Hash) (v1.Image, error) {
+		ref, err := name.NewDigest(req.Source.Repository+"@"+digest.String(), name.WeakValidation)
+		if err != nil {
+			return nil, err
+		}
+
+		return req.Source.MirroredImage(ref, imageOpts...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve image for platform: %s", err)
+	}
+
+	return image.ConfigFile()
+}
+
+// initialVersion returns the source's seed version, if any, for when there are
+// no images to report.
+func initialVersion(source resource.Source) CheckResponse {
+	initial := source.InitialVersion()
+	if initial == nil {
+		return CheckResponse{}
+	}
+
+	return CheckResponse{*initial}
+}
+
+// byCreationDate orders versions by the creation date of their images,
+// preserving the existing order of images created at the same time.
+type byCreationDate struct {
+	versions CheckResponse
+	created  []time.Time
+}
+
+func (s byCreationDate) Len() int { return len(s.versions) }
+
+func (s byCreationDate) Less(i, j int) bool { return s.created[i].Before(s.created[j]) }
+
+func (s byCreationDate) Swap(i, j int) {
+	s.versions[i], s.versions[j] = s.versions[j], s.versions[i]
+	s.created[i], s.created[j] = s.created[j], s.created[i]
+}
+
+// tagsSince returns the tags starting at the cursor's tag. If there is no
+// cursor, only the latest tag is returned. Versions without a tag were emitted
+// by older releases of the resource; all tags are returned so that the cursor
+// can be located by its digest.
+func tagsSince(tags []string, cursor *resource.Version) []string {
+	if len(tags) == 0 {
+		return tags
+	}
+
+	if cursor == nil {
+		return tags[len(tags)-1:]
+	}
+
+	if cursor.Tag == "" {
+		return tags
+	}
+
+	for i, tag := range tags {
+		if tag == cursor.Tag {
+			return tags[i:]
+		}
+	}
+
+	return tags[len(tags)-1:]
+}
+
+// versionsSince returns the versions starting at the cursor, which is located
+// by its tag or, for versions without a tag, its digest. If there is no
+// cursor, or it is no longer present, only the latest version is returned.
+func versionsSince(versions CheckResponse, cursor *resource.Version) CheckResponse {
+	if len(versions) == 0 {
+		return CheckResponse{}
+	}
+
+	if cursor != nil {
+		for i, v := range versions {
+			if cursor.Tag != "" && v.Tag == cursor.Tag {
+				return versions[i:]
+			}
+
+			if cursor.Tag == "" && v.Digest == cursor.Digest {
+				return versions[i:]
+			}
+		}
+	}
+
+	return versions[len(versions)-1:]
 }
 
 func checkMissingManifest(err error) bool {
@@ -131,3 +626,14 @@ func checkMissingManifest(err error) bool {
 	}
 	return missing
 }
+
+func checkMissingRepository(err error) bool {
+	if rErr, ok := err.(*remote.Error); ok {
+		for _, e := range rErr.Errors {
+			if e.Code == remote.NameUnknownErrorCode {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	resource "github.com/concourse/registry-image-resource"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/vbauerster/mpb"
+)
+
+// downloadLayers downloads up to concurrency layers at a time with download,
+// which returns the path it downloaded the layer's compressed archive to. As
+// soon as a layer and all of the layers before it have been downloaded,
+// extract is called with its path, so that layers are still applied in order.
+func downloadLayers(layers []v1.Layer, concurrency int, download func(int, v1.Layer) (string, error), extract func(int, string) error) error {
+	type result struct {
+		path string
+		err  error
+	}
+
+	results := make([]chan result, len(layers))
+	for i := range layers {
+		results[i] = make(chan result, 1)
+	}
+
+	// stops any downloads which haven't started yet if we fail early
+	abort := make(chan struct{})
+	defer close(abort)
+
+	go func() {
+		slots := make(chan struct{}, concurrency)
+
+		// start downloads in order so that earlier layers, which are needed
+		// first, aren't held up by later ones
+		for i, layer := range layers {
+			select {
+			case slots <- struct{}{}:
+			case <-abort:
+				return
+			}
+
+			go func(i int, layer v1.Layer) {
+				defer func() { <-slots }()
+
+				path, err := download(i, layer)
+				results[i] <- result{path, err}
+			}(i, layer)
+		}
+	}()
+
+	for i := range layers {
+		res := <-results[i]
+		if res.err != nil {
+			return fmt.Errorf("failed to download layer %d: %s", i+1, res.err)
+		}
+
+		err := extract(i, res.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prefetchBufferSize is how much of each layer is buffered in memory ahead of
+// its extraction when downloading layers concurrently. Anything beyond that is
+// spilled to a temporary file, so that downloads never stall waiting for their
+// turn, which would hold their connections to the registry open idly.
+const prefetchBufferSize = 8 * 1024 * 1024
+
+// streamLayers extracts the layers in order, streaming each from open to
+// extract as it's downloaded. Up to concurrency layers are downloaded at a
+// time: while a layer is extracted, the next ones are downloaded into buffers
+// of bufferSize bytes, spilling to temporary files once the buffers are full.
+func streamLayers(layers []v1.Layer, concurrency int, bufferSize int, open func(int, v1.Layer) (io.ReadCloser, error), extract func(int, io.Reader) error) error {
+	pipes := make([]*bufferedPipe, len(layers))
+	for i := range layers {
+		pipes[i] = newBufferedPipe(bufferSize)
+	}
+
+	// stops any downloads which haven't finished yet if we fail early
+	abort := make(chan struct{})
+	defer func() {
+		close(abort)
+
+		for _, pipe := range pipes {
+			pipe.CloseRead()
+		}
+	}()
+
+	slots := make(chan struct{}, concurrency)
+
+	go func() {
+		// start downloads in order so that earlier layers, which are needed
+		// first, aren't held up by later ones
+		for i, layer := range layers {
+			select {
+			case slots <- struct{}{}:
+			case <-abort:
+				return
+			}
+
+			go func(i int, layer v1.Layer) {
+				r, err := open(i, layer)
+				if err != nil {
+					pipes[i].CloseWrite(err)
+					return
+				}
+
+				defer r.Close()
+
+				_, err = io.Copy(pipes[i], r)
+				pipes[i].CloseWrite(err)
+			}(i, layer)
+		}
+	}()
+
+	for i := range layers {
+		err := extract(i, pipes[i])
+		if err != nil {
+			return fmt.Errorf("failed to extract layer %d: %s", i+1, err)
+		}
+
+		// finish the download, e.g. of padding after the end of the archive
+		_, err = io.Copy(ioutil.Discard, pipes[i])
+		if err != nil {
+			return fmt.Errorf("failed to download layer %d: %s", i+1, err)
+		}
+
+		<-slots
+	}
+
+	return nil
+}
+
+// bufferedPipe is a pipe which buffers up to a limit in memory, and spills
+// anything beyond that to a temporary file, so that writes never wait for the
+// reader.
+type bufferedPipe struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	buf   []byte
+	limit int
+
+	// spill holds what was written while buf was full, from spillRead up to
+	// spillWritten; it's reused from the start once the reader catches up
+	spill        *os.File
+	spillRead    int64
+	spillWritten int64
+
+	// writeErr is io.EOF once the writer is done
+	writeErr error
+	closed   bool
+}
+
+func newBufferedPipe(limit int) *bufferedPipe {
+	pipe := &bufferedPipe{limit: limit}
+	pipe.cond = sync.NewCond(&pipe.lock)
+	return pipe
+}
+
+func (pipe *bufferedPipe) Write(p []byte) (int, error) {
+	pipe.lock.Lock()
+	defer pipe.lock.Unlock()
+
+	if pipe.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if pipe.spillRead == pipe.spillWritten {
+		pipe.spillRead, pipe.spillWritten = 0, 0
+	}
+
+	written := 0
+
+	// nothing may be buffered in memory while the spill is unread, as it
+	// comes first
+	if pipe.spillWritten == 0 && len(pipe.buf) < pipe.limit {
+		n := pipe.limit - len(pipe.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		pipe.buf = append(pipe.buf, p[:n]...)
+		written = n
+	}
+
+	if written < len(p) {
+		if pipe.spill == nil {
+			spill, err := ioutil.TempFile("", "layer-")
+			if err != nil {
+				return written, fmt.Errorf("failed to spill download to disk: %s", err)
+			}
+
+			pipe.spill = spill
+		}
+
+		n, err := pipe.spill.WriteAt(p[written:], pipe.spillWritten)
+		pipe.spillWritten += int64(n)
+		written += n
+
+		if err != nil {
+			pipe.cond.Broadcast()
+			return written, fmt.Errorf("failed to spill download to disk: %s", err)
+		}
+	}
+
+	pipe.cond.Broadcast()
+
+	return written, nil
+}
+
+func (pipe *bufferedPipe) Read(p []byte) (int, error) {
+	pipe.lock.Lock()
+	defer pipe.lock.Unlock()
+
+	for len(pipe.buf) == 0 && pipe.spillRead == pipe.spillWritten && pipe.writeErr == nil && !pipe.closed {
+		pipe.cond.Wait()
+	}
+
+	if pipe.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	if len(pipe.buf) > 0 {
+		n := copy(p, pipe.buf)
+		pipe.buf = pipe.buf[n:]
+
+		if len(pipe.buf) == 0 {
+			// release the buffer rather than growing it forever
+			pipe.buf = nil
+		}
+
+		return n, nil
+	}
+
+	if pipe.spillRead < pipe.spillWritten {
+		if remaining := pipe.spillWritten - pipe.spillRead; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+
+		n, err := pipe.spill.ReadAt(p, pipe.spillRead)
+		pipe.spillRead += int64(n)
+
+		if err == io.EOF {
+			err = nil
+		}
+
+		return n, err
+	}
+
+	pipe.removeSpill()
+
+	return 0, pipe.writeErr
+}
+
+// CloseWrite ends the pipe once everything buffered has been read, with the
+// error if the write failed.
+func (pipe *bufferedPipe) CloseWrite(err error) {
+	pipe.lock.Lock()
+	defer pipe.lock.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+
+	if pipe.writeErr == nil {
+		pipe.writeErr = err
+	}
+
+	pipe.cond.Broadcast()
+}
+
+// CloseRead fails any further reads and writes, e.g. as extraction failed.
+func (pipe *bufferedPipe) CloseRead() {
+	pipe.lock.Lock()
+	defer pipe.lock.Unlock()
+
+	pipe.closed = true
+	pipe.buf = nil
+	pipe.removeSpill()
+
+	pipe.cond.Broadcast()
+}
+
+// removeSpill removes the spill file, if any, once it's no longer needed.
+func (pipe *bufferedPipe) removeSpill() {
+	if pipe.spill == nil {
+		return
+	}
+
+	pipe.spill.Close()
+	os.Remove(pipe.spill.Name())
+
+	pipe.spill = nil
+	pipe.spillRead, pipe.spillWritten = 0, 0
+}
+
+// cacheLayer fetches the layer into the cache, returning the path to its
+// compressed archive.
+func cacheLayer(cache *resource.BlobCache, layer v1.Layer, bar *mpb.Bar) (string, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return "", err
+	}
+
+	path, cached, err := cache.Fetch(digest, func() (io.ReadCloser, error) {
+		r, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+
+		return bar.ProxyReader(r), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if cached {
+		logrus.Debugf("using cached layer %s", digest)
+
+		size, err := layer.Size()
+		if err != nil {
+			return "", err
+		}
+
+		bar.IncrBy(int(size))
+	}
+
+	return path, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("downloadLayers", func() {
+	It("should extract the layers in order when later ones finish downloading first", func() {
+		// each download waits for the one after it to finish
+		finished := make([]chan struct{}, 4)
+		for i := range finished {
+			finished[i] = make(chan struct{})
+		}
+
+		var lock sync.Mutex
+		var order []int
+
+		var extracted []string
+		err := downloadLayers(make([]v1.Layer, 4), 4, func(i int, layer v1.Layer) (string, error) {
+			if i+1 < len(finished) {
+				<-finished[i+1]
+			}
+
+			lock.Lock()
+			order = append(order, i)
+			lock.Unlock()
+
+			close(finished[i])
+
+			return fmt.Sprintf("layer-%d.tgz", i), nil
+		}, func(i int, path string) error {
+			extracted = append(extracted, path)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(order).To(Equal([]int{3, 2, 1, 0}))
+		Expect(extracted).To(Equal([]string{"layer-0.tgz", "layer-1.tgz", "layer-2.tgz", "layer-3.tgz"}))
+	})
+
+	It("should stop starting downloads once a layer fails to download", func() {
+		release := make(chan struct{})
+		defer close(release)
+
+		var started int32
+		err := downloadLayers(make([]v1.Layer, 5), 1, func(i int, layer v1.Layer) (string, error) {
+			atomic.AddInt32(&started, 1)
+
+			if i == 0 {
+				return "", errors.New("some error")
+			}
+
+			<-release
+			return "", nil
+		}, func(i int, path string) error {
+			Fail("no layer should be extracted")
+			return nil
+		})
+		Expect(err).To(MatchError("failed to download layer 1: some error"))
+
+		// the next download may have taken the free slot just before
+		Consistently(func() int32 {
+			return atomic.LoadInt32(&started)
+		}).Should(BeNumerically("<=", 2))
+	})
+
+	It("should stop once a layer fails to extract", func() {
+		var extracted []int
+		err := downloadLayers(make([]v1.Layer, 3), 3, func(i int, layer v1.Layer) (string, error) {
+			return "", nil
+		}, func(i int, path string) error {
+			extracted = append(extracted, i)
+			if i == 1 {
+				return errors.New("some error")
+			}
+
+			return nil
+		})
+		Expect(err).To(MatchError("some error"))
+		Expect(extracted).To(Equal([]int{0, 1}))
+	})
+})
+
+var _ = Describe("streamLayers", func() {
+	var tmpDir string
+	var oldTmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "stream-layers")
+		Expect(err).ToNot(HaveOccurred())
+
+		oldTmpDir = os.Getenv("TMPDIR")
+		os.Setenv("TMPDIR", tmpDir)
+	})
+
+	AfterEach(func() {
+		os.Setenv("TMPDIR", oldTmpDir)
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	content := func(i int) string {
+		return strings.Repeat(fmt.Sprintf("layer-%d ", i), 100)
+	}
+
+	open := func(i int, layer v1.Layer) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(content(i))), nil
+	}
+
+	It("should extract the layers in order, spilling what doesn't fit in the buffers", func() {
+		var extracted []string
+		err := streamLayers(make([]v1.Layer, 3), 3, 16, open, func(i int, r io.Reader) error {
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+
+			extracted = append(extracted, string(content))
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(extracted).To(Equal([]string{content(0), content(1), content(2)}))
+
+		Expect(ioutil.ReadDir(tmpDir)).To(BeEmpty())
+	})
+
+	It("should fail when a layer can't be downloaded", func() {
+		err := streamLayers(make([]v1.Layer, 3), 3, 16, func(i int, layer v1.Layer) (io.ReadCloser, error) {
+			if i == 1 {
+				return nil, errors.New("some error")
+			}
+
+			return open(i, layer)
+		}, func(i int, r io.Reader) error {
+			_, err := ioutil.ReadAll(r)
+			return err
+		})
+		Expect(err).To(MatchError("failed to extract layer 2: some error"))
+	})
+
+	It("should stop once a layer can't be extracted, removing any spilled downloads", func() {
+		var extracted []int
+		err := streamLayers(make([]v1.Layer, 3), 3, 16, open, func(i int, r io.Reader) error {
+			extracted = append(extracted, i)
+			return errors.New("some error")
+		})
+		Expect(err).To(MatchError("failed to extract layer 1: some error"))
+		Expect(extracted).To(Equal([]int{0}))
+
+		// downloads may still be running, writing to the closed pipes
+		Eventually(func() ([]os.FileInfo, error) {
+			return ioutil.ReadDir(tmpDir)
+		}).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("bufferedPipe", func() {
+	var tmpDir string
+	var oldTmpDir string
+
+	var pipe *bufferedPipe
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "buffered-pipe")
+		Expect(err).ToNot(HaveOccurred())
+
+		oldTmpDir = os.Getenv("TMPDIR")
+		os.Setenv("TMPDIR", tmpDir)
+
+		pipe = newBufferedPipe(4)
+	})
+
+	AfterEach(func() {
+		os.Setenv("TMPDIR", oldTmpDir)
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	write := func(content string) {
+		n, err := pipe.Write([]byte(content))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(len(content)))
+	}
+
+	read := func(n int) string {
+		p := make([]byte, n)
+		n, err := io.ReadFull(pipe, p)
+		Expect(err).ToNot(HaveOccurred())
+		return string(p[:n])
+	}
+
+	It("should buffer writes up to the limit in memory", func() {
+		write("abcd")
+		Expect(pipe.spill).To(BeNil())
+
+		pipe.CloseWrite(nil)
+		Expect(ioutil.ReadAll(pipe)).To(Equal([]byte("abcd")))
+	})
+
+	It("should spill writes past the limit to disk, and read them back in order", func() {
+		write("abcdef")
+		Expect(pipe.spill).ToNot(BeNil())
+		Expect(ioutil.ReadDir(tmpDir)).To(HaveLen(1))
+
+		Expect(read(2)).To(Equal("ab"))
+
+		// the spill is read first, so later writes go after it
+		write("gh")
+		write("ij")
+
+		Expect(read(8)).To(Equal("cdefghij"))
+
+		// once the spill has been read, the buffer is used again, and then
+		// the spill from the start
+		write("klmnop")
+		Expect(pipe.buf).To(Equal([]byte("klmn")))
+		Expect(pipe.spillWritten).To(Equal(int64(2)))
+
+		pipe.CloseWrite(nil)
+		Expect(ioutil.ReadAll(pipe)).To(Equal([]byte("klmnop")))
+	})
+
+	It("should remove the spill once everything has been read", func() {
+		write("abcdefgh")
+		pipe.CloseWrite(nil)
+
+		_, err := ioutil.ReadAll(pipe)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.ReadDir(tmpDir)).To(BeEmpty())
+	})
+
+	It("should fail reads with the writer's error once everything has been read", func() {
+		write("abcdef")
+		pipe.CloseWrite(errors.New("some error"))
+
+		content, err := ioutil.ReadAll(pipe)
+		Expect(err).To(MatchError("some error"))
+		Expect(string(content)).To(Equal("abcdef"))
+	})
+
+	It("should wait for writes", func() {
+		done := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			done <- read(2)
+		}()
+
+		Consistently(done).ShouldNot(Receive())
+
+		write("ab")
+		Eventually(done).Should(Receive(Equal("ab")))
+	})
+
+	Describe("CloseRead", func() {
+		It("should unblock waiting reads", func() {
+			errs := make(chan error)
+			go func() {
+				_, err := pipe.Read(make([]byte, 1))
+				errs <- err
+			}()
+
+			Consistently(errs).ShouldNot(Receive())
+
+			pipe.CloseRead()
+			Eventually(errs).Should(Receive(Equal(io.ErrClosedPipe)))
+		})
+
+		It("should fail further writes", func() {
+			pipe.CloseRead()
+
+			_, err := pipe.Write([]byte("abc"))
+			Expect(err).To(Equal(io.ErrClosedPipe))
+		})
+
+		It("should remove the spill", func() {
+			write("abcdefgh")
+			Expect(ioutil.ReadDir(tmpDir)).To(HaveLen(1))
+
+			pipe.CloseRead()
+			Expect(ioutil.ReadDir(tmpDir)).To(BeEmpty())
+		})
+	})
+})
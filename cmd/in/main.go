@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	resource "github.com/concourse/registry-image-resource"
 	color "github.com/fatih/color"
@@ -13,6 +14,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/sirupsen/logrus"
 )
@@ -51,8 +53,78 @@ func main() {
 		return
 	}
 
-	if req.Source.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
+	err = req.Source.ConfigureLogging()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.Interpolate(os.LookupEnv)
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.CheckAllowedRegistries()
+	if err != nil {
+		logrus.Errorf("registry not allowed: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.ConfigureTransport()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.Metrics != nil {
+		err = req.Source.Metrics.Validate()
+		if err != nil {
+			logrus.Errorf("invalid metrics: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Source.Tracing != nil {
+		err = req.Source.Tracing.Validate()
+		if err != nil {
+			logrus.Errorf("invalid tracing: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	metrics := req.Source.Metrics.Start("get")
+	trace := req.Source.Tracing.Start("get", os.Getenv)
+
+	timeout, err := req.Source.Timeout()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			logrus.Errorf("get timed out after %s", timeout)
+			os.Exit(1)
+		})
+	}
+
+	err = req.Source.PinDigest()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if pinned := req.Source.PinnedVersion(); pinned != nil {
+		req.Version = *pinned
 	}
 
 	if len(os.Args) < 2 {
@@ -63,6 +135,76 @@ func main() {
 
 	dest := os.Args[1]
 
+	switch req.Params.GenerateSBOM {
+	case "", resource.SBOMCycloneDX, resource.SBOMSPDX:
+	default:
+		logrus.Errorf("unknown generate_sbom format %q (supported: %s, %s)", req.Params.GenerateSBOM, resource.SBOMCycloneDX, resource.SBOMSPDX)
+		os.Exit(1)
+		return
+	}
+
+	var scanThreshold string
+	if req.Params.Scan != nil {
+		scanThreshold, err = req.Params.Scan.Threshold()
+		if err != nil {
+			logrus.Errorf("invalid scan config: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Version.Tag != "" {
+		// versions discovered from a list of tags refer to the tag they were
+		// found under rather than the source's tag
+		req.Source.RawTag = resource.Tag(req.Version.Tag)
+	}
+
+	initial := req.Source.IsInitialVersion(req.Version)
+	if initial || req.Params.SkipDownload {
+		tag := req.Source.Tag()
+		if initial {
+			// the seed version doesn't refer to an actual image; there's
+			// nothing to fetch
+			progressf(req.Source, "skipping fetch of initial version %s", color.YellowString(req.Version.Digest))
+
+			if req.Source.InitialTag != "" {
+				tag = req.Source.InitialTag
+			}
+		} else {
+			progressf(req.Source, "skipping download of %s@%s", color.GreenString(req.Source.Repository), color.YellowString(req.Version.Digest))
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dest, "tag"), []byte(tag), 0644)
+		if err != nil {
+			logrus.Errorf("failed to save image tag: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dest, "digest"), []byte(req.Version.Digest), 0644)
+		if err != nil {
+			logrus.Errorf("failed to save image digest: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dest, "repository"), []byte(req.Source.Repository), 0644)
+		if err != nil {
+			logrus.Errorf("failed to save image repository: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		metrics.Succeeded()
+		trace.Succeeded()
+
+		json.NewEncoder(os.Stdout).Encode(InResponse{
+			Version:  req.Version,
+			Metadata: req.Source.Metadata(),
+		})
+		return
+	}
+
 	ref := req.Source.Repository + "@" + req.Version.Digest
 
 	n, err := name.ParseReference(ref, name.WeakValidation)
@@ -72,33 +214,245 @@ func main() {
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "fetching %s@%s\n", color.GreenString(req.Source.Repository), color.YellowString(req.Version.Digest))
+	progressf(req.Source, "fetching %s@%s", color.GreenString(req.Source.Repository), color.YellowString(req.Version.Digest))
+
+	pullStart := time.Now()
+
+	auth, err := req.Source.Authenticator()
+	if err != nil {
+		logrus.Errorf("failed to configure registry credentials: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.IsGHCR() {
+		repo, err := name.NewRepository(req.Source.Repository, name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve repository: %s", err)
+			os.Exit(1)
+			return
+		}
 
-	auth := &authn.Basic{
-		Username: req.Source.Username,
-		Password: req.Source.Password,
+		auth = resource.PullAuthenticator(repo, auth, resource.RetryTransport)
 	}
 
 	imageOpts := []remote.ImageOption{
 		remote.WithTransport(resource.RetryTransport),
+		remote.WithAuth(auth),
 	}
 
-	if auth.Username != "" && auth.Password != "" {
-		imageOpts = append(imageOpts, remote.WithAuth(auth))
+	image, err := req.Source.MirroredImage(n, imageOpts...)
+	if err != nil {
+		logrus.Errorf("failed to locate remote image: %s", err)
+		os.Exit(1)
+		return
 	}
 
-	image, err := remote.Image(n, imageOpts...)
+	// signatures are the kinds of signatures the image has been verified with
+	var signatures []string
+
+	if req.Source.ContentTrust != nil && req.Source.PinnedVersion() == nil {
+		tag, err := name.NewTag(req.Source.Name(), name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve repository/tag reference: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		// the trust data only signs the tag's current image, so older versions
+		// were verified by the check that emitted them
+		current, err := resource.HeadManifest(tag, auth, resource.RetryTransport, req.Version.Digest)
+		if err != nil && !checkMissingManifest(err) {
+			logrus.Errorf("failed to resolve %s: %s", tag, err)
+			os.Exit(1)
+			return
+		}
+
+		if err == nil && current.String() == req.Version.Digest {
+			err = req.Source.ContentTrust.VerifyDigest(tag, auth, req.Version.Digest)
+			if err != nil {
+				logrus.Errorf("failed to verify content trust: %s", err)
+				os.Exit(1)
+				return
+			}
+
+			progressf(req.Source, "verified trust data for %s", color.GreenString(tag.String()))
+
+			signatures = append(signatures, "notary")
+		} else {
+			logrus.Infof("not verifying trust data: %s no longer tags %s", tag, req.Version.Digest)
+		}
+	}
+
+	digest, err := v1.NewHash(req.Version.Digest)
 	if err != nil {
-		logrus.Errorf("failed to locate remote image: %s", err)
+		logrus.Errorf("invalid version digest: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.Cosign != nil {
+		err = req.Source.VerifyCosign(digest, imageOpts...)
+		if err != nil {
+			logrus.Errorf("failed to verify image signature: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		progressf(req.Source, "verified cosign signature of %s", color.YellowString(req.Version.Digest))
+
+		signatures = append(signatures, "cosign")
+	}
+
+	if req.Source.Attestations != nil {
+		err = req.Source.VerifyAttestations(digest, imageOpts...)
+		if err != nil {
+			logrus.Errorf("failed to verify image attestations: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		progressf(req.Source, "verified attestations of %s", color.YellowString(req.Version.Digest))
+	}
+
+	fetch := func(digest v1.Hash) (v1.Image, error) {
+		ref, err := name.NewDigest(req.Source.Repository+"@"+digest.String(), name.WeakValidation)
+		if err != nil {
+			return nil, err
+		}
+
+		return req.Source.MirroredImage(ref, imageOpts...)
+	}
+
+	platform := req.Params.PlatformFor(req.Source)
+
+	platformImage, err := resource.ResolvePlatform(image, platform, func(digest v1.Hash) (v1.Image, error) {
+		progressf(req.Source, "fetching %s image %s", color.GreenString(platform.String()), color.YellowString(digest.String()))
+		return fetch(digest)
+	})
+	if err != nil {
+		logrus.Errorf("failed to resolve image for platform: %s", err)
 		os.Exit(1)
 		return
 	}
 
+	if req.Source.Policy != nil {
+		input, err := resource.NewPolicyInput("get", n.Context(), []string{req.Source.Tag()}, digest, []v1.Image{platformImage}, signatures)
+		if err != nil {
+			logrus.Errorf("failed to describe image for policy: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		err = req.Source.Policy.Evaluate("", input)
+		if err != nil {
+			logrus.Errorf("cannot fetch %s: %s", req.Version.Digest, err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Source.MaxImageSize != "" {
+		var size int64
+		if req.Params.Format() == "oci" && req.Params.AllPlatforms {
+			size, err = resource.TotalImageSize(image, fetch)
+		} else {
+			size, err = resource.ImageSize(platformImage)
+		}
+
+		if err != nil {
+			logrus.Errorf("failed to get image size: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		err = req.Source.CheckImageSize(size)
+		if err != nil {
+			logrus.Errorf("cannot fetch %s: %s", req.Version.Digest, err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Source.MaxAge != "" {
+		config, err := platformImage.ConfigFile()
+		if err != nil {
+			logrus.Errorf("failed to get image config: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		err = req.Source.CheckImageAge(config.Created.Time, time.Now())
+		if err != nil {
+			logrus.Errorf("cannot fetch %s: %s", req.Version.Digest, err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	// progress bars are only shown when unpacking a rootfs, and not when
+	// debugging or logging as JSON
+	if req.Params.Format() != "rootfs" || req.Source.Debug || req.Source.JSONLogs() {
+		platformImage, err = resource.WithProgress(platformImage, "downloading")
+		if err != nil {
+			logrus.Errorf("failed to get image layers: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
 	switch req.Params.Format() {
 	case "oci":
-		ociFormat(dest, req, image)
+		ociFormat(dest, req, platformImage)
+
+		if req.Params.AllPlatforms {
+			allPlatformsLayout(dest, req, image, fetch)
+		} else {
+			ociLayout(dest, req, platformImage)
+		}
+	case "oci-archive":
+		ociArchiveFormat(dest, req, platformImage)
+	case "docker-archive":
+		dockerArchiveFormat(dest, req, platformImage)
 	case "rootfs":
-		rootfsFormat(dest, req, image)
+		rootfsFormat(dest, req, platformImage)
+	case "artifact":
+		artifactFormat(dest, platformImage)
+	}
+
+	if req.Params.GenerateSBOM != "" || req.Params.Scan != nil {
+		packages, err := imagePackages(dest, req, platformImage)
+		if err != nil {
+			logrus.Errorf("failed to list image packages: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		if req.Params.GenerateSBOM != "" {
+			err = saveSBOM(dest, req, packages, digest)
+			if err != nil {
+				logrus.Errorf("failed to generate SBOM: %s", err)
+				os.Exit(1)
+				return
+			}
+		}
+
+		if req.Params.Scan != nil {
+			scan(dest, req, packages, scanThreshold)
+		}
+	}
+
+	if req.Params.Format() == "artifact" {
+		// an artifact's config needn't be an image config, or even JSON
+		err = saveRawConfig(dest, platformImage)
+	} else {
+		err = saveConfig(dest, platformImage)
+	}
+
+	if err != nil {
+		logrus.Errorf("failed to save image config: %s", err)
+		os.Exit(1)
+		return
 	}
 
 	err = ioutil.WriteFile(filepath.Join(dest, "tag"), []byte(req.Source.Tag()), 0644)
@@ -115,12 +469,62 @@ func main() {
 		return
 	}
 
+	err = saveManifest(dest, image)
+	if err != nil {
+		logrus.Errorf("failed to save image manifest: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dest, "repository"), []byte(req.Source.Repository), 0644)
+	if err != nil {
+		logrus.Errorf("failed to save image repository: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Params.Referrers != nil {
+		err = saveReferrers(dest, req, n.Context(), digest, auth, imageOpts)
+		if err != nil {
+			logrus.Errorf("failed to fetch referrers: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	layers, err := platformImage.Layers()
+	if err != nil {
+		logrus.Errorf("failed to get image layers: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	stats, err := resource.Transfers.Metadata("pull", time.Since(pullStart), layers)
+	if err != nil {
+		logrus.Errorf("failed to compute transfer statistics: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	metrics.Succeeded()
+	trace.Succeeded()
+
 	json.NewEncoder(os.Stdout).Encode(InResponse{
 		Version:  req.Version,
-		Metadata: req.Source.Metadata(),
+		Metadata: append(append(req.Source.Metadata(), stats...), resource.RateLimiter.Metadata()...),
 	})
 }
 
+// progressf prints progress to stderr, or logs it if logs are JSON lines.
+func progressf(source resource.Source, format string, args ...interface{}) {
+	if source.JSONLogs() {
+		logrus.Infof(format, args...)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
 func saveDigest(dest string, image v1.Image) error {
 	digest, err := image.Digest()
 	if err != nil {
@@ -131,15 +535,202 @@ func saveDigest(dest string, image v1.Image) error {
 	return ioutil.WriteFile(digestDest, []byte(digest.String()), 0644)
 }
 
-func ociFormat(dest string, req InRequest, image v1.Image) {
-	tag, err := name.NewTag(req.Source.Name(), name.WeakValidation)
+// saveManifest writes the raw manifest of the image, or of the image index or
+// manifest list, as manifest.json, so that it can be verified against the
+// digest.
+func saveManifest(dest string, image v1.Image) error {
+	rawManifest, err := image.RawManifest()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dest, "manifest.json"), rawManifest, 0644)
+}
+
+// saveReferrers lists the artifacts referring to the image in
+// referrers/referrers.json, and downloads each of them into a directory named
+// by its digest if configured to.
+func saveReferrers(dest string, req InRequest, repo name.Repository, digest v1.Hash, auth authn.Authenticator, imageOpts []remote.ImageOption) error {
+	tr := resource.NewTokenTransport(repo.Registry, auth, resource.RetryTransport, []string{
+		repo.Scope(transport.PullScope),
+	})
+
+	referrers, err := resource.ListReferrers(repo, digest, req.Params.Referrers.ArtifactTypes, tr)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(dest, "referrers")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(referrers)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "referrers.json"), encoded, 0644)
+	if err != nil {
+		return err
+	}
+
+	progressf(req.Source, "found %d referrers of %s", len(referrers), color.YellowString(digest.String()))
+
+	if !req.Params.Referrers.Download {
+		return nil
+	}
+
+	for _, referrer := range referrers {
+		ref, err := name.NewDigest(repo.Name()+"@"+referrer.Digest.String(), name.WeakValidation)
+		if err != nil {
+			return err
+		}
+
+		progressf(req.Source, "fetching %s referrer %s", color.GreenString(referrer.ArtifactType), color.YellowString(referrer.Digest.String()))
+
+		artifact, err := resource.RemoteImage(ref, imageOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to locate %s: %s", referrer.Digest, err)
+		}
+
+		err = resource.SaveArtifact(filepath.Join(dir, resource.ReferrersTag(referrer.Digest)), artifact)
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %s", referrer.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+// imagePackages lists the packages installed in the image, reading them from
+// the rootfs if it was extracted, or from the layers otherwise.
+func imagePackages(dest string, req InRequest, image v1.Image) ([]resource.Package, error) {
+	if req.Params.Format() == "rootfs" {
+		return resource.DirPackages(filepath.Join(dest, "rootfs"))
+	}
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	progressf(req.Source, "scanning %d layers for packages", len(layers))
+
+	return resource.LayerPackages(layers)
+}
+
+// saveSBOM writes an SBOM of the packages installed in the image as sbom.json.
+func saveSBOM(dest string, req InRequest, packages []resource.Package, digest v1.Hash) error {
+	sbom, err := os.Create(filepath.Join(dest, "sbom.json"))
+	if err != nil {
+		return err
+	}
+
+	defer sbom.Close()
+
+	err = resource.WriteSBOM(sbom, req.Params.GenerateSBOM, resource.SBOMSubject{
+		Repository: req.Source.Repository,
+		Digest:     digest,
+	}, packages, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return sbom.Close()
+}
+
+// scan writes the vulnerabilities found in the packages as
+// vulnerabilities.json, failing the get if any are at or above the threshold.
+func scan(dest string, req InRequest, packages []resource.Package, threshold string) {
+	vulns, err := req.Params.Scan.Scan(packages)
+	if err != nil {
+		logrus.Errorf("failed to scan image: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if vulns == nil {
+		vulns = []resource.Vulnerability{}
+	}
+
+	report, err := json.MarshalIndent(vulns, "", "  ")
+	if err != nil {
+		logrus.Errorf("failed to encode vulnerabilities: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dest, "vulnerabilities.json"), report, 0644)
+	if err != nil {
+		logrus.Errorf("failed to save vulnerabilities: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	exceeding := resource.Exceeding(vulns, threshold)
+	if len(exceeding) == 0 {
+		progressf(req.Source, "found %d vulnerabilities in %d packages, none %s or above", len(vulns), len(packages), threshold)
+		return
+	}
+
+	for _, vuln := range exceeding {
+		logrus.Errorf("%s: %s %s is affected by %s (%s)", vuln.Severity, vuln.Package, vuln.Version, vuln.ID, vuln.Summary)
+	}
+
+	logrus.Errorf("found %d vulnerabilities of severity %s or above", len(exceeding), threshold)
+	os.Exit(1)
+}
+
+// saveRawConfig writes the image's config as config.json, as is, e.g. for
+// artifacts whose config isn't an image config.
+func saveRawConfig(dest string, image v1.Image) error {
+	rawConfig, err := image.RawConfigFile()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dest, "config.json"), rawConfig, 0644)
+}
+
+// saveConfig writes the image's config as config.json, and its labels as
+// labels.json.
+func saveConfig(dest string, image v1.Image) error {
+	err := saveRawConfig(dest, image)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := image.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	labels := cfg.Config.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dest, "labels.json"), labelsJSON, 0644)
+}
+
+func artifactFormat(dest string, image v1.Image) {
+	err := resource.SaveArtifact(filepath.Join(dest, "artifact"), image)
 	if err != nil {
-		logrus.Errorf("failed to construct tag reference: %s", err)
+		logrus.Errorf("failed to save artifact: %s", err)
 		os.Exit(1)
 		return
 	}
+}
 
-	err = tarball.WriteToFile(filepath.Join(dest, "image.tar"), tag, image)
+func ociFormat(dest string, req InRequest, image v1.Image) {
+	err := writeDockerArchive(filepath.Join(dest, "image.tar"), req, image)
 	if err != nil {
 		logrus.Errorf("failed to write OCI image: %s", err)
 		os.Exit(1)
@@ -147,8 +738,82 @@ func ociFormat(dest string, req InRequest, image v1.Image) {
 	}
 }
 
+func ociLayout(dest string, req InRequest, image v1.Image) {
+	err := resource.WriteLayout(filepath.Join(dest, "oci"), req.Source.Tag(), image)
+	if err != nil {
+		logrus.Errorf("failed to write OCI image layout: %s", err)
+		os.Exit(1)
+		return
+	}
+}
+
+func allPlatformsLayout(dest string, req InRequest, image v1.Image, fetch func(v1.Hash) (v1.Image, error)) {
+	index, err := resource.IsIndex(image)
+	if err != nil {
+		logrus.Errorf("failed to inspect image manifest: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if !index {
+		ociLayout(dest, req, image)
+		return
+	}
+
+	err = resource.WriteIndexLayout(filepath.Join(dest, "oci"), req.Source.Tag(), image, func(digest v1.Hash) (v1.Image, error) {
+		progressf(req.Source, "fetching manifest %s", color.YellowString(digest.String()))
+		return fetch(digest)
+	})
+	if err != nil {
+		logrus.Errorf("failed to write OCI image layout: %s", err)
+		os.Exit(1)
+		return
+	}
+}
+
+func dockerArchiveFormat(dest string, req InRequest, image v1.Image) {
+	err := writeDockerArchive(filepath.Join(dest, "image.tar"), req, image)
+	if err != nil {
+		logrus.Errorf("failed to write docker archive: %s", err)
+		os.Exit(1)
+		return
+	}
+}
+
+// writeDockerArchive writes the image as a tarball for `docker load`, tagged
+// with the source's tag and any additional tags.
+func writeDockerArchive(path string, req InRequest, image v1.Image) error {
+	tags := map[name.Tag]v1.Image{}
+	for _, t := range append([]string{req.Source.Tag()}, req.Params.AdditionalTags...) {
+		tag, err := name.NewTag(req.Source.Repository+":"+t, name.WeakValidation)
+		if err != nil {
+			return fmt.Errorf("failed to construct tag reference: %s", err)
+		}
+
+		tags[tag] = image
+	}
+
+	return tarball.MultiWriteToFile(path, tags)
+}
+
+func ociArchiveFormat(dest string, req InRequest, image v1.Image) {
+	err := resource.WriteLayoutArchive(filepath.Join(dest, "image.tar"), req.Source.Tag(), image)
+	if err != nil {
+		logrus.Errorf("failed to write OCI archive: %s", err)
+		os.Exit(1)
+		return
+	}
+}
+
 func rootfsFormat(dest string, req InRequest, image v1.Image) {
-	err := unpackImage(filepath.Join(dest, "rootfs"), image, req.Source.Debug)
+	cache, err := req.Source.BlobCache()
+	if err != nil {
+		logrus.Errorf("failed to configure blob cache: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = unpackImage(filepath.Join(dest, "rootfs"), image, req.Source, req.Params, cache)
 	if err != nil {
 		logrus.Errorf("failed to extract image: %s", err)
 		os.Exit(1)
@@ -196,3 +861,16 @@ func rootfsFormat(dest string, req InRequest, image v1.Image) {
 		return
 	}
 }
+
+func checkMissingManifest(err error) bool {
+	var missing bool
+	if rErr, ok := err.(*remote.Error); ok {
+		for _, e := range rErr.Errors {
+			if e.Code == remote.ManifestUnknownErrorCode {
+				missing = true
+				break
+			}
+		}
+	}
+	return missing
+}
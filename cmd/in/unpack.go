@@ -2,7 +2,7 @@ package main
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/concourse/go-archive/tarfs"
+	resource "github.com/concourse/registry-image-resource"
 	"github.com/fatih/color"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/sirupsen/logrus"
@@ -19,21 +20,121 @@ import (
 
 const whiteoutPrefix = ".wh."
 
-func unpackImage(dest string, img v1.Image, debug bool) error {
+// setuidBits are the setuid and setgid bits of a tar entry's mode.
+const setuidBits = 06000
+
+// extractOptions control how layers are flattened into the rootfs.
+type extractOptions struct {
+	chown          bool
+	stripSetuid    bool
+	skipDevices    bool
+	preserveXattrs bool
+
+	// estargz is set for eStargz layers, whose lazy-pulling metadata files
+	// are left out of the rootfs
+	estargz bool
+
+	limits *sizeLimits
+}
+
+// sizeLimits bounds the size of the layers extracted into the rootfs, so that
+// a malicious or bloated image can't fill up the worker's disk. Sizes are
+// checked both compressed, before downloading, and uncompressed, while
+// extracting, as a small layer may decompress to something huge.
+type sizeLimits struct {
+	maxLayer int64
+	maxTotal int64
+
+	compressed   int64
+	uncompressed int64
+}
+
+func (limits *sizeLimits) checkCompressed(size int64) error {
+	limits.compressed += size
+	return limits.check(size, limits.compressed)
+}
+
+func (limits *sizeLimits) check(layer int64, total int64) error {
+	if limits.maxLayer != 0 && layer > limits.maxLayer {
+		return fmt.Errorf("layer exceeds max_layer_size of %d bytes", limits.maxLayer)
+	}
+
+	if limits.maxTotal != 0 && total > limits.maxTotal {
+		return fmt.Errorf("image exceeds max_total_size of %d bytes", limits.maxTotal)
+	}
+
+	return nil
+}
+
+// limitedReader fails once more of a layer has been read than the limits
+// allow.
+type limitedReader struct {
+	r      io.Reader
+	limits *sizeLimits
+	read   int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	r.limits.uncompressed += int64(n)
+
+	if limitErr := r.limits.check(r.read, r.limits.uncompressed); limitErr != nil {
+		return n, limitErr
+	}
+
+	return n, err
+}
+
+// forLayer returns the options for extracting the i-th layer.
+func (opts extractOptions) forLayer(i int, estargz []bool) extractOptions {
+	opts.estargz = i < len(estargz) && estargz[i]
+	return opts
+}
+
+func unpackImage(dest string, img v1.Image, source resource.Source, params resource.GetParams, cache *resource.BlobCache) error {
 	layers, err := img.Layers()
 	if err != nil {
 		return err
 	}
 
-	chown := os.Getuid() == 0
+	opts := extractOptions{
+		chown:          os.Getuid() == 0,
+		stripSetuid:    params.StripSetuid,
+		skipDevices:    params.SkipsDeviceFiles(),
+		preserveXattrs: params.PreserveXattrs,
+	}
+
+	maxLayer, maxTotal, err := params.SizeLimits()
+	if err != nil {
+		return err
+	}
+
+	if maxLayer != 0 || maxTotal != 0 {
+		opts.limits = &sizeLimits{
+			maxLayer: maxLayer,
+			maxTotal: maxTotal,
+		}
+	}
+
+	estargz, err := resource.EstargzLayers(img)
+	if err != nil {
+		return err
+	}
 
 	var out io.Writer
-	if debug {
+	if source.Debug || source.JSONLogs() {
 		out = ioutil.Discard
 	} else {
 		out = os.Stderr
 	}
 
+	// without progress bars, JSON logs report each layer instead
+	logLayer := logrus.Debugf
+	if source.JSONLogs() {
+		logLayer = logrus.Infof
+	}
+
 	progress := mpb.New(mpb.WithOutput(out))
 
 	bars := make([]*mpb.Bar, len(layers))
@@ -49,6 +150,13 @@ func unpackImage(dest string, img v1.Image, debug bool) error {
 			return err
 		}
 
+		if opts.limits != nil {
+			err = opts.limits.checkCompressed(size)
+			if err != nil {
+				return fmt.Errorf("layer %s: %s", digest, err)
+			}
+		}
+
 		bars[i] = progress.AddBar(
 			size,
 			mpb.PrependDecorators(decor.Name(color.HiBlackString(digest.Hex[0:12]))),
@@ -56,15 +164,35 @@ func unpackImage(dest string, img v1.Image, debug bool) error {
 		)
 	}
 
-	// iterate over layers in reverse order; no need to write things files that
-	// are modified by later layers anyway
-	for i, layer := range layers {
-		logrus.Debugf("extracting layer %d of %d", i+1, len(layers))
-
-		err := extractLayer(dest, layer, bars[i], chown)
+	if cache != nil {
+		err := downloadLayers(layers, params.DownloadConcurrency(), func(i int, layer v1.Layer) (string, error) {
+			return cacheLayer(cache, layer, bars[i])
+		}, func(i int, path string) error {
+			logLayer("extracting layer %d of %d", i+1, len(layers))
+			return extractLayerFile(dest, path, opts.forLayer(i, estargz))
+		})
 		if err != nil {
 			return err
 		}
+
+		progress.Wait()
+
+		return cache.Evict()
+	}
+
+	err = streamLayers(layers, params.DownloadConcurrency(), prefetchBufferSize, func(i int, layer v1.Layer) (io.ReadCloser, error) {
+		r, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+
+		return bars[i].ProxyReader(r), nil
+	}, func(i int, r io.Reader) error {
+		logLayer("extracting layer %d of %d", i+1, len(layers))
+		return extractArchive(dest, r, opts.forLayer(i, estargz))
+	})
+	if err != nil {
+		return err
 	}
 
 	progress.Wait()
@@ -72,18 +200,29 @@ func unpackImage(dest string, img v1.Image, debug bool) error {
 	return nil
 }
 
-func extractLayer(dest string, layer v1.Layer, bar *mpb.Bar, chown bool) error {
-	r, err := layer.Compressed()
+func extractLayerFile(dest string, path string, opts extractOptions) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 
-	gr, err := gzip.NewReader(bar.ProxyReader(r))
+	defer file.Close()
+
+	return extractArchive(dest, file, opts)
+}
+
+func extractArchive(dest string, r io.Reader, opts extractOptions) error {
+	lr, err := resource.DecompressLayer(r)
 	if err != nil {
 		return err
 	}
 
-	tr := tar.NewReader(gr)
+	var tarStream io.Reader = lr
+	if opts.limits != nil {
+		tarStream = &limitedReader{r: lr, limits: opts.limits}
+	}
+
+	tr := tar.NewReader(tarStream)
 
 	for {
 		hdr, err := tr.Next()
@@ -103,6 +242,11 @@ func extractLayer(dest string, layer v1.Layer, bar *mpb.Bar, chown bool) error {
 			"Name": hdr.Name,
 		})
 
+		if opts.estargz && resource.IsEstargzEntry(hdr.Name) {
+			log.Debug("skipping eStargz metadata")
+			continue
+		}
+
 		log.Debug("unpacking")
 
 		if strings.HasPrefix(base, whiteoutPrefix) {
@@ -120,12 +264,17 @@ func extractLayer(dest string, layer v1.Layer, bar *mpb.Bar, chown bool) error {
 			continue
 		}
 
-		if hdr.Typeflag == tar.TypeBlock || hdr.Typeflag == tar.TypeChar {
+		if opts.skipDevices && (hdr.Typeflag == tar.TypeBlock || hdr.Typeflag == tar.TypeChar) {
 			// devices can't be created in a user namespace
 			log.Debugf("skipping device %s", hdr.Name)
 			continue
 		}
 
+		if opts.stripSetuid && hdr.Mode&setuidBits != 0 {
+			log.Debugf("stripping setuid/setgid bits")
+			hdr.Mode &^= setuidBits
+		}
+
 		if hdr.Typeflag == tar.TypeSymlink {
 			log.Debugf("symlinking to %s", hdr.Linkname)
 		}
@@ -147,21 +296,17 @@ func extractLayer(dest string, layer v1.Layer, bar *mpb.Bar, chown bool) error {
 			}
 		}
 
-		if err := tarfs.ExtractEntry(hdr, dest, tr, chown); err != nil {
+		if err := tarfs.ExtractEntry(hdr, dest, tr, opts.chown); err != nil {
 			log.Debugf("extracting")
 			return err
 		}
-	}
 
-	err = gr.Close()
-	if err != nil {
-		return err
-	}
-
-	err = r.Close()
-	if err != nil {
-		return err
+		if opts.preserveXattrs {
+			if err := setXattrs(hdr, path); err != nil {
+				return fmt.Errorf("failed to set extended attributes of %s: %s", hdr.Name, err)
+			}
+		}
 	}
 
-	return nil
+	return lr.Close()
 }
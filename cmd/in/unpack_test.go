@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	resource "github.com/concourse/registry-image-resource"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// layerEntry is a file in a layer built by tarLayer.
+type layerEntry struct {
+	header  tar.Header
+	content string
+}
+
+func tarLayer(entries ...layerEntry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		hdr := entry.header
+		hdr.Size = int64(len(entry.content))
+
+		Expect(tw.WriteHeader(&hdr)).To(Succeed())
+
+		_, err := tw.Write([]byte(entry.content))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	Expect(tw.Close()).To(Succeed())
+
+	return buf
+}
+
+var _ = Describe("extractArchive", func() {
+	var dest string
+
+	BeforeEach(func() {
+		var err error
+		dest, err = ioutil.TempDir("", "rootfs")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dest)).To(Succeed())
+	})
+
+	Describe("device files", func() {
+		var layer *bytes.Buffer
+
+		BeforeEach(func() {
+			layer = tarLayer(
+				layerEntry{header: tar.Header{Name: "dev/", Typeflag: tar.TypeDir, Mode: 0755}},
+				layerEntry{header: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+				layerEntry{header: tar.Header{Name: "some-file", Typeflag: tar.TypeReg, Mode: 0644}, content: "some-content"},
+			)
+		})
+
+		It("should skip them with skip_device_files", func() {
+			Expect(extractArchive(dest, layer, extractOptions{skipDevices: true})).To(Succeed())
+
+			_, err := os.Lstat(filepath.Join(dest, "dev", "null"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			Expect(ioutil.ReadFile(filepath.Join(dest, "some-file"))).To(Equal([]byte("some-content")))
+		})
+
+		It("should create them otherwise", func() {
+			if os.Getuid() != 0 {
+				Skip("devices can only be created as root")
+			}
+
+			Expect(extractArchive(dest, layer, extractOptions{})).To(Succeed())
+
+			info, err := os.Lstat(filepath.Join(dest, "dev", "null"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode() & os.ModeCharDevice).ToNot(BeZero())
+		})
+	})
+
+	Describe("setuid files", func() {
+		var layer *bytes.Buffer
+
+		BeforeEach(func() {
+			layer = tarLayer(
+				layerEntry{header: tar.Header{Name: "some-binary", Typeflag: tar.TypeReg, Mode: 06755}, content: "some-content"},
+			)
+		})
+
+		It("should strip the setuid and setgid bits with strip_setuid", func() {
+			Expect(extractArchive(dest, layer, extractOptions{stripSetuid: true})).To(Succeed())
+
+			info, err := os.Lstat(filepath.Join(dest, "some-binary"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode() & (os.ModeSetuid | os.ModeSetgid)).To(BeZero())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		})
+
+		It("should keep them otherwise", func() {
+			Expect(extractArchive(dest, layer, extractOptions{})).To(Succeed())
+
+			info, err := os.Lstat(filepath.Join(dest, "some-binary"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode() & os.ModeSetuid).ToNot(BeZero())
+			Expect(info.Mode() & os.ModeSetgid).ToNot(BeZero())
+		})
+	})
+})
+
+var _ = Describe("unpackImage with size limits", func() {
+	var dest string
+	var opens int32
+	var img v1.Image
+
+	// each layer is a few kilobytes of zeroes compressed, but 4MB extracted
+	bomb := func(name string) v1.Layer {
+		buf := new(bytes.Buffer)
+
+		zw := gzip.NewWriter(buf)
+		_, err := io.Copy(zw, tarLayer(layerEntry{
+			header:  tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644},
+			content: strings.Repeat("\x00", 4*1024*1024),
+		}))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			atomic.AddInt32(&opens, 1)
+			return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		return layer
+	}
+
+	BeforeEach(func() {
+		var err error
+		dest, err = ioutil.TempDir("", "rootfs")
+		Expect(err).ToNot(HaveOccurred())
+
+		img, err = mutate.AppendLayers(empty.Image, bomb("some-file"), bomb("some-other-file"))
+		Expect(err).ToNot(HaveOccurred())
+
+		atomic.StoreInt32(&opens, 0)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dest)).To(Succeed())
+	})
+
+	unpack := func(params resource.GetParams) error {
+		return unpackImage(dest, img, resource.Source{Debug: true}, params, nil)
+	}
+
+	It("should extract layers within the limits", func() {
+		Expect(unpack(resource.GetParams{MaxLayerSize: "5MB", MaxTotalSize: "10MB"})).To(Succeed())
+
+		info, err := os.Stat(filepath.Join(dest, "some-other-file"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Size()).To(Equal(int64(4 * 1024 * 1024)))
+	})
+
+	It("should stop extracting a layer which decompresses beyond max_layer_size", func() {
+		err := unpack(resource.GetParams{MaxLayerSize: "1MB"})
+		Expect(err).To(MatchError(ContainSubstring("layer exceeds max_layer_size")))
+
+		_, err = os.Stat(filepath.Join(dest, "some-other-file"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should stop extracting once the layers decompress beyond max_total_size", func() {
+		err := unpack(resource.GetParams{MaxTotalSize: "6MB"})
+		Expect(err).To(MatchError(ContainSubstring("image exceeds max_total_size")))
+	})
+
+	It("should not download layers which are too large compressed", func() {
+		err := unpack(resource.GetParams{MaxLayerSize: "1KB"})
+		Expect(err).To(MatchError(ContainSubstring("layer exceeds max_layer_size")))
+		Expect(atomic.LoadInt32(&opens)).To(BeZero())
+	})
+})
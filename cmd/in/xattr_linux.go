@@ -0,0 +1,31 @@
+package main
+
+import (
+	"archive/tar"
+	"strings"
+	"syscall"
+)
+
+const xattrPrefix = "SCHILY.xattr."
+
+// setXattrs applies the extended attributes recorded in the entry's PAX
+// headers to the extracted file. Symlinks are skipped, as setting attributes
+// on them isn't generally permitted.
+func setXattrs(hdr *tar.Header, path string) error {
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+
+		err := syscall.Setxattr(path, strings.TrimPrefix(key, xattrPrefix), []byte(value), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("extractArchive with extended attributes", func() {
+	var dest string
+	var layer *bytes.Buffer
+
+	BeforeEach(func() {
+		var err error
+		dest, err = ioutil.TempDir("", "rootfs")
+		Expect(err).ToNot(HaveOccurred())
+
+		probe := filepath.Join(dest, "probe")
+		Expect(ioutil.WriteFile(probe, nil, 0644)).To(Succeed())
+
+		if syscall.Setxattr(probe, "user.probe", []byte("probe"), 0) != nil {
+			Skip("the filesystem doesn't support extended attributes")
+		}
+
+		Expect(os.Remove(probe)).To(Succeed())
+
+		layer = tarLayer(layerEntry{
+			header: tar.Header{
+				Name:       "some-file",
+				Typeflag:   tar.TypeReg,
+				Mode:       0644,
+				Format:     tar.FormatPAX,
+				PAXRecords: map[string]string{"SCHILY.xattr.user.some-attr": "some-value"},
+			},
+			content: "some-content",
+		})
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dest)).To(Succeed())
+	})
+
+	xattr := func(name string) (string, error) {
+		value := make([]byte, 64)
+		n, err := syscall.Getxattr(filepath.Join(dest, "some-file"), name, value)
+		if err != nil {
+			return "", err
+		}
+
+		return string(value[:n]), nil
+	}
+
+	It("should set them with preserve_xattrs", func() {
+		Expect(extractArchive(dest, layer, extractOptions{preserveXattrs: true})).To(Succeed())
+
+		Expect(xattr("user.some-attr")).To(Equal("some-value"))
+	})
+
+	It("should leave them out otherwise", func() {
+		Expect(extractArchive(dest, layer, extractOptions{})).To(Succeed())
+
+		_, err := xattr("user.some-attr")
+		Expect(err).To(Equal(syscall.ENODATA))
+	})
+})
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"archive/tar"
+)
+
+// setXattrs is a no-op on platforms without extended attribute support.
+func setXattrs(hdr *tar.Header, path string) error {
+	return nil
+}
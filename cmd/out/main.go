@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/sirupsen/logrus"
 
 	resource "github.com/concourse/registry-image-resource"
@@ -46,8 +53,80 @@ func main() {
 		return
 	}
 
-	if req.Source.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
+	err = req.Source.ConfigureLogging()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.Interpolate(os.LookupEnv)
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.CheckAllowedRegistries()
+	if err != nil {
+		logrus.Errorf("registry not allowed: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	err = req.Source.ConfigureTransport()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.Metrics != nil {
+		err = req.Source.Metrics.Validate()
+		if err != nil {
+			logrus.Errorf("invalid metrics: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Source.Tracing != nil {
+		err = req.Source.Tracing.Validate()
+		if err != nil {
+			logrus.Errorf("invalid tracing: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	metrics := req.Source.Metrics.Start("put")
+	trace := req.Source.Tracing.Start("put", os.Getenv)
+
+	timeout, err := req.Source.Timeout()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			logrus.Errorf("put timed out after %s", timeout)
+			os.Exit(1)
+		})
+	}
+
+	err = req.Source.PinDigest()
+	if err != nil {
+		logrus.Errorf("invalid source: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.PinnedVersion() != nil {
+		logrus.Errorf("cannot push to a digest-pinned source")
+		os.Exit(1)
+		return
 	}
 
 	if len(os.Args) < 2 {
@@ -58,6 +137,17 @@ func main() {
 
 	src := os.Args[1]
 
+	tag, err := req.Params.ParseTag(src, req.Source)
+	if err != nil {
+		logrus.Errorf("could not determine tag: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	// the tag may be given at push time, so push under it as if it were
+	// configured
+	req.Source.RawTag = resource.Tag(tag)
+
 	ref, err := name.ParseReference(req.Source.Name(), name.WeakValidation)
 	if err != nil {
 		logrus.Errorf("could not resolve repository/tag reference: %s", err)
@@ -72,6 +162,36 @@ func main() {
 		return
 	}
 
+	additionalRepos, err := req.Params.ParseRepositories(src, req.Source)
+	if err != nil {
+		logrus.Errorf("could not parse additional repositories: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	for _, repo := range additionalRepos {
+		err = req.Source.AllowRegistry(repo.Repository)
+		if err != nil {
+			logrus.Errorf("registry not allowed: additional repository %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Params.PushByDigest {
+		if len(tags) > 0 || req.Params.TagFile != "" || req.Params.BumpAliases {
+			logrus.Errorf("additional_tags, tag_file, and bump_aliases cannot be used with push_by_digest")
+			os.Exit(1)
+			return
+		}
+
+		if req.Source.ContentTrust != nil {
+			logrus.Errorf("content_trust cannot be used with push_by_digest, as only tags can be signed")
+			os.Exit(1)
+			return
+		}
+	}
+
 	var extraRefs []name.Reference
 	for _, tag := range tags {
 		n := fmt.Sprintf("%s:%s", req.Source.Repository, tag)
@@ -86,87 +206,1674 @@ func main() {
 		extraRefs = append(extraRefs, extraRef)
 	}
 
-	imagePath := filepath.Join(src, req.Params.Image)
-
-	img, err := tarball.ImageFromPath(imagePath, nil)
+	platformImages, err := req.Params.PlatformImages()
 	if err != nil {
-		logrus.Errorf("could not load image from path '%s': %s", req.Params.Image, err)
+		logrus.Errorf("invalid params: %s", err)
 		os.Exit(1)
 		return
 	}
 
-	digest, err := img.Digest()
-	if err != nil {
-		logrus.Errorf("failed to get image digest: %s", err)
+	if req.Params.CopyFrom != nil || req.Params.DigestFile != "" {
+		if len(req.Params.Labels) > 0 || len(req.Params.Annotations) > 0 || req.Params.AddBuildMetadataLabels {
+			logrus.Errorf("labels, annotations, and add_build_metadata_labels cannot be used with copy_from or digest_file, as the image is pushed as is")
+			os.Exit(1)
+			return
+		}
+
+		if req.Params.Compression != "" {
+			logrus.Errorf("compression cannot be used with copy_from or digest_file, as the image is pushed as is")
+			os.Exit(1)
+			return
+		}
+	}
+
+	if len(req.Params.Files) > 0 {
+		if len(req.Params.Labels) > 0 || req.Params.AddBuildMetadataLabels || req.Params.Compression != "" || req.Params.RecompressZstd || req.Params.GenerateSBOM != "" {
+			logrus.Errorf("labels, add_build_metadata_labels, compression, recompress_zstd, and generate_sbom cannot be used with files, as artifacts aren't images")
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Params.Compression != "" {
+		err = resource.ValidateCompression(req.Params.Compression, req.Params.CompressionLevel)
+		if err != nil {
+			logrus.Errorf("invalid params: %s", err)
+			os.Exit(1)
+			return
+		}
+	} else if req.Params.CompressionLevel != 0 {
+		logrus.Errorf("compression_level requires compression")
+		os.Exit(1)
+		return
+	}
+
+	if req.Params.SBOM != "" && req.Params.GenerateSBOM != "" {
+		logrus.Errorf("sbom and generate_sbom are mutually exclusive")
+		os.Exit(1)
+		return
+	}
+
+	switch req.Params.GenerateSBOM {
+	case "", resource.SBOMCycloneDX, resource.SBOMSPDX:
+	default:
+		logrus.Errorf("unknown generate_sbom format %q (supported: %s, %s)", req.Params.GenerateSBOM, resource.SBOMCycloneDX, resource.SBOMSPDX)
 		os.Exit(1)
 		return
 	}
 
-	logrus.Infof("pushing %s to %s", digest, ref.Name())
+	if req.Params.Provenance != "" || req.Params.GenerateProvenance {
+		if req.Params.Provenance != "" && req.Params.GenerateProvenance {
+			logrus.Errorf("provenance and generate_provenance are mutually exclusive")
+			os.Exit(1)
+			return
+		}
+
+		if req.Params.Cosign == nil {
+			logrus.Errorf("provenance and generate_provenance require cosign, to sign the attestation")
+			os.Exit(1)
+			return
+		}
+	}
 
-	auth := &authn.Basic{
-		Username: req.Source.Username,
-		Password: req.Source.Password,
+	switch req.Params.OnImmutableConflict {
+	case "", resource.ImmutableConflictFail, resource.ImmutableConflictSkip:
+	default:
+		logrus.Errorf("unknown on_immutable_conflict %q (supported: %s, %s)", req.Params.OnImmutableConflict, resource.ImmutableConflictFail, resource.ImmutableConflictSkip)
+		os.Exit(1)
+		return
 	}
 
-	err = remote.Write(ref, img, auth, resource.RetryTransport)
+	err = resource.ValidatePushFailurePolicy(req.Params.OnPushFailure)
 	if err != nil {
-		logrus.Errorf("failed to upload image: %s", err)
+		logrus.Errorf("invalid params: %s", err)
 		os.Exit(1)
 		return
 	}
 
-	logrus.Info("pushed")
+	for _, attachment := range req.Params.Attach {
+		if attachment.File == "" || attachment.ArtifactType == "" {
+			logrus.Errorf("attach requires file and artifact_type")
+			os.Exit(1)
+			return
+		}
+	}
 
-	var notaryConfigDir string
-	if req.Source.ContentTrust != nil {
-		notaryConfigDir, err = req.Source.ContentTrust.PrepareConfigDir(src)
+	if req.Params.CopyFrom != nil && req.Source.MaxConcurrency > 0 && sameRegistry(req.Params.CopyFrom.Repository, req.Source.Repository) {
+		// each blob is downloaded while it's uploaded, so both would wait for
+		// connections held by the other
+		logrus.Errorf("max_concurrency cannot be used with copy_from within the same registry")
+		os.Exit(1)
+		return
+	}
+
+	if req.Params.CopyFrom != nil {
+		err = req.Source.AllowRegistry(req.Params.CopyFrom.Repository)
 		if err != nil {
-			logrus.Errorf("failed to prepare notary-config-dir: %s", err)
+			logrus.Errorf("registry not allowed: copy_from %s", err)
 			os.Exit(1)
 			return
 		}
-		trustedRepo, err := gcr.NewTrustedGcrRepository(notaryConfigDir, ref, auth)
+
+		err = req.Params.CopyFrom.PinDigest()
 		if err != nil {
-			logrus.Errorf("failed to create TrustedGcrRepository: %s", err)
+			logrus.Errorf("invalid copy_from: %s", err)
 			os.Exit(1)
 			return
 		}
-		err = trustedRepo.SignImage(img)
+	}
+
+	auth, err := req.Source.Authenticator()
+	if err != nil {
+		logrus.Errorf("failed to configure registry credentials: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Params.CreateRepository {
+		ecrAuth, ok := auth.(*resource.ECRAuthenticator)
+		if !ok {
+			logrus.Errorf("create_repository is only supported for ECR repositories, with AWS credentials configured")
+			os.Exit(1)
+			return
+		}
+
+		var settings resource.ECRRepositorySettings
+		if req.Params.RepositorySettings != nil {
+			settings = *req.Params.RepositorySettings
+		}
+
+		created, err := ecrAuth.EnsureRepository(ref.Context().RepositoryStr(), settings)
 		if err != nil {
-			logrus.Errorf("failed to sign image: %s", err)
+			logrus.Errorf("failed to create repository: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		if created {
+			logrus.Infof("created ECR repository %s", ref.Context().Name())
 		}
+	} else if req.Params.RepositorySettings != nil {
+		logrus.Errorf("repository_settings requires create_repository")
+		os.Exit(1)
+		return
 	}
 
-	for _, extraRef := range extraRefs {
-		logrus.Infof("tagging %s with %s", digest, extraRef.Identifier())
+	chunkSize, err := req.Params.ChunkSize()
+	if err != nil {
+		logrus.Errorf("invalid params: %s", err)
+		os.Exit(1)
+		return
+	}
 
-		err = remote.Write(extraRef, img, auth, http.DefaultTransport)
+	var inner http.RoundTripper = resource.RetryTransport
+	if req.Source.Retry != nil {
+		err = req.Source.Retry.Validate()
 		if err != nil {
-			logrus.Errorf("failed to tag image: %s", err)
+			logrus.Errorf("invalid retry: %s", err)
 			os.Exit(1)
 			return
 		}
 
-		logrus.Info("tagged")
-		if req.Source.ContentTrust != nil {
-			trustedRepo, err := gcr.NewTrustedGcrRepository(notaryConfigDir, extraRef, auth)
+		inner = &resource.StatusRetryTransport{
+			Inner:  inner,
+			Policy: req.Source.Retry,
+		}
+	}
+
+	if chunkSize > 0 {
+		inner = &resource.ChunkedUploadTransport{
+			Inner:     inner,
+			ChunkSize: chunkSize,
+		}
+	}
+
+	// limit uploads of whole blobs, rather than of their chunks
+	inner = &resource.UploadLimitTransport{
+		Inner:         inner,
+		MaxConcurrent: req.Params.UploadConcurrency(),
+	}
+
+	scopes := []string{
+		ref.Scope(transport.PushScope),
+	}
+
+	var mountFrom []name.Repository
+	for _, from := range req.Params.MountFrom {
+		repo, err := name.NewRepository(from, name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve mount_from repository: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		if repo.RegistryStr() != ref.Context().RegistryStr() {
+			logrus.Errorf("cannot mount blobs from %s, as it is in another registry than %s", repo.Name(), ref.Context().Name())
+			os.Exit(1)
+			return
+		}
+
+		mountFrom = append(mountFrom, repo)
+		scopes = append(scopes, repo.Scope(transport.PullScope))
+	}
+
+	tr := resource.NewTokenTransport(ref.Context().Registry, auth, inner, scopes)
+
+	labels, err := resource.ResolveValues(src, req.Params.Labels)
+	if err != nil {
+		logrus.Errorf("could not resolve labels: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	annotations, err := resource.ResolveValues(src, req.Params.Annotations)
+	if err != nil {
+		logrus.Errorf("could not resolve annotations: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Params.AddBuildMetadataLabels {
+		buildLabels, buildAnnotations := resource.BuildMetadata(os.Getenv, time.Now())
+
+		if req.Params.BuildMetadataGit != "" {
+			gitAnnotations, err := resource.GitAnnotations(filepath.Join(src, req.Params.BuildMetadataGit))
 			if err != nil {
-				logrus.Errorf("failed to create TrustedGcrRepository: %s", err)
+				logrus.Errorf("could not determine git metadata: %s", err)
 				os.Exit(1)
 				return
 			}
-			err = trustedRepo.SignImage(img)
-			if err != nil {
-				logrus.Errorf("failed to sign image: %s", err)
+
+			for key, value := range gitAnnotations {
+				buildAnnotations[key] = value
 			}
 		}
+
+		// explicitly configured labels and annotations take precedence
+		labels = merge(buildLabels, labels)
+		annotations = merge(buildAnnotations, annotations)
 	}
 
-	json.NewEncoder(os.Stdout).Encode(OutResponse{
-		Version: resource.Version{
-			Digest: digest.String(),
-		},
-		Metadata: req.Source.MetadataWithAdditionalTags(tags),
-	})
+	opts := imageOptions{
+		recompressZstd:   req.Params.RecompressZstd,
+		compression:      req.Params.Compression,
+		compressionLevel: req.Params.CompressionLevel,
+		labels:           labels,
+		annotations:      annotations,
+		mountFrom:        mountFrom,
+		retry:            req.Source.Retry,
+	}
+
+	var img v1.Image
+	var platformImgs map[resource.Platform]v1.Image
+	if req.Params.CopyFrom != nil {
+		img, err = copyImage(req.Params.CopyFrom, &req.Source, ref, auth, tr)
+		if err != nil {
+			logrus.Errorf("failed to copy image: %s", err)
+			os.Exit(1)
+			return
+		}
+	} else if req.Params.DigestFile != "" {
+		img, err = existingImage(src, req, auth)
+		if err != nil {
+			logrus.Errorf("failed to locate image to tag: %s", err)
+			os.Exit(1)
+			return
+		}
+	} else if len(platformImages) > 0 {
+		platformImgs, img, err = loadPlatformImages(src, platformImages, opts)
+		if err != nil {
+			logrus.Errorf("failed to load multi-arch image: %s", err)
+			os.Exit(1)
+			return
+		}
+	} else if len(req.Params.Files) > 0 {
+		img, err = resource.NewFilesArtifact(src, req.Params.ArtifactType, req.Params.ArtifactConfig, req.Params.Files, annotations)
+		if err != nil {
+			logrus.Errorf("could not build artifact: %s", err)
+			os.Exit(1)
+			return
+		}
+	} else {
+		img, err = loadImage(src, req.Params.Image, opts)
+		if err != nil {
+			logrus.Errorf("could not load image from path '%s': %s", req.Params.Image, err)
+			os.Exit(1)
+			return
+		}
+
+		img, err = resource.WithMounts(img, opts.mountFrom, tr)
+		if err != nil {
+			logrus.Errorf("failed to check for blobs to mount: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		logrus.Errorf("failed to get image digest: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if req.Source.MaxImageSize != "" && req.Params.CopyFrom == nil && req.Params.DigestFile == "" {
+		err = checkImageSize(req, img, platformImgs)
+		if err != nil {
+			logrus.Errorf("cannot push %s: %s", digest, err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Source.Policy != nil {
+		err = evaluatePolicy(src, req, ref.Context(), tags, digest, img, platformImgs)
+		if err != nil {
+			logrus.Errorf("cannot push %s: %s", digest, err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Params.PushByDigest {
+		ref, err = name.NewDigest(req.Source.Repository+"@"+digest.String(), name.WeakValidation)
+		if err != nil {
+			logrus.Errorf("could not resolve repository/digest reference: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	// aliases are worked out before pushing, so that they are checked for
+	// immutable tags too
+	if req.Params.BumpAliases {
+		aliases, err := semverAliases(ref.Context(), req, auth)
+		if err != nil {
+			logrus.Errorf("failed to determine alias tags: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		for _, alias := range aliases {
+			aliasRef, err := name.NewTag(req.Source.Repository+":"+alias, name.WeakValidation)
+			if err != nil {
+				logrus.Errorf("could not resolve repository/tag reference: %s", err)
+				os.Exit(1)
+				return
+			}
+
+			tags = append(tags, alias)
+			extraRefs = append(extraRefs, aliasRef)
+		}
+	}
+
+	conflicts, err := immutableConflicts(append([]name.Reference{ref}, extraRefs...), digest, auth)
+	if err != nil {
+		logrus.Errorf("failed to check for immutable tags: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if len(conflicts) > 0 {
+		for tag, existing := range conflicts {
+			logrus.Warnf("%s is immutable and already refers to %s", tag, existing)
+		}
+
+		if req.Params.OnImmutableConflict != resource.ImmutableConflictSkip {
+			logrus.Errorf("cannot push %s, as the registry doesn't allow immutable tags to be pushed again; set on_immutable_conflict to skip them", digest)
+			os.Exit(1)
+			return
+		}
+
+		if existing, found := conflicts[ref.Identifier()]; found {
+			logrus.Warnf("skipping push, as %s cannot be pushed", ref.Name())
+
+			metrics.Succeeded()
+			trace.Succeeded()
+
+			json.NewEncoder(os.Stdout).Encode(OutResponse{
+				Version: resource.Version{
+					Tag:    req.Source.Tag(),
+					Digest: existing.String(),
+				},
+				Metadata: req.Source.MetadataWithAdditionalTags(nil),
+			})
+
+			return
+		}
+
+		var pushTags []string
+		var pushRefs []name.Reference
+		for i, extraRef := range extraRefs {
+			if _, found := conflicts[extraRef.Identifier()]; found {
+				logrus.Warnf("skipping %s, as it cannot be pushed", extraRef.Name())
+				continue
+			}
+
+			pushTags = append(pushTags, tags[i])
+			pushRefs = append(pushRefs, extraRef)
+		}
+
+		tags, extraRefs = pushTags, pushRefs
+	}
+
+	pushStart := time.Now()
+
+	if len(platformImgs) > 0 {
+		err = pushPlatformImages(ref, platformImgs, opts, auth, tr)
+		if err != nil {
+			logrus.Errorf("failed to push multi-arch image: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	write := resource.Write
+	if req.Params.DigestFile != "" {
+		// the repository already has the image's blobs
+		write = resource.WriteManifest
+	}
+
+	if alreadyPushed(ref, digest, auth) {
+		logrus.Infof("%s is already %s; skipping upload", ref.Name(), digest)
+	} else {
+		logrus.Infof("pushing %s to %s", digest, ref.Name())
+
+		err = req.Source.Retry.Do(func() error {
+			return write(ref, img, tr)
+		})
+		if err != nil {
+			logrus.Errorf("failed to upload image: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		logrus.Info("pushed")
+	}
+
+	var notaryConfigDir string
+	if req.Source.ContentTrust != nil {
+		notaryConfigDir, err = req.Source.ContentTrust.PrepareConfigDir(src)
+		if err != nil {
+			logrus.Errorf("failed to prepare notary-config-dir: %s", err)
+			os.Exit(1)
+			return
+		}
+		trustedRepo, err := gcr.NewTrustedGcrRepository(notaryConfigDir, ref, auth)
+		if err != nil {
+			logrus.Errorf("failed to create TrustedGcrRepository: %s", err)
+			os.Exit(1)
+			return
+		}
+		span := trace.Span("sign")
+		span.SetAttribute("signer", "notary")
+		err = trustedRepo.SignImage(img)
+		span.End(err)
+		if err != nil {
+			logrus.Errorf("failed to sign image: %s", err)
+		}
+	}
+
+	var metadata []resource.MetadataField
+	if req.Params.Cosign != nil {
+		span := trace.Span("sign")
+		span.SetAttribute("signer", "cosign")
+		metadata, err = cosignSign(ref.Context(), digest, req, auth, tr)
+		span.End(err)
+		if err != nil {
+			logrus.Errorf("failed to sign image with cosign: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if req.Params.Provenance != "" || req.Params.GenerateProvenance {
+		span := trace.Span("attest")
+		attestationMetadata, err := cosignAttest(src, ref.Context(), digest, req, auth, tr)
+		span.End(err)
+		if err != nil {
+			logrus.Errorf("failed to attest provenance: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		metadata = append(metadata, attestationMetadata...)
+	}
+
+	if req.Params.SBOM != "" || req.Params.GenerateSBOM != "" {
+		err = attachSBOM(src, ref.Context(), img, req, tr)
+		if err != nil {
+			logrus.Errorf("failed to attach SBOM: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if len(req.Params.Attach) > 0 {
+		err = attachArtifacts(src, ref.Context(), img, req, tr)
+		if err != nil {
+			logrus.Errorf("failed to attach artifacts: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
+	for _, extraRef := range extraRefs {
+		if alreadyPushed(extraRef, digest, auth) {
+			logrus.Infof("%s is already tagged with %s; skipping", digest, extraRef.Identifier())
+		} else {
+			logrus.Infof("tagging %s with %s", digest, extraRef.Identifier())
+
+			err = req.Source.Retry.Do(func() error {
+				return write(extraRef, img, tr)
+			})
+			if err != nil {
+				logrus.Errorf("failed to tag image: %s", err)
+				os.Exit(1)
+				return
+			}
+
+			logrus.Info("tagged")
+		}
+
+		if req.Source.ContentTrust != nil {
+			trustedRepo, err := gcr.NewTrustedGcrRepository(notaryConfigDir, extraRef, auth)
+			if err != nil {
+				logrus.Errorf("failed to create TrustedGcrRepository: %s", err)
+				os.Exit(1)
+				return
+			}
+			span := trace.Span("sign")
+			span.SetAttribute("signer", "notary")
+			err = trustedRepo.SignImage(img)
+			span.End(err)
+			if err != nil {
+				logrus.Errorf("failed to sign image: %s", err)
+			}
+		}
+	}
+
+	var pushedRepos, failedRepos []string
+	for _, repo := range additionalRepos {
+		err = pushAdditionalRepository(src, repo, ref.Context(), img, platformImgs, tags, req, opts, inner, notaryConfigDir)
+		if err != nil {
+			if req.Params.OnPushFailure == "" || req.Params.OnPushFailure == resource.PushFailureFailFast {
+				logrus.Errorf("failed to push to %s: %s", repo.Repository, err)
+				os.Exit(1)
+				return
+			}
+
+			logrus.Warnf("failed to push to %s: %s", repo.Repository, err)
+
+			failedRepos = append(failedRepos, repo.Repository)
+			continue
+		}
+
+		pushedRepos = append(pushedRepos, repo.Repository)
+	}
+
+	if len(failedRepos) > 0 {
+		for _, repo := range pushedRepos {
+			logrus.WithFields(logrus.Fields{"repository": repo, "result": "succeeded"}).Info("push summary")
+		}
+
+		for _, repo := range failedRepos {
+			logrus.WithFields(logrus.Fields{"repository": repo, "result": "failed"}).Info("push summary")
+		}
+	}
+
+	if resource.PushFailed(req.Params.OnPushFailure, len(failedRepos), len(additionalRepos)) {
+		logrus.Errorf("failed to push to %d of %d additional repositories: %s", len(failedRepos), len(additionalRepos), strings.Join(failedRepos, ", "))
+		os.Exit(1)
+		return
+	}
+
+	metadata = append(metadata, resource.PushSummary(pushedRepos, failedRepos)...)
+
+	if req.Source.Harbor != nil && req.Source.Harbor.ReportProject {
+		var pushedTags []string
+		if !req.Params.PushByDigest {
+			pushedTags = append([]string{req.Source.Tag()}, tags...)
+		}
+
+		// only informational, so not worth failing the push over
+		harborMetadata, err := resource.HarborProjectMetadata(ref.Context(), pushedTags, auth, resource.RetryTransport)
+		if err != nil {
+			logrus.Warnf("failed to report on Harbor project: %s", err)
+		}
+
+		metadata = append(metadata, harborMetadata...)
+	}
+
+	layers, err := pushedLayers(img, platformImgs)
+	if err != nil {
+		logrus.Errorf("failed to get image layers: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	stats, err := resource.Transfers.Metadata("push", time.Since(pushStart), layers)
+	if err != nil {
+		logrus.Errorf("failed to compute transfer statistics: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	metadata = append(metadata, stats...)
+	metadata = append(metadata, resource.RateLimiter.Metadata()...)
+
+	err = writeOutputs(src, req.Params, req.Source.Repository, digest)
+	if err != nil {
+		logrus.Errorf("failed to write outputs: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	metrics.Succeeded()
+	trace.Succeeded()
+
+	if req.Params.PushByDigest {
+		json.NewEncoder(os.Stdout).Encode(OutResponse{
+			Version: resource.Version{
+				Digest: digest.String(),
+			},
+			Metadata: append([]resource.MetadataField{
+				{Name: "repository", Value: req.Source.Repository},
+				{Name: "reference", Value: req.Source.Repository + "@" + digest.String()},
+			}, metadata...),
+		})
+
+		return
+	}
+
+	json.NewEncoder(os.Stdout).Encode(OutResponse{
+		Version: resource.Version{
+			Tag:    req.Source.Tag(),
+			Digest: digest.String(),
+		},
+		Metadata: append(req.Source.MetadataWithAdditionalTags(tags), metadata...),
+	})
+}
+
+// pushedLayers returns the layers of the image, or of each of the platform
+// images for multi-arch images.
+func pushedLayers(img v1.Image, platformImgs map[resource.Platform]v1.Image) ([]v1.Layer, error) {
+	if len(platformImgs) == 0 {
+		return img.Layers()
+	}
+
+	var layers []v1.Layer
+	for _, platformImg := range platformImgs {
+		platformLayers, err := platformImg.Layers()
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, platformLayers...)
+	}
+
+	return layers, nil
+}
+
+// pushAdditionalRepository pushes the image to another repository with its
+// own credentials, under the same tags, or by digest. Blobs are mounted from
+// the primary repository if it's in the same registry, as it has them all by
+// now. Immutable tags that already exist are handled as in the primary
+// repository. The image is signed there as in the primary repository, with
+// cosign and with notary under the repository's content trust, and its
+// attestations, SBOM and attachments are attached there too.
+func pushAdditionalRepository(src string, repo resource.Source, primary name.Repository, img v1.Image, platformImgs map[resource.Platform]v1.Image, tags []string, req OutRequest, opts imageOptions, inner http.RoundTripper, notaryConfigDir string) error {
+	repository, err := name.NewRepository(repo.Repository, name.WeakValidation)
+	if err != nil {
+		return err
+	}
+
+	auth, err := repo.Authenticator()
+	if err != nil {
+		return fmt.Errorf("failed to configure registry credentials: %s", err)
+	}
+
+	if ecrAuth, ok := auth.(*resource.ECRAuthenticator); ok && req.Params.CreateRepository {
+		var settings resource.ECRRepositorySettings
+		if req.Params.RepositorySettings != nil {
+			settings = *req.Params.RepositorySettings
+		}
+
+		created, err := ecrAuth.EnsureRepository(repository.RepositoryStr(), settings)
+		if err != nil {
+			return fmt.Errorf("failed to create repository: %s", err)
+		}
+
+		if created {
+			logrus.Infof("created ECR repository %s", repository.Name())
+		}
+	}
+
+	scopes := []string{
+		repository.Scope(transport.PushScope),
+	}
+
+	// blobs in other registries can't be mounted
+	opts.mountFrom = nil
+	if repository.RegistryStr() == primary.RegistryStr() {
+		opts.mountFrom = []name.Repository{primary}
+		scopes = append(scopes, primary.Scope(transport.PullScope))
+	}
+
+	tr := resource.NewTokenTransport(repository.Registry, auth, inner, scopes)
+
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	var refs []name.Reference
+	if req.Params.PushByDigest {
+		ref, err := name.NewDigest(repository.Name()+"@"+digest.String(), name.WeakValidation)
+		if err != nil {
+			return err
+		}
+
+		refs = append(refs, ref)
+	} else {
+		for _, tag := range append([]string{req.Source.Tag()}, tags...) {
+			ref, err := name.NewTag(repository.Name()+":"+tag, name.WeakValidation)
+			if err != nil {
+				return err
+			}
+
+			refs = append(refs, ref)
+		}
+	}
+
+	conflicts, err := immutableConflicts(refs, digest, auth)
+	if err != nil {
+		return fmt.Errorf("failed to check for immutable tags: %s", err)
+	}
+
+	if len(conflicts) > 0 {
+		for tag, existing := range conflicts {
+			logrus.Warnf("%s:%s is immutable and already refers to %s", repository.Name(), tag, existing)
+		}
+
+		if req.Params.OnImmutableConflict != resource.ImmutableConflictSkip {
+			return fmt.Errorf("cannot push %s, as the registry doesn't allow immutable tags to be pushed again", digest)
+		}
+
+		if _, found := conflicts[refs[0].Identifier()]; found {
+			logrus.Warnf("skipping push to %s, as %s cannot be pushed", repository.Name(), refs[0].Name())
+			return nil
+		}
+
+		var pushRefs []name.Reference
+		for _, ref := range refs {
+			if _, found := conflicts[ref.Identifier()]; found {
+				logrus.Warnf("skipping %s, as it cannot be pushed", ref.Name())
+				continue
+			}
+
+			pushRefs = append(pushRefs, ref)
+		}
+
+		refs = pushRefs
+	}
+
+	if len(platformImgs) > 0 {
+		err = pushPlatformImages(refs[0], platformImgs, opts, auth, tr)
+		if err != nil {
+			return fmt.Errorf("failed to push multi-arch image: %s", err)
+		}
+	}
+
+	img, err = resource.WithMounts(img, opts.mountFrom, tr)
+	if err != nil {
+		return fmt.Errorf("failed to check for blobs to mount: %s", err)
+	}
+
+	for _, ref := range refs {
+		if alreadyPushed(ref, digest, auth) {
+			logrus.Infof("%s is already %s; skipping upload", ref.Name(), digest)
+			continue
+		}
+
+		logrus.Infof("pushing %s to %s", digest, ref.Name())
+
+		err = opts.retry.Do(func() error {
+			return resource.Write(ref, img, tr)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload image: %s", err)
+		}
+	}
+
+	if repo.ContentTrust != nil {
+		if repo.ContentTrust != req.Source.ContentTrust {
+			// the repository has its own content trust rather than sharing
+			// the source's
+			dir, err := ioutil.TempDir("", "content-trust")
+			if err != nil {
+				return err
+			}
+
+			defer os.RemoveAll(dir)
+
+			notaryConfigDir, err = repo.ContentTrust.PrepareConfigDir(dir)
+			if err != nil {
+				return fmt.Errorf("failed to prepare notary-config-dir: %s", err)
+			}
+		}
+
+		for _, ref := range refs {
+			trustedRepo, err := gcr.NewTrustedGcrRepository(notaryConfigDir, ref, auth)
+			if err != nil {
+				return fmt.Errorf("failed to create TrustedGcrRepository: %s", err)
+			}
+
+			err = trustedRepo.SignImage(img)
+			if err != nil {
+				logrus.Errorf("failed to sign image: %s", err)
+			}
+		}
+	}
+
+	if req.Params.Cosign != nil {
+		_, err = cosignSign(repository, digest, req, auth, tr)
+		if err != nil {
+			return fmt.Errorf("failed to sign image with cosign: %s", err)
+		}
+	}
+
+	if req.Params.Provenance != "" || req.Params.GenerateProvenance {
+		_, err = cosignAttest(src, repository, digest, req, auth, tr)
+		if err != nil {
+			return fmt.Errorf("failed to attest provenance: %s", err)
+		}
+	}
+
+	if req.Params.SBOM != "" || req.Params.GenerateSBOM != "" {
+		err = attachSBOM(src, repository, img, req, tr)
+		if err != nil {
+			return fmt.Errorf("failed to attach SBOM: %s", err)
+		}
+	}
+
+	if len(req.Params.Attach) > 0 {
+		err = attachArtifacts(src, repository, img, req, tr)
+		if err != nil {
+			return fmt.Errorf("failed to attach artifacts: %s", err)
+		}
+	}
+
+	logrus.Infof("pushed to %s", repository.Name())
+
+	return nil
+}
+
+// checkImageSize checks that the image to push, or all of the platform images
+// for multi-arch images, are within the source's max_image_size. Images
+// copied with copy_from are checked before they're copied instead.
+func checkImageSize(req OutRequest, img v1.Image, platformImgs map[resource.Platform]v1.Image) error {
+	images := []v1.Image{img}
+	if len(platformImgs) > 0 {
+		images = nil
+		for _, platformImg := range platformImgs {
+			images = append(images, platformImg)
+		}
+	}
+
+	var total int64
+	for _, image := range images {
+		size, err := resource.ImageSize(image)
+		if err != nil {
+			return fmt.Errorf("failed to get image size: %s", err)
+		}
+
+		total += size
+	}
+
+	return req.Source.CheckImageSize(total)
+}
+
+// evaluatePolicy evaluates the source's policy against the image to push,
+// or each of the platform images for multi-arch images.
+func evaluatePolicy(src string, req OutRequest, repo name.Repository, tags []string, digest v1.Hash, img v1.Image, platformImgs map[resource.Platform]v1.Image) error {
+	images := []v1.Image{img}
+	if len(platformImgs) > 0 {
+		var platforms []resource.Platform
+		for platform := range platformImgs {
+			platforms = append(platforms, platform)
+		}
+
+		sort.Slice(platforms, func(i, j int) bool {
+			return platforms[i].String() < platforms[j].String()
+		})
+
+		images = nil
+		for _, platform := range platforms {
+			images = append(images, platformImgs[platform])
+		}
+	}
+
+	var pushedTags []string
+	if !req.Params.PushByDigest {
+		pushedTags = append([]string{req.Source.Tag()}, tags...)
+	}
+
+	var signatures []string
+	if req.Source.ContentTrust != nil {
+		signatures = append(signatures, "notary")
+	}
+
+	if req.Params.Cosign != nil {
+		signatures = append(signatures, "cosign")
+	}
+
+	input, err := resource.NewPolicyInput("put", repo, pushedTags, digest, images, signatures)
+	if err != nil {
+		return fmt.Errorf("failed to describe image for policy: %s", err)
+	}
+
+	return req.Source.Policy.Evaluate(src, input)
+}
+
+// sameRegistry determines whether the repositories are in the same registry.
+func sameRegistry(a string, b string) bool {
+	repoA, err := name.NewRepository(a, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+
+	repoB, err := name.NewRepository(b, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+
+	return repoA.RegistryStr() == repoB.RegistryStr()
+}
+
+// alreadyPushed determines whether the reference already refers to the
+// digest, in which case pushing it again can be skipped.
+func alreadyPushed(ref name.Reference, digest v1.Hash, auth authn.Authenticator) bool {
+	existing, err := resource.HeadManifest(ref, auth, resource.RetryTransport, "")
+	if err != nil {
+		// most likely it does not exist yet; if anything else is wrong, the
+		// push will fail with a clearer error
+		logrus.Debugf("failed to resolve %s: %s", ref.Name(), err)
+		return false
+	}
+
+	return existing == digest
+}
+
+// cosignSign signs the pushed image with the configured key, adding the
+// signature to any that cosign has stored for it already, and returns the
+// metadata of the signature. If one of them is already by the key, the image
+// is left as is.
+func cosignSign(repo name.Repository, digest v1.Hash, req OutRequest, auth authn.Authenticator, tr *resource.TokenTransport) ([]resource.MetadataField, error) {
+	err := req.Params.Cosign.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	sigRef, err := name.NewTag(repo.Name()+":"+resource.CosignSignatureTag(digest), name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := resource.RemoteImage(sigRef, remote.WithTransport(resource.RetryTransport), remote.WithAuth(auth))
+	if err == nil {
+		_, err = existing.RawManifest()
+	}
+
+	if err != nil {
+		// most likely the image has not been signed yet
+		logrus.Debugf("failed to fetch existing signatures: %s", err)
+		existing = nil
+	}
+
+	var signature resource.SignatureLayer
+	var metadata []resource.MetadataField
+	if req.Params.Cosign.Keyless != nil {
+		// the key is ephemeral, so there's no telling whether it's signed
+		// already
+		var logIndex int64
+		signature, logIndex, err = req.Params.Cosign.Keyless.Sign(repo, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		logrus.Infof("recorded signature of %s in transparency log at index %d", digest, logIndex)
+
+		metadata = append(metadata, resource.MetadataField{
+			Name:  "rekor_log_index",
+			Value: strconv.FormatInt(logIndex, 10),
+		})
+	} else {
+		signer, err := req.Params.Cosign.Signer(&req.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil {
+			publicKey, err := resource.PublicKeyPEM(signer)
+			if err != nil {
+				return nil, err
+			}
+
+			verifier := resource.CosignConfig{PublicKey: publicKey}
+			if verifier.Verify(existing, digest) == nil {
+				logrus.Infof("%s is already signed with the key; skipping", digest)
+				return nil, nil
+			}
+		}
+
+		signature, err = resource.CosignSignature(signer, repo, digest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signatures, err := resource.SignatureImage(existing, signature)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("pushing signature of %s to %s", digest, sigRef.Identifier())
+
+	err = resource.Write(sigRef, signatures, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// cosignAttest signs the provenance given by the provenance param, or else
+// generated from the build, as an attestation of the pushed image, adding it
+// to any that cosign has stored for it already, and returns the metadata of
+// the attestation.
+func cosignAttest(src string, repo name.Repository, digest v1.Hash, req OutRequest, auth authn.Authenticator, tr *resource.TokenTransport) ([]resource.MetadataField, error) {
+	err := req.Params.Cosign.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var predicateType string
+	var predicate []byte
+	if req.Params.Provenance != "" {
+		content, err := ioutil.ReadFile(filepath.Join(src, req.Params.Provenance))
+		if err != nil {
+			return nil, err
+		}
+
+		predicateType, predicate, err = resource.ParsePredicate(content)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var git map[string]string
+		if req.Params.BuildMetadataGit != "" {
+			git, err = resource.GitAnnotations(filepath.Join(src, req.Params.BuildMetadataGit))
+			if err != nil {
+				return nil, fmt.Errorf("could not determine git metadata: %s", err)
+			}
+		}
+
+		predicateType = resource.SLSAProvenanceV1
+		predicate, err = resource.BuildProvenance(os.Getenv, git, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statement, err := resource.InTotoStatement(repo, digest, predicateType, predicate)
+	if err != nil {
+		return nil, err
+	}
+
+	attRef, err := name.NewTag(repo.Name()+":"+resource.CosignAttestationTag(digest), name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := resource.RemoteImage(attRef, remote.WithTransport(resource.RetryTransport), remote.WithAuth(auth))
+	if err == nil {
+		_, err = existing.RawManifest()
+	}
+
+	if err != nil {
+		// most likely the image has no attestations yet
+		logrus.Debugf("failed to fetch existing attestations: %s", err)
+		existing = nil
+	}
+
+	var attestation resource.SignatureLayer
+	var metadata []resource.MetadataField
+	if req.Params.Cosign.Keyless != nil {
+		var logIndex int64
+		attestation, logIndex, err = req.Params.Cosign.Keyless.Attest(statement, predicateType)
+		if err != nil {
+			return nil, err
+		}
+
+		logrus.Infof("recorded attestation of %s in transparency log at index %d", digest, logIndex)
+
+		metadata = append(metadata, resource.MetadataField{
+			Name:  "attestation_rekor_log_index",
+			Value: strconv.FormatInt(logIndex, 10),
+		})
+	} else {
+		signer, err := req.Params.Cosign.Signer(&req.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		attestation, err = resource.CosignAttestation(signer, statement, predicateType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	attestations, err := resource.SignatureImage(existing, attestation)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("pushing %s attestation of %s to %s", predicateType, digest, attRef.Identifier())
+
+	err = resource.Write(attRef, attestations, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// attachSBOM attaches the SBOM given by the sbom param, or else one generated
+// from the image's packages, to the pushed image as a referrer.
+func attachSBOM(src string, repo name.Repository, img v1.Image, req OutRequest, tr *resource.TokenTransport) error {
+	subject, err := resource.Descriptor(img)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	var format string
+	if req.Params.SBOM != "" {
+		content, err = ioutil.ReadFile(filepath.Join(src, req.Params.SBOM))
+		if err != nil {
+			return err
+		}
+
+		format, err = resource.SBOMFormat(content)
+		if err != nil {
+			return err
+		}
+	} else {
+		isIndex, err := resource.IsIndex(img)
+		if err != nil {
+			return err
+		}
+
+		if isIndex {
+			return fmt.Errorf("generate_sbom is not supported for multi-arch images; give an sbom file instead")
+		}
+
+		layers, err := img.Layers()
+		if err != nil {
+			return err
+		}
+
+		packages, err := resource.LayerPackages(layers)
+		if err != nil {
+			return fmt.Errorf("failed to list image packages: %s", err)
+		}
+
+		sbom := new(bytes.Buffer)
+		err = resource.WriteSBOM(sbom, req.Params.GenerateSBOM, resource.SBOMSubject{
+			Repository: req.Source.Repository,
+			Digest:     subject.Digest,
+		}, packages, time.Now())
+		if err != nil {
+			return err
+		}
+
+		content, format = sbom.Bytes(), req.Params.GenerateSBOM
+	}
+
+	artifact, err := resource.NewArtifact(resource.SBOMMediaType(format), content, subject, nil)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("attaching %s SBOM to %s", format, subject.Digest)
+
+	return resource.AttachArtifact(repo, artifact, subject.Digest, tr)
+}
+
+// attachArtifacts attaches each of the attach param's files to the pushed
+// image as an artifact referring to it, titled with the file's name unless
+// its annotations give a title.
+func attachArtifacts(src string, repo name.Repository, img v1.Image, req OutRequest, tr *resource.TokenTransport) error {
+	subject, err := resource.Descriptor(img)
+	if err != nil {
+		return err
+	}
+
+	for _, attachment := range req.Params.Attach {
+		content, err := ioutil.ReadFile(filepath.Join(src, attachment.File))
+		if err != nil {
+			return err
+		}
+
+		annotations := map[string]string{
+			resource.OCITitleAnnotation: filepath.Base(attachment.File),
+		}
+
+		for key, value := range attachment.Annotations {
+			annotations[key] = value
+		}
+
+		artifact, err := resource.NewArtifact(attachment.ArtifactType, content, subject, annotations)
+		if err != nil {
+			return err
+		}
+
+		logrus.Infof("attaching %s as %s to %s", attachment.File, attachment.ArtifactType, subject.Digest)
+
+		err = resource.AttachArtifact(repo, artifact, subject.Digest, tr)
+		if err != nil {
+			return fmt.Errorf("failed to attach %s: %s", attachment.File, err)
+		}
+	}
+
+	return nil
+}
+
+// semverAliases returns the alias tags to update for the pushed tag, given
+// the repository's tags, which include it now that it has been pushed.
+func semverAliases(repo name.Repository, req OutRequest, auth authn.Authenticator) ([]string, error) {
+	var tags []string
+	err := resource.ListTags(repo, auth, resource.RetryTransport, req.Source.TagPageSize, func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repository tags: %s", err)
+	}
+
+	aliases, err := resource.SemverAliases(req.Source.Tag(), tags, req.Source.Variant, req.Params.BumpLatest)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(aliases) == 0 {
+		logrus.Infof("no aliases of %s to update", req.Source.Tag())
+	}
+
+	return aliases, nil
+}
+
+// outputDirs returns the directories of the inputs containing the images, so
+// that files written to them are available to later steps. There are none
+// for images which aren't loaded from inputs, e.g. with copy_from.
+func outputDirs(src string, params resource.PutParams) []string {
+	var paths []string
+	if len(params.Images) > 0 {
+		for _, path := range params.Images {
+			paths = append(paths, path)
+		}
+	} else if params.Image != "" {
+		paths = append(paths, params.Image)
+	}
+
+	seen := map[string]bool{}
+
+	var dirs []string
+	for _, path := range paths {
+		input := strings.SplitN(filepath.ToSlash(filepath.Clean(path)), "/", 2)[0]
+
+		dir := filepath.Join(src, input)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			// the image is directly within src, e.g. image.tar
+			dir = src
+		}
+
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	sort.Strings(dirs)
+
+	return dirs
+}
+
+// writeOutputs writes the digest of the pushed image and its reference in the
+// repository as configured, e.g. repo@sha256:..., to the digest and reference
+// files in output_path, or else in the inputs containing the images, so that
+// later steps can deploy exactly the image pushed.
+func writeOutputs(src string, params resource.PutParams, repository string, digest v1.Hash) error {
+	dirs := outputDirs(src, params)
+	if params.OutputPath != "" {
+		dir := filepath.Join(src, params.OutputPath)
+
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create output_path: %s", err)
+		}
+
+		dirs = []string{dir}
+	}
+
+	if len(dirs) == 0 {
+		logrus.Infof("not writing the digest and reference files, as no input contains the image; set output_path to write them")
+		return nil
+	}
+
+	files := map[string]string{
+		"digest":    digest.String(),
+		"reference": repository + "@" + digest.String(),
+	}
+
+	for _, dir := range dirs {
+		for file, content := range files {
+			err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+			if err != nil {
+				return fmt.Errorf("failed to write %s: %s", file, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// merge returns the entries of both maps, preferring those of override.
+func merge(base map[string]string, override map[string]string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// imageOptions configure how each image is prepared for pushing.
+type imageOptions struct {
+	recompressZstd bool
+	labels         map[string]string
+	annotations    map[string]string
+
+	// compression is what to recompress the layers with, if anything
+	compression      string
+	compressionLevel int
+
+	// mountFrom are the repositories to mount blobs from rather than
+	// uploading them
+	mountFrom []name.Repository
+
+	// retry is the policy for retrying uploads, if any
+	retry *resource.RetryPolicy
+}
+
+// loadImage loads the image at the path within src, in whichever format it
+// is in, and adds the labels and annotations to it.
+func loadImage(src string, path string, opts imageOptions) (v1.Image, error) {
+	img, format, err := resource.LoadImage(filepath.Join(src, path))
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("loaded %s from %s", format, path)
+
+	if opts.compression != "" {
+		logrus.Infof("recompressing layers with %s", opts.compression)
+
+		// zstd layers of docker archives are decompressed too
+		img, err = resource.Compress(img, opts.compression, opts.compressionLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recompress layers: %s", err)
+		}
+	} else if format == resource.DockerArchiveFormat {
+		// OCI archives and layouts record the media types of their layers, so
+		// their zstd layers can be pushed as is
+		img, err = recompressZstd(img, opts.recompressZstd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	img, err = resource.WithLabels(img, opts.labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add labels: %s", err)
+	}
+
+	img, err = resource.WithAnnotations(img, opts.annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add annotations: %s", err)
+	}
+
+	return img, nil
+}
+
+// loadPlatformImages loads the image for each platform, returning them and
+// an image index of them to push under the tags.
+func loadPlatformImages(src string, paths map[resource.Platform]string, opts imageOptions) (map[resource.Platform]v1.Image, v1.Image, error) {
+	images := map[resource.Platform]v1.Image{}
+	for platform, path := range paths {
+		img, err := loadImage(src, path, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not load %s image from path '%s': %s", platform, path, err)
+		}
+
+		images[platform] = img
+	}
+
+	index, err := resource.NewIndex(images)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index, err = resource.WithAnnotations(index, opts.annotations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return images, index, nil
+}
+
+// pushPlatformImages pushes the image for each platform by digest, so that
+// the image index of them can be pushed under the tags.
+func pushPlatformImages(ref name.Reference, images map[resource.Platform]v1.Image, opts imageOptions, auth authn.Authenticator, tr *resource.TokenTransport) error {
+	for platform, img := range images {
+		digest, err := img.Digest()
+		if err != nil {
+			return fmt.Errorf("failed to get digest of %s image: %s", platform, err)
+		}
+
+		digestRef, err := name.NewDigest(ref.Context().Name()+"@"+digest.String(), name.WeakValidation)
+		if err != nil {
+			return err
+		}
+
+		if alreadyPushed(digestRef, digest, auth) {
+			logrus.Infof("%s image %s already exists; skipping upload", platform, digest)
+			continue
+		}
+
+		logrus.Infof("pushing %s image %s", platform, digest)
+
+		img, err = resource.WithMounts(img, opts.mountFrom, tr)
+		if err != nil {
+			return fmt.Errorf("failed to check for %s blobs to mount: %s", platform, err)
+		}
+
+		err = opts.retry.Do(func() error {
+			return resource.Write(digestRef, img, tr)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload %s image: %s", platform, err)
+		}
+	}
+
+	return nil
+}
+
+// immutableConflicts returns the digests that those of the tags which already
+// exist, refer to another digest, and are immutable, already refer to, keyed
+// by tag, as they can't be pushed.
+func immutableConflicts(refs []name.Reference, digest v1.Hash, auth authn.Authenticator) (map[string]v1.Hash, error) {
+	existing := map[string]v1.Hash{}
+
+	var tags []string
+	for _, ref := range refs {
+		if _, isTag := ref.(name.Tag); !isTag {
+			// pushed by digest
+			continue
+		}
+
+		current, err := resource.HeadManifest(ref, auth, resource.RetryTransport, "")
+		if err != nil {
+			// most likely it does not exist yet
+			continue
+		}
+
+		if current != digest {
+			existing[ref.Identifier()] = current
+			tags = append(tags, ref.Identifier())
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	immutable, err := resource.ImmutableTags(refs[0].Context(), tags, auth, resource.RetryTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := map[string]v1.Hash{}
+	for _, tag := range immutable {
+		conflicts[tag] = existing[tag]
+	}
+
+	return conflicts, nil
+}
+
+// existingImage returns the image in the repository with the digest given in
+// the digest_file, so that it can be tagged.
+func existingImage(src string, req OutRequest, auth authn.Authenticator) (v1.Image, error) {
+	digest, err := req.Params.ParseDigest(src)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := name.NewDigest(req.Source.Repository+"@"+digest.String(), name.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve repository/digest reference: %s", err)
+	}
+
+	logrus.Infof("tagging existing image %s", digest)
+
+	return resource.RemoteImage(ref, remote.WithTransport(resource.RetryTransport), remote.WithAuth(auth))
+}
+
+// copyImage returns the image to copy from the copy_from source, having first
+// copied the images it refers to if it is an image index. Blobs are streamed
+// from one registry to the other rather than downloaded.
+func copyImage(from *resource.Source, to *resource.Source, ref name.Reference, auth authn.Authenticator, tr *resource.TokenTransport) (v1.Image, error) {
+	fromRef, err := from.Reference()
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve copy_from reference: %s", err)
+	}
+
+	fromAuth, err := from.Authenticator()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure copy_from registry credentials: %s", err)
+	}
+
+	opts := []remote.ImageOption{
+		remote.WithTransport(resource.RetryTransport),
+		remote.WithAuth(fromAuth),
+	}
+
+	img, err := resource.RemoteImage(fromRef, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate remote image: %s", err)
+	}
+
+	if to.MaxImageSize != "" {
+		size, err := resource.TotalImageSize(img, func(digest v1.Hash) (v1.Image, error) {
+			ref, err := name.NewDigest(fromRef.Context().Name()+"@"+digest.String(), name.WeakValidation)
+			if err != nil {
+				return nil, err
+			}
+
+			return resource.RemoteImage(ref, opts...)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image size: %s", err)
+		}
+
+		err = to.CheckImageSize(size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Infof("copying %s", fromRef.Name())
+
+	err = copyIndexImages(fromRef.Context(), ref.Context(), img, opts, auth, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// copyIndexImages copies the images that img refers to by digest if it is an
+// image index, so that it can then be written itself.
+func copyIndexImages(from name.Repository, to name.Repository, img v1.Image, opts []remote.ImageOption, auth authn.Authenticator, tr *resource.TokenTransport) error {
+	isIndex, err := resource.IsIndex(img)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %s", err)
+	}
+
+	if !isIndex {
+		return nil
+	}
+
+	manifests, err := resource.IndexManifests(img)
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range manifests {
+		fromRef, err := name.NewDigest(from.Name()+"@"+desc.Digest.String(), name.WeakValidation)
+		if err != nil {
+			return err
+		}
+
+		toRef, err := name.NewDigest(to.Name()+"@"+desc.Digest.String(), name.WeakValidation)
+		if err != nil {
+			return err
+		}
+
+		if alreadyPushed(toRef, desc.Digest, auth) {
+			logrus.Infof("%s already exists; skipping copy", desc.Digest)
+			continue
+		}
+
+		child, err := resource.RemoteImage(fromRef, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to locate %s: %s", desc.Digest, err)
+		}
+
+		// indexes may refer to further indexes
+		err = copyIndexImages(from, to, child, opts, auth, tr)
+		if err != nil {
+			return err
+		}
+
+		logrus.Infof("copying %s", desc.Digest)
+
+		err = resource.Write(toRef, child, tr)
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %s", desc.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+// recompressZstd recompresses a docker archive's zstd layers with gzip if
+// configured to, as they would otherwise be pushed as uncompressed layers.
+func recompressZstd(img v1.Image, recompress bool) (v1.Image, error) {
+	zstdLayers, err := resource.ZstdLayers(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image layers: %s", err)
+	}
+
+	if len(zstdLayers) == 0 {
+		return img, nil
+	}
+
+	if !recompress {
+		return nil, fmt.Errorf("image has %d zstd-compressed layer(s), which cannot be pushed from a docker archive; set recompress_zstd to push them gzip-compressed", len(zstdLayers))
+	}
+
+	logrus.Infof("recompressing %d zstd-compressed layer(s) with gzip", len(zstdLayers))
+
+	img, err = resource.RecompressZstd(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recompress layers: %s", err)
+	}
+
+	return img, nil
 }
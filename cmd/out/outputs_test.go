@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	resource "github.com/concourse/registry-image-resource"
+	"github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("writeOutputs", func() {
+	var src string
+
+	digest := v1.Hash{Algorithm: "sha256", Hex: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+
+	BeforeEach(func() {
+		var err error
+		src, err = ioutil.TempDir("", "put-src")
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, input := range []string{"image", "arm64-image"} {
+			Expect(os.Mkdir(filepath.Join(src, input), 0755)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(src)).To(Succeed())
+	})
+
+	outputs := func(dir string) map[string]string {
+		files := map[string]string{}
+		for _, file := range []string{"digest", "reference"} {
+			content, err := ioutil.ReadFile(filepath.Join(dir, file))
+			if err == nil {
+				files[file] = string(content)
+			}
+		}
+
+		return files
+	}
+
+	expected := map[string]string{
+		"digest":    digest.String(),
+		"reference": "some/repo@" + digest.String(),
+	}
+
+	It("should write to the input containing the image", func() {
+		Expect(writeOutputs(src, resource.PutParams{Image: "image/image.tar"}, "some/repo", digest)).To(Succeed())
+
+		Expect(outputs(filepath.Join(src, "image"))).To(Equal(expected))
+		Expect(outputs(src)).To(BeEmpty())
+	})
+
+	It("should write to the working directory for images directly within it", func() {
+		Expect(writeOutputs(src, resource.PutParams{Image: "image.tar"}, "some/repo", digest)).To(Succeed())
+
+		Expect(outputs(src)).To(Equal(expected))
+	})
+
+	It("should write to each input containing one of the platform images", func() {
+		Expect(writeOutputs(src, resource.PutParams{Images: map[string]string{
+			"linux/amd64": "image/amd64.tar",
+			"linux/386":   "image/386.tar",
+			"linux/arm64": "arm64-image/image.tar",
+		}}, "some/repo", digest)).To(Succeed())
+
+		Expect(outputs(filepath.Join(src, "image"))).To(Equal(expected))
+		Expect(outputs(filepath.Join(src, "arm64-image"))).To(Equal(expected))
+	})
+
+	It("should write only to output_path when given, creating it", func() {
+		Expect(writeOutputs(src, resource.PutParams{Image: "image/image.tar", OutputPath: "outputs/pushed"}, "some/repo", digest)).To(Succeed())
+
+		Expect(outputs(filepath.Join(src, "outputs", "pushed"))).To(Equal(expected))
+		Expect(outputs(filepath.Join(src, "image"))).To(BeEmpty())
+	})
+
+	It("should write nothing without output_path when no input contains the image", func() {
+		Expect(writeOutputs(src, resource.PutParams{CopyFrom: &resource.Source{Repository: "some/other-repo"}}, "some/repo", digest)).To(Succeed())
+
+		Expect(outputs(src)).To(BeEmpty())
+	})
+
+	It("should write to output_path when no input contains the image", func() {
+		Expect(writeOutputs(src, resource.PutParams{DigestFile: "image/digest", OutputPath: "outputs"}, "some/repo", digest)).To(Succeed())
+
+		Expect(outputs(filepath.Join(src, "outputs"))).To(Equal(expected))
+	})
+})
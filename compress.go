@@ -0,0 +1,328 @@
+package resource
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/go-containerregistry/pkg/v1/v1util"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compressions layers can be recompressed with before pushing.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// OCIZstdLayerMediaType is the media type of zstd-compressed OCI layers.
+const OCIZstdLayerMediaType types.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+// ValidateCompression checks that the compression is known and that the
+// level, if given, is valid for it: 1 to 9 for gzip, or 1 to 22 for zstd.
+func ValidateCompression(compression string, level int) error {
+	max := 0
+	switch compression {
+	case CompressionGzip:
+		max = gzip.BestCompression
+	case CompressionZstd:
+		max = 22
+	case CompressionNone:
+	default:
+		return fmt.Errorf("unknown compression %q (supported: %s, %s, %s)", compression, CompressionGzip, CompressionZstd, CompressionNone)
+	}
+
+	if level != 0 && (level < 1 || level > max) {
+		if max == 0 {
+			return fmt.Errorf("compression_level cannot be used with compression %s", compression)
+		}
+
+		return fmt.Errorf("invalid compression_level %d for %s (must be 1 to %d)", level, compression, max)
+	}
+
+	return nil
+}
+
+// Compress returns the image with its layers recompressed, at the level if
+// given or else the compression's default. Layers are decompressed whatever
+// their compression, including zstd layers loaded from docker archives (see
+// ZstdLayers). Foreign layers are left as is, since they aren't pushed.
+//
+// Images with zstd layers are pushed with an OCI manifest, as Docker
+// manifests have no media type for them. The layers are compressed once up
+// front to determine their digests, and again as they're uploaded.
+func Compress(image v1.Image, compression string, level int) (v1.Image, error) {
+	err := ValidateCompression(compression, level)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest = manifest.DeepCopy()
+
+	if compression == CompressionZstd && manifest.MediaType != types.OCIManifestSchema1 {
+		manifest.MediaType = types.OCIManifestSchema1
+		manifest.Config.MediaType = types.OCIConfigJSON
+	}
+
+	oci := manifest.MediaType == types.OCIManifestSchema1
+
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(layers) != len(manifest.Layers) {
+		return nil, fmt.Errorf("image has %d layers but its manifest has %d", len(layers), len(manifest.Layers))
+	}
+
+	compressed := &compressedImage{
+		Image:    image,
+		manifest: manifest,
+	}
+
+	for i, layer := range layers {
+		desc := &manifest.Layers[i]
+
+		switch desc.MediaType {
+		case types.DockerForeignLayer, types.OCIRestrictedLayer, types.OCIUncompressedRestrictedLayer:
+			compressed.layers = append(compressed.layers, layer)
+			continue
+		}
+
+		recompressed := &compressedLayer{
+			Layer:       layer,
+			compression: compression,
+			level:       level,
+			zstdBlob:    desc.MediaType == OCIZstdLayerMediaType,
+		}
+
+		desc.MediaType = layerMediaType(compression, oci)
+
+		desc.Digest, err = recompressed.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress layer: %s", err)
+		}
+
+		desc.Size, err = recompressed.Size()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress layer: %s", err)
+		}
+
+		// the table of contents no longer matches the layer
+		delete(desc.Annotations, EstargzTOCDigestAnnotation)
+
+		compressed.layers = append(compressed.layers, recompressed)
+	}
+
+	compressed.rawManifest, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return compressed, nil
+}
+
+func layerMediaType(compression string, oci bool) types.MediaType {
+	switch {
+	case compression == CompressionZstd:
+		return OCIZstdLayerMediaType
+	case compression == CompressionNone && oci:
+		return types.OCIUncompressedLayer
+	case compression == CompressionNone:
+		return types.DockerUncompressedLayer
+	case oci:
+		return types.OCILayer
+	default:
+		return types.DockerLayer
+	}
+}
+
+// compressedImage overrides the manifest and layers of the image with the
+// recompressed ones.
+type compressedImage struct {
+	v1.Image
+
+	manifest    *v1.Manifest
+	rawManifest []byte
+	layers      []v1.Layer
+}
+
+func (image *compressedImage) MediaType() (types.MediaType, error) {
+	return image.manifest.MediaType, nil
+}
+
+func (image *compressedImage) Manifest() (*v1.Manifest, error) {
+	return image.manifest, nil
+}
+
+func (image *compressedImage) RawManifest() ([]byte, error) {
+	return image.rawManifest, nil
+}
+
+func (image *compressedImage) Digest() (v1.Hash, error) {
+	return partial.Digest(image)
+}
+
+func (image *compressedImage) BlobSet() (map[v1.Hash]struct{}, error) {
+	return partial.BlobSet(image)
+}
+
+func (image *compressedImage) Layers() ([]v1.Layer, error) {
+	return image.layers, nil
+}
+
+func (image *compressedImage) LayerByDigest(digest v1.Hash) (v1.Layer, error) {
+	if digest == image.manifest.Config.Digest {
+		return partial.ConfigLayer(image)
+	}
+
+	for _, layer := range image.layers {
+		layerDigest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		if layerDigest == digest {
+			return layer, nil
+		}
+	}
+
+	return nil, fmt.Errorf("layer %s not found", digest)
+}
+
+func (image *compressedImage) LayerByDiffID(diffID v1.Hash) (v1.Layer, error) {
+	for _, layer := range image.layers {
+		layerDiffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+
+		if layerDiffID == diffID {
+			return layer, nil
+		}
+	}
+
+	return nil, fmt.Errorf("layer with diff ID %s not found", diffID)
+}
+
+// compressedLayer recompresses the layer as it is read.
+type compressedLayer struct {
+	v1.Layer
+
+	compression string
+	level       int
+
+	// zstdBlob is set if the layer's blob is zstd-compressed, in which case
+	// the layer can't decompress it itself, as it assumes gzip
+	zstdBlob bool
+
+	once   sync.Once
+	digest v1.Hash
+	size   int64
+	err    error
+}
+
+func (layer *compressedLayer) Uncompressed() (io.ReadCloser, error) {
+	if !layer.zstdBlob {
+		return recompressedLayer{layer.Layer}.Uncompressed()
+	}
+
+	r, err := layer.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := DecompressLayer(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return readCloser{zr, closers{zr, r}}, nil
+}
+
+func (layer *compressedLayer) Compressed() (io.ReadCloser, error) {
+	r, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer r.Close()
+
+		w, err := layer.compressor(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		_, err = io.Copy(w, r)
+		if err == nil {
+			err = w.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func (layer *compressedLayer) compressor(w io.Writer) (io.WriteCloser, error) {
+	switch layer.compression {
+	case CompressionGzip:
+		level := gzip.DefaultCompression
+		if layer.level != 0 {
+			level = layer.level
+		}
+
+		return gzip.NewWriterLevel(w, level)
+
+	case CompressionZstd:
+		level := zstd.SpeedDefault
+		if layer.level != 0 {
+			level = zstd.EncoderLevelFromZstd(layer.level)
+		}
+
+		// a single goroutine keeps the output, and so the digest, the same
+		// each time the layer is compressed
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+
+	default:
+		return v1util.NopWriteCloser(w), nil
+	}
+}
+
+func (layer *compressedLayer) Digest() (v1.Hash, error) {
+	layer.once.Do(layer.compute)
+	return layer.digest, layer.err
+}
+
+func (layer *compressedLayer) Size() (int64, error) {
+	layer.once.Do(layer.compute)
+	return layer.size, layer.err
+}
+
+func (layer *compressedLayer) compute() {
+	r, err := layer.Compressed()
+	if err != nil {
+		layer.err = err
+		return
+	}
+
+	defer r.Close()
+
+	layer.digest, layer.size, layer.err = v1.SHA256(r)
+}
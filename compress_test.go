@@ -0,0 +1,188 @@
+package resource_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Compress", func() {
+	var image v1.Image
+
+	BeforeEach(func() {
+		var err error
+		image, err = random.Image(4096, 2)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// expectLayers checks that the layers' blobs match their descriptors and
+	// decompress to the original layers.
+	expectLayers := func(compressed v1.Image, mediaType types.MediaType) {
+		manifest, err := compressed.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		layers, err := compressed.Layers()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layers).To(HaveLen(len(manifest.Layers)))
+
+		originals, err := image.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		for i, layer := range layers {
+			Expect(manifest.Layers[i].MediaType).To(Equal(mediaType))
+
+			blob, err := layer.Compressed()
+			Expect(err).ToNot(HaveOccurred())
+
+			digest, size, err := v1.SHA256(blob)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(blob.Close()).To(Succeed())
+
+			Expect(digest).To(Equal(manifest.Layers[i].Digest))
+			Expect(size).To(Equal(manifest.Layers[i].Size))
+
+			originalDiffID, err := originals[i].DiffID()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(layer.DiffID()).To(Equal(originalDiffID))
+
+			r, err := layer.Uncompressed()
+			Expect(err).ToNot(HaveOccurred())
+
+			diffID, _, err := v1.SHA256(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Close()).To(Succeed())
+			Expect(diffID).To(Equal(originalDiffID))
+		}
+	}
+
+	It("should recompress layers with gzip at the level", func() {
+		compressed, err := resource.Compress(image, resource.CompressionGzip, gzip.BestCompression)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(compressed.MediaType()).To(Equal(types.DockerManifestSchema2))
+		expectLayers(compressed, types.DockerLayer)
+	})
+
+	It("should push zstd layers with an OCI manifest", func() {
+		compressed, err := resource.Compress(image, resource.CompressionZstd, 19)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(compressed.MediaType()).To(Equal(types.OCIManifestSchema1))
+		expectLayers(compressed, resource.OCIZstdLayerMediaType)
+
+		manifest, err := compressed.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Config.MediaType).To(Equal(types.OCIConfigJSON))
+	})
+
+	It("should recompress zstd layers", func() {
+		zstd, err := resource.Compress(image, resource.CompressionZstd, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		compressed, err := resource.Compress(zstd, resource.CompressionGzip, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(compressed.MediaType()).To(Equal(types.OCIManifestSchema1))
+		expectLayers(compressed, types.OCILayer)
+	})
+
+	It("should push layers uncompressed", func() {
+		compressed, err := resource.Compress(image, resource.CompressionNone, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		expectLayers(compressed, types.DockerUncompressedLayer)
+
+		layers, err := compressed.Layers()
+		Expect(err).ToNot(HaveOccurred())
+		diffID, err := layers[0].DiffID()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layers[0].Digest()).To(Equal(diffID))
+	})
+
+	It("should upload the layers as described", func() {
+		registry := newFakeRegistry()
+		defer registry.Close()
+
+		ref, err := name.NewTag(registry.Host()+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		compressed, err := resource.Compress(image, resource.CompressionZstd, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr := resource.NewTokenTransport(ref.Registry, authn.Anonymous, http.DefaultTransport, []string{ref.Scope(transport.PushScope)})
+
+		// the registry verifies the digest of each blob as it is committed
+		Expect(resource.Write(ref, compressed, tr)).To(Succeed())
+
+		manifest, found := registry.Manifest("some/repo", "latest")
+		Expect(found).To(BeTrue())
+
+		raw, err := compressed.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest).To(Equal(raw))
+	})
+
+	It("should decompress zstd layers loaded from docker archives", func() {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: 5})).To(Succeed())
+		_, err := tw.Write([]byte("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tw.Close()).To(Succeed())
+
+		diffID, _, err := v1.SHA256(bytes.NewReader(buf.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+
+		zbuf := new(bytes.Buffer)
+		zw, err := zstd.NewWriter(zbuf)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = zw.Write(buf.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+
+		image, err = partial.UncompressedToImage(zstdImage{diffID: diffID, blob: zbuf.Bytes()})
+		Expect(err).ToNot(HaveOccurred())
+
+		compressed, err := resource.Compress(image, resource.CompressionGzip, 0)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.ZstdLayers(compressed)).To(BeEmpty())
+
+		layers, err := compressed.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		r, err := layers[0].Uncompressed()
+		Expect(err).ToNot(HaveOccurred())
+		defer r.Close()
+
+		actual, _, err := v1.SHA256(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(diffID))
+	})
+
+	Describe("ValidateCompression", func() {
+		It("should reject unknown compressions", func() {
+			Expect(resource.ValidateCompression("lz4", 0)).To(MatchError(ContainSubstring(`unknown compression "lz4"`)))
+		})
+
+		It("should reject levels out of range", func() {
+			Expect(resource.ValidateCompression(resource.CompressionGzip, 10)).To(MatchError(ContainSubstring("must be 1 to 9")))
+			Expect(resource.ValidateCompression(resource.CompressionZstd, 23)).To(MatchError(ContainSubstring("must be 1 to 22")))
+			Expect(resource.ValidateCompression(resource.CompressionNone, 1)).To(MatchError(ContainSubstring("cannot be used with compression none")))
+		})
+	})
+})
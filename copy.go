@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Reference returns the reference to the source's image: its digest if the
+// source is pinned to one, or else its tag.
+func (source *Source) Reference() (name.Reference, error) {
+	if source.Digest != "" {
+		return name.NewDigest(source.Repository+"@"+source.Digest, name.WeakValidation)
+	}
+
+	return name.NewTag(source.Name(), name.WeakValidation)
+}
+
+// RemoteImage returns the image in the registry. Its blobs are fetched only
+// as they are read, so that writing it to another registry streams them from
+// one to the other.
+//
+// Unlike remote.Image, its media type is that given by its manifest, so that
+// OCI manifests and image indexes are written as such.
+func RemoteImage(ref name.Reference, opts ...remote.ImageOption) (v1.Image, error) {
+	image, err := remote.Image(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteImage{image}, nil
+}
+
+type remoteImage struct {
+	v1.Image
+}
+
+func (image remoteImage) MediaType() (types.MediaType, error) {
+	raw, err := image.RawManifest()
+	if err != nil {
+		return "", err
+	}
+
+	return manifestMediaType(raw)
+}
+
+// IndexManifests returns the descriptors of the manifests that an image
+// index refers to.
+func IndexManifests(index v1.Image) ([]v1.Descriptor, error) {
+	raw, err := index.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest v1.IndexManifest
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image index: %s", err)
+	}
+
+	return manifest.Manifests, nil
+}
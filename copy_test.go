@@ -0,0 +1,86 @@
+package resource_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("RemoteImage", func() {
+	var server *httptest.Server
+	var host string
+
+	index := `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.oci.image.index.v1+json",
+		"manifests": [
+			{"mediaType": "application/vnd.oci.image.manifest.v1+json", "size": 123, "digest": "sha256:` + strings.Repeat("a", 64) + `", "platform": {"os": "linux", "architecture": "amd64"}},
+			{"mediaType": "application/vnd.oci.image.manifest.v1+json", "size": 456, "digest": "sha256:` + strings.Repeat("b", 64) + `", "platform": {"os": "linux", "architecture": "arm64"}}
+		]
+	}`
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/":
+				w.WriteHeader(http.StatusOK)
+			case "/v2/some/repo/manifests/latest":
+				w.Header().Set("Content-Type", string(types.OCIImageIndex))
+				w.Write([]byte(index))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		host = strings.TrimPrefix(server.URL, "http://")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should have the media type of its manifest", func() {
+		source := resource.Source{Repository: host + "/some/repo"}
+
+		ref, err := source.Reference()
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err := resource.RemoteImage(ref, remote.WithTransport(resource.RetryTransport))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(image.MediaType()).To(Equal(types.OCIImageIndex))
+		Expect(resource.IsIndex(image)).To(BeTrue())
+
+		manifests, err := resource.IndexManifests(image)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifests).To(HaveLen(2))
+		Expect(manifests[1].Digest.Hex).To(Equal(strings.Repeat("b", 64)))
+	})
+})
+
+var _ = Describe("Reference", func() {
+	It("should refer to the source's digest if pinned", func() {
+		source := resource.Source{Repository: "some/repo", RawTag: "some-tag", Digest: "sha256:" + strings.Repeat("a", 64)}
+
+		ref, err := source.Reference()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ref).To(BeAssignableToTypeOf(name.Digest{}))
+		Expect(ref.Identifier()).To(Equal("sha256:" + strings.Repeat("a", 64)))
+	})
+
+	It("should refer to the source's tag otherwise", func() {
+		source := resource.Source{Repository: "some/repo", RawTag: "some-tag"}
+
+		ref, err := source.Reference()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ref.Name()).To(Equal("index.docker.io/some/repo:some-tag"))
+	})
+})
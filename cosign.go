@@ -0,0 +1,504 @@
+package resource
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// CosignSignatureAnnotation holds the base64-encoded signature of a cosign
+// signature layer's payload.
+const CosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// CosignCertificateAnnotation holds the PEM-encoded signing certificate of a
+// keyless cosign signature.
+const CosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+
+// CosignChainAnnotation holds the PEM-encoded certificate chain of a keyless
+// cosign signature's signing certificate.
+const CosignChainAnnotation = "dev.sigstore.cosign/chain"
+
+// CosignBundleAnnotation holds the transparency log entry of a cosign
+// signature.
+const CosignBundleAnnotation = "dev.sigstore.cosign/bundle"
+
+// CosignPayloadType is the type of the payload cosign signs.
+const CosignPayloadType = "cosign container image signature"
+
+var (
+	// oidIssuerV1 is Fulcio's original, raw-string OIDC issuer extension.
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+
+	// oidIssuerV2 is Fulcio's DER-encoded OIDC issuer extension.
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// CosignConfig configures the verification of cosign signatures. Exactly one
+// of PublicKey or Keyless must be set.
+type CosignConfig struct {
+	// PublicKey is the PEM-encoded public key the image must be signed with.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Keyless verifies signatures made with short-lived certificates, e.g.
+	// those issued by Fulcio.
+	Keyless *CosignKeyless `json:"keyless,omitempty"`
+}
+
+// CosignKeyless constrains the certificates of keyless signatures.
+type CosignKeyless struct {
+	// Identity is the email address or URI the certificate must be issued
+	// to.
+	Identity string `json:"identity"`
+
+	// Issuer is the OIDC issuer that must have authenticated the identity.
+	Issuer string `json:"issuer"`
+
+	// RootCerts are the PEM-encoded certificates the signing certificate
+	// must chain up to, e.g. Fulcio's root.
+	RootCerts string `json:"root_certs"`
+
+	// RekorPublicKey is the PEM-encoded public key of the transparency log,
+	// for verifying the signature's log entry. If set, the certificate is
+	// verified as of the time the entry was logged; otherwise the entry is
+	// ignored and the certificate must still be valid now.
+	RekorPublicKey string `json:"rekor_public_key,omitempty"`
+}
+
+// CosignSignatureTag returns the tag cosign stores an image's signatures
+// under.
+func CosignSignatureTag(digest v1.Hash) string {
+	return digest.Algorithm + "-" + digest.Hex + ".sig"
+}
+
+// VerifyCosign verifies that the image with the digest has a valid cosign
+// signature in the source's repository.
+func (source *Source) VerifyCosign(digest v1.Hash, opts ...remote.ImageOption) error {
+	signatures, err := source.cosignImage(CosignSignatureTag(digest), opts...)
+	if err != nil {
+		return err
+	}
+
+	return source.Cosign.Verify(signatures, digest)
+}
+
+// cosignImage fetches the image cosign stores under the tag in the source's
+// repository, e.g. an image's signatures or attestations.
+func (source *Source) cosignImage(tag string, opts ...remote.ImageOption) (v1.Image, error) {
+	ref, err := name.NewTag(source.Repository+":"+tag, name.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tag %s: %s", tag, err)
+	}
+
+	image, err := remote.Image(ref, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %s", ref, err)
+	}
+
+	return image, nil
+}
+
+// Verify verifies that the signature image, as stored by cosign, has at least
+// one valid signature of the digest.
+func (config *CosignConfig) Verify(signatures v1.Image, digest v1.Hash) error {
+	err := config.validate()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get signature manifest: %s", err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return fmt.Errorf("no signatures found for %s", digest)
+	}
+
+	var failures []string
+	for _, desc := range manifest.Layers {
+		err := config.verifyLayer(signatures, desc, digest)
+		if err == nil {
+			return nil
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %s", desc.Digest, err))
+	}
+
+	return fmt.Errorf("no valid signature for %s (%s)", digest, strings.Join(failures, "; "))
+}
+
+func (config *CosignConfig) validate() error {
+	if (config.PublicKey == "") == (config.Keyless == nil) {
+		return errors.New("exactly one of public_key or keyless must be configured")
+	}
+
+	return nil
+}
+
+func (config *CosignConfig) verifyLayer(signatures v1.Image, desc v1.Descriptor, digest v1.Hash) error {
+	encoded, found := desc.Annotations[CosignSignatureAnnotation]
+	if !found {
+		return errors.New("missing signature annotation")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+
+	payload, err := readBlob(signatures, desc)
+	if err != nil {
+		return err
+	}
+
+	key, err := config.signingKey(desc.Annotations, payload, signature)
+	if err != nil {
+		return err
+	}
+
+	err = verifySignature(key, payload, signature)
+	if err != nil {
+		return err
+	}
+
+	return verifyPayload(payload, digest)
+}
+
+// signingKey returns the key that the signature of the message, in a layer
+// with the annotations, must be made with: either the configured public key,
+// or the key of its verified signing certificate.
+func (config *CosignConfig) signingKey(annotations map[string]string, message []byte, signature []byte) (crypto.PublicKey, error) {
+	if config.Keyless != nil {
+		return config.Keyless.verifyCertificate(annotations, message, signature)
+	}
+
+	key, err := parsePublicKey(config.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public_key: %s", err)
+	}
+
+	return key, nil
+}
+
+// readBlob reads the content of one of the image's layers, verifying it
+// against the descriptor's digest.
+func readBlob(image v1.Image, desc v1.Descriptor) ([]byte, error) {
+	layer, err := image.LayerByDigest(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	defer blob.Close()
+
+	content, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _, err := v1.SHA256(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if actual != desc.Digest {
+		return nil, fmt.Errorf("digest mismatch: got %s", actual)
+	}
+
+	return content, nil
+}
+
+// verifyCertificate verifies the signing certificate of a keyless signature
+// of the message, returning its public key.
+func (keyless *CosignKeyless) verifyCertificate(annotations map[string]string, message []byte, signature []byte) (crypto.PublicKey, error) {
+	certPEM, found := annotations[CosignCertificateAnnotation]
+	if !found {
+		return nil, errors.New("missing certificate annotation")
+	}
+
+	certs, err := parseCertificates(certPEM)
+	if err != nil || len(certs) != 1 {
+		return nil, fmt.Errorf("invalid certificate: %v", err)
+	}
+
+	cert := certs[0]
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(keyless.RootCerts)) {
+		return nil, errors.New("no root_certs configured")
+	}
+
+	intermediates := x509.NewCertPool()
+	if chain, found := annotations[CosignChainAnnotation]; found {
+		intermediates.AppendCertsFromPEM([]byte(chain))
+	}
+
+	// signing certificates are only valid for a few minutes, so they're
+	// verified as of the time the signature was logged, but only if that
+	// time can be trusted
+	signedAt := time.Now()
+	if bundle, found := annotations[CosignBundleAnnotation]; found && keyless.RekorPublicKey != "" {
+		signedAt, err = keyless.verifyBundle(bundle, certPEM, message, signature)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: %s", err)
+		}
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("untrusted certificate: %s", err)
+	}
+
+	if !certificateHasIdentity(cert, keyless.Identity) {
+		return nil, fmt.Errorf("certificate is not issued to %s", keyless.Identity)
+	}
+
+	issuer, err := certificateIssuer(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if issuer != keyless.Issuer {
+		return nil, fmt.Errorf("certificate identity was issued by %s, not %s", issuer, keyless.Issuer)
+	}
+
+	return cert.PublicKey, nil
+}
+
+// rekorBundle is a transparency log entry, as stored by cosign.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+
+	// Payload's fields are in lexical order so that it marshals to the
+	// canonical JSON that the timestamp is signed over.
+	Payload struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	} `json:"Payload"`
+}
+
+// hashedRekord is the body of a transparency log entry for a signature of a
+// message by a certificate.
+type hashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyBundle verifies the log entry against the log's public key, and that
+// it is for the signature of the message by the certificate, returning the
+// time it was logged at.
+func (keyless *CosignKeyless) verifyBundle(encoded string, certPEM string, message []byte, signature []byte) (time.Time, error) {
+	var bundle rekorBundle
+	err := json.Unmarshal([]byte(encoded), &bundle)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	key, err := parsePublicKey(keyless.RekorPublicKey)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid rekor_public_key: %s", err)
+	}
+
+	canonical, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = verifySignature(key, canonical, bundle.SignedEntryTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("signed entry timestamp: %s", err)
+	}
+
+	rawBody, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid body: %s", err)
+	}
+
+	var body hashedRekord
+	err = json.Unmarshal(rawBody, &body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid body: %s", err)
+	}
+
+	if body.Kind != "hashedrekord" || body.Spec.Data.Hash.Algorithm != "sha256" {
+		return time.Time{}, fmt.Errorf("unsupported entry kind %q", body.Kind)
+	}
+
+	hash := sha256.Sum256(message)
+	if body.Spec.Data.Hash.Value != hex.EncodeToString(hash[:]) {
+		return time.Time{}, errors.New("entry is for another payload")
+	}
+
+	if !bytes.Equal(body.Spec.Signature.Content, signature) {
+		return time.Time{}, errors.New("entry is for another signature")
+	}
+
+	if string(body.Spec.Signature.PublicKey.Content) != certPEM {
+		return time.Time{}, errors.New("entry is for another certificate")
+	}
+
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+func certificateHasIdentity(cert *x509.Certificate, identity string) bool {
+	for _, email := range cert.EmailAddresses {
+		if email == identity {
+			return true
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if uri.String() == identity {
+			return true
+		}
+	}
+
+	return false
+}
+
+func certificateIssuer(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			var issuer string
+			_, err := asn1.Unmarshal(ext.Value, &issuer)
+			if err != nil {
+				return "", fmt.Errorf("invalid issuer extension: %s", err)
+			}
+
+			return issuer, nil
+
+		case ext.Id.Equal(oidIssuerV1):
+			return string(ext.Value), nil
+		}
+	}
+
+	return "", errors.New("certificate has no issuer extension")
+}
+
+// verifyPayload verifies that a signature payload refers to the digest.
+func verifyPayload(payload []byte, digest v1.Hash) error {
+	var simpleSigning struct {
+		Critical struct {
+			Type  string `json:"type"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+
+	err := json.Unmarshal(payload, &simpleSigning)
+	if err != nil {
+		return fmt.Errorf("invalid payload: %s", err)
+	}
+
+	if simpleSigning.Critical.Type != CosignPayloadType {
+		return fmt.Errorf("unknown payload type %q", simpleSigning.Critical.Type)
+	}
+
+	if simpleSigning.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature is for %s", simpleSigning.Critical.Image.DockerManifestDigest)
+	}
+
+	return nil
+}
+
+func verifySignature(key crypto.PublicKey, payload []byte, signature []byte) error {
+	hash := sha256.Sum256(payload)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], signature) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, signature) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func parsePublicKey(keyPEM string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func parseCertificates(certsPEM string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	rest := []byte(certsPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,301 @@
+package resource_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// signatureImage is a cosign signature image with a single signature layer.
+type signatureImage struct {
+	payload     []byte
+	annotations map[string]string
+}
+
+func (image signatureImage) RawConfigFile() ([]byte, error) {
+	return []byte("{}"), nil
+}
+
+func (image signatureImage) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (image signatureImage) RawManifest() ([]byte, error) {
+	config, _, err := v1.SHA256(strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+
+	digest, size, err := v1.SHA256(bytes.NewReader(image.payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config: v1.Descriptor{
+			MediaType: types.OCIConfigJSON,
+			Size:      2,
+			Digest:    config,
+		},
+		Layers: []v1.Descriptor{{
+			MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+			Size:        size,
+			Digest:      digest,
+			Annotations: image.annotations,
+		}},
+	})
+}
+
+func (image signatureImage) LayerByDigest(v1.Hash) (partial.CompressedLayer, error) {
+	return image, nil
+}
+
+func (image signatureImage) Digest() (v1.Hash, error) {
+	digest, _, err := v1.SHA256(bytes.NewReader(image.payload))
+	return digest, err
+}
+
+func (image signatureImage) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(image.payload)), nil
+}
+
+func (image signatureImage) Size() (int64, error) {
+	return int64(len(image.payload)), nil
+}
+
+var _ = Describe("CosignConfig", func() {
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+
+	payloadFor := func(digest v1.Hash) []byte {
+		return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"some/repo"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, digest))
+	}
+
+	sign := func(key *ecdsa.PrivateKey, payload []byte) string {
+		hash := sha256.Sum256(payload)
+		signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		Expect(err).ToNot(HaveOccurred())
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+
+	publicKeyPEM := func(key *ecdsa.PrivateKey) string {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	verify := func(config resource.CosignConfig, image signatureImage) error {
+		signatures, err := partial.CompressedToImage(image)
+		Expect(err).ToNot(HaveOccurred())
+		return config.Verify(signatures, digest)
+	}
+
+	var key *ecdsa.PrivateKey
+
+	BeforeEach(func() {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should require a public key or keyless constraints", func() {
+		err := verify(resource.CosignConfig{}, signatureImage{payload: payloadFor(digest)})
+		Expect(err).To(MatchError(ContainSubstring("exactly one of public_key or keyless")))
+	})
+
+	Context("with a public key", func() {
+		var config resource.CosignConfig
+
+		BeforeEach(func() {
+			config = resource.CosignConfig{PublicKey: publicKeyPEM(key)}
+		})
+
+		It("should accept a valid signature", func() {
+			payload := payloadFor(digest)
+
+			Expect(verify(config, signatureImage{
+				payload:     payload,
+				annotations: map[string]string{resource.CosignSignatureAnnotation: sign(key, payload)},
+			})).To(Succeed())
+		})
+
+		It("should reject signatures made with another key", func() {
+			otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+
+			payload := payloadFor(digest)
+
+			err = verify(config, signatureImage{
+				payload:     payload,
+				annotations: map[string]string{resource.CosignSignatureAnnotation: sign(otherKey, payload)},
+			})
+			Expect(err).To(MatchError(ContainSubstring("no valid signature")))
+		})
+
+		It("should reject signatures of another image", func() {
+			payload := payloadFor(v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)})
+
+			err := verify(config, signatureImage{
+				payload:     payload,
+				annotations: map[string]string{resource.CosignSignatureAnnotation: sign(key, payload)},
+			})
+			Expect(err).To(MatchError(ContainSubstring("signature is for sha256:bbbb")))
+		})
+	})
+
+	Context("keyless", func() {
+		var rootPEM string
+		var certPEM string
+
+		BeforeEach(func() {
+			rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+
+			root := &x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: "some-root"},
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+				KeyUsage:              x509.KeyUsageCertSign,
+			}
+
+			rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			root, err = x509.ParseCertificate(rootDER)
+			Expect(err).ToNot(HaveOccurred())
+
+			issuer, err := asn1.Marshal("https://some-issuer.example.com")
+			Expect(err).ToNot(HaveOccurred())
+
+			leaf := &x509.Certificate{
+				SerialNumber:   big.NewInt(2),
+				NotBefore:      time.Now().Add(-time.Minute),
+				NotAfter:       time.Now().Add(time.Minute),
+				EmailAddresses: []string{"someone@example.com"},
+				KeyUsage:       x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+				ExtraExtensions: []pkix.Extension{{
+					Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8},
+					Value: issuer,
+				}},
+			}
+
+			leafDER, err := x509.CreateCertificate(rand.Reader, leaf, root, &key.PublicKey, rootKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			rootPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}))
+			certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}))
+		})
+
+		signed := func() signatureImage {
+			payload := payloadFor(digest)
+
+			return signatureImage{
+				payload: payload,
+				annotations: map[string]string{
+					resource.CosignSignatureAnnotation:   sign(key, payload),
+					resource.CosignCertificateAnnotation: certPEM,
+				},
+			}
+		}
+
+		It("should accept a certificate issued to the identity", func() {
+			Expect(verify(resource.CosignConfig{
+				Keyless: &resource.CosignKeyless{
+					Identity:  "someone@example.com",
+					Issuer:    "https://some-issuer.example.com",
+					RootCerts: rootPEM,
+				},
+			}, signed())).To(Succeed())
+		})
+
+		It("should ignore the log entry's time without a rekor_public_key", func() {
+			signature := signed()
+			signature.annotations[resource.CosignBundleAnnotation] = fmt.Sprintf(
+				`{"SignedEntryTimestamp":"","Payload":{"body":"","integratedTime":%d,"logID":"","logIndex":0}}`,
+				time.Now().Add(-2*time.Hour).Unix(),
+			)
+
+			Expect(verify(resource.CosignConfig{
+				Keyless: &resource.CosignKeyless{
+					Identity:  "someone@example.com",
+					Issuer:    "https://some-issuer.example.com",
+					RootCerts: rootPEM,
+				},
+			}, signature)).To(Succeed())
+		})
+
+		It("should reject certificates issued to another identity", func() {
+			err := verify(resource.CosignConfig{
+				Keyless: &resource.CosignKeyless{
+					Identity:  "someone-else@example.com",
+					Issuer:    "https://some-issuer.example.com",
+					RootCerts: rootPEM,
+				},
+			}, signed())
+			Expect(err).To(MatchError(ContainSubstring("not issued to someone-else@example.com")))
+		})
+
+		It("should reject certificates from another issuer", func() {
+			err := verify(resource.CosignConfig{
+				Keyless: &resource.CosignKeyless{
+					Identity:  "someone@example.com",
+					Issuer:    "https://another-issuer.example.com",
+					RootCerts: rootPEM,
+				},
+			}, signed())
+			Expect(err).To(MatchError(ContainSubstring("issued by https://some-issuer.example.com")))
+		})
+
+		It("should reject certificates that don't chain up to the roots", func() {
+			otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+
+			other := &x509.Certificate{
+				SerialNumber:          big.NewInt(3),
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				IsCA:                  true,
+				BasicConstraintsValid: true,
+			}
+
+			otherDER, err := x509.CreateCertificate(rand.Reader, other, other, &otherKey.PublicKey, otherKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = verify(resource.CosignConfig{
+				Keyless: &resource.CosignKeyless{
+					Identity:  "someone@example.com",
+					Issuer:    "https://some-issuer.example.com",
+					RootCerts: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherDER})),
+				},
+			}, signed())
+			Expect(err).To(MatchError(ContainSubstring("untrusted certificate")))
+		})
+	})
+})
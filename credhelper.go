@@ -0,0 +1,102 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const credentialHelperPrefix = "docker-credential-"
+
+// credentialHelperNotFound is printed by credential helpers that have no
+// credentials for the requested registry.
+const credentialHelperNotFound = "credentials not found in native keychain"
+
+// CredentialHelperAuthenticator obtains registry credentials by invoking a
+// docker credential helper, e.g. docker-credential-ecr-login.
+type CredentialHelperAuthenticator struct {
+	helper   string
+	registry string
+
+	lock sync.Mutex
+	auth authn.Authenticator
+}
+
+// NewCredentialHelperAuthenticator configures a credential helper to be asked
+// for the credentials of the registry hosting the given repository. The
+// helper may be given with or without its "docker-credential-" prefix.
+func NewCredentialHelperAuthenticator(helper string, repository string) (*CredentialHelperAuthenticator, error) {
+	repo, err := name.NewRepository(repository, name.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve repository: %s", err)
+	}
+
+	if !strings.HasPrefix(helper, credentialHelperPrefix) {
+		helper = credentialHelperPrefix + helper
+	}
+
+	return &CredentialHelperAuthenticator{
+		helper:   helper,
+		registry: repo.RegistryStr(),
+	}, nil
+}
+
+// Authorization implements authn.Authenticator. The helper is only invoked
+// once; its credentials are reused for subsequent requests.
+func (auth *CredentialHelperAuthenticator) Authorization() (string, error) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if auth.auth == nil {
+		creds, err := auth.get()
+		if err != nil {
+			return "", err
+		}
+
+		auth.auth = creds
+	}
+
+	return auth.auth.Authorization()
+}
+
+func (auth *CredentialHelperAuthenticator) get() (authn.Authenticator, error) {
+	cmd := exec.Command(auth.helper, "get")
+	cmd.Stdin = strings.NewReader(auth.registry)
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+
+	output := strings.TrimSpace(stdout.String())
+	if output == credentialHelperNotFound {
+		return authn.Anonymous, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %s failed: %s: %s", auth.helper, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+
+	err = json.Unmarshal([]byte(output), &creds)
+	if err != nil {
+		return nil, fmt.Errorf("malformed output from credential helper %s: %s", auth.helper, err)
+	}
+
+	return &authn.Basic{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
@@ -0,0 +1,71 @@
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("CredentialHelperAuthenticator", func() {
+	var binDir string
+	var oldPath string
+
+	BeforeEach(func() {
+		var err error
+		binDir, err = ioutil.TempDir("", "credential-helper")
+		Expect(err).ToNot(HaveOccurred())
+
+		oldPath = os.Getenv("PATH")
+		os.Setenv("PATH", binDir+string(os.PathListSeparator)+oldPath)
+	})
+
+	AfterEach(func() {
+		os.Setenv("PATH", oldPath)
+		Expect(os.RemoveAll(binDir)).To(Succeed())
+	})
+
+	writeHelper := func(script string) {
+		path := filepath.Join(binDir, "docker-credential-fake")
+		err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("should use the credentials returned for the registry", func() {
+		writeHelper(`read registry
+[ "$1" = "get" ] && [ "$registry" = "registry.example.com" ] || exit 1
+echo '{"ServerURL":"registry.example.com","Username":"user","Secret":"pass"}'`)
+
+		auth, err := resource.NewCredentialHelperAuthenticator("fake", "registry.example.com/some/repo")
+		Expect(err).ToNot(HaveOccurred())
+
+		expected, err := (&authn.Basic{Username: "user", Password: "pass"}).Authorization()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(auth.Authorization()).To(Equal(expected))
+	})
+
+	It("should fall back to anonymous when the helper has no credentials", func() {
+		writeHelper(`echo "credentials not found in native keychain"; exit 1`)
+
+		auth, err := resource.NewCredentialHelperAuthenticator("docker-credential-fake", "registry.example.com/some/repo")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(auth.Authorization()).To(BeEmpty())
+	})
+
+	It("should fail when the helper fails", func() {
+		writeHelper(`echo "boom" >&2; exit 1`)
+
+		auth, err := resource.NewCredentialHelperAuthenticator("fake", "registry.example.com/some/repo")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = auth.Authorization()
+		Expect(err).To(MatchError(ContainSubstring("boom")))
+	})
+})
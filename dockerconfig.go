@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerHubAliases are the hostnames Docker Hub is known by in docker configs,
+// e.g. in the traditional https://index.docker.io/v1/ key.
+var dockerHubAliases = map[string]bool{
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// DockerConfig is the format of ~/.docker/config.json, as also used by
+// Kubernetes' dockerconfigjson secrets.
+type DockerConfig struct {
+	Auths       map[string]DockerConfigAuth `json:"auths"`
+	CredHelpers map[string]string           `json:"credHelpers,omitempty"`
+	CredsStore  string                      `json:"credsStore,omitempty"`
+}
+
+type DockerConfigAuth struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// NewDockerConfigAuthenticator resolves the credentials for the registry
+// hosting the given repository from a docker config.
+func NewDockerConfigAuthenticator(configJSON string, repository string) (authn.Authenticator, error) {
+	var config DockerConfig
+	err := json.Unmarshal([]byte(configJSON), &config)
+	if err != nil {
+		return nil, fmt.Errorf("malformed docker config: %s", err)
+	}
+
+	repo, err := name.NewRepository(repository, name.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve repository: %s", err)
+	}
+
+	registry := repo.RegistryStr()
+
+	helper, found := config.CredHelpers[registry]
+	if !found {
+		for key, keyHelper := range config.CredHelpers {
+			if dockerConfigKeyMatches(key, registry) {
+				helper, found = keyHelper, true
+				break
+			}
+		}
+	}
+
+	if found {
+		return NewCredentialHelperAuthenticator(helper, repository)
+	}
+
+	for key, auth := range config.Auths {
+		if !dockerConfigKeyMatches(key, registry) {
+			continue
+		}
+
+		username, password := auth.Username, auth.Password
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("malformed auth for %s in docker config: %s", key, err)
+			}
+
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("malformed auth for %s in docker config", key)
+			}
+
+			username, password = parts[0], parts[1]
+		}
+
+		return &authn.Basic{
+			Username: username,
+			Password: password,
+		}, nil
+	}
+
+	if config.CredsStore != "" {
+		return NewCredentialHelperAuthenticator(config.CredsStore, repository)
+	}
+
+	return authn.Anonymous, nil
+}
+
+// dockerConfigKeyMatches determines whether a key in a docker config's auths
+// or credHelpers refers to the given registry. Keys may be bare hostnames or URLs, e.g.
+// "https://registry.example.com/v1/", and any of Docker Hub's aliases match
+// it.
+func dockerConfigKeyMatches(key string, registry string) bool {
+	host := strings.TrimPrefix(key, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+
+	return normalizeDockerHub(host) == normalizeDockerHub(registry)
+}
+
+// normalizeDockerHub returns Docker Hub's canonical hostname for any of its
+// aliases, and other hostnames as they are.
+func normalizeDockerHub(host string) string {
+	if dockerHubAliases[host] {
+		return name.DefaultRegistry
+	}
+
+	return host
+}
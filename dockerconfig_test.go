@@ -0,0 +1,73 @@
+package resource_test
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("DockerConfigAuthenticator", func() {
+	const config = `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "aHViLXVzZXI6aHViLXBhc3M="},
+			"registry.example.com": {"username": "user", "password": "pass"}
+		},
+		"credHelpers": {
+			"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"
+		}
+	}`
+
+	It("should resolve Docker Hub credentials from their legacy key", func() {
+		auth, err := resource.NewDockerConfigAuthenticator(config, "concourse/test-image-static")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(&authn.Basic{Username: "hub-user", Password: "hub-pass"}))
+	})
+
+	It("should resolve Docker Hub credentials from any of its aliases", func() {
+		for _, key := range []string{"docker.io", "index.docker.io", "registry-1.docker.io", "https://index.docker.io/v1/"} {
+			config := `{"auths": {"` + key + `": {"username": "hub-user", "password": "hub-pass"}}}`
+
+			auth, err := resource.NewDockerConfigAuthenticator(config, "docker.io/concourse/test-image-static")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth).To(Equal(&authn.Basic{Username: "hub-user", Password: "hub-pass"}), key)
+		}
+	})
+
+	It("should resolve credentials by registry hostname", func() {
+		auth, err := resource.NewDockerConfigAuthenticator(config, "registry.example.com/some/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(&authn.Basic{Username: "user", Password: "pass"}))
+	})
+
+	It("should use the registry's credential helper", func() {
+		auth, err := resource.NewDockerConfigAuthenticator(config, "123456789012.dkr.ecr.us-east-1.amazonaws.com/some/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(BeAssignableToTypeOf(&resource.CredentialHelperAuthenticator{}))
+	})
+
+	It("should use Docker Hub's credential helper under any of its aliases", func() {
+		for _, key := range []string{"docker.io", "index.docker.io", "https://index.docker.io/v1/"} {
+			config := `{
+				"auths": {"registry.example.com": {"username": "user", "password": "pass"}},
+				"credHelpers": {"` + key + `": "desktop"}
+			}`
+
+			auth, err := resource.NewDockerConfigAuthenticator(config, "concourse/test-image-static")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth).To(BeAssignableToTypeOf(&resource.CredentialHelperAuthenticator{}), key)
+		}
+	})
+
+	It("should be anonymous for unknown registries", func() {
+		auth, err := resource.NewDockerConfigAuthenticator(config, "other.example.com/some/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(authn.Anonymous))
+	})
+
+	It("should fail with a malformed config", func() {
+		_, err := resource.NewDockerConfigAuthenticator("{", "registry.example.com/some/repo")
+		Expect(err).To(HaveOccurred())
+	})
+})
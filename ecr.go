@@ -0,0 +1,241 @@
+package resource
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/sirupsen/logrus"
+)
+
+// ecrTokenRefreshMargin is how long before its expiry an ECR token is
+// considered stale, so that in-flight requests never race the deadline.
+const ecrTokenRefreshMargin = 5 * time.Minute
+
+var ecrRegistryRegexp = regexp.MustCompile(`^(\d{12})\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// ECRAuthenticator exchanges IAM credentials for ECR authorization tokens,
+// refreshing them whenever they are about to expire.
+type ECRAuthenticator struct {
+	client     ecriface.ECRAPI
+	registryID string
+
+	lock      sync.Mutex
+	basic     *authn.Basic
+	expiresAt time.Time
+}
+
+// NewECRAuthenticator configures an ECR client from the source's AWS
+// credentials, assuming the configured role if any, and fetches an initial
+// authorization token.
+func NewECRAuthenticator(source *Source) (*ECRAuthenticator, error) {
+	config := &aws.Config{
+		Region: aws.String(source.AwsRegion),
+	}
+
+	if source.AwsAccessKeyId != "" && source.AwsSecretAccessKey != "" {
+		config.Credentials = credentials.NewStaticCredentials(
+			source.AwsAccessKeyId,
+			source.AwsSecretAccessKey,
+			"",
+		)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %s", err)
+	}
+
+	var client *ecr.ECR
+	if source.AwsRoleArn != "" {
+		// the assumed role's credentials are refreshed by the provider itself
+		// as they expire
+		roleCreds := stscreds.NewCredentials(sess, source.AwsRoleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = source.AwsSessionName
+
+			if source.AwsExternalId != "" {
+				p.ExternalID = aws.String(source.AwsExternalId)
+			}
+		})
+
+		client = ecr.New(sess, &aws.Config{Credentials: roleCreds})
+	} else {
+		client = ecr.New(sess)
+	}
+
+	return NewECRClientAuthenticator(client, source.Repository)
+}
+
+// NewECRClientAuthenticator fetches an initial authorization token for the
+// repository's registry with the ECR client.
+func NewECRClientAuthenticator(client ecriface.ECRAPI, repository string) (*ECRAuthenticator, error) {
+	auth := &ECRAuthenticator{
+		client:     client,
+		registryID: ecrRegistryID(repository),
+	}
+
+	err := auth.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// Authorization implements authn.Authenticator.
+func (auth *ECRAuthenticator) Authorization() (string, error) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	if time.Now().Add(ecrTokenRefreshMargin).After(auth.expiresAt) {
+		logrus.Debug("refreshing ECR authorization token")
+
+		err := auth.refresh()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return auth.basic.Authorization()
+}
+
+func (auth *ECRAuthenticator) refresh() error {
+	input := &ecr.GetAuthorizationTokenInput{}
+	if auth.registryID != "" {
+		input.RegistryIds = []*string{aws.String(auth.registryID)}
+	}
+
+	output, err := auth.client.GetAuthorizationToken(input)
+	if err != nil {
+		return fmt.Errorf("failed to get ECR authorization token: %s", err)
+	}
+
+	if len(output.AuthorizationData) == 0 {
+		return fmt.Errorf("no ECR authorization data returned")
+	}
+
+	data := output.AuthorizationData[0]
+
+	token, err := base64.StdEncoding.DecodeString(aws.StringValue(data.AuthorizationToken))
+	if err != nil {
+		return fmt.Errorf("failed to decode ECR authorization token: %s", err)
+	}
+
+	parts := strings.SplitN(string(token), ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed ECR authorization token")
+	}
+
+	auth.basic = &authn.Basic{
+		Username: parts[0],
+		Password: parts[1],
+	}
+	auth.expiresAt = aws.TimeValue(data.ExpiresAt)
+
+	return nil
+}
+
+// ECRRepositorySettings configure ECR repositories created on put.
+type ECRRepositorySettings struct {
+	// Tags are the AWS resource tags of the repository, e.g. its owner.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ScanOnPush enables ECR's scanning of images as they're pushed.
+	ScanOnPush bool `json:"scan_on_push,omitempty"`
+
+	// ImmutableTags prevents tags from being pushed again once pushed.
+	ImmutableTags bool `json:"immutable_tags,omitempty"`
+}
+
+// EnsureRepository creates the repository, within the registry this
+// authenticator is for, unless it already exists. It returns whether the
+// repository was created.
+func (auth *ECRAuthenticator) EnsureRepository(repository string, settings ECRRepositorySettings) (bool, error) {
+	return EnsureECRRepository(auth.client, auth.registryID, repository, settings)
+}
+
+// EnsureECRRepository creates the repository with the settings unless it
+// already exists, returning whether it was created. Existing repositories are
+// left as they are, even if their settings differ.
+func EnsureECRRepository(client ecriface.ECRAPI, registryID string, repository string, settings ECRRepositorySettings) (bool, error) {
+	describe := &ecr.DescribeRepositoriesInput{
+		RepositoryNames: []*string{aws.String(repository)},
+	}
+
+	if registryID != "" {
+		describe.RegistryId = aws.String(registryID)
+	}
+
+	_, err := client.DescribeRepositories(describe)
+	if err == nil {
+		return false, nil
+	}
+
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ecr.ErrCodeRepositoryNotFoundException {
+		return false, fmt.Errorf("failed to describe ECR repository: %s", err)
+	}
+
+	create := &ecr.CreateRepositoryInput{
+		RepositoryName: aws.String(repository),
+		ImageScanningConfiguration: &ecr.ImageScanningConfiguration{
+			ScanOnPush: aws.Bool(settings.ScanOnPush),
+		},
+		ImageTagMutability: aws.String(ecr.ImageTagMutabilityMutable),
+	}
+
+	if settings.ImmutableTags {
+		create.ImageTagMutability = aws.String(ecr.ImageTagMutabilityImmutable)
+	}
+
+	keys := make([]string, 0, len(settings.Tags))
+	for key := range settings.Tags {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		create.Tags = append(create.Tags, &ecr.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(settings.Tags[key]),
+		})
+	}
+
+	_, err = client.CreateRepository(create)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ecr.ErrCodeRepositoryAlreadyExistsException {
+			// created concurrently, e.g. by another put
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to create ECR repository: %s", err)
+	}
+
+	return true, nil
+}
+
+// ecrRegistryID extracts the AWS account ID from an ECR repository, e.g.
+// 123456789012.dkr.ecr.eu-west-1.amazonaws.com/foo. It returns an empty string
+// for repositories that are not hosted on ECR.
+func ecrRegistryID(repository string) string {
+	host := strings.SplitN(repository, "/", 2)[0]
+
+	matches := ecrRegistryRegexp.FindStringSubmatch(host)
+	if matches == nil {
+		return ""
+	}
+
+	return matches[1]
+}
@@ -0,0 +1,203 @@
+package resource_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecr/ecriface"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// fakeECR implements the ECR API calls made to ensure repositories exist.
+type fakeECR struct {
+	ecriface.ECRAPI
+
+	repositories map[string]bool
+
+	describeErr error
+	createErr   error
+
+	created []*ecr.CreateRepositoryInput
+
+	expiresIn      time.Duration
+	authorizations []*ecr.GetAuthorizationTokenInput
+}
+
+func (client *fakeECR) GetAuthorizationToken(input *ecr.GetAuthorizationTokenInput) (*ecr.GetAuthorizationTokenOutput, error) {
+	client.authorizations = append(client.authorizations, input)
+
+	token := fmt.Sprintf("AWS:some-token-%d", len(client.authorizations))
+
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []*ecr.AuthorizationData{{
+			AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte(token))),
+			ExpiresAt:          aws.Time(time.Now().Add(client.expiresIn)),
+		}},
+	}, nil
+}
+
+func (client *fakeECR) DescribeRepositories(input *ecr.DescribeRepositoriesInput) (*ecr.DescribeRepositoriesOutput, error) {
+	if client.describeErr != nil {
+		return nil, client.describeErr
+	}
+
+	name := aws.StringValue(input.RepositoryNames[0])
+	if !client.repositories[name] {
+		return nil, awserr.New(ecr.ErrCodeRepositoryNotFoundException, "not found", nil)
+	}
+
+	return &ecr.DescribeRepositoriesOutput{
+		Repositories: []*ecr.Repository{{RepositoryName: aws.String(name)}},
+	}, nil
+}
+
+func (client *fakeECR) CreateRepository(input *ecr.CreateRepositoryInput) (*ecr.CreateRepositoryOutput, error) {
+	if client.createErr != nil {
+		return nil, client.createErr
+	}
+
+	client.created = append(client.created, input)
+	client.repositories[aws.StringValue(input.RepositoryName)] = true
+
+	return &ecr.CreateRepositoryOutput{}, nil
+}
+
+var _ = Describe("ECRAuthenticator", func() {
+	var client *fakeECR
+
+	BeforeEach(func() {
+		client = &fakeECR{expiresIn: 12 * time.Hour}
+	})
+
+	authorize := func(auth *resource.ECRAuthenticator) string {
+		authorization, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+
+		credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(credentials)
+	}
+
+	It("should authenticate with the authorization token", func() {
+		auth, err := resource.NewECRClientAuthenticator(client, "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(authorize(auth)).To(Equal("AWS:some-token-1"))
+	})
+
+	It("should reuse the token until it nears expiry", func() {
+		auth, err := resource.NewECRClientAuthenticator(client, "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(authorize(auth)).To(Equal("AWS:some-token-1"))
+		Expect(authorize(auth)).To(Equal("AWS:some-token-1"))
+		Expect(client.authorizations).To(HaveLen(1))
+	})
+
+	It("should refresh the token within the expiry margin", func() {
+		client.expiresIn = time.Minute
+
+		auth, err := resource.NewECRClientAuthenticator(client, "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(authorize(auth)).To(Equal("AWS:some-token-2"))
+		Expect(authorize(auth)).To(Equal("AWS:some-token-3"))
+	})
+
+	Describe("registry IDs", func() {
+		registryIDs := func(repository string) []string {
+			_, err := resource.NewECRClientAuthenticator(client, repository)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.authorizations).To(HaveLen(1))
+
+			return aws.StringValueSlice(client.authorizations[0].RegistryIds)
+		}
+
+		It("should request a token for the repository's account", func() {
+			Expect(registryIDs("210987654321.dkr.ecr.us-east-1.amazonaws.com/some/app")).To(Equal([]string{"210987654321"}))
+		})
+
+		It("should recognize China regions", func() {
+			Expect(registryIDs("123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn/app")).To(Equal([]string{"123456789012"}))
+		})
+
+		It("should request the default registry's token for other hosts", func() {
+			Expect(registryIDs("registry.example.com/app")).To(BeEmpty())
+		})
+
+		It("should not mistake malformed ECR hosts for accounts", func() {
+			for _, repository := range []string{
+				"12345.dkr.ecr.us-east-1.amazonaws.com/app",
+				"1234567890123.dkr.ecr.us-east-1.amazonaws.com/app",
+				"123456789012.dkr.ecr.us-east-1.amazonaws.com.example.com/app",
+				"foo.123456789012.dkr.ecr.us-east-1.amazonaws.com/app",
+			} {
+				client.authorizations = nil
+				Expect(registryIDs(repository)).To(BeEmpty(), repository)
+			}
+		})
+	})
+})
+
+var _ = Describe("EnsureECRRepository", func() {
+	var client *fakeECR
+
+	BeforeEach(func() {
+		client = &fakeECR{
+			repositories: map[string]bool{"existing": true},
+		}
+	})
+
+	It("should create missing repositories with the settings", func() {
+		created, err := resource.EnsureECRRepository(client, "123456789012", "some/service", resource.ECRRepositorySettings{
+			Tags:          map[string]string{"team": "some-team", "cost-center": "42"},
+			ScanOnPush:    true,
+			ImmutableTags: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeTrue())
+
+		Expect(client.created).To(HaveLen(1))
+
+		input := client.created[0]
+		Expect(aws.StringValue(input.RepositoryName)).To(Equal("some/service"))
+		Expect(aws.BoolValue(input.ImageScanningConfiguration.ScanOnPush)).To(BeTrue())
+		Expect(aws.StringValue(input.ImageTagMutability)).To(Equal(ecr.ImageTagMutabilityImmutable))
+		Expect(input.Tags).To(Equal([]*ecr.Tag{
+			{Key: aws.String("cost-center"), Value: aws.String("42")},
+			{Key: aws.String("team"), Value: aws.String("some-team")},
+		}))
+	})
+
+	It("should leave existing repositories as they are", func() {
+		created, err := resource.EnsureECRRepository(client, "", "existing", resource.ECRRepositorySettings{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeFalse())
+		Expect(client.created).To(BeEmpty())
+	})
+
+	It("should tolerate repositories created concurrently", func() {
+		client.createErr = awserr.New(ecr.ErrCodeRepositoryAlreadyExistsException, "exists", nil)
+
+		created, err := resource.EnsureECRRepository(client, "", "some/service", resource.ECRRepositorySettings{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeFalse())
+	})
+
+	It("should fail when the repository cannot be described", func() {
+		client.describeErr = awserr.New("AccessDeniedException", "denied", nil)
+
+		_, err := resource.EnsureECRRepository(client, "", "some/service", resource.ECRRepositorySettings{})
+		Expect(err).To(MatchError(ContainSubstring("failed to describe ECR repository")))
+		Expect(client.created).To(BeEmpty())
+	})
+})
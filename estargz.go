@@ -0,0 +1,43 @@
+package resource
+
+import (
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// EstargzTOCDigestAnnotation is set on eStargz layers to the digest of their
+// table of contents, which lazy-pulling runtimes (e.g. the stargz snapshotter)
+// use to fetch files on demand.
+const EstargzTOCDigestAnnotation = "containerd.io/snapshot/stargz/toc.digest"
+
+// estargzEntries are the entries of an eStargz layer that exist for the sake
+// of lazy pulling, rather than being part of the filesystem.
+var estargzEntries = map[string]bool{
+	"stargz.index.json":     true,
+	".prefetch.landmark":    true,
+	".no.prefetch.landmark": true,
+}
+
+// IsEstargzEntry determines whether an entry of an eStargz layer is one of its
+// lazy-pulling metadata files, which shouldn't be extracted.
+func IsEstargzEntry(name string) bool {
+	return estargzEntries[path.Clean(strings.TrimPrefix(name, "/"))]
+}
+
+// EstargzLayers reports which of the image's layers are eStargz, in the same
+// order as its Layers, going by the annotations of the layers' descriptors.
+func EstargzLayers(image v1.Image) ([]bool, error) {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	estargz := make([]bool, len(manifest.Layers))
+	for i, desc := range manifest.Layers {
+		_, estargz[i] = desc.Annotations[EstargzTOCDigestAnnotation]
+	}
+
+	return estargz, nil
+}
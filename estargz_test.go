@@ -0,0 +1,55 @@
+package resource_test
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// annotatedImage is an image whose layers' descriptors have annotations.
+type annotatedImage struct {
+	v1.Image
+	annotations []map[string]string
+}
+
+func (image annotatedImage) Manifest() (*v1.Manifest, error) {
+	manifest, err := image.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest = manifest.DeepCopy()
+	for i := range manifest.Layers {
+		manifest.Layers[i].Annotations = image.annotations[i]
+	}
+
+	return manifest, nil
+}
+
+var _ = Describe("eStargz", func() {
+	It("should find the eStargz layers", func() {
+		base, err := random.Image(10, 3)
+		Expect(err).ToNot(HaveOccurred())
+
+		image := annotatedImage{
+			Image: base,
+			annotations: []map[string]string{
+				nil,
+				{resource.EstargzTOCDigestAnnotation: "sha256:deadbeef"},
+				{"some-annotation": "some-value"},
+			},
+		}
+
+		Expect(resource.EstargzLayers(image)).To(Equal([]bool{false, true, false}))
+	})
+
+	It("should recognize the lazy-pulling metadata files", func() {
+		Expect(resource.IsEstargzEntry("stargz.index.json")).To(BeTrue())
+		Expect(resource.IsEstargzEntry("./.prefetch.landmark")).To(BeTrue())
+		Expect(resource.IsEstargzEntry("/.no.prefetch.landmark")).To(BeTrue())
+		Expect(resource.IsEstargzEntry("etc/stargz.index.json")).To(BeFalse())
+	})
+})
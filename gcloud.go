@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcloudScope is the OAuth scope granting read/write access to GCR and
+// Artifact Registry.
+const gcloudScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gcloudUsername is the username GCR and Artifact Registry expect alongside an
+// OAuth access token.
+const gcloudUsername = "oauth2accesstoken"
+
+// GcloudAuthenticator authenticates to GCR and Artifact Registry using OAuth
+// access tokens, which are refreshed by the token source as they expire.
+type GcloudAuthenticator struct {
+	tokens oauth2.TokenSource
+}
+
+// NewGcloudAuthenticator exchanges a service account JSON key for OAuth access
+// tokens.
+func NewGcloudAuthenticator(serviceAccountKey string) (*GcloudAuthenticator, error) {
+	config, err := google.JWTConfigFromJSON([]byte(serviceAccountKey), gcloudScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %s", err)
+	}
+
+	return &GcloudAuthenticator{
+		tokens: config.TokenSource(context.Background()),
+	}, nil
+}
+
+// NewGcloudMetadataAuthenticator fetches OAuth access tokens for the
+// instance's service account from the GCE metadata server, e.g. when running
+// on GKE with Workload Identity.
+func NewGcloudMetadataAuthenticator() *GcloudAuthenticator {
+	return &GcloudAuthenticator{
+		tokens: google.ComputeTokenSource(""),
+	}
+}
+
+// Authorization implements authn.Authenticator.
+func (auth *GcloudAuthenticator) Authorization() (string, error) {
+	token, err := auth.tokens.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to get gcloud access token: %s", err)
+	}
+
+	basic := &authn.Basic{
+		Username: gcloudUsername,
+		Password: token.AccessToken,
+	}
+
+	return basic.Authorization()
+}
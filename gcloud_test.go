@@ -0,0 +1,103 @@
+package resource_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("NewGcloudMetadataAuthenticator", func() {
+	var server *httptest.Server
+	var fetches int
+	var expiresIn int
+
+	var oldMetadataHost string
+
+	BeforeEach(func() {
+		fetches = 0
+		expiresIn = 3600
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/computeMetadata/v1/instance/service-accounts/default/token"))
+			Expect(r.Header.Get("Metadata-Flavor")).To(Equal("Google"))
+
+			fetches++
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": fmt.Sprintf("some-token-%d", fetches),
+				"expires_in":   expiresIn,
+				"token_type":   "Bearer",
+			})
+		}))
+
+		oldMetadataHost = os.Getenv("GCE_METADATA_HOST")
+		os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Setenv("GCE_METADATA_HOST", oldMetadataHost)
+	})
+
+	password := func(authorization string) string {
+		credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+		Expect(err).ToNot(HaveOccurred())
+
+		return strings.TrimPrefix(string(credentials), "oauth2accesstoken:")
+	}
+
+	It("should authenticate with the instance's access token", func() {
+		auth := resource.NewGcloudMetadataAuthenticator()
+
+		authorization, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(authorization).To(HavePrefix("Basic "))
+		Expect(password(authorization)).To(Equal("some-token-1"))
+	})
+
+	It("should reuse the access token until it nears expiry", func() {
+		auth := resource.NewGcloudMetadataAuthenticator()
+
+		_, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+
+		authorization, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(password(authorization)).To(Equal("some-token-1"))
+		Expect(fetches).To(Equal(1))
+	})
+
+	It("should refresh the access token once it nears expiry", func() {
+		expiresIn = 1
+
+		auth := resource.NewGcloudMetadataAuthenticator()
+
+		_, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+
+		authorization, err := auth.Authorization()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(password(authorization)).To(Equal("some-token-2"))
+		Expect(fetches).To(Equal(2))
+	})
+
+	It("should fail when the metadata server doesn't return a token", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		_, err := resource.NewGcloudMetadataAuthenticator().Authorization()
+		Expect(err).To(MatchError(ContainSubstring("failed to get gcloud access token")))
+	})
+})
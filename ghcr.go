@@ -0,0 +1,68 @@
+package resource
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sirupsen/logrus"
+)
+
+// GHCRRegistry is the GitHub Container Registry.
+const GHCRRegistry = "ghcr.io"
+
+// IsGHCR determines whether the source's repository is on the GitHub
+// Container Registry.
+func (source *Source) IsGHCR() bool {
+	repo, err := name.NewRepository(source.Repository, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+
+	return repo.RegistryStr() == GHCRRegistry
+}
+
+// githubTokenAuthenticator authenticates to GHCR with a GitHub token, e.g. a
+// personal access token or a workflow's GITHUB_TOKEN. GHCR only checks the
+// token, but requires a username, for which the repository's owner is used.
+func githubTokenAuthenticator(repository string, token string) authn.Authenticator {
+	username := "github"
+	if repo, err := name.NewRepository(repository, name.WeakValidation); err == nil {
+		username = strings.SplitN(repo.RepositoryStr(), "/", 2)[0]
+	}
+
+	return &authn.Basic{
+		Username: username,
+		Password: token,
+	}
+}
+
+// PullAuthenticator returns the credentials to pull from the repository with:
+// auth, unless the registry refuses them a token while granting one to
+// anonymous users. GHCR does so for public images when the token has no
+// access to their package, e.g. a GITHUB_TOKEN of another repository, which
+// would otherwise prevent tracking public images alongside private ones.
+func PullAuthenticator(repo name.Repository, auth authn.Authenticator, t http.RoundTripper) authn.Authenticator {
+	if auth == authn.Anonymous {
+		return auth
+	}
+
+	scopes := []string{repo.Scope(transport.PullScope)}
+
+	_, err := NewTokenTransport(repo.Registry, auth, t, scopes).authorization(false)
+	if err == nil {
+		return auth
+	}
+
+	_, anonErr := NewTokenTransport(repo.Registry, authn.Anonymous, t, scopes).authorization(false)
+	if anonErr != nil {
+		// let pulling fail with the original credentials' error
+		return auth
+	}
+
+	logrus.Warnf("credentials cannot pull %s (%s), but it is public; pulling anonymously", repo.Name(), err)
+
+	return authn.Anonymous
+}
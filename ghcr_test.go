@@ -0,0 +1,89 @@
+package resource_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("GHCR", func() {
+	It("should recognize ghcr.io repositories", func() {
+		Expect((&resource.Source{Repository: "ghcr.io/some-org/some-image"}).IsGHCR()).To(BeTrue())
+		Expect((&resource.Source{Repository: "registry.example.com/some-org/some-image"}).IsGHCR()).To(BeFalse())
+	})
+
+	It("should authenticate with github_token as the repository's owner", func() {
+		source := resource.Source{Repository: "ghcr.io/some-org/some-image", GithubToken: "some-token"}
+
+		auth, err := source.Authenticator()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(&authn.Basic{Username: "some-org", Password: "some-token"}))
+	})
+
+	Describe("PullAuthenticator", func() {
+		var server *httptest.Server
+		var repo name.Repository
+
+		var acceptCredentials bool
+		var public bool
+
+		BeforeEach(func() {
+			acceptCredentials = false
+			public = true
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v2/":
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, serverURL(r)))
+					w.WriteHeader(http.StatusUnauthorized)
+				case "/token":
+					_, _, hasCredentials := r.BasicAuth()
+					if (hasCredentials && !acceptCredentials) || (!hasCredentials && !public) {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+
+					fmt.Fprint(w, `{"token":"some-token"}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+
+			u, err := url.Parse(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			repo, err = name.NewRepository(u.Host+"/some-org/some-image", name.WeakValidation)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		auth := &authn.Basic{Username: "some-org", Password: "some-token"}
+
+		It("should pull anonymously if the credentials are refused but the image is public", func() {
+			Expect(resource.PullAuthenticator(repo, auth, http.DefaultTransport)).To(Equal(authn.Anonymous))
+		})
+
+		It("should keep credentials which are accepted", func() {
+			acceptCredentials = true
+
+			Expect(resource.PullAuthenticator(repo, auth, http.DefaultTransport)).To(Equal(auth))
+		})
+
+		It("should keep the credentials if the image isn't public", func() {
+			public = false
+
+			Expect(resource.PullAuthenticator(repo, auth, http.DefaultTransport)).To(Equal(auth))
+		})
+	})
+})
@@ -2,18 +2,25 @@ module github.com/concourse/registry-image-resource
 
 require (
 	code.cloudfoundry.org/lager v2.0.0+incompatible
+	github.com/Masterminds/semver/v3 v3.1.1
 	github.com/VividCortex/ewma v1.1.1 // indirect
+	github.com/aws/aws-sdk-go v1.25.43
 	github.com/concourse/go-archive v1.0.1
 	github.com/concourse/retryhttp v0.0.0-20181126170240-7ab5e29e634f
 	github.com/fatih/color v1.7.0
 	github.com/google/go-containerregistry v0.0.0-20181122122528-61e4aeff7593
+	github.com/klauspost/compress v1.11.13
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
 	github.com/simonshyu/notary-gcr v0.0.0-20190827084005-56dbd05c3ead
 	github.com/sirupsen/logrus v1.4.2
+	github.com/theupdateframework/notary v0.6.1
 	github.com/vbauerster/mpb v3.4.0+incompatible
+	golang.org/x/crypto v0.0.0-20190325154230-a5d413f7728c
+	golang.org/x/net v0.0.0-20190522155817-f3200d17e092
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 )
 
 go 1.13
@@ -0,0 +1,267 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultHarborRobotPrefix is the prefix Harbor gives the names of robot
+// accounts unless configured otherwise.
+const DefaultHarborRobotPrefix = "robot$"
+
+// harborQuotaWarning is the fraction of a project's storage quota beyond
+// which put warns that it's running out.
+const harborQuotaWarning = 0.9
+
+// HarborConfig configures credentials for Harbor robot accounts, and
+// reporting on the project pushed to.
+type HarborConfig struct {
+	// RobotAccount is the robot's name, e.g. robot$myproject+ci, with or
+	// without its prefix.
+	RobotAccount string `json:"robot_account,omitempty"`
+	RobotSecret  string `json:"robot_secret,omitempty"`
+
+	// RobotPrefix is the prefix of robot names, if not robot$.
+	RobotPrefix string `json:"robot_prefix,omitempty"`
+
+	// ReportProject reports the project's storage quota and whether its tag
+	// retention policy keeps the tags pushed.
+	ReportProject bool `json:"report_project,omitempty"`
+}
+
+// Credentials returns the robot's username, with the prefix Harbor expects,
+// and its secret.
+func (config *HarborConfig) Credentials() (string, string, error) {
+	if config.RobotAccount == "" || config.RobotSecret == "" {
+		return "", "", fmt.Errorf("harbor requires robot_account and robot_secret")
+	}
+
+	prefix := config.RobotPrefix
+	if prefix == "" {
+		prefix = DefaultHarborRobotPrefix
+	}
+
+	username := config.RobotAccount
+	if !strings.HasPrefix(username, prefix) {
+		// the name is often given without it, as it's awkward to quote
+		username = prefix + username
+	}
+
+	return username, config.RobotSecret, nil
+}
+
+// harborRetentionRule is a rule of a Harbor project's tag retention policy,
+// e.g. to retain the 10 most recently pushed tags.
+type harborRetentionRule struct {
+	harborRule
+
+	Template string                 `json:"template"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+func (rule harborRetentionRule) String() string {
+	var params []string
+	for _, value := range rule.Params {
+		params = append(params, fmt.Sprintf("%v", value))
+	}
+
+	sort.Strings(params)
+
+	var patterns []string
+	for _, selector := range rule.TagSelectors {
+		patterns = append(patterns, selector.Pattern)
+	}
+
+	description := rule.Template
+	if len(params) > 0 {
+		description += " " + strings.Join(params, " ")
+	}
+
+	return fmt.Sprintf("%s of tags matching %s", description, strings.Join(patterns, ","))
+}
+
+// HarborProjectMetadata reports the storage quota of the repository's Harbor
+// project and its tag retention rules as metadata, warning if the quota is
+// nearly used up or if the retention rules don't retain any of the tags, as
+// Harbor would delete them. Nothing is reported for registries which aren't
+// Harbor.
+func HarborProjectMetadata(repo name.Repository, tags []string, auth authn.Authenticator, t http.RoundTripper) ([]MetadataField, error) {
+	project := harborProject(repo)
+
+	var summary struct {
+		Quota *struct {
+			Hard struct {
+				Storage int64 `json:"storage"`
+			} `json:"hard"`
+			Used struct {
+				Storage int64 `json:"storage"`
+			} `json:"used"`
+		} `json:"quota"`
+	}
+
+	found, err := harborGet(repo, fmt.Sprintf("/projects/%s/summary", project), auth, t, &summary)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		logrus.Debugf("no Harbor project %s", project)
+		return nil, nil
+	}
+
+	var metadata []MetadataField
+	if summary.Quota != nil {
+		used, hard := summary.Quota.Used.Storage, summary.Quota.Hard.Storage
+
+		limit := "unlimited"
+		if hard > 0 {
+			limit = formatBytes(float64(hard))
+
+			if float64(used) >= harborQuotaWarning*float64(hard) {
+				logrus.Warnf("Harbor project %s has used %s of its %s storage quota", project, formatBytes(float64(used)), limit)
+			}
+		}
+
+		metadata = append(metadata,
+			MetadataField{Name: "harbor_quota_used", Value: formatBytes(float64(used))},
+			MetadataField{Name: "harbor_quota_limit", Value: limit},
+		)
+	}
+
+	rules, err := harborRetentionRules(repo, auth, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rules) == 0 {
+		return metadata, nil
+	}
+
+	var descriptions []string
+	for _, rule := range rules {
+		if !rule.Disabled {
+			descriptions = append(descriptions, rule.String())
+		}
+	}
+
+	metadata = append(metadata, MetadataField{Name: "harbor_retention", Value: strings.Join(descriptions, "; ")})
+
+	repository := strings.SplitN(repo.RepositoryStr(), "/", 2)
+	if len(repository) != 2 {
+		return metadata, nil
+	}
+
+	for _, tag := range tags {
+		retained := false
+		for _, rule := range rules {
+			if rule.matches(repository[1], tag) {
+				retained = true
+				break
+			}
+		}
+
+		if !retained {
+			logrus.Warnf("%s:%s is not retained by any of Harbor project %s's tag retention rules, so it will be deleted once retention runs", repo.Name(), tag, project)
+		}
+	}
+
+	return metadata, nil
+}
+
+// harborRetentionRules fetches the rules of the tag retention policy of the
+// repository's project, if it has one.
+func harborRetentionRules(repo name.Repository, auth authn.Authenticator, t http.RoundTripper) ([]harborRetentionRule, error) {
+	var project struct {
+		Metadata struct {
+			RetentionID string `json:"retention_id"`
+		} `json:"metadata"`
+	}
+
+	found, err := harborGet(repo, "/projects/"+harborProject(repo), auth, t, &project)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found || project.Metadata.RetentionID == "" {
+		return nil, nil
+	}
+
+	var policy struct {
+		Rules []harborRetentionRule `json:"rules"`
+	}
+
+	found, err = harborGet(repo, "/retentions/"+project.Metadata.RetentionID, auth, t, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return policy.Rules, nil
+}
+
+// harborProject returns the name of the repository's Harbor project.
+func harborProject(repo name.Repository) string {
+	return strings.SplitN(repo.RepositoryStr(), "/", 2)[0]
+}
+
+// harborGet fetches the path from the Harbor API of the repository's
+// registry, decoding the response into v. It returns false if there's nothing
+// there, e.g. as the registry isn't Harbor.
+func harborGet(repo name.Repository, path string, auth authn.Authenticator, t http.RoundTripper, v interface{}) (bool, error) {
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   "/api/v2.0" + path,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	// projects are referred to by name, even if it looks like an ID
+	req.Header.Set("X-Is-Resource-Name", "true")
+
+	// the Harbor API takes the same credentials as the registry, but not
+	// its tokens
+	authorization, err := auth.Authorization()
+	if err != nil {
+		return false, err
+	}
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return false, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		logrus.Debugf("failed to fetch %s from the Harbor API: %s", path, res.Status)
+		return false, nil
+	}
+
+	err = json.NewDecoder(res.Body).Decode(v)
+	if err != nil {
+		// e.g. some other registry serving a page for any path
+		logrus.Debugf("failed to parse %s from the Harbor API: %s", path, err)
+		return false, nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,165 @@
+package resource_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Harbor", func() {
+	Describe("credentials", func() {
+		credentials := func(config resource.HarborConfig) authn.Authenticator {
+			source := resource.Source{Harbor: &config}
+
+			auth, err := source.Authenticator()
+			Expect(err).ToNot(HaveOccurred())
+			return auth
+		}
+
+		It("should use robot accounts as named", func() {
+			Expect(credentials(resource.HarborConfig{RobotAccount: "robot$myproject+ci", RobotSecret: "some-secret"})).To(Equal(&authn.Basic{
+				Username: "robot$myproject+ci",
+				Password: "some-secret",
+			}))
+		})
+
+		It("should add the robot$ prefix if it's missing", func() {
+			Expect(credentials(resource.HarborConfig{RobotAccount: "myproject+ci", RobotSecret: "some-secret"})).To(Equal(&authn.Basic{
+				Username: "robot$myproject+ci",
+				Password: "some-secret",
+			}))
+		})
+
+		It("should add a custom prefix", func() {
+			Expect(credentials(resource.HarborConfig{RobotAccount: "myproject+ci", RobotSecret: "some-secret", RobotPrefix: "bot-"})).To(Equal(&authn.Basic{
+				Username: "bot-myproject+ci",
+				Password: "some-secret",
+			}))
+		})
+
+		It("should use the source's credentials if no robot is configured", func() {
+			source := resource.Source{Username: "some-user", Password: "some-password", Harbor: &resource.HarborConfig{ReportProject: true}}
+
+			auth, err := source.Authenticator()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth).To(Equal(&authn.Basic{Username: "some-user", Password: "some-password"}))
+		})
+
+		It("should reject robots without a secret", func() {
+			source := resource.Source{Harbor: &resource.HarborConfig{RobotAccount: "myproject+ci"}}
+
+			_, err := source.Authenticator()
+			Expect(err).To(MatchError("harbor requires robot_account and robot_secret"))
+		})
+	})
+
+	Describe("HarborProjectMetadata", func() {
+		var server *httptest.Server
+		var responses map[string]string
+
+		var logs *bytes.Buffer
+
+		BeforeEach(func() {
+			responses = map[string]string{
+				"/api/v2.0/projects/some-project/summary": `{"quota":{"hard":{"storage":10737418240},"used":{"storage":1073741824}}}`,
+				"/api/v2.0/projects/some-project":         `{"metadata":{"retention_id":"7"}}`,
+				"/api/v2.0/retentions/7": `{"rules":[{
+					"disabled": false,
+					"template": "latestPushedK",
+					"params": {"latestPushedK": 10},
+					"tag_selectors": [{"kind": "doublestar", "decoration": "matches", "pattern": "v*"}],
+					"scope_selectors": {"repository": [{"kind": "doublestar", "decoration": "repoMatches", "pattern": "**"}]}
+				}]}`,
+			}
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+
+				response, found := responses[r.URL.Path]
+				if !found {
+					http.NotFound(w, r)
+					return
+				}
+
+				Expect(r.Header.Get("Authorization")).To(HavePrefix("Basic "))
+				Expect(r.Header.Get("X-Is-Resource-Name")).To(Equal("true"))
+
+				w.Write([]byte(response))
+			}))
+
+			logs = new(bytes.Buffer)
+			logrus.SetOutput(logs)
+		})
+
+		AfterEach(func() {
+			server.Close()
+			logrus.SetOutput(os.Stderr)
+		})
+
+		metadata := func(tags ...string) []resource.MetadataField {
+			repo, err := name.NewRepository(server.Listener.Addr().String()+"/some-project/some-repo", name.WeakValidation)
+			Expect(err).ToNot(HaveOccurred())
+
+			auth := &authn.Basic{Username: "robot$some-project+ci", Password: "some-secret"}
+
+			fields, err := resource.HarborProjectMetadata(repo, tags, auth, http.DefaultTransport)
+			Expect(err).ToNot(HaveOccurred())
+			return fields
+		}
+
+		It("should report the quota and retention rules", func() {
+			Expect(metadata("v1.2.3")).To(Equal([]resource.MetadataField{
+				{Name: "harbor_quota_used", Value: "1.0 GiB"},
+				{Name: "harbor_quota_limit", Value: "10.0 GiB"},
+				{Name: "harbor_retention", Value: "latestPushedK 10 of tags matching v*"},
+			}))
+
+			Expect(logs.String()).To(BeEmpty())
+		})
+
+		It("should warn about tags which aren't retained", func() {
+			metadata("v1.2.3", "latest")
+
+			Expect(logs.String()).To(ContainSubstring("some-repo:latest is not retained by any of Harbor project some-project's tag retention rules"))
+			Expect(logs.String()).ToNot(ContainSubstring("v1.2.3"))
+		})
+
+		It("should warn when the quota is nearly used up", func() {
+			responses["/api/v2.0/projects/some-project/summary"] = `{"quota":{"hard":{"storage":1073741824},"used":{"storage":1000000000}}}`
+
+			metadata("v1.2.3")
+
+			Expect(logs.String()).To(ContainSubstring("Harbor project some-project has used 953.7 MiB of its 1.0 GiB storage quota"))
+		})
+
+		It("should report unlimited quotas", func() {
+			responses["/api/v2.0/projects/some-project/summary"] = `{"quota":{"hard":{"storage":-1},"used":{"storage":0}}}`
+
+			Expect(metadata("v1.2.3")).To(ContainElement(resource.MetadataField{Name: "harbor_quota_limit", Value: "unlimited"}))
+		})
+
+		It("should report only the quota of projects without a retention policy", func() {
+			responses["/api/v2.0/projects/some-project"] = `{"metadata":{}}`
+
+			Expect(metadata("latest")).To(Equal([]resource.MetadataField{
+				{Name: "harbor_quota_used", Value: "1.0 GiB"},
+				{Name: "harbor_quota_limit", Value: "10.0 GiB"},
+			}))
+		})
+
+		It("should report nothing for registries which aren't Harbor", func() {
+			responses = map[string]string{}
+
+			Expect(metadata("latest")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,81 @@
+package resource
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// HeadManifest resolves the digest of a tag's manifest with a single HEAD
+// request, without fetching the manifest or the image config. Given a digest
+// reference, it determines whether the manifest exists.
+//
+// If known is the digest from a previous request, it is sent as the request's
+// If-None-Match header, and returned as is if the registry reports that the
+// manifest has not been modified.
+func HeadManifest(ref name.Reference, auth authn.Authenticator, t http.RoundTripper, known string) (v1.Hash, error) {
+	repo := ref.Context()
+
+	tr, err := transport.New(repo.Registry, auth, t, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	uri := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", repo.RepositoryStr(), ref.Identifier()),
+	}
+
+	req, err := http.NewRequest(http.MethodHead, uri.String(), nil)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	req.Header.Set("Accept", acceptManifests())
+
+	if known != "" {
+		// registries use the manifest digest as its ETag
+		req.Header.Set("If-None-Match", `"`+known+`"`)
+	}
+
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if known != "" && resp.StatusCode == http.StatusNotModified {
+		return v1.NewHash(known)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		// HEAD responses carry no error body, so report it the way a GET
+		// would have
+		return v1.Hash{}, &remote.Error{
+			Errors: []remote.Diagnostic{{
+				Code:    remote.ManifestUnknownErrorCode,
+				Message: fmt.Sprintf("manifest unknown: %s", ref),
+			}},
+		}
+	}
+
+	err = remote.CheckError(resp, http.StatusOK)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return v1.Hash{}, fmt.Errorf("registry did not return a digest for %s", ref)
+	}
+
+	return v1.NewHash(digest)
+}
@@ -0,0 +1,88 @@
+package resource_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("HeadManifest", func() {
+	var server *httptest.Server
+	var host string
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			switch r.URL.Path {
+			case "/v2/":
+				w.WriteHeader(http.StatusOK)
+			case "/v2/some/repo/manifests/latest":
+				Expect(r.Method).To(Equal(http.MethodHead))
+
+				if r.Header.Get("If-None-Match") == `"sha256:`+strings.Repeat("a", 64)+`"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				w.Header().Set("Docker-Content-Digest", "sha256:"+strings.Repeat("a", 64))
+				w.WriteHeader(http.StatusOK)
+			case "/v2/some/repo/manifests/sha256:" + strings.Repeat("b", 64):
+				w.Header().Set("Docker-Content-Digest", "sha256:"+strings.Repeat("b", 64))
+				w.WriteHeader(http.StatusOK)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		host = strings.TrimPrefix(server.URL, "http://")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should return the digest from the response headers", func() {
+		tag, err := name.NewTag(host+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := resource.HeadManifest(tag, authn.Anonymous, http.DefaultTransport, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest.String()).To(Equal("sha256:" + strings.Repeat("a", 64)))
+	})
+
+	It("should return the known digest if the manifest has not been modified", func() {
+		tag, err := name.NewTag(host+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := resource.HeadManifest(tag, authn.Anonymous, http.DefaultTransport, "sha256:"+strings.Repeat("a", 64))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest.String()).To(Equal("sha256:" + strings.Repeat("a", 64)))
+	})
+
+	It("should resolve a digest reference", func() {
+		ref, err := name.NewDigest(host+"/some/repo@sha256:"+strings.Repeat("b", 64), name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := resource.HeadManifest(ref, authn.Anonymous, http.DefaultTransport, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest.String()).To(Equal("sha256:" + strings.Repeat("b", 64)))
+	})
+
+	It("should report a missing tag as an unknown manifest", func() {
+		tag, err := name.NewTag(host+"/some/repo:missing", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = resource.HeadManifest(tag, authn.Anonymous, http.DefaultTransport, "")
+		Expect(err).To(BeAssignableToTypeOf(&remote.Error{}))
+		Expect(err.(*remote.Error).Errors[0].Code).To(Equal(remote.ManifestUnknownErrorCode))
+	})
+})
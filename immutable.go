@@ -0,0 +1,195 @@
+package resource
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sirupsen/logrus"
+)
+
+// The ways to handle tags which can't be pushed as they're immutable.
+const (
+	ImmutableConflictFail = "fail"
+	ImmutableConflictSkip = "skip"
+)
+
+// ImmutableTags returns which of the tags the registry prevents from being
+// pushed again once they exist: all of them for ECR repositories with
+// immutable tags, or those matched by the project's immutability rules on
+// Harbor. Registries which can't be told to enforce immutability, or whose
+// policy can't be determined, are assumed not to.
+func ImmutableTags(repo name.Repository, tags []string, auth authn.Authenticator, t http.RoundTripper) ([]string, error) {
+	if ecrAuth, ok := auth.(*ECRAuthenticator); ok {
+		immutable, err := ecrAuth.TagsImmutable(repo.RepositoryStr())
+		if err != nil {
+			return nil, err
+		}
+
+		if !immutable {
+			return nil, nil
+		}
+
+		return tags, nil
+	}
+
+	rules, err := harborImmutabilityRules(repo, auth, t)
+	if err != nil {
+		return nil, err
+	}
+
+	repository := strings.SplitN(repo.RepositoryStr(), "/", 2)
+	if len(repository) != 2 {
+		// Harbor repositories are always within a project
+		return nil, nil
+	}
+
+	var immutable []string
+	for _, tag := range tags {
+		for _, rule := range rules {
+			if rule.matches(repository[1], tag) {
+				immutable = append(immutable, tag)
+				break
+			}
+		}
+	}
+
+	return immutable, nil
+}
+
+// TagsImmutable determines whether the repository prevents tags from being
+// pushed again.
+func (auth *ECRAuthenticator) TagsImmutable(repository string) (bool, error) {
+	input := &ecr.DescribeRepositoriesInput{
+		RepositoryNames: []*string{aws.String(repository)},
+	}
+
+	if auth.registryID != "" {
+		input.RegistryId = aws.String(auth.registryID)
+	}
+
+	output, err := auth.client.DescribeRepositories(input)
+	if err != nil {
+		return false, fmt.Errorf("failed to describe ECR repository: %s", err)
+	}
+
+	for _, repo := range output.Repositories {
+		if aws.StringValue(repo.ImageTagMutability) == ecr.ImageTagMutabilityImmutable {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// harborRule is a tag immutability or retention rule of a Harbor project.
+type harborRule struct {
+	Disabled       bool             `json:"disabled"`
+	TagSelectors   []harborSelector `json:"tag_selectors"`
+	ScopeSelectors struct {
+		Repository []harborSelector `json:"repository"`
+	} `json:"scope_selectors"`
+}
+
+type harborSelector struct {
+	Kind       string `json:"kind"`
+	Decoration string `json:"decoration"`
+	Pattern    string `json:"pattern"`
+}
+
+func (rule harborRule) matches(repository string, tag string) bool {
+	if rule.Disabled {
+		return false
+	}
+
+	for _, selector := range rule.ScopeSelectors.Repository {
+		if !selector.selects(repository) {
+			return false
+		}
+	}
+
+	for _, selector := range rule.TagSelectors {
+		if !selector.selects(tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selects determines whether the selector's doublestar pattern matches the
+// value, or doesn't for excluding selectors.
+func (selector harborSelector) selects(value string) bool {
+	matches := doublestarRegexp(selector.Pattern).MatchString(value)
+
+	switch selector.Decoration {
+	case "excludes", "repoExcludes":
+		return !matches
+	default:
+		return matches
+	}
+}
+
+// doublestarRegexp converts a doublestar pattern, as used by Harbor, to a
+// regular expression: ** matches anything, * and ? anything but a slash, and
+// {a,b} either alternative.
+func doublestarRegexp(pattern string) *regexp.Regexp {
+	expr := new(strings.Builder)
+	expr.WriteString("^")
+
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '{':
+			expr.WriteString("(?:")
+			depth++
+		case c == '}' && depth > 0:
+			expr.WriteString(")")
+			depth--
+		case c == ',' && depth > 0:
+			expr.WriteString("|")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	for ; depth > 0; depth-- {
+		expr.WriteString(")")
+	}
+
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String())
+}
+
+// harborImmutabilityRules fetches the tag immutability rules of the
+// repository's project from the Harbor API. No rules are returned for
+// registries which aren't Harbor.
+func harborImmutabilityRules(repo name.Repository, auth authn.Authenticator, t http.RoundTripper) ([]harborRule, error) {
+	project := harborProject(repo)
+
+	var rules []harborRule
+	found, err := harborGet(repo, fmt.Sprintf("/projects/%s/immutabletagrules", project), auth, t, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		logrus.Debugf("no Harbor immutability rules for %s", project)
+		return nil, nil
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,86 @@
+package resource_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ImmutableTags", func() {
+	var server *httptest.Server
+	var rules string
+	var authorization string
+
+	BeforeEach(func() {
+		rules = `[]`
+		authorization = ""
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+
+			if r.URL.Path != "/api/v2.0/projects/some-project/immutabletagrules" {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Write([]byte(rules))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	immutableTags := func(repository string, tags ...string) []string {
+		repo, err := name.NewRepository(server.Listener.Addr().String()+"/"+repository, name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		auth := &authn.Basic{Username: "some-user", Password: "some-password"}
+
+		immutable, err := resource.ImmutableTags(repo, tags, auth, http.DefaultTransport)
+		Expect(err).ToNot(HaveOccurred())
+
+		return immutable
+	}
+
+	It("should apply Harbor's immutability rules", func() {
+		rules = `[{
+			"disabled": false,
+			"tag_selectors": [{"kind": "doublestar", "decoration": "matches", "pattern": "{v*,release-**}"}],
+			"scope_selectors": {
+				"repository": [{"kind": "doublestar", "decoration": "repoMatches", "pattern": "**"}]
+			}
+		}, {
+			"disabled": true,
+			"tag_selectors": [{"kind": "doublestar", "decoration": "matches", "pattern": "**"}],
+			"scope_selectors": {
+				"repository": [{"kind": "doublestar", "decoration": "repoMatches", "pattern": "**"}]
+			}
+		}]`
+
+		Expect(immutableTags("some-project/some/repo", "v1.2.3", "release-2020/01", "latest")).To(Equal([]string{"v1.2.3", "release-2020/01"}))
+		Expect(authorization).To(HavePrefix("Basic "))
+	})
+
+	It("should apply excluding selectors", func() {
+		rules = `[{
+			"tag_selectors": [{"kind": "doublestar", "decoration": "excludes", "pattern": "latest"}],
+			"scope_selectors": {
+				"repository": [{"kind": "doublestar", "decoration": "repoExcludes", "pattern": "scratch/*"}]
+			}
+		}]`
+
+		Expect(immutableTags("some-project/some-repo", "1.0", "latest")).To(Equal([]string{"1.0"}))
+		Expect(immutableTags("some-project/scratch/repo", "1.0", "latest")).To(BeEmpty())
+	})
+
+	It("should assume other registries don't enforce immutability", func() {
+		Expect(immutableTags("other-project/some-repo", "1.0")).To(BeEmpty())
+	})
+})
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -73,6 +74,36 @@ var _ = Describe("In", func() {
 		Expect(err).ToNot(HaveOccurred())
 	})
 
+	Describe("the initial version", func() {
+		BeforeEach(func() {
+			req.Source.Repository = "concourse/test-image-does-not-exist"
+			req.Source.InitialDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+			req.Source.InitialTag = "initial"
+			req.Version.Digest = req.Source.InitialDigest
+		})
+
+		It("saves the digest and tag without fetching", func() {
+			Expect(cat(filepath.Join(destDir, "digest"))).To(Equal(req.Source.InitialDigest))
+			Expect(cat(filepath.Join(destDir, "tag"))).To(Equal("initial"))
+			Expect(rootfsPath()).ToNot(BeADirectory())
+		})
+	})
+
+	Describe("skipping the download", func() {
+		BeforeEach(func() {
+			req.Source.Repository = "concourse/test-image-does-not-exist"
+			req.Params.SkipDownload = true
+			req.Version.Digest = "sha256:" + strings.Repeat("1", 64)
+		})
+
+		It("saves the digest, tag, and repository without fetching", func() {
+			Expect(cat(filepath.Join(destDir, "digest"))).To(Equal(req.Version.Digest))
+			Expect(cat(filepath.Join(destDir, "tag"))).To(Equal("latest"))
+			Expect(cat(filepath.Join(destDir, "repository"))).To(Equal("concourse/test-image-does-not-exist"))
+			Expect(rootfsPath()).ToNot(BeADirectory())
+		})
+	})
+
 	Describe("image metadata", func() {
 		BeforeEach(func() {
 			req.Source.Repository = "concourse/test-image-metadata"
@@ -99,6 +130,28 @@ var _ = Describe("In", func() {
 		})
 	})
 
+	Describe("image config", func() {
+		BeforeEach(func() {
+			req.Source.Repository = "concourse/test-image-metadata"
+			req.Version.Digest = latestDigest(req.Source.Repository)
+		})
+
+		It("saves the config and its labels", func() {
+			var cfg v1.ConfigFile
+			err := json.Unmarshal([]byte(cat(filepath.Join(destDir, "config.json"))), &cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.Config.User).To(Equal("someuser"))
+
+			var labels map[string]string
+			err = json.Unmarshal([]byte(cat(filepath.Join(destDir, "labels.json"))), &labels)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(labels).To(HaveLen(len(cfg.Config.Labels)))
+			for k, v := range cfg.Config.Labels {
+				Expect(labels).To(HaveKeyWithValue(k, v))
+			}
+		})
+	})
+
 	Describe("response metadata", func() {
 		BeforeEach(func() {
 			req.Source.Repository = "concourse/test-image-metadata"
@@ -248,9 +301,6 @@ var _ = Describe("In", func() {
 			_, err := os.Stat(filepath.Join(destDir, "rootfs"))
 			Expect(os.IsNotExist(err)).To(BeTrue())
 
-			_, err = os.Stat(filepath.Join(destDir, "manifest.json"))
-			Expect(os.IsNotExist(err)).To(BeTrue())
-
 			tag, err := name.NewTag("concourse/test-image-static:latest", name.WeakValidation)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -266,6 +316,47 @@ var _ = Describe("In", func() {
 			// anyway.
 			Expect(fetchedManifest.Config.Digest).To(Equal(manifest.Config.Digest))
 		})
+
+		It("saves the image as an OCI image layout", func() {
+			Expect(filepath.Join(destDir, "oci", "oci-layout")).To(BeARegularFile())
+			Expect(filepath.Join(destDir, "oci", "index.json")).To(BeARegularFile())
+
+			digest, err := v1.NewHash(req.Version.Digest)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(filepath.Join(destDir, "oci", "blobs", "sha256", digest.Hex)).To(BeARegularFile())
+			Expect(filepath.Join(destDir, "oci", "blobs", "sha256", manifest.Config.Digest.Hex)).To(BeARegularFile())
+		})
+	})
+
+	Describe("fetching in docker-archive format", func() {
+		var manifest *v1.Manifest
+
+		BeforeEach(func() {
+			req.Source.Repository = "concourse/test-image-static"
+			req.Params.RawFormat = "docker-archive"
+			req.Params.AdditionalTags = []string{"some-tag"}
+
+			req.Version.Digest, manifest = latestManifest(req.Source.Repository)
+		})
+
+		It("saves the image as image.tar with every tag", func() {
+			_, err := os.Stat(filepath.Join(destDir, "rootfs"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			for _, t := range []string{"latest", "some-tag"} {
+				tag, err := name.NewTag("concourse/test-image-static:"+t, name.WeakValidation)
+				Expect(err).ToNot(HaveOccurred())
+
+				img, err := tarball.ImageFromPath(filepath.Join(destDir, "image.tar"), &tag)
+				Expect(err).ToNot(HaveOccurred())
+
+				fetchedManifest, err := img.Manifest()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(fetchedManifest.Config.Digest).To(Equal(manifest.Config.Digest))
+			}
+		})
 	})
 
 	Describe("saving the digest", func() {
@@ -281,6 +372,37 @@ var _ = Describe("In", func() {
 		})
 	})
 
+	Describe("saving the manifest", func() {
+		BeforeEach(func() {
+			req.Source.Repository = "concourse/test-image-static"
+			req.Version.Digest = LATEST_STATIC_DIGEST
+		})
+
+		It("saves the raw manifest matching the digest", func() {
+			manifest, err := os.Open(filepath.Join(destDir, "manifest.json"))
+			Expect(err).ToNot(HaveOccurred())
+
+			defer manifest.Close()
+
+			digest, _, err := v1.SHA256(manifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(digest.String()).To(Equal(req.Version.Digest))
+		})
+	})
+
+	Describe("saving the repository", func() {
+		BeforeEach(func() {
+			req.Source.Repository = "concourse/test-image-static"
+			req.Version.Digest = LATEST_STATIC_DIGEST
+		})
+
+		It("saves the repository to a file", func() {
+			repository, err := ioutil.ReadFile(filepath.Join(destDir, "repository"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(repository)).To(Equal("concourse/test-image-static"))
+		})
+	})
+
 	Describe("saving the tag", func() {
 		BeforeEach(func() {
 			req.Source.Repository = "concourse/test-image-static"
@@ -0,0 +1,113 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ParsePlatform parses a platform given as os/architecture[/variant], e.g.
+// linux/arm/v7, or as just an architecture, e.g. arm64, for linux.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+
+	switch len(parts) {
+	case 1:
+		return Platform{OS: DefaultPlatform.OS, Architecture: parts[0]}, nil
+	case 2:
+		return Platform{OS: parts[0], Architecture: parts[1]}, nil
+	case 3:
+		return Platform{OS: parts[0], Architecture: parts[1], Variant: parts[2]}, nil
+	default:
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os/architecture[/variant]", s)
+	}
+}
+
+// NewIndex returns an OCI image index of the images for each platform. The
+// images themselves must be written before the index is.
+func NewIndex(images map[Platform]v1.Image) (v1.Image, error) {
+	var platforms []Platform
+	for platform := range images {
+		platforms = append(platforms, platform)
+	}
+
+	sort.Slice(platforms, func(i, j int) bool {
+		return platforms[i].String() < platforms[j].String()
+	})
+
+	index := v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+	}
+
+	for _, platform := range platforms {
+		image := images[platform]
+
+		config, err := image.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config of %s image: %s", platform, err)
+		}
+
+		if config.OS != "" && config.OS != platform.OS || config.Architecture != "" && config.Architecture != platform.Architecture {
+			return nil, fmt.Errorf("image for %s is for %s/%s", platform, config.OS, config.Architecture)
+		}
+
+		rawManifest, err := image.RawManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest of %s image: %s", platform, err)
+		}
+
+		mediaType, err := image.MediaType()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get media type of %s image: %s", platform, err)
+		}
+
+		digest, err := image.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get digest of %s image: %s", platform, err)
+		}
+
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType: mediaType,
+			Size:      int64(len(rawManifest)),
+			Digest:    digest,
+			Platform: &v1.Platform{
+				OS:           platform.OS,
+				Architecture: platform.Architecture,
+				Variant:      platform.Variant,
+			},
+		})
+	}
+
+	raw, err := json.Marshal(&index)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(rawIndex(raw))
+}
+
+// rawIndex implements partial.CompressedImageCore for an image index, which
+// has only a manifest.
+type rawIndex []byte
+
+func (index rawIndex) RawConfigFile() ([]byte, error) {
+	return nil, fmt.Errorf("image index has no config")
+}
+
+func (index rawIndex) MediaType() (types.MediaType, error) {
+	return types.OCIImageIndex, nil
+}
+
+func (index rawIndex) RawManifest() ([]byte, error) {
+	return index, nil
+}
+
+func (index rawIndex) LayerByDigest(v1.Hash) (partial.CompressedLayer, error) {
+	return nil, fmt.Errorf("image index has no layers")
+}
@@ -0,0 +1,139 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ParsePlatform", func() {
+	It("should default the OS to linux", func() {
+		Expect(resource.ParsePlatform("arm64")).To(Equal(resource.Platform{OS: "linux", Architecture: "arm64"}))
+		Expect(resource.ParsePlatform("windows/amd64")).To(Equal(resource.Platform{OS: "windows", Architecture: "amd64"}))
+		Expect(resource.ParsePlatform("linux/arm/v7")).To(Equal(resource.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+
+		_, err := resource.ParsePlatform("linux/arm/v7/extra")
+		Expect(err).To(MatchError(ContainSubstring("invalid platform")))
+	})
+})
+
+var _ = Describe("NewIndex", func() {
+	amd64 := resource.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := resource.Platform{OS: "linux", Architecture: "arm64"}
+
+	platformImage := func(arch string) v1.Image {
+		image, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		config, err := image.ConfigFile()
+		Expect(err).ToNot(HaveOccurred())
+
+		config = config.DeepCopy()
+		config.OS = "linux"
+		config.Architecture = arch
+
+		return platformConfigImage{image, config}
+	}
+
+	It("should refer to each image by platform", func() {
+		images := map[resource.Platform]v1.Image{
+			arm64: platformImage("arm64"),
+			amd64: platformImage("amd64"),
+		}
+
+		index, err := resource.NewIndex(images)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.IsIndex(index)).To(BeTrue())
+
+		raw, err := index.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		var manifest v1.IndexManifest
+		Expect(json.Unmarshal(raw, &manifest)).To(Succeed())
+
+		Expect(manifest.MediaType).To(BeEquivalentTo("application/vnd.oci.image.index.v1+json"))
+		Expect(manifest.Manifests).To(HaveLen(2))
+
+		for i, platform := range []resource.Platform{amd64, arm64} {
+			digest, err := images[platform].Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(manifest.Manifests[i].Digest).To(Equal(digest))
+			Expect(platform.Matches(manifest.Manifests[i].Platform)).To(BeTrue())
+		}
+	})
+
+	It("should reject an image for another architecture", func() {
+		_, err := resource.NewIndex(map[resource.Platform]v1.Image{
+			arm64: platformImage("amd64"),
+		})
+		Expect(err).To(MatchError(ContainSubstring("image for linux/arm64 is for linux/amd64")))
+	})
+
+	It("should be written by uploading its manifest", func() {
+		index, err := resource.NewIndex(map[resource.Platform]v1.Image{
+			amd64: platformImage("amd64"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var contentType string
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/v2/":
+				w.WriteHeader(http.StatusOK)
+			case r.Method == http.MethodPut && r.URL.Path == "/v2/some/repo/manifests/latest":
+				contentType = r.Header.Get("Content-Type")
+
+				var err error
+				body, err = ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+
+				w.WriteHeader(http.StatusCreated)
+			default:
+				Fail("unexpected request: " + r.Method + " " + r.URL.Path)
+			}
+		}))
+
+		defer server.Close()
+
+		u, err := url.Parse(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		ref, err := name.NewTag(u.Host+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr := resource.NewTokenTransport(ref.Context().Registry, authn.Anonymous, http.DefaultTransport, nil)
+		Expect(resource.Write(ref, index, tr)).To(Succeed())
+
+		raw, err := index.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(contentType).To(Equal("application/vnd.oci.image.index.v1+json"))
+		Expect(body).To(Equal(raw))
+	})
+})
+
+// platformConfigImage overrides the config of an image, without updating its
+// manifest.
+type platformConfigImage struct {
+	v1.Image
+
+	config *v1.ConfigFile
+}
+
+func (image platformConfigImage) ConfigFile() (*v1.ConfigFile, error) {
+	return image.config, nil
+}
@@ -0,0 +1,70 @@
+package resource
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InsecureTransport talks to insecure registries: it sends requests to them
+// through Insecure, e.g. a transport which doesn't verify certificates, and
+// over plain HTTP once they turn out not to speak HTTPS.
+type InsecureTransport struct {
+	Inner http.RoundTripper
+
+	// Insecure sends the requests to the insecure registries.
+	Insecure http.RoundTripper
+
+	// Hosts are the insecure registries, e.g. registry.example.com:5000.
+	Hosts map[string]bool
+
+	plain sync.Map
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *InsecureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || !t.Hosts[req.URL.Host] {
+		return t.Inner.RoundTrip(req)
+	}
+
+	if _, isPlain := t.plain.Load(req.URL.Host); isPlain {
+		return t.Insecure.RoundTrip(plainHTTP(req))
+	}
+
+	res, err := t.Insecure.RoundTrip(req)
+
+	var recordErr tls.RecordHeaderError
+	if err == nil || !errors.As(err, &recordErr) || string(recordErr.RecordHeader[:]) != "HTTP/" {
+		return res, err
+	}
+
+	logrus.Warnf("%s does not support HTTPS; falling back to plain HTTP", req.URL.Host)
+
+	t.plain.Store(req.URL.Host, true)
+
+	plain := plainHTTP(req)
+	if req.Body != nil {
+		if req.GetBody == nil {
+			// the body may have been consumed; later requests go over plain
+			// HTTP straight away
+			return nil, err
+		}
+
+		plain.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t.Insecure.RoundTrip(plain)
+}
+
+// plainHTTP returns a copy of the request to send over plain HTTP.
+func plainHTTP(req *http.Request) *http.Request {
+	plain := req.Clone(req.Context())
+	plain.URL.Scheme = "http"
+	return plain
+}
@@ -0,0 +1,58 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// envReference matches references to environment variables, written either
+// as ${VAR} or ((env:VAR)).
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\(\(env:([A-Za-z_][A-Za-z0-9_]*)\)\)`)
+
+// Interpolate replaces references to environment variables in the value with
+// their values, as looked up with lookupEnv, e.g. os.LookupEnv. Referring to
+// a variable which isn't set is an error, rather than silently pushing to or
+// checking the wrong repository.
+func Interpolate(value string, lookupEnv func(string) (string, bool)) (string, error) {
+	var err error
+	interpolated := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		match := envReference.FindStringSubmatch(reference)
+
+		variable := match[1]
+		if variable == "" {
+			variable = match[2]
+		}
+
+		resolved, found := lookupEnv(variable)
+		if !found && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", variable)
+		}
+
+		return resolved
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return interpolated, nil
+}
+
+// Interpolate resolves references to environment variables in the source's
+// repository and tag, so that e.g. instanced pipelines can share a resource
+// definition whose repository is registry.example.com/${TEAM}/app.
+func (source *Source) Interpolate(lookupEnv func(string) (string, bool)) error {
+	repository, err := Interpolate(source.Repository, lookupEnv)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate repository: %s", err)
+	}
+
+	tag, err := Interpolate(string(source.RawTag), lookupEnv)
+	if err != nil {
+		return fmt.Errorf("failed to interpolate tag: %s", err)
+	}
+
+	source.Repository = repository
+	source.RawTag = Tag(tag)
+
+	return nil
+}
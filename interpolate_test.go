@@ -0,0 +1,58 @@
+package resource_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Interpolate", func() {
+	env := map[string]string{
+		"TEAM":    "payments",
+		"VERSION": "1.2.3",
+		"EMPTY":   "",
+	}
+
+	lookupEnv := func(variable string) (string, bool) {
+		value, found := env[variable]
+		return value, found
+	}
+
+	It("should replace ${VAR} references", func() {
+		Expect(resource.Interpolate("registry.example.com/${TEAM}/app", lookupEnv)).To(Equal("registry.example.com/payments/app"))
+	})
+
+	It("should replace ((env:VAR)) references", func() {
+		Expect(resource.Interpolate("((env:TEAM))-((env:VERSION))", lookupEnv)).To(Equal("payments-1.2.3"))
+	})
+
+	It("should replace variables which are set but empty", func() {
+		Expect(resource.Interpolate("app${EMPTY}", lookupEnv)).To(Equal("app"))
+	})
+
+	It("should leave values without references as they are", func() {
+		Expect(resource.Interpolate("registry.example.com/app", lookupEnv)).To(Equal("registry.example.com/app"))
+	})
+
+	It("should fail for variables which are not set", func() {
+		_, err := resource.Interpolate("registry.example.com/${MISSING}/app", lookupEnv)
+		Expect(err).To(MatchError("environment variable MISSING is not set"))
+	})
+
+	It("should interpolate the source's repository and tag", func() {
+		var source resource.Source
+		Expect(json.Unmarshal([]byte(`{"repository":"registry.example.com/${TEAM}/app","tag":"((env:VERSION))"}`), &source)).To(Succeed())
+
+		Expect(source.Interpolate(lookupEnv)).To(Succeed())
+		Expect(source.Repository).To(Equal("registry.example.com/payments/app"))
+		Expect(source.Tag()).To(Equal("1.2.3"))
+	})
+
+	It("should fail for sources referring to variables which are not set", func() {
+		source := resource.Source{Repository: "registry.example.com/app", RawTag: "${MISSING}"}
+		Expect(source.Interpolate(lookupEnv)).To(MatchError(ContainSubstring("failed to interpolate tag")))
+	})
+})
@@ -0,0 +1,305 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// DefaultFulcioURL is the URL of the public Sigstore certificate authority.
+const DefaultFulcioURL = "https://fulcio.sigstore.dev"
+
+// DefaultRekorURL is the URL of the public Sigstore transparency log.
+const DefaultRekorURL = "https://rekor.sigstore.dev"
+
+// CosignKeylessSigning configures signing with a short-lived certificate
+// issued by Fulcio, recording the signature in Rekor.
+type CosignKeylessSigning struct {
+	// IdentityToken is the OIDC identity token to request the certificate
+	// with. Defaults to the SIGSTORE_ID_TOKEN environment variable.
+	IdentityToken string `json:"identity_token,omitempty"`
+
+	// FulcioURL is the URL of the certificate authority.
+	FulcioURL string `json:"fulcio_url,omitempty"`
+
+	// RekorURL is the URL of the transparency log.
+	RekorURL string `json:"rekor_url,omitempty"`
+}
+
+// Sign signs the image with the digest in the repository with an ephemeral
+// key, returning the signature to store, with its certificate and log entry,
+// and the index of the entry in the log.
+func (keyless *CosignKeylessSigning) Sign(repo name.Repository, digest v1.Hash) (SignatureLayer, int64, error) {
+	payload, err := cosignPayload(repo, digest)
+	if err != nil {
+		return SignatureLayer{}, 0, err
+	}
+
+	signature, annotations, logIndex, err := keyless.sign(payload)
+	if err != nil {
+		return SignatureLayer{}, 0, err
+	}
+
+	annotations[CosignSignatureAnnotation] = base64.StdEncoding.EncodeToString(signature)
+
+	return SignatureLayer{
+		Payload:     payload,
+		Annotations: annotations,
+	}, logIndex, nil
+}
+
+// Attest signs the in-toto statement with an ephemeral key, returning the
+// attestation to store, as for Sign.
+func (keyless *CosignKeylessSigning) Attest(statement []byte, predicateType string) (SignatureLayer, int64, error) {
+	signature, annotations, logIndex, err := keyless.sign(preAuthEncoding(InTotoPayloadType, statement))
+	if err != nil {
+		return SignatureLayer{}, 0, err
+	}
+
+	attestation, err := dsseAttestation(statement, predicateType, signature)
+	if err != nil {
+		return SignatureLayer{}, 0, err
+	}
+
+	for key, value := range annotations {
+		attestation.Annotations[key] = value
+	}
+
+	return attestation, logIndex, nil
+}
+
+// sign signs the message with an ephemeral key, returning the signature, the
+// annotations of its certificate and log entry, and the index of the entry.
+func (keyless *CosignKeylessSigning) sign(message []byte) ([]byte, map[string]string, int64, error) {
+	token := keyless.IdentityToken
+	if token == "" {
+		token = os.Getenv("SIGSTORE_ID_TOKEN")
+	}
+
+	if token == "" {
+		return nil, nil, 0, errors.New("no identity_token given")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	certs, err := keyless.certificate(key, token)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to get signing certificate: %s", err)
+	}
+
+	signature, err := sign(key, message)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to sign: %s", err)
+	}
+
+	bundle, err := keyless.logEntry(message, signature, certs[0])
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to record signature in transparency log: %s", err)
+	}
+
+	rawBundle, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	annotations := map[string]string{
+		CosignCertificateAnnotation: certs[0],
+		CosignBundleAnnotation:      string(rawBundle),
+	}
+
+	if len(certs) > 1 {
+		annotations[CosignChainAnnotation] = strings.Join(certs[1:], "")
+	}
+
+	return signature, annotations, bundle.Payload.LogIndex, nil
+}
+
+// certificate requests a certificate for the key from Fulcio, returning it
+// followed by its chain, PEM-encoded.
+func (keyless *CosignKeylessSigning) certificate(key *ecdsa.PrivateKey, token string) ([]string, error) {
+	subject, err := tokenSubject(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity_token: %s", err)
+	}
+
+	// Fulcio requires proof that we hold the key, by signing the subject
+	proof, err := sign(key, []byte(subject))
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var request struct {
+		Credentials struct {
+			OIDCIdentityToken string `json:"oidcIdentityToken"`
+		} `json:"credentials"`
+		PublicKeyRequest struct {
+			PublicKey struct {
+				Algorithm string `json:"algorithm"`
+				Content   string `json:"content"`
+			} `json:"publicKey"`
+			ProofOfPossession []byte `json:"proofOfPossession"`
+		} `json:"publicKeyRequest"`
+	}
+
+	request.Credentials.OIDCIdentityToken = token
+	request.PublicKeyRequest.PublicKey.Algorithm = "ECDSA"
+	request.PublicKeyRequest.PublicKey.Content = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))
+	request.PublicKeyRequest.ProofOfPossession = proof
+
+	type chain struct {
+		Chain struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chain"`
+	}
+
+	var response struct {
+		Embedded *chain `json:"signedCertificateEmbeddedSct"`
+		Detached *chain `json:"signedCertificateDetachedSct"`
+	}
+
+	err = postJSON(urlOr(keyless.FulcioURL, DefaultFulcioURL)+"/api/v2/signingCert", request, &response, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	issued := response.Embedded
+	if issued == nil {
+		issued = response.Detached
+	}
+
+	if issued == nil || len(issued.Chain.Certificates) == 0 {
+		return nil, errors.New("no certificate issued")
+	}
+
+	return issued.Chain.Certificates, nil
+}
+
+// logEntry records the signature in Rekor as a hashedrekord entry, returning
+// the entry in the form cosign stores it in.
+func (keyless *CosignKeylessSigning) logEntry(message []byte, signature []byte, certPEM string) (rekorBundle, error) {
+	hash := sha256.Sum256(message)
+
+	var request hashedRekord
+	request.APIVersion = "0.0.1"
+	request.Kind = "hashedrekord"
+	request.Spec.Data.Hash.Algorithm = "sha256"
+	request.Spec.Data.Hash.Value = hex.EncodeToString(hash[:])
+	request.Spec.Signature.Content = signature
+	request.Spec.Signature.PublicKey.Content = []byte(certPEM)
+
+	var response map[string]struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+		Verification   struct {
+			SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+		} `json:"verification"`
+	}
+
+	err := postJSON(urlOr(keyless.RekorURL, DefaultRekorURL)+"/api/v1/log/entries", request, &response, http.StatusCreated)
+	if err != nil {
+		return rekorBundle{}, err
+	}
+
+	for _, entry := range response {
+		var bundle rekorBundle
+		bundle.SignedEntryTimestamp = entry.Verification.SignedEntryTimestamp
+		bundle.Payload.Body = entry.Body
+		bundle.Payload.IntegratedTime = entry.IntegratedTime
+		bundle.Payload.LogID = entry.LogID
+		bundle.Payload.LogIndex = entry.LogIndex
+
+		return bundle, nil
+	}
+
+	return rekorBundle{}, errors.New("no log entry returned")
+}
+
+// tokenSubject returns the identity a JWT is for: its email claim if it has
+// one, as for email identities Fulcio certifies the email, or else its sub
+// claim.
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("not a JWT")
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", err
+	}
+
+	var claims struct {
+		Subject string `json:"sub"`
+		Email   string `json:"email"`
+	}
+
+	err = json.Unmarshal(rawClaims, &claims)
+	if err != nil {
+		return "", err
+	}
+
+	if claims.Email != "" {
+		return claims.Email, nil
+	}
+
+	if claims.Subject == "" {
+		return "", errors.New("no subject")
+	}
+
+	return claims.Subject, nil
+}
+
+func urlOr(url string, fallback string) string {
+	if url == "" {
+		return fallback
+	}
+
+	return strings.TrimSuffix(url, "/")
+}
+
+func postJSON(url string, body interface{}, dest interface{}, statuses ...int) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return json.NewDecoder(resp.Body).Decode(dest)
+		}
+	}
+
+	return fmt.Errorf("unexpected status: %s", resp.Status)
+}
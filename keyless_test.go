@@ -0,0 +1,256 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("CosignKeylessSigning", func() {
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+
+	var fulcio, rekor *httptest.Server
+	var rootPEM, rekorPEM string
+	var keyless resource.CosignKeylessSigning
+
+	BeforeEach(func() {
+		rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		root := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "some-root"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+
+		rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		root, err = x509.ParseCertificate(rootDER)
+		Expect(err).ToNot(HaveOccurred())
+
+		rootPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}))
+
+		fulcio = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/api/v2/signingCert"))
+
+			var request struct {
+				PublicKeyRequest struct {
+					PublicKey struct {
+						Content string `json:"content"`
+					} `json:"publicKey"`
+					ProofOfPossession []byte `json:"proofOfPossession"`
+				} `json:"publicKeyRequest"`
+			}
+			Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+
+			block, _ := pem.Decode([]byte(request.PublicKeyRequest.PublicKey.Content))
+			Expect(block).ToNot(BeNil())
+
+			publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+			Expect(err).ToNot(HaveOccurred())
+
+			subject := sha256.Sum256([]byte("someone@example.com"))
+			Expect(ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), subject[:], request.PublicKeyRequest.ProofOfPossession)).To(BeTrue())
+
+			issuer, err := asn1.Marshal("https://some-issuer.example.com")
+			Expect(err).ToNot(HaveOccurred())
+
+			leaf := &x509.Certificate{
+				SerialNumber:   big.NewInt(2),
+				NotBefore:      time.Now().Add(-time.Minute),
+				NotAfter:       time.Now().Add(time.Minute),
+				EmailAddresses: []string{"someone@example.com"},
+				KeyUsage:       x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+				ExtraExtensions: []pkix.Extension{{
+					Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8},
+					Value: issuer,
+				}},
+			}
+
+			leafDER, err := x509.CreateCertificate(rand.Reader, leaf, root, publicKey, rootKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"signedCertificateEmbeddedSct": map[string]interface{}{
+					"chain": map[string]interface{}{
+						"certificates": []string{
+							string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})),
+							rootPEM,
+						},
+					},
+				},
+			})
+		}))
+
+		rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		rekorDER, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		rekorPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rekorDER}))
+
+		rekor = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/api/v1/log/entries"))
+
+			var entry map[string]interface{}
+			Expect(json.NewDecoder(r.Body).Decode(&entry)).To(Succeed())
+			Expect(entry["kind"]).To(Equal("hashedrekord"))
+
+			body, err := json.Marshal(entry)
+			Expect(err).ToNot(HaveOccurred())
+
+			payload := map[string]interface{}{
+				"body":           base64.StdEncoding.EncodeToString(body),
+				"integratedTime": time.Now().Unix(),
+				"logID":          "some-log-id",
+				"logIndex":       42,
+			}
+
+			canonical, err := json.Marshal(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			hash := sha256.Sum256(canonical)
+			set, err := ecdsa.SignASN1(rand.Reader, rekorKey, hash[:])
+			Expect(err).ToNot(HaveOccurred())
+
+			payload["verification"] = map[string]interface{}{
+				"signedEntryTimestamp": set,
+			}
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"some-uuid": payload,
+			})
+		}))
+
+		claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"some-subject","email":"someone@example.com"}`))
+
+		keyless = resource.CosignKeylessSigning{
+			IdentityToken: "e30." + claims + ".c2ln",
+			FulcioURL:     fulcio.URL,
+			RekorURL:      rekor.URL,
+		}
+	})
+
+	AfterEach(func() {
+		fulcio.Close()
+		rekor.Close()
+	})
+
+	It("should sign with a certificate for the token's identity", func() {
+		repo, err := name.NewRepository("some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		signature, logIndex, err := keyless.Sign(repo, digest)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logIndex).To(Equal(int64(42)))
+
+		signatures, err := resource.SignatureImage(nil, signature)
+		Expect(err).ToNot(HaveOccurred())
+
+		verifier := resource.CosignConfig{
+			Keyless: &resource.CosignKeyless{
+				Identity:       "someone@example.com",
+				Issuer:         "https://some-issuer.example.com",
+				RootCerts:      rootPEM,
+				RekorPublicKey: rekorPEM,
+			},
+		}
+
+		Expect(verifier.Verify(signatures, digest)).To(Succeed())
+	})
+
+	It("should attest with a certificate for the token's identity", func() {
+		repo, err := name.NewRepository("some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		statement, err := resource.InTotoStatement(repo, digest, resource.SLSAProvenanceV1, []byte(`{"runDetails": {"builder": {"id": "some-builder"}}}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		attestation, logIndex, err := keyless.Attest(statement, resource.SLSAProvenanceV1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logIndex).To(Equal(int64(42)))
+
+		attestations, err := resource.SignatureImage(nil, attestation)
+		Expect(err).ToNot(HaveOccurred())
+
+		policy := resource.AttestationPolicy{
+			CosignConfig: resource.CosignConfig{
+				Keyless: &resource.CosignKeyless{
+					Identity:       "someone@example.com",
+					Issuer:         "https://some-issuer.example.com",
+					RootCerts:      rootPEM,
+					RekorPublicKey: rekorPEM,
+				},
+			},
+			BuilderID: "some-builder",
+		}
+
+		Expect(policy.Verify(attestations, digest)).To(Succeed())
+	})
+
+	It("should reject log entries for another signature", func() {
+		repo, err := name.NewRepository("some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		signature, _, err := keyless.Sign(repo, digest)
+		Expect(err).ToNot(HaveOccurred())
+
+		other, _, err := keyless.Sign(repo, v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)})
+		Expect(err).ToNot(HaveOccurred())
+
+		signature.Annotations[resource.CosignBundleAnnotation] = other.Annotations[resource.CosignBundleAnnotation]
+
+		signatures, err := resource.SignatureImage(nil, signature)
+		Expect(err).ToNot(HaveOccurred())
+
+		verifier := resource.CosignConfig{
+			Keyless: &resource.CosignKeyless{
+				Identity:       "someone@example.com",
+				Issuer:         "https://some-issuer.example.com",
+				RootCerts:      rootPEM,
+				RekorPublicKey: rekorPEM,
+			},
+		}
+
+		Expect(verifier.Verify(signatures, digest)).To(MatchError(ContainSubstring("entry is for another payload")))
+	})
+
+	It("should require an identity token", func() {
+		keyless.IdentityToken = ""
+
+		_, _, err := keyless.Sign(name.Repository{}, digest)
+		Expect(err).To(MatchError("no identity_token given"))
+	})
+})
@@ -0,0 +1,26 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// MatchLabels determines whether the labels match all of the source's
+// label_filters. Each filter is a regex which must match the whole value of
+// its label, so a plain value must match exactly. Images without one of the
+// labels never match.
+func (source *Source) MatchLabels(labels map[string]string) (bool, error) {
+	for key, filter := range source.LabelFilters {
+		re, err := regexp.Compile("^(?:" + filter + ")$")
+		if err != nil {
+			return false, fmt.Errorf("invalid label_filters regex for %s: %s", key, err)
+		}
+
+		value, found := labels[key]
+		if !found || !re.MatchString(value) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
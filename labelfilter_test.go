@@ -0,0 +1,44 @@
+package resource_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("MatchLabels", func() {
+	labels := map[string]string{"quality": "promoted", "team": "checkout-web"}
+
+	It("should match any labels without label_filters", func() {
+		Expect((&resource.Source{}).MatchLabels(nil)).To(BeTrue())
+	})
+
+	It("should match labels with exactly the filtered values", func() {
+		source := resource.Source{LabelFilters: map[string]string{"quality": "promoted"}}
+		Expect(source.MatchLabels(labels)).To(BeTrue())
+
+		source = resource.Source{LabelFilters: map[string]string{"quality": "promote"}}
+		Expect(source.MatchLabels(labels)).To(BeFalse())
+	})
+
+	It("should match labels against regexes", func() {
+		source := resource.Source{LabelFilters: map[string]string{"quality": "promoted|released", "team": "checkout-.*"}}
+		Expect(source.MatchLabels(labels)).To(BeTrue())
+
+		source = resource.Source{LabelFilters: map[string]string{"team": "checkout"}}
+		Expect(source.MatchLabels(labels)).To(BeFalse())
+	})
+
+	It("should not match images without the label", func() {
+		source := resource.Source{LabelFilters: map[string]string{"owner": ".*"}}
+		Expect(source.MatchLabels(labels)).To(BeFalse())
+	})
+
+	It("should reject invalid regexes", func() {
+		source := resource.Source{LabelFilters: map[string]string{"quality": "("}}
+
+		_, err := source.MatchLabels(labels)
+		Expect(err).To(MatchError(ContainSubstring("invalid label_filters regex for quality")))
+	})
+})
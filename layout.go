@@ -0,0 +1,599 @@
+package resource
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// LayoutVersion is the version of the OCI image layout written by WriteLayout.
+const LayoutVersion = "1.0.0"
+
+// OCIIndexMediaType is the media type of an OCI image layout's index.json.
+const OCIIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// RefNameAnnotation names an image in an OCI image layout's index.json.
+const RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// WriteLayout writes the image to dir as an OCI image layout, i.e. an
+// oci-layout file, an index.json referring to the image's manifest by the
+// given ref name (e.g. its tag), and the manifest, config, and layers under
+// blobs/.
+//
+// The manifest is written as is, so that its digest matches the image's.
+func WriteLayout(dir string, refName string, image v1.Image) error {
+	err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeLayout(dirLayout(dir), refName, image, nil)
+}
+
+// WriteIndexLayout writes an image index or manifest list to dir as an OCI
+// image layout, as with WriteLayout, along with every manifest it refers to,
+// which are fetched with fetch.
+func WriteIndexLayout(dir string, refName string, index v1.Image, fetch func(v1.Hash) (v1.Image, error)) error {
+	err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeLayout(dirLayout(dir), refName, index, fetch)
+}
+
+// WriteLayoutArchive writes the image to path as a tar archive of an OCI image
+// layout, as with WriteLayout. Layers are written with their original
+// compression and media types.
+func WriteLayoutArchive(path string, refName string, image v1.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir,
+			Mode:     0755,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeLayout(tarLayout{tw}, refName, image, nil)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// layoutWriter writes the files of an OCI image layout.
+type layoutWriter interface {
+	// writeBlob writes the content to blobs/, verifying that it matches the
+	// digest.
+	writeBlob(digest v1.Hash, size int64, content io.Reader) error
+
+	writeFile(name string, content []byte) error
+}
+
+func writeLayout(layout layoutWriter, refName string, image v1.Image, fetch func(v1.Hash) (v1.Image, error)) error {
+	desc, err := writeImage(layout, image, fetch)
+	if err != nil {
+		return err
+	}
+
+	if refName != "" {
+		desc.Annotations = map[string]string{
+			RefNameAnnotation: refName,
+		}
+	}
+
+	index, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     OCIIndexMediaType,
+		Manifests:     []v1.Descriptor{desc},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = layout.writeFile("index.json", index)
+	if err != nil {
+		return err
+	}
+
+	ociLayout, err := json.Marshal(map[string]string{
+		"imageLayoutVersion": LayoutVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	return layout.writeFile("oci-layout", ociLayout)
+}
+
+// writeImage writes the image's blobs and manifest, returning its descriptor.
+// If the image is an image index and fetch is given, every manifest it refers
+// to is fetched and written as well.
+func writeImage(layout layoutWriter, image v1.Image, fetch func(v1.Hash) (v1.Image, error)) (v1.Descriptor, error) {
+	rawManifest, err := image.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to get manifest: %s", err)
+	}
+
+	mediaType, err := manifestMediaType(rawManifest)
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to get media type: %s", err)
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to get digest: %s", err)
+	}
+
+	if mediaType == types.OCIImageIndex || mediaType == types.DockerManifestList {
+		if fetch == nil {
+			return v1.Descriptor{}, fmt.Errorf("cannot write image index %s without fetching its manifests", digest)
+		}
+
+		err = writeIndexManifests(layout, rawManifest, fetch)
+	} else {
+		err = writeImageBlobs(layout, image)
+	}
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	err = layout.writeBlob(digest, int64(len(rawManifest)), bytes.NewReader(rawManifest))
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to write manifest: %s", err)
+	}
+
+	return v1.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(rawManifest)),
+		Digest:    digest,
+	}, nil
+}
+
+func writeIndexManifests(layout layoutWriter, rawIndex []byte, fetch func(v1.Hash) (v1.Image, error)) error {
+	var index v1.IndexManifest
+	err := json.Unmarshal(rawIndex, &index)
+	if err != nil {
+		return fmt.Errorf("failed to parse image index: %s", err)
+	}
+
+	for _, desc := range index.Manifests {
+		image, err := fetch(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to fetch manifest %s: %s", desc.Digest, err)
+		}
+
+		_, err = writeImage(layout, image, fetch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeImageBlobs(layout layoutWriter, image v1.Image) error {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %s", err)
+	}
+
+	for _, desc := range manifest.Layers {
+		if len(desc.URLs) > 0 {
+			// foreign layers aren't distributed with the image
+			continue
+		}
+
+		layer, err := image.LayerByDigest(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to get layer %s: %s", desc.Digest, err)
+		}
+
+		blob, err := layer.Compressed()
+		if err != nil {
+			return fmt.Errorf("failed to fetch layer %s: %s", desc.Digest, err)
+		}
+
+		err = layout.writeBlob(desc.Digest, desc.Size, blob)
+		blob.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write layer %s: %s", desc.Digest, err)
+		}
+	}
+
+	config, err := image.RawConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to get config: %s", err)
+	}
+
+	err = layout.writeBlob(manifest.Config.Digest, int64(len(config)), bytes.NewReader(config))
+	if err != nil {
+		return fmt.Errorf("failed to write config: %s", err)
+	}
+
+	return nil
+}
+
+// IsLayout determines whether the path is an OCI image layout directory.
+func IsLayout(path string) bool {
+	_, err := os.Stat(filepath.Join(path, "oci-layout"))
+	return err == nil
+}
+
+// ReadLayout reads the image from the OCI image layout in dir, e.g. as written
+// by WriteLayout or a build tool such as buildkit. The layout's index.json
+// must refer to exactly one image manifest.
+//
+// The manifest and blobs are read as is, so that pushing the image preserves
+// its digest and media types.
+func ReadLayout(dir string) (v1.Image, error) {
+	return readLayout(dirLayout(dir))
+}
+
+// IsLayoutArchive determines whether the file at path is a tar archive of an
+// OCI image layout, e.g. as written by WriteLayoutArchive or by skopeo or
+// podman, as opposed to a docker archive.
+func IsLayoutArchive(path string) (bool, error) {
+	return archiveLayout(path).contains("oci-layout")
+}
+
+// ReadLayoutArchive reads the image from a tar archive of an OCI image
+// layout, as with ReadLayout.
+func ReadLayoutArchive(path string) (v1.Image, error) {
+	return readLayout(archiveLayout(path))
+}
+
+// layoutReader reads the files of an OCI image layout.
+type layoutReader interface {
+	readBlob(digest v1.Hash) (io.ReadCloser, error)
+
+	readFile(name string) ([]byte, error)
+}
+
+func readLayout(layout layoutReader) (v1.Image, error) {
+	ociLayout, err := layout.readFile("oci-layout")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oci-layout: %s", err)
+	}
+
+	var version struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}
+
+	err = json.Unmarshal(ociLayout, &version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse oci-layout: %s", err)
+	}
+
+	if version.ImageLayoutVersion != LayoutVersion {
+		return nil, fmt.Errorf("unsupported image layout version: %q", version.ImageLayoutVersion)
+	}
+
+	rawIndex, err := layout.readFile("index.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index.json: %s", err)
+	}
+
+	var index v1.IndexManifest
+	err = json.Unmarshal(rawIndex, &index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %s", err)
+	}
+
+	if len(index.Manifests) != 1 {
+		return nil, fmt.Errorf("expected index.json to refer to one manifest, found %d", len(index.Manifests))
+	}
+
+	desc := index.Manifests[0]
+
+	rawManifest, err := readLayoutFile(layout, desc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %s", err)
+	}
+
+	mediaType, err := manifestMediaType(rawManifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %s", err)
+	}
+
+	if mediaType == types.OCIImageIndex || mediaType == types.DockerManifestList {
+		return nil, fmt.Errorf("manifest %s is an image index, not an image", desc.Digest)
+	}
+
+	var manifest v1.Manifest
+	err = json.Unmarshal(rawManifest, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %s", err)
+	}
+
+	return partial.CompressedToImage(&layoutImage{
+		layout:      layout,
+		rawManifest: rawManifest,
+		mediaType:   mediaType,
+		manifest:    &manifest,
+	})
+}
+
+// readLayoutFile reads a blob which is small enough to hold in memory, e.g.
+// a manifest or config, verifying its digest.
+func readLayoutFile(layout layoutReader, digest v1.Hash) ([]byte, error) {
+	blob, err := layout.readBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	defer blob.Close()
+
+	content, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, _, err := v1.SHA256(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if actual != digest {
+		return nil, fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+
+	return content, nil
+}
+
+// layoutImage implements partial.CompressedImageCore.
+type layoutImage struct {
+	layout      layoutReader
+	rawManifest []byte
+	mediaType   types.MediaType
+	manifest    *v1.Manifest
+}
+
+func (image *layoutImage) RawConfigFile() ([]byte, error) {
+	return readLayoutFile(image.layout, image.manifest.Config.Digest)
+}
+
+func (image *layoutImage) MediaType() (types.MediaType, error) {
+	return image.mediaType, nil
+}
+
+func (image *layoutImage) RawManifest() ([]byte, error) {
+	return image.rawManifest, nil
+}
+
+func (image *layoutImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	for _, desc := range image.manifest.Layers {
+		if desc.Digest == digest {
+			return layoutLayer{image.layout, desc}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("layer %s not found in manifest", digest)
+}
+
+// layoutLayer implements partial.CompressedLayer.
+type layoutLayer struct {
+	layout layoutReader
+	desc   v1.Descriptor
+}
+
+func (layer layoutLayer) Digest() (v1.Hash, error) {
+	return layer.desc.Digest, nil
+}
+
+func (layer layoutLayer) Compressed() (io.ReadCloser, error) {
+	return layer.layout.readBlob(layer.desc.Digest)
+}
+
+func (layer layoutLayer) Size() (int64, error) {
+	return layer.desc.Size, nil
+}
+
+func (layer layoutLayer) MediaType() (types.MediaType, error) {
+	return layer.desc.MediaType, nil
+}
+
+// dirLayout reads and writes an OCI image layout in a directory.
+type dirLayout string
+
+func (dir dirLayout) writeBlob(digest v1.Hash, size int64, content io.Reader) error {
+	if digest.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm: %s", digest.Algorithm)
+	}
+
+	path := filepath.Join(string(dir), "blobs", digest.Algorithm, digest.Hex)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), digest.Hex+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tmp, hash), content)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != digest.Hex {
+		return fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, actual)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (dir dirLayout) writeFile(name string, content []byte) error {
+	return ioutil.WriteFile(filepath.Join(string(dir), name), content, 0644)
+}
+
+func (dir dirLayout) readBlob(digest v1.Hash) (io.ReadCloser, error) {
+	if digest.Algorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", digest.Algorithm)
+	}
+
+	return os.Open(filepath.Join(string(dir), "blobs", digest.Algorithm, digest.Hex))
+}
+
+func (dir dirLayout) readFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(dir), name))
+}
+
+// tarLayout writes an OCI image layout to a tar archive.
+type tarLayout struct {
+	tw *tar.Writer
+}
+
+func (layout tarLayout) writeBlob(digest v1.Hash, size int64, content io.Reader) error {
+	if digest.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm: %s", digest.Algorithm)
+	}
+
+	err := layout.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "blobs/" + digest.Algorithm + "/" + digest.Hex,
+		Mode:     0644,
+		Size:     size,
+	})
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(layout.tw, hash), content)
+	if err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != digest.Hex {
+		return fmt.Errorf("digest mismatch: expected %s, got sha256:%s", digest, actual)
+	}
+
+	return nil
+}
+
+func (layout tarLayout) writeFile(name string, content []byte) error {
+	err := layout.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = layout.tw.Write(content)
+	return err
+}
+
+var errNotInArchive = errors.New("file not found in archive")
+
+// archiveLayout reads an OCI image layout from a tar archive. The archive is
+// scanned for each file read, as tar archives have no index.
+type archiveLayout string
+
+func (path archiveLayout) readBlob(digest v1.Hash) (io.ReadCloser, error) {
+	if digest.Algorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", digest.Algorithm)
+	}
+
+	return path.open("blobs/" + digest.Algorithm + "/" + digest.Hex)
+}
+
+func (path archiveLayout) readFile(name string) ([]byte, error) {
+	r, err := path.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+func (path archiveLayout) contains(name string) (bool, error) {
+	r, err := path.open(name)
+	if err == errNotInArchive {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, r.Close()
+}
+
+// open returns a reader for the named file in the archive, positioned at its
+// content.
+func (path archiveLayout) open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(string(path))
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			file.Close()
+			return nil, errNotInArchive
+		}
+
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		if hdr.Typeflag == tar.TypeReg && strings.TrimPrefix(hdr.Name, "./") == name {
+			return readCloser{tr, file}, nil
+		}
+	}
+}
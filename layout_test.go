@@ -0,0 +1,319 @@
+package resource_test
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("WriteLayout", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oci-layout")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should write the image as an OCI image layout", func() {
+		image, err := random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = resource.WriteLayout(dir, "latest", image)
+		Expect(err).ToNot(HaveOccurred())
+
+		layout, err := ioutil.ReadFile(filepath.Join(dir, "oci-layout"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layout).To(MatchJSON(`{"imageLayoutVersion":"1.0.0"}`))
+
+		indexFile, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var index v1.IndexManifest
+		Expect(json.Unmarshal(indexFile, &index)).To(Succeed())
+
+		digest, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(index.Manifests).To(HaveLen(1))
+		Expect(index.Manifests[0].Digest).To(Equal(digest))
+		Expect(index.Manifests[0].Annotations).To(HaveKeyWithValue(resource.RefNameAnnotation, "latest"))
+
+		manifest, err := image.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		blobs := []v1.Hash{digest, manifest.Config.Digest}
+		for _, layer := range manifest.Layers {
+			blobs = append(blobs, layer.Digest)
+		}
+
+		for _, blob := range blobs {
+			Expect(filepath.Join(dir, "blobs", "sha256", blob.Hex)).To(BeARegularFile())
+		}
+	})
+})
+
+var _ = Describe("WriteLayoutArchive", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oci-archive")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should write the OCI image layout as a tar archive", func() {
+		image, err := random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		archive := filepath.Join(dir, "image.tar")
+
+		err = resource.WriteLayoutArchive(archive, "latest", image)
+		Expect(err).ToNot(HaveOccurred())
+
+		file, err := os.Open(archive)
+		Expect(err).ToNot(HaveOccurred())
+
+		defer file.Close()
+
+		var names []string
+		tr := tar.NewReader(file)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+
+			Expect(err).ToNot(HaveOccurred())
+			names = append(names, hdr.Name)
+		}
+
+		digest, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(names).To(ContainElement("oci-layout"))
+		Expect(names).To(ContainElement("index.json"))
+		Expect(names).To(ContainElement("blobs/sha256/" + digest.Hex))
+		Expect(names).To(HaveLen(2 + 2 + 2 + 2)) // dirs, layers, config and manifest, index and oci-layout
+	})
+})
+
+var _ = Describe("WriteIndexLayout", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oci-layout")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should write every manifest referenced by the index", func() {
+		images := map[v1.Hash]v1.Image{}
+
+		var manifests []string
+		for _, arch := range []string{"amd64", "arm64"} {
+			image, err := random.Image(1024, 1)
+			Expect(err).ToNot(HaveOccurred())
+
+			digest, err := image.Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			images[digest] = image
+			manifests = append(manifests, fmt.Sprintf(`{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":%q,"platform":{"os":"linux","architecture":%q}}`, digest, arch))
+		}
+
+		raw := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[` + strings.Join(manifests, ",") + `]}`
+
+		err := resource.WriteIndexLayout(dir, "latest", indexImage{raw: raw}, func(digest v1.Hash) (v1.Image, error) {
+			return images[digest], nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		indexFile, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var index v1.IndexManifest
+		Expect(json.Unmarshal(indexFile, &index)).To(Succeed())
+
+		Expect(index.Manifests).To(HaveLen(1))
+		Expect(string(index.Manifests[0].MediaType)).To(Equal("application/vnd.docker.distribution.manifest.list.v2+json"))
+		Expect(filepath.Join(dir, "blobs", "sha256", index.Manifests[0].Digest.Hex)).To(BeARegularFile())
+
+		for digest, image := range images {
+			manifest, err := image.Manifest()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(filepath.Join(dir, "blobs", "sha256", digest.Hex)).To(BeARegularFile())
+			Expect(filepath.Join(dir, "blobs", "sha256", manifest.Config.Digest.Hex)).To(BeARegularFile())
+			Expect(filepath.Join(dir, "blobs", "sha256", manifest.Layers[0].Digest.Hex)).To(BeARegularFile())
+		}
+	})
+})
+
+var _ = Describe("ReadLayout", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oci-layout")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should read the image as written, preserving its manifest", func() {
+		image, err := random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.WriteLayout(dir, "latest", image)).To(Succeed())
+		Expect(resource.IsLayout(dir)).To(BeTrue())
+
+		read, err := resource.ReadLayout(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		expectedManifest, err := image.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		actualManifest, err := read.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actualManifest).To(Equal(expectedManifest))
+
+		expectedDigest, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		actualDigest, err := read.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actualDigest).To(Equal(expectedDigest))
+
+		layers, err := read.Layers()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layers).To(HaveLen(2))
+
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			blob, err := layer.Compressed()
+			Expect(err).ToNot(HaveOccurred())
+
+			actual, _, err := v1.SHA256(blob)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(blob.Close()).To(Succeed())
+			Expect(actual).To(Equal(digest))
+		}
+	})
+
+	It("should reject a directory that is not an OCI image layout", func() {
+		Expect(resource.IsLayout(dir)).To(BeFalse())
+
+		_, err := resource.ReadLayout(dir)
+		Expect(err).To(MatchError(ContainSubstring("failed to read oci-layout")))
+	})
+
+	It("should reject an image index", func() {
+		raw := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[]}`
+
+		err := resource.WriteIndexLayout(dir, "latest", indexImage{raw: raw}, func(digest v1.Hash) (v1.Image, error) {
+			return nil, fmt.Errorf("unexpected fetch of %s", digest)
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = resource.ReadLayout(dir)
+		Expect(err).To(MatchError(ContainSubstring("is an image index")))
+	})
+})
+
+var _ = Describe("ReadLayoutArchive", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "oci-archive")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should read the image from an OCI archive", func() {
+		image, err := random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		archive := filepath.Join(dir, "image.tar")
+		Expect(resource.WriteLayoutArchive(archive, "latest", image)).To(Succeed())
+
+		isLayout, err := resource.IsLayoutArchive(archive)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(isLayout).To(BeTrue())
+
+		read, err := resource.ReadLayoutArchive(archive)
+		Expect(err).ToNot(HaveOccurred())
+
+		expectedDigest, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		actualDigest, err := read.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actualDigest).To(Equal(expectedDigest))
+
+		layers, err := read.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			blob, err := layer.Compressed()
+			Expect(err).ToNot(HaveOccurred())
+
+			actual, _, err := v1.SHA256(blob)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(blob.Close()).To(Succeed())
+			Expect(actual).To(Equal(digest))
+		}
+	})
+
+	It("should not mistake a docker archive for an OCI archive", func() {
+		image, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		tag, err := name.NewTag("some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		archive := filepath.Join(dir, "image.tar")
+		Expect(tarball.WriteToFile(archive, tag, image)).To(Succeed())
+
+		isLayout, err := resource.IsLayoutArchive(archive)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(isLayout).To(BeFalse())
+	})
+})
@@ -0,0 +1,100 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ListTags lists the repository's tags a page at a time, following the Link
+// header to subsequent pages, and calls fn with each page. This allows huge
+// repositories to be listed without holding every tag in memory.
+//
+// If pageSize is 0, the registry's default page size is used.
+func ListTags(repo name.Repository, auth authn.Authenticator, t http.RoundTripper, pageSize int, fn func([]string) error) error {
+	tr, err := transport.New(repo.Registry, auth, t, []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return err
+	}
+
+	uri := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/tags/list", repo.RepositoryStr()),
+	}
+
+	if pageSize > 0 {
+		uri.RawQuery = url.Values{"n": {strconv.Itoa(pageSize)}}.Encode()
+	}
+
+	client := &http.Client{Transport: tr}
+
+	next := uri.String()
+	for next != "" {
+		next, err = listPage(client, next, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func listPage(client *http.Client, uri string, fn func([]string) error) (string, error) {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	err = remote.CheckError(resp, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+
+	var page remote.Tags
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return "", err
+	}
+
+	err = fn(page.Tags)
+	if err != nil {
+		return "", err
+	}
+
+	return nextPage(resp)
+}
+
+// nextPage returns the URL of the next page given by a response's Link header,
+// e.g. </v2/foo/tags/list?n=100&last=bar>; rel="next", or an empty string if
+// this was the last page.
+func nextPage(resp *http.Response) (string, error) {
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return "", nil
+	}
+
+	parts := strings.SplitN(link, ";", 2)
+	if len(parts) != 2 || !strings.Contains(parts[1], `rel="next"`) {
+		return "", nil
+	}
+
+	target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("malformed Link header %q: %s", link, err)
+	}
+
+	return resp.Request.URL.ResolveReference(u).String(), nil
+}
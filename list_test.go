@@ -0,0 +1,79 @@
+package resource_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ListTags", func() {
+	var server *httptest.Server
+	var repo name.Repository
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			switch r.URL.Path {
+			case "/v2/":
+				w.WriteHeader(http.StatusOK)
+			case "/v2/some/repo/tags/list":
+				Expect(r.URL.Query().Get("n")).To(Equal("2"))
+
+				switch r.URL.Query().Get("last") {
+				case "":
+					w.Header().Set("Link", `</v2/some/repo/tags/list?n=2&last=b>; rel="next"`)
+					fmt.Fprint(w, `{"name":"some/repo","tags":["a","b"]}`)
+				case "b":
+					w.Header().Set("Link", `</v2/some/repo/tags/list?n=2&last=d>; rel="next"`)
+					fmt.Fprint(w, `{"name":"some/repo","tags":["c","d"]}`)
+				case "d":
+					fmt.Fprint(w, `{"name":"some/repo","tags":["e"]}`)
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		var err error
+		repo, err = name.NewRepository(strings.TrimPrefix(server.URL, "http://")+"/some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should follow the Link header through every page", func() {
+		var pages [][]string
+		err := resource.ListTags(repo, authn.Anonymous, http.DefaultTransport, 2, func(page []string) error {
+			pages = append(pages, page)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(pages).To(Equal([][]string{
+			{"a", "b"},
+			{"c", "d"},
+			{"e"},
+		}))
+	})
+
+	It("should stop when a page cannot be handled", func() {
+		var pages int
+		err := resource.ListTags(repo, authn.Anonymous, http.DefaultTransport, 2, func(page []string) error {
+			pages++
+			return fmt.Errorf("boom")
+		})
+		Expect(err).To(MatchError("boom"))
+		Expect(pages).To(Equal(1))
+	})
+})
@@ -0,0 +1,67 @@
+package resource
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Image input formats, named as with GetParams.Format.
+const (
+	DockerArchiveFormat = "docker-archive"
+	OCIArchiveFormat    = "oci-archive"
+	OCILayoutFormat     = "oci"
+)
+
+// LoadImage loads the image at path, detecting whether it is a docker archive,
+// an OCI archive, or an OCI image layout directory, and returns it along with
+// the detected format.
+//
+// A directory which is not itself an OCI image layout but contains an
+// image.tar, as with the output of oci-build-task, is loaded from the
+// image.tar.
+func LoadImage(path string) (v1.Image, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if info.IsDir() {
+		if IsLayout(path) {
+			image, err := ReadLayout(path)
+			return image, OCILayoutFormat, err
+		}
+
+		archive := filepath.Join(path, "image.tar")
+		if _, err := os.Stat(archive); err == nil {
+			return LoadImage(archive)
+		}
+
+		return nil, "", fmt.Errorf("%s is a directory, but neither an OCI image layout (no oci-layout file) nor contains an image.tar", path)
+	}
+
+	isLayout, err := archiveLayout(path).contains("oci-layout")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s as a tar archive: %s", path, err)
+	}
+
+	if isLayout {
+		image, err := ReadLayoutArchive(path)
+		return image, OCIArchiveFormat, err
+	}
+
+	isDocker, err := archiveLayout(path).contains("manifest.json")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s as a tar archive: %s", path, err)
+	}
+
+	if !isDocker {
+		return nil, "", fmt.Errorf("%s is neither a docker archive (no manifest.json) nor an OCI archive (no oci-layout)", path)
+	}
+
+	image, err := tarball.ImageFromPath(path, nil)
+	return image, DockerArchiveFormat, err
+}
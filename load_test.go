@@ -0,0 +1,85 @@
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("LoadImage", func() {
+	var dir string
+	var image v1.Image
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "load-image")
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err = random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	load := func(path string, expectedFormat string) {
+		loaded, format, err := resource.LoadImage(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(format).To(Equal(expectedFormat))
+
+		expected, err := image.ConfigName()
+		Expect(err).ToNot(HaveOccurred())
+
+		actual, err := loaded.ConfigName()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual).To(Equal(expected))
+	}
+
+	writeDockerArchive := func(path string) {
+		tag, err := name.NewTag("some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tarball.WriteToFile(path, tag, image)).To(Succeed())
+	}
+
+	It("should load a docker archive", func() {
+		writeDockerArchive(filepath.Join(dir, "image.tar"))
+		load(filepath.Join(dir, "image.tar"), "docker-archive")
+	})
+
+	It("should load an OCI archive", func() {
+		Expect(resource.WriteLayoutArchive(filepath.Join(dir, "image.tar"), "latest", image)).To(Succeed())
+		load(filepath.Join(dir, "image.tar"), "oci-archive")
+	})
+
+	It("should load an OCI image layout", func() {
+		Expect(resource.WriteLayout(dir, "latest", image)).To(Succeed())
+		load(dir, "oci")
+	})
+
+	It("should load the image.tar in a directory", func() {
+		writeDockerArchive(filepath.Join(dir, "image.tar"))
+		load(dir, "docker-archive")
+	})
+
+	It("should reject a directory with no image", func() {
+		_, _, err := resource.LoadImage(dir)
+		Expect(err).To(MatchError(ContainSubstring("neither an OCI image layout")))
+	})
+
+	It("should reject a file that is not an image archive", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "image.tar"), []byte("not a tarball"), 0644)).To(Succeed())
+
+		_, _, err := resource.LoadImage(filepath.Join(dir, "image.tar"))
+		Expect(err).To(MatchError(ContainSubstring("failed to read")))
+	})
+})
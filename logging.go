@@ -0,0 +1,57 @@
+package resource
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+// The formats logs can be written to stderr in.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// ConfigureLogging sets up logging for the source: as JSON lines with levels
+// and timestamps if its log_format is json, or else as colored text, and at
+// the debug level if debug is set.
+func (source *Source) ConfigureLogging() error {
+	switch source.LogFormat {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+		color.NoColor = true
+
+		// e.g. go-containerregistry's progress, as it pushes blobs
+		log.SetFlags(0)
+		log.SetOutput(logrus.StandardLogger().WriterLevel(logrus.InfoLevel))
+	default:
+		return fmt.Errorf("unknown log_format %q (supported: %s, %s)", source.LogFormat, LogFormatText, LogFormatJSON)
+	}
+
+	if source.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	return nil
+}
+
+// JSONLogs determines whether logs are written as JSON lines, in which case
+// progress should be logged rather than printed.
+func (source *Source) JSONLogs() bool {
+	return source.LogFormat == LogFormatJSON
+}
+
+// failureHook is called with the error when the step fails, i.e. logs one.
+type failureHook func(message string)
+
+func (hook failureHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel}
+}
+
+func (hook failureHook) Fire(entry *logrus.Entry) error {
+	hook(entry.Message)
+	return nil
+}
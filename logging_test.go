@@ -0,0 +1,82 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ConfigureLogging", func() {
+	var logs *syncBuffer
+
+	BeforeEach(func() {
+		logs = new(syncBuffer)
+		logrus.SetOutput(logs)
+	})
+
+	AfterEach(func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetFormatter(&logrus.TextFormatter{})
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		logrus.SetLevel(logrus.InfoLevel)
+		color.NoColor = false
+	})
+
+	It("should log JSON lines with levels and timestamps", func() {
+		source := resource.Source{
+			LogFormat: resource.LogFormatJSON,
+		}
+
+		Expect(source.ConfigureLogging()).To(Succeed())
+		Expect(source.JSONLogs()).To(BeTrue())
+
+		logrus.Warnf("retrying in %s", color.YellowString("1s"))
+
+		var line map[string]string
+		Expect(json.Unmarshal([]byte(logs.String()), &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("level", "warning"))
+		Expect(line).To(HaveKeyWithValue("msg", "retrying in 1s"))
+		Expect(line).To(HaveKey("time"))
+	})
+
+	It("should log the standard logger's output as JSON lines", func() {
+		source := resource.Source{
+			LogFormat: resource.LogFormatJSON,
+		}
+
+		Expect(source.ConfigureLogging()).To(Succeed())
+
+		log.Printf("pushed blob: sha256:abc")
+
+		var line map[string]string
+		Eventually(func() error { return json.Unmarshal([]byte(logs.String()), &line) }).Should(Succeed())
+		Expect(line).To(HaveKeyWithValue("level", "info"))
+		Expect(line).To(HaveKeyWithValue("msg", "pushed blob: sha256:abc"))
+	})
+
+	It("should log at the debug level if debug is set", func() {
+		source := resource.Source{
+			Debug: true,
+		}
+
+		Expect(source.ConfigureLogging()).To(Succeed())
+		Expect(source.JSONLogs()).To(BeFalse())
+		Expect(logrus.GetLevel()).To(Equal(logrus.DebugLevel))
+	})
+
+	It("should reject unknown formats", func() {
+		source := resource.Source{
+			LogFormat: "xml",
+		}
+
+		Expect(source.ConfigureLogging()).To(MatchError(`unknown log_format "xml" (supported: text, json)`))
+	})
+})
@@ -0,0 +1,244 @@
+package resource
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMetricsPrefix prefixes the names of metrics by default.
+const DefaultMetricsPrefix = "registry_image"
+
+// Metrics configures where check, get, and put report their metrics to.
+type Metrics struct {
+	// StatsD is the host:port of a StatsD server to send metrics to over UDP,
+	// tagged in the DogStatsD format.
+	StatsD string `json:"statsd,omitempty"`
+
+	// Pushgateway is the URL of a Prometheus Pushgateway to push metrics to.
+	Pushgateway string `json:"pushgateway,omitempty"`
+
+	// Prefix prefixes the names of the metrics.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Tags are added to every metric, e.g. {team: some-team}.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Validate checks that at least one endpoint is configured.
+func (metrics *Metrics) Validate() error {
+	if metrics.StatsD == "" && metrics.Pushgateway == "" {
+		return fmt.Errorf("metrics requires statsd or pushgateway")
+	}
+
+	if metrics.StatsD != "" {
+		_, _, err := net.SplitHostPort(metrics.StatsD)
+		if err != nil {
+			return fmt.Errorf("invalid statsd address: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// StepMetrics reports the metrics of a check, get, or put once it finishes.
+type StepMetrics struct {
+	metrics *Metrics
+	step    string
+	start   time.Time
+
+	once sync.Once
+}
+
+// Start starts timing the step, returning its metrics. They are reported when
+// Succeeded is called, or as soon as the step fails, i.e. logs an error, as it
+// exits straight after.
+func (metrics *Metrics) Start(step string) *StepMetrics {
+	if metrics == nil {
+		return nil
+	}
+
+	stepMetrics := &StepMetrics{
+		metrics: metrics,
+		step:    step,
+		start:   time.Now(),
+	}
+
+	logrus.AddHook(failureHook(func(string) {
+		// not logged, as hooks can't log
+		stepMetrics.report(false)
+	}))
+
+	return stepMetrics
+}
+
+// Succeeded reports the metrics of the step as having succeeded.
+func (stepMetrics *StepMetrics) Succeeded() {
+	if stepMetrics == nil {
+		return
+	}
+
+	err := stepMetrics.report(true)
+	if err != nil {
+		logrus.Warnf("failed to report metrics: %s", err)
+	}
+}
+
+// report sends the metrics, unless they have been already.
+func (stepMetrics *StepMetrics) report(succeeded bool) error {
+	var err error
+	stepMetrics.once.Do(func() {
+		values := []metricValue{
+			{"duration_seconds", time.Since(stepMetrics.start).Seconds(), "ms"},
+			{"requests", float64(Transfers.Requests()), "c"},
+			{"retries", float64(Retries()), "c"},
+			{"bytes", float64(Transfers.Bytes()), "c"},
+		}
+
+		if succeeded {
+			values = append(values, metricValue{"succeeded", 1, "c"}, metricValue{"failed", 0, "c"})
+		} else {
+			values = append(values, metricValue{"succeeded", 0, "c"}, metricValue{"failed", 1, "c"})
+		}
+
+		if stepMetrics.metrics.StatsD != "" {
+			err = stepMetrics.sendStatsD(values)
+			if err != nil {
+				return
+			}
+		}
+
+		if stepMetrics.metrics.Pushgateway != "" {
+			err = stepMetrics.push(values)
+		}
+	})
+
+	return err
+}
+
+type metricValue struct {
+	name  string
+	value float64
+
+	// statsdType is the StatsD type of the metric: c for counters, or ms for
+	// timings, which are sent in milliseconds
+	statsdType string
+}
+
+func (stepMetrics *StepMetrics) prefix() string {
+	if stepMetrics.metrics.Prefix == "" {
+		return DefaultMetricsPrefix
+	}
+
+	return stepMetrics.metrics.Prefix
+}
+
+func (stepMetrics *StepMetrics) tagNames() []string {
+	var names []string
+	for name := range stepMetrics.metrics.Tags {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// sendStatsD sends the metrics as <prefix>.<step>.<name>, tagged in the
+// DogStatsD format.
+func (stepMetrics *StepMetrics) sendStatsD(values []metricValue) error {
+	var tags []string
+	for _, name := range stepMetrics.tagNames() {
+		tags = append(tags, name+":"+stepMetrics.metrics.Tags[name])
+	}
+
+	suffix := ""
+	if len(tags) > 0 {
+		suffix = "|#" + strings.Join(tags, ",")
+	}
+
+	payload := new(bytes.Buffer)
+	for _, value := range values {
+		name := strings.TrimSuffix(value.name, "_seconds")
+
+		v := value.value
+		if value.statsdType == "ms" {
+			v *= 1000
+		}
+
+		if value.statsdType == "c" && v == 0 {
+			continue
+		}
+
+		fmt.Fprintf(payload, "%s.%s.%s:%g|%s%s\n", stepMetrics.prefix(), stepMetrics.step, name, v, value.statsdType, suffix)
+	}
+
+	conn, err := net.Dial("udp", stepMetrics.metrics.StatsD)
+	if err != nil {
+		return fmt.Errorf("failed to connect to statsd: %s", err)
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write(payload.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to send metrics to statsd: %s", err)
+	}
+
+	return nil
+}
+
+// push pushes the metrics to the Pushgateway as <prefix>_<name> gauges,
+// grouped by the step and labelled with the tags.
+func (stepMetrics *StepMetrics) push(values []metricValue) error {
+	var labels []string
+	for _, name := range stepMetrics.tagNames() {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, stepMetrics.metrics.Tags[name]))
+	}
+
+	payload := new(bytes.Buffer)
+	for _, value := range values {
+		name := stepMetrics.prefix() + "_" + value.name
+
+		fmt.Fprintf(payload, "# TYPE %s gauge\n", name)
+		if len(labels) > 0 {
+			fmt.Fprintf(payload, "%s{%s} %g\n", name, strings.Join(labels, ","), value.value)
+		} else {
+			fmt.Fprintf(payload, "%s %g\n", name, value.value)
+		}
+	}
+
+	url := fmt.Sprintf("%s/metrics/job/%s/step/%s", strings.TrimSuffix(stepMetrics.metrics.Pushgateway, "/"), stepMetrics.prefix(), stepMetrics.step)
+
+	req, err := http.NewRequest(http.MethodPut, url, payload)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{
+		Transport: BaseTransport,
+		Timeout:   10 * time.Second,
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %s", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("failed to push metrics: %s", res.Status)
+	}
+
+	return nil
+}
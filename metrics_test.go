@@ -0,0 +1,99 @@
+package resource_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Metrics", func() {
+	var statsd net.PacketConn
+	var pushgateway *httptest.Server
+	var pushes chan string
+	var metrics *resource.Metrics
+
+	BeforeEach(func() {
+		var err error
+		statsd, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		pushes = make(chan string, 1)
+		pushgateway = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			pushes <- r.Method + " " + r.URL.Path + "\n" + string(body)
+		}))
+
+		metrics = &resource.Metrics{
+			StatsD:      statsd.LocalAddr().String(),
+			Pushgateway: pushgateway.URL,
+			Tags:        map[string]string{"team": "some-team", "pipeline": "some-pipeline"},
+		}
+	})
+
+	AfterEach(func() {
+		statsd.Close()
+		pushgateway.Close()
+
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	})
+
+	received := func() string {
+		buf := make([]byte, 4096)
+		n, _, err := statsd.ReadFrom(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(buf[:n])
+	}
+
+	It("should report the metrics of steps which succeed", func() {
+		metrics.Start("put").Succeeded()
+
+		lines := strings.Split(strings.TrimSpace(received()), "\n")
+		Expect(lines[0]).To(MatchRegexp(`^registry_image\.put\.duration:[0-9.e+-]+\|ms\|#pipeline:some-pipeline,team:some-team$`))
+		Expect(lines).To(ContainElement("registry_image.put.succeeded:1|c|#pipeline:some-pipeline,team:some-team"))
+		Expect(lines).ToNot(ContainElement(ContainSubstring("failed")))
+
+		var push string
+		Eventually(pushes).Should(Receive(&push))
+		Expect(push).To(HavePrefix("PUT /metrics/job/registry_image/step/put\n"))
+		Expect(push).To(ContainSubstring("# TYPE registry_image_succeeded gauge\n"))
+		Expect(push).To(ContainSubstring(`registry_image_succeeded{pipeline="some-pipeline",team="some-team"} 1` + "\n"))
+		Expect(push).To(ContainSubstring(`registry_image_failed{pipeline="some-pipeline",team="some-team"} 0` + "\n"))
+	})
+
+	It("should report the metrics of steps as soon as they fail", func() {
+		metrics.Pushgateway = ""
+		metrics.Prefix = "registry"
+
+		stepMetrics := metrics.Start("get")
+
+		logrus.SetOutput(ioutil.Discard)
+		logrus.Error("failed to fetch image")
+		logrus.SetOutput(os.Stderr)
+
+		Expect(received()).To(ContainSubstring("registry.get.failed:1|c|"))
+
+		// reported once only
+		stepMetrics.Succeeded()
+
+		Expect(statsd.SetReadDeadline(time.Now().Add(100 * time.Millisecond))).To(Succeed())
+		_, _, err := statsd.ReadFrom(make([]byte, 4096))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should require an endpoint", func() {
+		Expect((&resource.Metrics{}).Validate()).To(MatchError("metrics requires statsd or pushgateway"))
+	})
+})
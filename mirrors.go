@@ -0,0 +1,74 @@
+package resource
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
+)
+
+// MirroredImage fetches the image from each of the source's registry mirrors
+// in turn, falling back to the reference's own registry with the given
+// options if none of them have it.
+//
+// Mirrors are accessed anonymously, so that the source's credentials are only
+// ever sent to the canonical registry, and without retrying connection errors,
+// so that a mirror which is down fails over straight away.
+func (source *Source) MirroredImage(ref name.Reference, opts ...remote.ImageOption) (v1.Image, error) {
+	for _, mirror := range source.RegistryMirrors {
+		mirrorRef, err := mirrorReference(mirror, ref)
+		if err != nil {
+			logrus.Warnf("skipping invalid registry mirror %s: %s", mirror, err)
+			continue
+		}
+
+		image, err := remote.Image(mirrorRef, remote.WithTransport(RateLimiter))
+		if err == nil {
+			// make sure the mirror actually has the image
+			_, err = image.Digest()
+		}
+
+		if err != nil {
+			logrus.Warnf("failed to fetch %s from mirror %s: %s", ref, mirror, err)
+			continue
+		}
+
+		logrus.Debugf("fetching %s from mirror %s", ref, mirror)
+
+		return image, nil
+	}
+
+	return remote.Image(ref, opts...)
+}
+
+// mirrorReference returns the reference to the same repository and tag or
+// digest as ref in the mirror, e.g. mirror.example.com/library/alpine:latest
+// for alpine:latest.
+func mirrorReference(mirror string, ref name.Reference) (name.Reference, error) {
+	repo := mirrorHost(mirror) + "/" + ref.Context().RepositoryStr()
+
+	switch r := ref.(type) {
+	case name.Digest:
+		return name.NewDigest(repo+"@"+r.DigestStr(), name.WeakValidation)
+	case name.Tag:
+		return name.NewTag(repo+":"+r.TagStr(), name.WeakValidation)
+	default:
+		return name.ParseReference(repo+":"+ref.Identifier(), name.WeakValidation)
+	}
+}
+
+// mirrorHost returns the host of the mirror, which may be given as a URL with
+// any scheme, e.g. mirror.example.com for https://mirror.example.com/.
+func mirrorHost(mirror string) string {
+	if strings.Contains(mirror, "://") {
+		u, err := url.Parse(mirror)
+		if err == nil {
+			mirror = u.Host + u.Path
+		}
+	}
+
+	return strings.TrimSuffix(mirror, "/")
+}
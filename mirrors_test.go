@@ -0,0 +1,130 @@
+package resource_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("MirroredImage", func() {
+	manifest := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest)))
+
+	registry := func(hits *int, hasImage bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/":
+				w.WriteHeader(http.StatusOK)
+			case "/v2/some/repo/manifests/latest":
+				*hits++
+
+				if !hasImage {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+				fmt.Fprint(w, manifest)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	}
+
+	var canonical *httptest.Server
+	var canonicalHits int
+
+	BeforeEach(func() {
+		canonicalHits = 0
+		canonical = registry(&canonicalHits, true)
+	})
+
+	AfterEach(func() {
+		canonical.Close()
+	})
+
+	host := func(server *httptest.Server) string {
+		return strings.TrimPrefix(server.URL, "http://")
+	}
+
+	It("should fail over to the next mirror", func() {
+		down := registry(new(int), true)
+		down.Close()
+
+		var mirrorHits int
+		mirror := registry(&mirrorHits, true)
+		defer mirror.Close()
+
+		source := resource.Source{
+			RegistryMirrors: []string{host(down), host(mirror)},
+		}
+
+		ref, err := name.ParseReference(host(canonical)+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err := source.MirroredImage(ref)
+		Expect(err).ToNot(HaveOccurred())
+
+		actual, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.String()).To(Equal(digest))
+
+		Expect(mirrorHits).To(Equal(1))
+		Expect(canonicalHits).To(Equal(0))
+	})
+
+	It("should accept mirrors given as URLs with any scheme", func() {
+		var mirrorHits int
+		mirror := registry(&mirrorHits, true)
+		defer mirror.Close()
+
+		source := resource.Source{
+			RegistryMirrors: []string{"http://" + host(mirror) + "/"},
+		}
+
+		ref, err := name.ParseReference(host(canonical)+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err := source.MirroredImage(ref)
+		Expect(err).ToNot(HaveOccurred())
+
+		actual, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.String()).To(Equal(digest))
+
+		Expect(mirrorHits).To(Equal(1))
+		Expect(canonicalHits).To(Equal(0))
+	})
+
+	It("should fall back to the canonical registry", func() {
+		var mirrorHits int
+		mirror := registry(&mirrorHits, false)
+		defer mirror.Close()
+
+		source := resource.Source{
+			RegistryMirrors: []string{host(mirror)},
+		}
+
+		ref, err := name.ParseReference(host(canonical)+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err := source.MirroredImage(ref)
+		Expect(err).ToNot(HaveOccurred())
+
+		actual, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(actual.String()).To(Equal(digest))
+
+		Expect(mirrorHits).To(Equal(1))
+		Expect(canonicalHits).To(Equal(1))
+	})
+})
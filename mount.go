@@ -0,0 +1,109 @@
+package resource
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
+)
+
+// WithMounts returns the image with those of its layers which one of the
+// repositories has marked as mountable from it, so that they are mounted
+// rather than uploaded when the image is written to a repository in the same
+// registry. The transport must be authorized to pull from the repositories.
+func WithMounts(img v1.Image, from []name.Repository, t http.RoundTripper) (v1.Image, error) {
+	if len(from) == 0 {
+		return img, nil
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	mounted := 0
+
+	mountable := make([]v1.Layer, len(layers))
+	for i, layer := range layers {
+		mountable[i] = layer
+
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repo := range from {
+			found, err := hasBlob(repo, digest, t)
+			if err != nil {
+				// fall back on uploading it
+				logrus.Debugf("failed to check for %s in %s: %s", digest, repo.Name(), err)
+				continue
+			}
+
+			if found {
+				// only the reference's repository is used
+				ref, err := name.NewTag(repo.Name()+":"+DefaultTag, name.WeakValidation)
+				if err != nil {
+					return nil, err
+				}
+
+				mountable[i] = &remote.MountableLayer{
+					Layer:     layer,
+					Reference: ref,
+				}
+
+				mounted++
+				break
+			}
+		}
+	}
+
+	logrus.Debugf("%d of %d layers can be mounted", mounted, len(layers))
+
+	return &mountableImage{Image: img, layers: mountable}, nil
+}
+
+// hasBlob determines whether the repository has the blob.
+func hasBlob(repo name.Repository, digest v1.Hash, t http.RoundTripper) (bool, error) {
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo.RepositoryStr(), digest),
+	}
+
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return false, err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, remote.CheckError(res, http.StatusOK)
+	}
+}
+
+// mountableImage overrides the layers of the image, e.g. with mountable ones.
+type mountableImage struct {
+	v1.Image
+
+	layers []v1.Layer
+}
+
+func (img *mountableImage) Layers() ([]v1.Layer, error) {
+	return img.layers, nil
+}
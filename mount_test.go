@@ -0,0 +1,89 @@
+package resource_test
+
+import (
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("WithMounts", func() {
+	var registry *fakeRegistry
+	var base, other, target name.Repository
+	var tr *resource.TokenTransport
+
+	var baseImage, image v1.Image
+
+	BeforeEach(func() {
+		registry = newFakeRegistry()
+
+		var err error
+		base, err = name.NewRepository(registry.Host()+"/some/base", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		other, err = name.NewRepository(registry.Host()+"/some/other", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		target, err = name.NewRepository(registry.Host()+"/some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr = resource.NewTokenTransport(target.Registry, authn.Anonymous, http.DefaultTransport, []string{
+			target.Scope(transport.PushScope),
+			base.Scope(transport.PullScope),
+		})
+
+		baseImage, err = random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		baseRef, err := name.NewTag(base.Name()+":latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.Write(baseRef, baseImage, tr)).To(Succeed())
+
+		layerImage, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		layers, err := layerImage.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err = mutate.AppendLayers(baseImage, layers...)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		registry.Close()
+	})
+
+	It("should mount the layers the repositories have", func() {
+		mountable, err := resource.WithMounts(image, []name.Repository{other, base}, tr)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := mountable.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		expected, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest).To(Equal(expected))
+
+		ref, err := name.NewTag(target.Name()+":latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.Write(ref, mountable, tr)).To(Succeed())
+
+		Expect(registry.Mounts).To(Equal(2))
+	})
+
+	It("should leave the image as is without repositories", func() {
+		mountable, err := resource.WithMounts(image, nil, tr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mountable).To(Equal(image))
+	})
+})
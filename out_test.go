@@ -131,10 +131,10 @@ var _ = Describe("Out", func() {
 
 		Context("with additional_tags (newline separator)", func() {
 			BeforeEach(func() {
-				req.Params.AdditionalTags = "tags"
+				req.Params.AdditionalTags = resource.AdditionalTags{File: "tags"}
 
 				err := ioutil.WriteFile(
-					filepath.Join(srcDir, req.Params.AdditionalTags),
+					filepath.Join(srcDir, req.Params.AdditionalTags.File),
 					[]byte(fmt.Sprintf("%s\n%s\n", parallelTag("additional"), parallelTag("tags"))),
 					0644,
 				)
@@ -166,6 +166,34 @@ var _ = Describe("Out", func() {
 				}
 			})
 		})
+
+		Context("with push_by_digest", func() {
+			BeforeEach(func() {
+				req.Params.PushByDigest = true
+			})
+
+			It("pushes the image by digest and emits it as the version", func() {
+				randomDigest, err := randomImage.Digest()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(res.Version).To(Equal(resource.Version{Digest: randomDigest.String()}))
+
+				reference, err := ioutil.ReadFile(filepath.Join(srcDir, "reference"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(reference)).To(Equal(dockerPushRepo + "@" + randomDigest.String()))
+
+				name, err := name.ParseReference(string(reference), name.WeakValidation)
+				Expect(err).ToNot(HaveOccurred())
+
+				auth := &authn.Basic{
+					Username: req.Source.Username,
+					Password: req.Source.Password,
+				}
+
+				_, err = remote.Image(name, remote.WithAuth(auth))
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
 	})
 })
 
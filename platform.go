@@ -0,0 +1,182 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ManifestMediaTypes are accepted when fetching manifests, so that registries
+// don't convert image indexes and manifest lists to a single-platform image.
+var ManifestMediaTypes = []types.MediaType{
+	types.DockerManifestSchema2,
+	types.DockerManifestList,
+	types.OCIManifestSchema1,
+	types.OCIImageIndex,
+}
+
+// DefaultPlatform is the platform fetched from an image index or manifest list
+// when none is configured.
+var DefaultPlatform = Platform{
+	OS:           "linux",
+	Architecture: "amd64",
+}
+
+// Platform identifies one of the images in an image index or manifest list.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (platform Platform) String() string {
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s += "/" + platform.Variant
+	}
+
+	return s
+}
+
+// Matches determines whether the platform of an image in an index satisfies
+// the platform. If no variant is configured, any variant matches.
+func (platform Platform) Matches(other *v1.Platform) bool {
+	if other == nil {
+		return false
+	}
+
+	if platform.OS != other.OS || platform.Architecture != other.Architecture {
+		return false
+	}
+
+	return platform.Variant == "" || platform.Variant == other.Variant
+}
+
+// PlatformOrDefault returns the platform to fetch from image indexes.
+func (source *Source) PlatformOrDefault() Platform {
+	if source.Platform != nil {
+		return *source.Platform
+	}
+
+	return DefaultPlatform
+}
+
+// PlatformFor returns the platform to fetch from image indexes, preferring the
+// get params' platform over the source's.
+func (p GetParams) PlatformFor(source Source) Platform {
+	if p.Platform != nil {
+		return *p.Platform
+	}
+
+	return source.PlatformOrDefault()
+}
+
+// ManifestTransport sets the Accept header of manifest requests to every
+// supported manifest media type.
+type ManifestTransport struct {
+	Inner http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ManifestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method == http.MethodGet || req.Method == http.MethodHead) && strings.Contains(req.URL.Path, "/manifests/") {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept", acceptManifests())
+	}
+
+	return t.Inner.RoundTrip(req)
+}
+
+func acceptManifests() string {
+	accept := make([]string, len(ManifestMediaTypes))
+	for i, mediaType := range ManifestMediaTypes {
+		accept[i] = string(mediaType)
+	}
+
+	return strings.Join(accept, ", ")
+}
+
+// manifestMediaType returns the media type given in a raw manifest.
+func manifestMediaType(raw []byte) (types.MediaType, error) {
+	var manifest struct {
+		MediaType types.MediaType   `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+
+	err := json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return "", err
+	}
+
+	if manifest.MediaType == "" {
+		// the media type is optional in OCI manifests and indexes
+		if manifest.Manifests != nil {
+			return types.OCIImageIndex, nil
+		}
+
+		return types.OCIManifestSchema1, nil
+	}
+
+	return manifest.MediaType, nil
+}
+
+// IsIndex determines whether the image is actually an image index or manifest
+// list.
+func IsIndex(image v1.Image) (bool, error) {
+	raw, err := image.RawManifest()
+	if err != nil {
+		return false, err
+	}
+
+	mediaType, err := manifestMediaType(raw)
+	if err != nil {
+		return false, err
+	}
+
+	return mediaType == types.OCIImageIndex || mediaType == types.DockerManifestList, nil
+}
+
+// ResolvePlatform returns the image for the platform if image is an image
+// index or manifest list, fetching it with fetch, or image itself otherwise.
+func ResolvePlatform(image v1.Image, platform Platform, fetch func(v1.Hash) (v1.Image, error)) (v1.Image, error) {
+	index, err := IsIndex(image)
+	if err != nil {
+		return nil, err
+	}
+
+	if !index {
+		return image, nil
+	}
+
+	raw, err := image.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest v1.IndexManifest
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image index: %s", err)
+	}
+
+	var available []string
+	for _, desc := range manifest.Manifests {
+		if platform.Matches(desc.Platform) {
+			return fetch(desc.Digest)
+		}
+
+		if desc.Platform != nil {
+			available = append(available, Platform{
+				OS:           desc.Platform.OS,
+				Architecture: desc.Platform.Architecture,
+				Variant:      desc.Platform.Variant,
+			}.String())
+		}
+	}
+
+	return nil, fmt.Errorf("no image for platform %s (available: %s)", platform, strings.Join(available, ", "))
+}
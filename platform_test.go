@@ -0,0 +1,118 @@
+package resource_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// indexImage is an image whose manifest is actually an image index, as
+// returned by remote.Image for a manifest list.
+type indexImage struct {
+	v1.Image
+	raw string
+}
+
+func (image indexImage) RawManifest() ([]byte, error) {
+	return []byte(image.raw), nil
+}
+
+func (image indexImage) Digest() (v1.Hash, error) {
+	digest, _, err := v1.SHA256(strings.NewReader(image.raw))
+	return digest, err
+}
+
+var _ = Describe("ResolvePlatform", func() {
+	amd64 := "sha256:" + strings.Repeat("a", 64)
+	armv6 := "sha256:" + strings.Repeat("6", 64)
+	armv7 := "sha256:" + strings.Repeat("7", 64)
+
+	index := indexImage{raw: fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": [
+			{"digest": %q, "platform": {"os": "linux", "architecture": "amd64"}},
+			{"digest": %q, "platform": {"os": "linux", "architecture": "arm", "variant": "v6"}},
+			{"digest": %q, "platform": {"os": "linux", "architecture": "arm", "variant": "v7"}}
+		]
+	}`, amd64, armv6, armv7)}
+
+	var fetched []string
+	fetch := func(digest v1.Hash) (v1.Image, error) {
+		fetched = append(fetched, digest.String())
+		return random.Image(10, 1)
+	}
+
+	BeforeEach(func() {
+		fetched = nil
+	})
+
+	It("should fetch the image for the platform", func() {
+		_, err := resource.ResolvePlatform(index, resource.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, fetch)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetched).To(Equal([]string{armv7}))
+	})
+
+	It("should fetch linux/amd64 by default", func() {
+		_, err := resource.ResolvePlatform(index, resource.DefaultPlatform, fetch)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetched).To(Equal([]string{amd64}))
+	})
+
+	It("should fail if the platform is absent", func() {
+		_, err := resource.ResolvePlatform(index, resource.Platform{OS: "windows", Architecture: "amd64"}, fetch)
+		Expect(err).To(MatchError(ContainSubstring("no image for platform windows/amd64")))
+		Expect(err).To(MatchError(ContainSubstring("linux/arm/v7")))
+		Expect(fetched).To(BeEmpty())
+	})
+
+	It("should return single-platform images as is", func() {
+		image, err := random.Image(10, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		resolved, err := resource.ResolvePlatform(image, resource.DefaultPlatform, fetch)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved).To(Equal(image))
+		Expect(fetched).To(BeEmpty())
+	})
+})
+
+var _ = Describe("PlatformFor", func() {
+	It("should prefer the get params' platform", func() {
+		source := resource.Source{Platform: &resource.Platform{OS: "linux", Architecture: "arm64"}}
+		params := resource.GetParams{Platform: &resource.Platform{OS: "linux", Architecture: "s390x"}}
+
+		Expect(params.PlatformFor(source)).To(Equal(resource.Platform{OS: "linux", Architecture: "s390x"}))
+		Expect(resource.GetParams{}.PlatformFor(source)).To(Equal(resource.Platform{OS: "linux", Architecture: "arm64"}))
+		Expect(resource.GetParams{}.PlatformFor(resource.Source{})).To(Equal(resource.DefaultPlatform))
+	})
+})
+
+var _ = Describe("ManifestTransport", func() {
+	It("should accept image indexes and manifest lists when fetching manifests", func() {
+		var accept string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accept = r.Header.Get("Accept")
+		}))
+		defer server.Close()
+
+		req, err := http.NewRequest("GET", server.URL+"/v2/some/repo/manifests/latest", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+
+		_, err = (&resource.ManifestTransport{Inner: http.DefaultTransport}).RoundTrip(req)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(accept).To(ContainSubstring("application/vnd.docker.distribution.manifest.list.v2+json"))
+		Expect(accept).To(ContainSubstring("application/vnd.oci.image.index.v1+json"))
+	})
+})
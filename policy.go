@@ -0,0 +1,232 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// DefaultPolicyQuery is the query policies are evaluated with by default: the
+// messages of the deny rules of the registry_image package, as with conftest.
+const DefaultPolicyQuery = "data.registry_image.deny"
+
+// PolicyConfig configures a Rego policy which images must satisfy to be
+// fetched or pushed, e.g. that they have a maintainer label. It is evaluated
+// with the opa CLI.
+type PolicyConfig struct {
+	// Rego is the policy itself.
+	Rego string `json:"rego,omitempty"`
+
+	// File is the path to the policy, relative to the put's working
+	// directory for put.
+	File string `json:"file,omitempty"`
+
+	// Query is the query of the messages of the rules which deny the image.
+	Query string `json:"query,omitempty"`
+
+	// OPA is the opa binary, if it isn't opa on the PATH.
+	OPA string `json:"opa,omitempty"`
+}
+
+// PolicyInput is the input the policy is evaluated against.
+type PolicyInput struct {
+	// Step is get or put.
+	Step string `json:"step"`
+
+	Registry   string   `json:"registry"`
+	Repository string   `json:"repository"`
+	Tags       []string `json:"tags"`
+	Digest     string   `json:"digest"`
+
+	// Config is the image's config, or the first platform's for multi-arch
+	// images.
+	Config *v1.ConfigFile    `json:"config"`
+	Labels map[string]string `json:"labels"`
+
+	// Platforms are those of the images fetched or pushed, e.g. linux/amd64.
+	Platforms []string `json:"platforms"`
+
+	// Size is the total size of the images' compressed layers and configs.
+	Size int64 `json:"size"`
+
+	// Signatures are the kinds of signatures the image has been verified
+	// with for get, or will be signed with for put: cosign or notary.
+	Signatures []string `json:"signatures"`
+}
+
+// NewPolicyInput describes the images fetched or pushed under the digest for
+// the policy.
+func NewPolicyInput(step string, repo name.Repository, tags []string, digest v1.Hash, images []v1.Image, signatures []string) (PolicyInput, error) {
+	input := PolicyInput{
+		Step:       step,
+		Registry:   repo.RegistryStr(),
+		Repository: repo.RepositoryStr(),
+		Tags:       tags,
+		Digest:     digest.String(),
+		Labels:     map[string]string{},
+		Platforms:  []string{},
+		Signatures: signatures,
+	}
+
+	if input.Tags == nil {
+		input.Tags = []string{}
+	}
+
+	if input.Signatures == nil {
+		input.Signatures = []string{}
+	}
+
+	for _, img := range images {
+		config, err := img.ConfigFile()
+		if err != nil {
+			return PolicyInput{}, fmt.Errorf("failed to get image config: %s", err)
+		}
+
+		if input.Config == nil {
+			input.Config = config
+			for key, value := range config.Config.Labels {
+				input.Labels[key] = value
+			}
+		}
+
+		input.Platforms = append(input.Platforms, Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+		}.String())
+
+		size, err := ImageSize(img)
+		if err != nil {
+			return PolicyInput{}, fmt.Errorf("failed to get image size: %s", err)
+		}
+
+		input.Size += size
+	}
+
+	return input, nil
+}
+
+// Evaluate evaluates the policy against the input with opa, returning an
+// error with the messages of the deny rules if any deny the image. Relative
+// policy files are resolved within dir.
+func (policy *PolicyConfig) Evaluate(dir string, input PolicyInput) error {
+	if (policy.Rego == "") == (policy.File == "") {
+		return fmt.Errorf("policy requires either rego or file")
+	}
+
+	file := policy.File
+	if file == "" {
+		tmp, err := ioutil.TempFile("", "policy-*.rego")
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(tmp.Name())
+
+		_, err = tmp.WriteString(policy.Rego)
+		if err == nil {
+			err = tmp.Close()
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to write policy: %s", err)
+		}
+
+		file = tmp.Name()
+	} else if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+
+	query := policy.Query
+	if query == "" {
+		query = DefaultPolicyQuery
+	}
+
+	opa := policy.OPA
+	if opa == "" {
+		opa = "opa"
+	}
+
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(opa, "eval", "--format", "json", "--stdin-input", "--data", file, query)
+	cmd.Stdin = bytes.NewReader(payload)
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to evaluate policy with %s: %s: %s", opa, err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	var output struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), &output)
+	if err != nil {
+		return fmt.Errorf("malformed output from %s: %s", opa, err)
+	}
+
+	var denials []string
+	for _, result := range output.Result {
+		for _, expression := range result.Expressions {
+			denials = append(denials, policyMessages(expression.Value)...)
+		}
+	}
+
+	if len(denials) > 0 {
+		return fmt.Errorf("denied by policy: %s", strings.Join(denials, "; "))
+	}
+
+	return nil
+}
+
+// policyMessages returns the messages of a query's value: those of a set of
+// denials, each either a message or an object with a msg, or true or a
+// message for a single rule.
+func policyMessages(value interface{}) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		var messages []string
+		for _, denial := range v {
+			messages = append(messages, policyMessages(denial)...)
+		}
+
+		return messages
+	case map[string]interface{}:
+		if msg, ok := v["msg"].(string); ok {
+			return []string{msg}
+		}
+
+		encoded, _ := json.Marshal(v)
+		return []string{string(encoded)}
+	case string:
+		return []string{v}
+	case bool:
+		if v {
+			return []string{"denied"}
+		}
+
+		return nil
+	default:
+		return nil
+	}
+}
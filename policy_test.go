@@ -0,0 +1,133 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("PolicyConfig", func() {
+	var dir string
+	var opa string
+
+	var input resource.PolicyInput
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "policy")
+		Expect(err).ToNot(HaveOccurred())
+
+		opa = filepath.Join(dir, "opa")
+
+		image, err := random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+
+		image, err = resource.WithLabels(image, map[string]string{"maintainer": "some-team"})
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		repo, err := name.NewRepository("registry.example.com/some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		input, err = resource.NewPolicyInput("put", repo, []string{"latest"}, digest, []v1.Image{image}, []string{"cosign"})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	// writeOPA writes a fake opa which saves its arguments, input, and policy
+	// for inspection, and outputs the result
+	writeOPA := func(result string) {
+		script := `#!/bin/sh
+echo "$@" > ` + filepath.Join(dir, "args") + `
+cat > ` + filepath.Join(dir, "input.json") + `
+eval policy=\${$(($# - 1))}
+cp "$policy" ` + filepath.Join(dir, "policy.rego") + `
+echo '` + result + `'
+`
+		Expect(ioutil.WriteFile(opa, []byte(script), 0755)).To(Succeed())
+	}
+
+	It("should describe the image", func() {
+		Expect(input.Registry).To(Equal("registry.example.com"))
+		Expect(input.Repository).To(Equal("some/repo"))
+		Expect(input.Tags).To(Equal([]string{"latest"}))
+		Expect(input.Labels).To(Equal(map[string]string{"maintainer": "some-team"}))
+		Expect(input.Platforms).To(HaveLen(1))
+		Expect(input.Size).To(BeNumerically(">", 2048))
+		Expect(input.Signatures).To(Equal([]string{"cosign"}))
+	})
+
+	It("should evaluate the inline policy against the input", func() {
+		writeOPA(`{}`)
+
+		policy := resource.PolicyConfig{Rego: "package registry_image\n", OPA: opa}
+		Expect(policy.Evaluate(dir, input)).To(Succeed())
+
+		args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(args)).To(HavePrefix("eval --format json --stdin-input --data "))
+		Expect(string(args)).To(HaveSuffix(" data.registry_image.deny\n"))
+
+		rego, err := ioutil.ReadFile(filepath.Join(dir, "policy.rego"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rego)).To(Equal("package registry_image\n"))
+
+		var evaluated resource.PolicyInput
+		content, err := ioutil.ReadFile(filepath.Join(dir, "input.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Unmarshal(content, &evaluated)).To(Succeed())
+		Expect(evaluated.Labels).To(Equal(input.Labels))
+		Expect(evaluated.Digest).To(Equal(input.Digest))
+	})
+
+	It("should resolve policy files relative to the directory", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "org.rego"), []byte("package org\n"), 0644)).To(Succeed())
+		writeOPA(`{}`)
+
+		policy := resource.PolicyConfig{File: "org.rego", Query: "data.org.deny", OPA: opa}
+		Expect(policy.Evaluate(dir, input)).To(Succeed())
+
+		rego, err := ioutil.ReadFile(filepath.Join(dir, "policy.rego"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rego)).To(Equal("package org\n"))
+	})
+
+	It("should deny images with the deny rules' messages", func() {
+		writeOPA(`{"result":[{"expressions":[{"value":["no :latest pushes to prod repos",{"msg":"must have an owner label"}],"text":"data.registry_image.deny"}]}]}`)
+
+		policy := resource.PolicyConfig{Rego: "package registry_image\n", OPA: opa}
+		Expect(policy.Evaluate(dir, input)).To(MatchError("denied by policy: no :latest pushes to prod repos; must have an owner label"))
+	})
+
+	It("should allow images which no rule denies", func() {
+		writeOPA(`{"result":[{"expressions":[{"value":[],"text":"data.registry_image.deny"}]}]}`)
+
+		policy := resource.PolicyConfig{Rego: "package registry_image\n", OPA: opa}
+		Expect(policy.Evaluate(dir, input)).To(Succeed())
+	})
+
+	It("should fail if opa fails", func() {
+		Expect(ioutil.WriteFile(opa, []byte("#!/bin/sh\necho 'rego_parse_error' >&2\nexit 1\n"), 0755)).To(Succeed())
+
+		policy := resource.PolicyConfig{Rego: "not rego", OPA: opa}
+		Expect(policy.Evaluate(dir, input)).To(MatchError(ContainSubstring("rego_parse_error")))
+	})
+
+	It("should require either rego or a file", func() {
+		Expect((&resource.PolicyConfig{}).Evaluate(dir, input)).To(MatchError("policy requires either rego or file"))
+	})
+})
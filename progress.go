@@ -0,0 +1,154 @@
+package resource
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
+)
+
+// ProgressInterval is how often the progress of each layer being transferred
+// is logged.
+var ProgressInterval = 15 * time.Second
+
+// WithProgress returns the image with its layers logging their progress
+// periodically as they are read, e.g. "uploading 0123456789ab: 1.2 GiB of
+// 10.0 GiB (45.3 MiB/s, ETA 3m12s)", so that large transfers can be told
+// apart from hung ones. Mountable layers are kept mountable.
+func WithProgress(img v1.Image, verb string) (v1.Image, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	progress := make([]v1.Layer, len(layers))
+	for i, layer := range layers {
+		if mountable, ok := layer.(*remote.MountableLayer); ok {
+			progress[i] = &remote.MountableLayer{
+				Layer:     &progressLayer{Layer: mountable.Layer, verb: verb},
+				Reference: mountable.Reference,
+			}
+		} else {
+			progress[i] = &progressLayer{Layer: layer, verb: verb}
+		}
+	}
+
+	return &mountableImage{Image: img, layers: progress}, nil
+}
+
+// progressLayer logs the progress of reading the layer's blob.
+type progressLayer struct {
+	v1.Layer
+
+	verb string
+}
+
+func (layer *progressLayer) Compressed() (io.ReadCloser, error) {
+	r, err := layer.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+
+	label := layer.verb + " layer"
+	if digest, err := layer.Layer.Digest(); err == nil {
+		label = layer.verb + " " + digest.Hex[:12]
+	}
+
+	// unknown if it can't be determined
+	size, _ := layer.Layer.Size()
+
+	return newProgressReader(r, label, size, ProgressInterval), nil
+}
+
+// progressReader logs how much of a blob has been read every interval until
+// it is closed.
+type progressReader struct {
+	io.ReadCloser
+
+	label string
+	size  int64
+	start time.Time
+
+	read int64
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newProgressReader(r io.ReadCloser, label string, size int64, interval time.Duration) *progressReader {
+	reader := &progressReader{
+		ReadCloser: r,
+		label:      label,
+		size:       size,
+		start:      time.Now(),
+		done:       make(chan struct{}),
+	}
+
+	go reader.report(interval)
+
+	return reader
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.read, int64(n))
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	r.once.Do(func() { close(r.done) })
+	return r.ReadCloser.Close()
+}
+
+func (r *progressReader) report(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			logrus.Info(r.progress(atomic.LoadInt64(&r.read), time.Since(r.start)))
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// progress describes how much of the blob has been read after the elapsed
+// time, how fast, and when it should be done.
+func (r *progressReader) progress(read int64, elapsed time.Duration) string {
+	rate := float64(read) / elapsed.Seconds()
+
+	if r.size <= 0 {
+		return fmt.Sprintf("%s: %s (%s/s)", r.label, formatBytes(float64(read)), formatBytes(rate))
+	}
+
+	eta := "unknown"
+	if rate > 0 && read <= r.size {
+		eta = time.Duration(float64(r.size-read) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s: %s of %s (%s/s, ETA %s)", r.label, formatBytes(float64(read)), formatBytes(float64(r.size)), formatBytes(rate), eta)
+}
+
+// formatBytes formats a number of bytes in binary units, e.g. 1.5 MiB.
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
@@ -0,0 +1,113 @@
+package resource_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// syncBuffer is a buffer which can be logged to concurrently.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+var _ = Describe("WithProgress", func() {
+	var logs *syncBuffer
+	var image v1.Image
+
+	BeforeEach(func() {
+		logs = new(syncBuffer)
+		logrus.SetOutput(logs)
+
+		resource.ProgressInterval = 10 * time.Millisecond
+
+		var err error
+		image, err = random.Image(100*1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		logrus.SetOutput(os.Stderr)
+
+		resource.ProgressInterval = 15 * time.Second
+	})
+
+	It("should log the progress of layers as they are read", func() {
+		progress, err := resource.WithProgress(image, "uploading")
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, err := progress.Digest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(image.Digest()).To(Equal(digest))
+
+		layers, err := progress.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		layerDigest, err := layers[0].Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		r, err := layers[0].Compressed()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = io.ReadFull(r, make([]byte, 1024))
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(logs.String).Should(MatchRegexp(`uploading %s: 1\.0 KiB of [0-9.]+ KiB \([0-9.]+ [KMG]?i?B/s, ETA [0-9hms.]+\)`, layerDigest.Hex[:12]))
+
+		Expect(r.Close()).To(Succeed())
+	})
+
+	It("should keep mountable layers mountable", func() {
+		layers, err := image.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		ref, err := name.NewTag("some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		mountable := &remote.MountableLayer{Layer: layers[0], Reference: ref}
+
+		progress, err := resource.WithProgress(mountableImage{image, []v1.Layer{mountable}}, "uploading")
+		Expect(err).ToNot(HaveOccurred())
+
+		progressLayers, err := progress.Layers()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progressLayers[0]).To(BeAssignableToTypeOf(&remote.MountableLayer{}))
+		Expect(progressLayers[0].(*remote.MountableLayer).Reference).To(Equal(ref))
+	})
+})
+
+// mountableImage overrides the layers of the image.
+type mountableImage struct {
+	v1.Image
+
+	layers []v1.Layer
+}
+
+func (image mountableImage) Layers() ([]v1.Layer, error) {
+	return image.layers, nil
+}
@@ -0,0 +1,186 @@
+package resource
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// DSSEEnvelopeMediaType is the media type of cosign attestation layers.
+const DSSEEnvelopeMediaType = "application/vnd.dsse.envelope.v1+json"
+
+// CosignPredicateTypeAnnotation is the annotation of an attestation layer
+// with its predicate type.
+const CosignPredicateTypeAnnotation = "predicateType"
+
+// InTotoStatementV1 is the type of in-toto v1 statements.
+const InTotoStatementV1 = "https://in-toto.io/Statement/v1"
+
+// ConcourseBuildType is the build type of the provenance generated for
+// Concourse builds.
+const ConcourseBuildType = "https://concourse-ci.org/provenance/build/v1"
+
+// slsaProvenanceV1 is the predicate of the provenance generated for
+// Concourse builds.
+type slsaProvenanceV1 struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   map[string]string    `json:"externalParameters"`
+		ResolvedDependencies []slsaResourceDigest `json:"resolvedDependencies,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationID,omitempty"`
+			FinishedOn   string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type slsaResourceDigest struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// BuildProvenance returns basic SLSA v1 provenance of an image built by the
+// Concourse build, from the BUILD_* and ATC_EXTERNAL_URL environment
+// variables given to put. The Concourse instance is the builder. If git
+// annotations are given, as returned by GitAnnotations, the revision is
+// recorded as the source the image was built from.
+func BuildProvenance(getenv func(string) string, git map[string]string, now time.Time) ([]byte, error) {
+	atc := getenv("ATC_EXTERNAL_URL")
+	if atc == "" {
+		return nil, errors.New("ATC_EXTERNAL_URL is not set, so the builder cannot be identified")
+	}
+
+	var provenance slsaProvenanceV1
+	provenance.BuildDefinition.BuildType = ConcourseBuildType
+	provenance.BuildDefinition.ExternalParameters = map[string]string{}
+	provenance.RunDetails.Builder.ID = atc
+	provenance.RunDetails.Metadata.InvocationID = BuildURL(getenv)
+	provenance.RunDetails.Metadata.FinishedOn = now.UTC().Format(time.RFC3339)
+
+	for param, env := range map[string]string{
+		"team":     "BUILD_TEAM_NAME",
+		"pipeline": "BUILD_PIPELINE_NAME",
+		"job":      "BUILD_JOB_NAME",
+		"build":    "BUILD_NAME",
+	} {
+		if value := getenv(env); value != "" {
+			provenance.BuildDefinition.ExternalParameters[param] = value
+		}
+	}
+
+	if source := git[OCISourceAnnotation]; source != "" {
+		provenance.BuildDefinition.ResolvedDependencies = []slsaResourceDigest{{
+			URI: "git+" + source,
+			Digest: map[string]string{
+				"gitCommit": git[OCIRevisionAnnotation],
+			},
+		}}
+	}
+
+	return json.Marshal(provenance)
+}
+
+// ParsePredicate parses a provenance predicate, e.g. as written by a build
+// tool, returning its predicate type: SLSA v1 if it has a buildDefinition, or
+// else SLSA v0.2. A whole in-toto statement may be given too, in which case
+// its predicate and predicate type are returned.
+func ParsePredicate(content []byte) (string, []byte, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(content, &fields)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid predicate: %s", err)
+	}
+
+	if _, isStatement := fields["_type"]; isStatement {
+		var statement struct {
+			PredicateType string          `json:"predicateType"`
+			Predicate     json.RawMessage `json:"predicate"`
+		}
+
+		err := json.Unmarshal(content, &statement)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid statement: %s", err)
+		}
+
+		if statement.PredicateType == "" || len(statement.Predicate) == 0 {
+			return "", nil, errors.New("statement has no predicate")
+		}
+
+		return statement.PredicateType, statement.Predicate, nil
+	}
+
+	if _, isV1 := fields["buildDefinition"]; isV1 {
+		return SLSAProvenanceV1, content, nil
+	}
+
+	return SLSAProvenanceV02, content, nil
+}
+
+// InTotoStatement returns the in-toto statement of the predicate about the
+// image with the digest in the repository.
+func InTotoStatement(repo name.Repository, digest v1.Hash, predicateType string, predicate []byte) ([]byte, error) {
+	type subject struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	}
+
+	return json.Marshal(struct {
+		Type          string          `json:"_type"`
+		Subject       []subject       `json:"subject"`
+		PredicateType string          `json:"predicateType"`
+		Predicate     json.RawMessage `json:"predicate"`
+	}{
+		Type: InTotoStatementV1,
+		Subject: []subject{{
+			Name:   repo.Name(),
+			Digest: map[string]string{digest.Algorithm: digest.Hex},
+		}},
+		PredicateType: predicateType,
+		Predicate:     predicate,
+	})
+}
+
+// CosignAttestation signs the in-toto statement as `cosign attest` does,
+// returning the attestation to store.
+func CosignAttestation(signer crypto.Signer, statement []byte, predicateType string) (SignatureLayer, error) {
+	signature, err := sign(signer, preAuthEncoding(InTotoPayloadType, statement))
+	if err != nil {
+		return SignatureLayer{}, fmt.Errorf("failed to sign: %s", err)
+	}
+
+	return dsseAttestation(statement, predicateType, signature)
+}
+
+// dsseAttestation returns the attestation of the statement with the DSSE
+// signature.
+func dsseAttestation(statement []byte, predicateType string, signature []byte) (SignatureLayer, error) {
+	envelope, err := json.Marshal(dsseEnvelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures: []dsseSignature{{
+			Sig: base64.StdEncoding.EncodeToString(signature),
+		}},
+	})
+	if err != nil {
+		return SignatureLayer{}, err
+	}
+
+	return SignatureLayer{
+		MediaType: DSSEEnvelopeMediaType,
+		Payload:   envelope,
+		Annotations: map[string]string{
+			CosignPredicateTypeAnnotation: predicateType,
+		},
+	}, nil
+}
@@ -0,0 +1,166 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Provenance", func() {
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+
+	env := map[string]string{
+		"ATC_EXTERNAL_URL":    "https://ci.example.com",
+		"BUILD_TEAM_NAME":     "some-team",
+		"BUILD_PIPELINE_NAME": "some-pipeline",
+		"BUILD_JOB_NAME":      "some-job",
+		"BUILD_NAME":          "42",
+	}
+
+	getenv := func(key string) string {
+		return env[key]
+	}
+
+	var repo name.Repository
+
+	BeforeEach(func() {
+		var err error
+		repo, err = name.NewRepository("some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("BuildProvenance", func() {
+		git := map[string]string{
+			resource.OCIRevisionAnnotation: "some-revision",
+			resource.OCISourceAnnotation:   "https://github.com/some-org/some-repo.git",
+		}
+
+		It("should name the Concourse instance as the builder", func() {
+			predicate, err := resource.BuildProvenance(getenv, git, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(predicate).To(MatchJSON(`{
+				"buildDefinition": {
+					"buildType": "https://concourse-ci.org/provenance/build/v1",
+					"externalParameters": {
+						"team": "some-team",
+						"pipeline": "some-pipeline",
+						"job": "some-job",
+						"build": "42"
+					},
+					"resolvedDependencies": [{
+						"uri": "git+https://github.com/some-org/some-repo.git",
+						"digest": {"gitCommit": "some-revision"}
+					}]
+				},
+				"runDetails": {
+					"builder": {"id": "https://ci.example.com"},
+					"metadata": {
+						"invocationID": "https://ci.example.com/teams/some-team/pipelines/some-pipeline/jobs/some-job/builds/42",
+						"finishedOn": "2020-01-02T03:04:05Z"
+					}
+				}
+			}`))
+		})
+
+		It("should require ATC_EXTERNAL_URL", func() {
+			_, err := resource.BuildProvenance(func(string) string { return "" }, nil, time.Now())
+			Expect(err).To(MatchError(ContainSubstring("ATC_EXTERNAL_URL is not set")))
+		})
+
+		It("should be verifiable as a signed attestation", func() {
+			predicate, err := resource.BuildProvenance(getenv, git, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+
+			statement, err := resource.InTotoStatement(repo, digest, resource.SLSAProvenanceV1, predicate)
+			Expect(err).ToNot(HaveOccurred())
+
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+
+			attestation, err := resource.CosignAttestation(key, statement, resource.SLSAProvenanceV1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attestation.MediaType).To(BeEquivalentTo(resource.DSSEEnvelopeMediaType))
+			Expect(attestation.Annotations).To(HaveKeyWithValue("predicateType", resource.SLSAProvenanceV1))
+
+			attestations, err := resource.SignatureImage(nil, attestation)
+			Expect(err).ToNot(HaveOccurred())
+
+			publicKey, err := resource.PublicKeyPEM(key)
+			Expect(err).ToNot(HaveOccurred())
+
+			policy := resource.AttestationPolicy{
+				CosignConfig:     resource.CosignConfig{PublicKey: publicKey},
+				BuilderID:        "https://ci.example.com",
+				SourceRepository: "github.com/some-org/some-repo",
+			}
+
+			Expect(policy.Verify(attestations, digest)).To(Succeed())
+
+			manifest, err := attestations.Manifest()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Layers[0].MediaType).To(BeEquivalentTo("application/vnd.dsse.envelope.v1+json"))
+		})
+	})
+
+	Describe("ParsePredicate", func() {
+		It("should detect SLSA v1 provenance", func() {
+			predicateType, predicate, err := resource.ParsePredicate([]byte(`{"buildDefinition": {}, "runDetails": {}}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(predicateType).To(Equal(resource.SLSAProvenanceV1))
+			Expect(predicate).To(MatchJSON(`{"buildDefinition": {}, "runDetails": {}}`))
+		})
+
+		It("should default to SLSA v0.2 provenance", func() {
+			predicateType, _, err := resource.ParsePredicate([]byte(`{"builder": {"id": "some-builder"}}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(predicateType).To(Equal(resource.SLSAProvenanceV02))
+		})
+
+		It("should take the predicate of a whole statement", func() {
+			predicateType, predicate, err := resource.ParsePredicate([]byte(`{
+				"_type": "https://in-toto.io/Statement/v1",
+				"subject": [{"name": "other", "digest": {"sha256": "other"}}],
+				"predicateType": "https://example.com/some-predicate",
+				"predicate": {"some": "predicate"}
+			}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(predicateType).To(Equal("https://example.com/some-predicate"))
+			Expect(predicate).To(MatchJSON(`{"some": "predicate"}`))
+		})
+
+		It("should reject invalid JSON", func() {
+			_, _, err := resource.ParsePredicate([]byte(`nope`))
+			Expect(err).To(MatchError(ContainSubstring("invalid predicate")))
+		})
+	})
+
+	Describe("InTotoStatement", func() {
+		It("should be about the image", func() {
+			statement, err := resource.InTotoStatement(repo, digest, resource.SLSAProvenanceV1, []byte(`{}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			var parsed struct {
+				Subject []struct {
+					Name   string            `json:"name"`
+					Digest map[string]string `json:"digest"`
+				} `json:"subject"`
+			}
+			Expect(json.Unmarshal(statement, &parsed)).To(Succeed())
+
+			Expect(parsed.Subject).To(HaveLen(1))
+			Expect(parsed.Subject[0].Name).To(Equal("index.docker.io/some/repo"))
+			Expect(parsed.Subject[0].Digest).To(Equal(map[string]string{"sha256": digest.Hex}))
+		})
+	})
+})
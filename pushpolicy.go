@@ -0,0 +1,61 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The policies for how failing to push to some of the additional
+// repositories fails the put.
+const (
+	// PushFailureFailFast fails as soon as a push fails, without pushing to
+	// the remaining repositories.
+	PushFailureFailFast = "fail_fast"
+
+	// PushFailureFailAny pushes to every repository, failing if any failed.
+	PushFailureFailAny = "fail_any"
+
+	// PushFailureFailAll pushes to every repository, failing only if all of
+	// them failed.
+	PushFailureFailAll = "fail_all"
+)
+
+// ValidatePushFailurePolicy checks that the policy is known, if given.
+func ValidatePushFailurePolicy(policy string) error {
+	switch policy {
+	case "", PushFailureFailFast, PushFailureFailAny, PushFailureFailAll:
+		return nil
+	default:
+		return fmt.Errorf("unknown on_push_failure %q (supported: %s, %s, %s)", policy, PushFailureFailFast, PushFailureFailAny, PushFailureFailAll)
+	}
+}
+
+// PushFailed determines whether the put fails under the policy, given how
+// many of the pushes to the additional repositories failed.
+func PushFailed(policy string, failed int, total int) bool {
+	if failed == 0 {
+		return false
+	}
+
+	if policy == PushFailureFailAll {
+		return failed == total
+	}
+
+	return true
+}
+
+// PushSummary reports which repositories were pushed to and which couldn't
+// be as metadata, e.g. additional_repositories: "a b" and
+// failed_repositories: "c".
+func PushSummary(succeeded []string, failed []string) []MetadataField {
+	var metadata []MetadataField
+	if len(succeeded) > 0 {
+		metadata = append(metadata, MetadataField{Name: "additional_repositories", Value: strings.Join(succeeded, " ")})
+	}
+
+	if len(failed) > 0 {
+		metadata = append(metadata, MetadataField{Name: "failed_repositories", Value: strings.Join(failed, " ")})
+	}
+
+	return metadata
+}
@@ -0,0 +1,42 @@
+package resource_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("PushFailed", func() {
+	It("should fail on any failure by default", func() {
+		Expect(resource.PushFailed("", 1, 3)).To(BeTrue())
+		Expect(resource.PushFailed(resource.PushFailureFailFast, 1, 3)).To(BeTrue())
+		Expect(resource.PushFailed(resource.PushFailureFailAny, 1, 3)).To(BeTrue())
+	})
+
+	It("should fail with fail_all only if every push failed", func() {
+		Expect(resource.PushFailed(resource.PushFailureFailAll, 2, 3)).To(BeFalse())
+		Expect(resource.PushFailed(resource.PushFailureFailAll, 3, 3)).To(BeTrue())
+	})
+
+	It("should not fail if nothing failed", func() {
+		for _, policy := range []string{"", resource.PushFailureFailFast, resource.PushFailureFailAny, resource.PushFailureFailAll} {
+			Expect(resource.PushFailed(policy, 0, 3)).To(BeFalse())
+			Expect(resource.PushFailed(policy, 0, 0)).To(BeFalse())
+		}
+	})
+
+	It("should reject unknown policies", func() {
+		Expect(resource.ValidatePushFailurePolicy(resource.PushFailureFailAll)).To(Succeed())
+		Expect(resource.ValidatePushFailurePolicy("fail_some")).To(MatchError(ContainSubstring(`unknown on_push_failure "fail_some"`)))
+	})
+
+	It("should summarize the repositories pushed to and not", func() {
+		Expect(resource.PushSummary([]string{"a/b", "c/d"}, []string{"e/f"})).To(Equal([]resource.MetadataField{
+			{Name: "additional_repositories", Value: "a/b c/d"},
+			{Name: "failed_repositories", Value: "e/f"},
+		}))
+
+		Expect(resource.PushSummary(nil, nil)).To(BeEmpty())
+	})
+})
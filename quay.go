@@ -0,0 +1,164 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sirupsen/logrus"
+)
+
+// QuayOAuthTokenUsername is the username Quay takes OAuth application tokens
+// with, in place of a user's password.
+const QuayOAuthTokenUsername = "$oauthtoken"
+
+// quayPageLimit is the most tags Quay's API returns in a page.
+const quayPageLimit = 100
+
+// QuayConfig configures credentials for Quay: either a robot account and its
+// token, or an OAuth application token.
+type QuayConfig struct {
+	RobotAccount string `json:"robot_account,omitempty"`
+	RobotToken   string `json:"robot_token,omitempty"`
+	OAuthToken   string `json:"oauth_token,omitempty"`
+}
+
+// Credentials returns the username and password to authenticate with.
+func (config *QuayConfig) Credentials() (string, string, error) {
+	switch {
+	case config.OAuthToken != "" && (config.RobotAccount != "" || config.RobotToken != ""):
+		return "", "", fmt.Errorf("quay oauth_token cannot be used with robot_account or robot_token")
+	case config.OAuthToken != "":
+		return QuayOAuthTokenUsername, config.OAuthToken, nil
+	case config.RobotAccount == "" || config.RobotToken == "":
+		return "", "", fmt.Errorf("quay requires oauth_token, or robot_account and robot_token")
+	case !strings.Contains(config.RobotAccount, "+"):
+		// a common mistake is to give only the robot's short name
+		return "", "", fmt.Errorf("invalid quay robot_account %q: must be the robot's full name, e.g. myorg+ci", config.RobotAccount)
+	default:
+		return config.RobotAccount, config.RobotToken, nil
+	}
+}
+
+// IsQuay determines whether the source's repository is on Quay: either
+// quay.io, or a registry configured with quay credentials.
+func (source *Source) IsQuay() bool {
+	if source.Quay != nil {
+		return true
+	}
+
+	repo, err := name.NewRepository(source.Repository, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+
+	return repo.RegistryStr() == "quay.io"
+}
+
+// ListQuayTags lists the repository's active tags through Quay's API a page
+// at a time, calling fn with each page like ListTags. Quay pages through tags
+// by page number, reporting whether there are more, rather than with Link
+// headers, and at most 100 at a time. If the API can't be used, e.g. as it
+// doesn't accept the credentials, the tags are listed with ListTags instead.
+func ListQuayTags(repo name.Repository, auth authn.Authenticator, t http.RoundTripper, pageSize int, fn func([]string) error) error {
+	limit := pageSize
+	if limit <= 0 || limit > quayPageLimit {
+		limit = quayPageLimit
+	}
+
+	authorization, err := quayAuthorization(auth)
+	if err != nil {
+		return err
+	}
+
+	for page := 1; ; page++ {
+		u := url.URL{
+			Scheme: repo.Registry.Scheme(),
+			Host:   repo.RegistryStr(),
+			Path:   fmt.Sprintf("/api/v1/repository/%s/tag/", repo.RepositoryStr()),
+			RawQuery: url.Values{
+				"onlyActiveTags": {"true"},
+				"limit":          {strconv.Itoa(limit)},
+				"page":           {strconv.Itoa(page)},
+			}.Encode(),
+		}
+
+		tags, more, err := quayTagsPage(u.String(), authorization, t)
+		if err != nil {
+			if page == 1 {
+				logrus.Debugf("failed to list tags through the Quay API, falling back on the registry's: %s", err)
+				return ListTags(repo, auth, t, pageSize, fn)
+			}
+
+			return err
+		}
+
+		err = fn(tags)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			return nil
+		}
+	}
+}
+
+// quayTagsPage fetches a page of tags from Quay's API, returning their names
+// and whether there are more.
+func quayTagsPage(uri string, authorization string, t http.RoundTripper) ([]string, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("failed to list tags: %s", res.Status)
+	}
+
+	var page struct {
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+		HasAdditional bool `json:"has_additional"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&page)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse tags: %s", err)
+	}
+
+	var tags []string
+	for _, tag := range page.Tags {
+		tags = append(tags, tag.Name)
+	}
+
+	return tags, page.HasAdditional, nil
+}
+
+// quayAuthorization returns the Authorization header to send to Quay's API
+// with the credentials, which takes OAuth application tokens as bearer
+// tokens rather than with the $oauthtoken username.
+func quayAuthorization(auth authn.Authenticator) (string, error) {
+	if basic, ok := auth.(*authn.Basic); ok && basic.Username == QuayOAuthTokenUsername {
+		return "Bearer " + basic.Password, nil
+	}
+
+	return auth.Authorization()
+}
@@ -0,0 +1,155 @@
+package resource_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Quay", func() {
+	Describe("credentials", func() {
+		It("should authenticate robot accounts with their token", func() {
+			source := resource.Source{Quay: &resource.QuayConfig{RobotAccount: "myorg+ci", RobotToken: "some-token"}}
+
+			auth, err := source.Authenticator()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth).To(Equal(&authn.Basic{Username: "myorg+ci", Password: "some-token"}))
+		})
+
+		It("should authenticate OAuth application tokens as $oauthtoken", func() {
+			source := resource.Source{Quay: &resource.QuayConfig{OAuthToken: "some-token"}}
+
+			auth, err := source.Authenticator()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth).To(Equal(&authn.Basic{Username: "$oauthtoken", Password: "some-token"}))
+		})
+
+		It("should reject robot accounts without their namespace", func() {
+			source := resource.Source{Quay: &resource.QuayConfig{RobotAccount: "ci", RobotToken: "some-token"}}
+
+			_, err := source.Authenticator()
+			Expect(err).To(MatchError(ContainSubstring("must be the robot's full name")))
+		})
+
+		It("should reject both a robot account and an OAuth token", func() {
+			source := resource.Source{Quay: &resource.QuayConfig{RobotAccount: "myorg+ci", OAuthToken: "some-token"}}
+
+			_, err := source.Authenticator()
+			Expect(err).To(MatchError(ContainSubstring("cannot be used with robot_account")))
+		})
+
+		It("should reject incomplete credentials", func() {
+			source := resource.Source{Quay: &resource.QuayConfig{RobotAccount: "myorg+ci"}}
+
+			_, err := source.Authenticator()
+			Expect(err).To(MatchError(ContainSubstring("requires oauth_token, or robot_account and robot_token")))
+		})
+	})
+
+	Describe("IsQuay", func() {
+		It("should recognize quay.io", func() {
+			Expect((&resource.Source{Repository: "quay.io/myorg/app"}).IsQuay()).To(BeTrue())
+		})
+
+		It("should recognize registries with quay credentials", func() {
+			Expect((&resource.Source{Repository: "quay.example.com/myorg/app", Quay: &resource.QuayConfig{}}).IsQuay()).To(BeTrue())
+		})
+
+		It("should not recognize other registries", func() {
+			Expect((&resource.Source{Repository: "registry.example.com/myorg/app"}).IsQuay()).To(BeFalse())
+		})
+	})
+
+	Describe("ListQuayTags", func() {
+		var server *httptest.Server
+		var repo name.Repository
+
+		var apiAvailable bool
+		var authorization string
+
+		BeforeEach(func() {
+			apiAvailable = true
+			authorization = ""
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+
+				switch r.URL.Path {
+				case "/api/v1/repository/myorg/app/tag/":
+					if !apiAvailable {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+
+					authorization = r.Header.Get("Authorization")
+
+					Expect(r.URL.Query().Get("onlyActiveTags")).To(Equal("true"))
+					Expect(r.URL.Query().Get("limit")).To(Equal("100"))
+
+					switch r.URL.Query().Get("page") {
+					case "1":
+						fmt.Fprint(w, `{"tags":[{"name":"a"},{"name":"b"}],"page":1,"has_additional":true}`)
+					case "2":
+						fmt.Fprint(w, `{"tags":[{"name":"c"}],"page":2,"has_additional":false}`)
+					}
+				case "/v2/":
+					w.WriteHeader(http.StatusOK)
+				case "/v2/myorg/app/tags/list":
+					fmt.Fprint(w, `{"name":"myorg/app","tags":["a","b","c"]}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+
+			var err error
+			repo, err = name.NewRepository(strings.TrimPrefix(server.URL, "http://")+"/myorg/app", name.WeakValidation)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		list := func(auth authn.Authenticator) [][]string {
+			var pages [][]string
+			err := resource.ListQuayTags(repo, auth, http.DefaultTransport, 1000, func(page []string) error {
+				pages = append(pages, page)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return pages
+		}
+
+		It("should page through the tags until there are no more", func() {
+			Expect(list(authn.Anonymous)).To(Equal([][]string{{"a", "b"}, {"c"}}))
+		})
+
+		It("should send OAuth application tokens as bearer tokens", func() {
+			list(&authn.Basic{Username: "$oauthtoken", Password: "some-token"})
+			Expect(authorization).To(Equal("Bearer some-token"))
+		})
+
+		It("should send robot credentials as they are", func() {
+			list(&authn.Basic{Username: "myorg+ci", Password: "some-token"})
+			Expect(authorization).To(HavePrefix("Basic "))
+		})
+
+		Context("when the API cannot be used", func() {
+			BeforeEach(func() {
+				apiAvailable = false
+			})
+
+			It("should list the tags through the registry API instead", func() {
+				Expect(list(authn.Anonymous)).To(Equal([][]string{{"a", "b", "c"}}))
+			})
+		})
+	})
+})
@@ -0,0 +1,225 @@
+package resource
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rateLimitAttempts is the number of times a request is sent when the
+// registry responds with 429 Too Many Requests.
+const rateLimitAttempts = 5
+
+// rateLimitBackOff is the base delay between attempts of a rate limited
+// request, doubled after every attempt.
+const rateLimitBackOff = 5 * time.Second
+
+// rateLimitMaxBackOff caps the delay between attempts, including those asked
+// for by the registry with Retry-After.
+const rateLimitMaxBackOff = 2 * time.Minute
+
+// rateLimitLowWater is the fraction of the quota below which requests are
+// spaced out, to avoid exhausting it.
+const rateLimitLowWater = 0.1
+
+// RateLimit is the pull quota reported by a registry, e.g. Docker Hub.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+
+	// Window is the period over which the quota applies.
+	Window time.Duration
+}
+
+// Low determines whether the remaining quota is nearly exhausted.
+func (limit RateLimit) Low() bool {
+	return float64(limit.Remaining) <= float64(limit.Limit)*rateLimitLowWater
+}
+
+// RateLimitTransport backs off with jitter when a registry responds with 429
+// Too Many Requests or reports that the remaining quota is low, rather than
+// failing straight away. Only manifest requests are spaced out for a low
+// quota, as they're what registries like Docker Hub count as pulls.
+type RateLimitTransport struct {
+	Inner http.RoundTripper
+
+	// BackOff overrides rateLimitBackOff, for testing.
+	BackOff time.Duration
+
+	lock  sync.Mutex
+	limit *RateLimit
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limit := t.Limit(); limit != nil && limit.Low() && strings.Contains(req.URL.Path, "/manifests/") {
+		delay := jitter(t.backOff())
+		logrus.Warnf("rate limit nearly exhausted (%d of %d remaining); waiting %s", limit.Remaining, limit.Limit, delay)
+		time.Sleep(delay)
+	}
+
+	rewindable := req.Body == nil || req.GetBody != nil
+
+	for attempt := 1; ; attempt++ {
+		res, err := t.Inner.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		t.observe(res)
+
+		if res.StatusCode != http.StatusTooManyRequests || !rewindable || attempt == rateLimitAttempts {
+			return res, nil
+		}
+
+		delay := retryAfter(res)
+		if delay == 0 {
+			delay = jitter(t.backOff() << uint(attempt-1))
+		}
+
+		if delay > rateLimitMaxBackOff {
+			delay = rateLimitMaxBackOff
+		}
+
+		res.Body.Close()
+
+		logrus.Warnf("rate limited by %s (attempt %d of %d); retrying in %s", req.URL.Host, attempt, rateLimitAttempts, delay)
+		time.Sleep(delay)
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Limit returns the most recent quota reported by the registry, or nil if it
+// has not reported one.
+func (t *RateLimitTransport) Limit() *RateLimit {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.limit == nil {
+		return nil
+	}
+
+	limit := *t.limit
+	return &limit
+}
+
+// Metadata returns the most recent quota reported by the registry as the
+// ratelimit-limit and ratelimit-remaining metadata fields, or none if it has
+// not reported one.
+func (t *RateLimitTransport) Metadata() []MetadataField {
+	limit := t.Limit()
+	if limit == nil {
+		return nil
+	}
+
+	return []MetadataField{
+		{Name: "ratelimit-limit", Value: strconv.Itoa(limit.Limit)},
+		{Name: "ratelimit-remaining", Value: strconv.Itoa(limit.Remaining)},
+	}
+}
+
+func (t *RateLimitTransport) observe(res *http.Response) {
+	limit, ok := ParseRateLimit(res.Header)
+	if !ok {
+		return
+	}
+
+	t.lock.Lock()
+	t.limit = &limit
+	t.lock.Unlock()
+}
+
+func (t *RateLimitTransport) backOff() time.Duration {
+	if t.BackOff != 0 {
+		return t.BackOff
+	}
+
+	return rateLimitBackOff
+}
+
+// ParseRateLimit parses the RateLimit-Limit and RateLimit-Remaining headers,
+// e.g. "100;w=21600".
+func ParseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, window, ok := parseQuota(header.Get("RateLimit-Limit"))
+	if !ok {
+		return RateLimit{}, false
+	}
+
+	remaining, _, ok := parseQuota(header.Get("RateLimit-Remaining"))
+	if !ok {
+		return RateLimit{}, false
+	}
+
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Window:    window,
+	}, true
+}
+
+func parseQuota(value string) (int, time.Duration, bool) {
+	if value == "" {
+		return 0, 0, false
+	}
+
+	parts := strings.Split(value, ";")
+
+	quota, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var window time.Duration
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "w=") {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.TrimPrefix(param, "w="))
+		if err == nil {
+			window = time.Duration(seconds) * time.Second
+		}
+	}
+
+	return quota, window, true
+}
+
+// retryAfter returns the delay asked for by a response's Retry-After header,
+// or 0 if there is none.
+func retryAfter(res *http.Response) time.Duration {
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+
+	return 0
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,158 @@
+package resource_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ParseRateLimit", func() {
+	It("should parse the quota and its window", func() {
+		header := http.Header{}
+		header.Set("RateLimit-Limit", "100;w=21600")
+		header.Set("RateLimit-Remaining", "76;w=21600")
+
+		limit, ok := resource.ParseRateLimit(header)
+		Expect(ok).To(BeTrue())
+		Expect(limit).To(Equal(resource.RateLimit{
+			Limit:     100,
+			Remaining: 76,
+			Window:    6 * time.Hour,
+		}))
+		Expect(limit.Low()).To(BeFalse())
+	})
+
+	It("should not report a limit without the headers", func() {
+		_, ok := resource.ParseRateLimit(http.Header{})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should consider a nearly exhausted quota low", func() {
+		Expect(resource.RateLimit{Limit: 100, Remaining: 10}.Low()).To(BeTrue())
+	})
+})
+
+var _ = Describe("RateLimitTransport", func() {
+	var server *httptest.Server
+	var requests int
+	var limited int
+	var remaining string
+
+	BeforeEach(func() {
+		requests = 0
+		remaining = "50;w=21600"
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			w.Header().Set("RateLimit-Limit", "100;w=21600")
+			w.Header().Set("RateLimit-Remaining", remaining)
+
+			if requests <= limited {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should retry rate limited requests", func() {
+		limited = 2
+
+		t := &resource.RateLimitTransport{
+			Inner:   http.DefaultTransport,
+			BackOff: time.Millisecond,
+		}
+
+		res, err := (&http.Client{Transport: t}).Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(requests).To(Equal(3))
+
+		Expect(t.Limit()).To(Equal(&resource.RateLimit{
+			Limit:     100,
+			Remaining: 50,
+			Window:    6 * time.Hour,
+		}))
+	})
+
+	It("should report the quota as metadata", func() {
+		limited = 0
+
+		t := &resource.RateLimitTransport{
+			Inner: http.DefaultTransport,
+		}
+
+		Expect(t.Metadata()).To(BeEmpty())
+
+		res, err := (&http.Client{Transport: t}).Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+
+		Expect(t.Metadata()).To(Equal([]resource.MetadataField{
+			{Name: "ratelimit-limit", Value: "100"},
+			{Name: "ratelimit-remaining", Value: "50"},
+		}))
+	})
+
+	Context("when the quota is low", func() {
+		var t *resource.RateLimitTransport
+
+		BeforeEach(func() {
+			limited = 0
+			remaining = "5;w=21600"
+
+			t = &resource.RateLimitTransport{
+				Inner:   http.DefaultTransport,
+				BackOff: 100 * time.Millisecond,
+			}
+
+			res, err := (&http.Client{Transport: t}).Get(server.URL + "/v2/")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Body.Close()).To(Succeed())
+			Expect(t.Limit().Low()).To(BeTrue())
+		})
+
+		It("should space out manifest requests", func() {
+			start := time.Now()
+
+			res, err := (&http.Client{Transport: t}).Get(server.URL + "/v2/some/repo/manifests/latest")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Body.Close()).To(Succeed())
+
+			Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		})
+
+		It("should not hold up other requests, e.g. for blobs", func() {
+			t.BackOff = time.Hour
+
+			res, err := (&http.Client{Transport: t}).Get(server.URL + "/v2/some/repo/blobs/sha256:abc")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Body.Close()).To(Succeed())
+		})
+	})
+
+	It("should eventually give up", func() {
+		limited = 100
+
+		t := &resource.RateLimitTransport{
+			Inner:   http.DefaultTransport,
+			BackOff: time.Millisecond,
+		}
+
+		res, err := (&http.Client{Transport: t}).Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(requests).To(Equal(5))
+	})
+})
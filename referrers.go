@@ -0,0 +1,542 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// OCIEmptyMediaType is the media type of the empty config of an OCI artifact.
+const OCIEmptyMediaType = "application/vnd.oci.empty.v1+json"
+
+// OCITitleAnnotation is the annotation naming the file an artifact or one of
+// its layers holds, as set by e.g. oras push.
+const OCITitleAnnotation = "org.opencontainers.image.title"
+
+// referrersDescriptor is a descriptor with the fields added for referrers,
+// which v1.Descriptor lacks.
+type referrersDescriptor struct {
+	MediaType    types.MediaType   `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// referrersManifest is an OCI image manifest for an artifact referring to
+// another manifest, its subject.
+type referrersManifest struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	MediaType     types.MediaType       `json:"mediaType"`
+	ArtifactType  string                `json:"artifactType"`
+	Config        referrersDescriptor   `json:"config"`
+	Layers        []referrersDescriptor `json:"layers"`
+	Subject       *referrersDescriptor  `json:"subject,omitempty"`
+	Annotations   map[string]string     `json:"annotations,omitempty"`
+}
+
+// referrersIndex is the image index of an image's referrers, as kept under
+// its ReferrersTag by registries without the referrers API. The manifests
+// are kept raw so that any fields we don't know of are preserved.
+type referrersIndex struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	Manifests     []json.RawMessage `json:"manifests"`
+}
+
+// Referrer describes an artifact referring to an image, as listed by the
+// referrers API.
+type Referrer struct {
+	MediaType    types.MediaType   `json:"mediaType"`
+	Digest       v1.Hash           `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ReferrersParams configures fetching the artifacts referring to the image
+// for get.
+type ReferrersParams struct {
+	// ArtifactTypes are the types of the referrers to fetch, e.g.
+	// application/spdx+json. All of them are fetched by default.
+	ArtifactTypes []string `json:"artifact_types,omitempty"`
+
+	// Download downloads each referrer, rather than only listing them.
+	Download bool `json:"download,omitempty"`
+}
+
+// Attachment is a file to attach to the pushed image as an OCI artifact
+// referring to it.
+type Attachment struct {
+	File         string            `json:"file"`
+	ArtifactType string            `json:"artifact_type"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ReferrersTag returns the tag the index of the referrers of the image with
+// the digest is kept under in registries without the referrers API.
+func ReferrersTag(digest v1.Hash) string {
+	return digest.Algorithm + "-" + digest.Hex
+}
+
+// Descriptor returns the descriptor of the image, e.g. to refer to it as an
+// artifact's subject.
+func Descriptor(image v1.Image) (v1.Descriptor, error) {
+	raw, err := image.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	mediaType, err := image.MediaType()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	return v1.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(raw)),
+		Digest:    digest,
+	}, nil
+}
+
+// NewArtifact returns an OCI artifact of the type with the content as its
+// only layer, referring to the subject.
+func NewArtifact(artifactType string, content []byte, subject v1.Descriptor, annotations map[string]string) (v1.Image, error) {
+	return newArtifact(artifactType, nil, []ArtifactBlob{{
+		MediaType: artifactType,
+		Content:   content,
+	}}, &referrersDescriptor{
+		MediaType: subject.MediaType,
+		Digest:    subject.Digest.String(),
+		Size:      subject.Size,
+	}, annotations)
+}
+
+// newArtifact returns an OCI artifact of the type with the config, or the
+// empty config if nil, and layers, referring to the subject if any.
+func newArtifact(artifactType string, config *ArtifactBlob, layers []ArtifactBlob, subject *referrersDescriptor, annotations map[string]string) (v1.Image, error) {
+	if config == nil {
+		config = &ArtifactBlob{
+			MediaType: OCIEmptyMediaType,
+			Content:   []byte("{}"),
+		}
+	}
+
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config.Content))
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := artifactImage{
+		rawConfig: config.Content,
+		blobs:     map[v1.Hash][]byte{},
+	}
+
+	manifest := referrersManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  artifactType,
+		Config: referrersDescriptor{
+			MediaType: types.MediaType(config.MediaType),
+			Digest:    configDigest.String(),
+			Size:      configSize,
+		},
+		Layers:      []referrersDescriptor{},
+		Subject:     subject,
+		Annotations: annotations,
+	}
+
+	for _, layer := range layers {
+		digest, size, err := v1.SHA256(bytes.NewReader(layer.Content))
+		if err != nil {
+			return nil, err
+		}
+
+		artifact.blobs[digest] = layer.Content
+
+		manifest.Layers = append(manifest.Layers, referrersDescriptor{
+			MediaType:   types.MediaType(layer.MediaType),
+			Digest:      digest.String(),
+			Size:        size,
+			Annotations: layer.Annotations,
+		})
+	}
+
+	artifact.rawManifest, err = json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(artifact)
+}
+
+// SaveArtifact saves the artifact's manifest to manifest.json in the
+// directory, along with each of its layers, named by their title annotation
+// (or the artifact's, if it has only one layer), or else by digest, e.g.
+// sha256-<hex>.
+func SaveArtifact(dir string, artifact v1.Image) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	raw, err := artifact.RawManifest()
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "manifest.json"), raw, 0644)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := artifact.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		content, err := readBlob(artifact, layer)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %s", layer.Digest, err)
+		}
+
+		title := layer.Annotations[OCITitleAnnotation]
+		if title == "" && len(manifest.Layers) == 1 {
+			// artifacts of a single file may be titled as a whole instead
+			title = manifest.Annotations[OCITitleAnnotation]
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, artifactFileName(title, layer.Digest)), content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// artifactFileName returns the name to save a layer with the title and
+// digest as: the base name of its title, so that it can't escape the
+// directory, or else its digest.
+func artifactFileName(title string, digest v1.Hash) string {
+	switch base := filepath.Base(title); base {
+	case ".", "..", "/", "manifest.json":
+		return ReferrersTag(digest)
+	default:
+		return base
+	}
+}
+
+// AttachArtifact writes the artifact to the repository by digest, and makes
+// it discoverable as a referrer of its subject: by the registry itself if it
+// supports the referrers API, or else by adding it to the index under the
+// subject's ReferrersTag.
+func AttachArtifact(repo name.Repository, artifact v1.Image, subject v1.Hash, t *TokenTransport) error {
+	digest, err := artifact.Digest()
+	if err != nil {
+		return err
+	}
+
+	ref, err := name.NewDigest(repo.Name()+"@"+digest.String(), name.WeakValidation)
+	if err != nil {
+		return err
+	}
+
+	err = Write(ref, artifact, t)
+	if err != nil {
+		return fmt.Errorf("failed to upload artifact: %s", err)
+	}
+
+	supported, err := referrersSupported(repo, subject, t)
+	if err != nil {
+		return fmt.Errorf("failed to check for the referrers API: %s", err)
+	}
+
+	if supported {
+		return nil
+	}
+
+	return addReferrer(repo, artifact, subject, t)
+}
+
+// ListReferrers lists the artifacts in the repository referring to the
+// subject, e.g. its signatures, SBOMs, and attestations: with the referrers
+// API if the registry supports it, or else from the index under the subject's
+// ReferrersTag. If any artifact types are given, only referrers of those
+// types are listed.
+func ListReferrers(repo name.Repository, subject v1.Hash, artifactTypes []string, t *TokenTransport) ([]Referrer, error) {
+	manifests, supported, err := queryReferrers(repo, subject, t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the referrers API: %s", err)
+	}
+
+	if !supported {
+		tag, err := name.NewTag(repo.Name()+":"+ReferrersTag(subject), name.WeakValidation)
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := fetchReferrersIndex(tag, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch referrers index: %s", err)
+		}
+
+		manifests = index.Manifests
+	}
+
+	referrers := []Referrer{}
+	for _, raw := range manifests {
+		var desc referrersDescriptor
+		err := json.Unmarshal(raw, &desc)
+		if err != nil {
+			return nil, fmt.Errorf("malformed referrer: %s", err)
+		}
+
+		if len(artifactTypes) > 0 && !containsString(artifactTypes, desc.ArtifactType) {
+			continue
+		}
+
+		digest, err := v1.NewHash(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("malformed referrer digest: %s", err)
+		}
+
+		referrers = append(referrers, Referrer{
+			MediaType:    desc.MediaType,
+			Digest:       digest,
+			Size:         desc.Size,
+			ArtifactType: desc.ArtifactType,
+			Annotations:  desc.Annotations,
+		})
+	}
+
+	return referrers, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// referrersSupported determines whether the registry supports the referrers
+// API, which lists an image's referrers by itself.
+func referrersSupported(repo name.Repository, subject v1.Hash, t *TokenTransport) (bool, error) {
+	_, supported, err := queryReferrers(repo, subject, t)
+	return supported, err
+}
+
+// queryReferrers lists the subject's referrers with the referrers API,
+// returning false if the registry doesn't support it.
+func queryReferrers(repo name.Repository, subject v1.Hash, t *TokenTransport) ([]json.RawMessage, bool, error) {
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/referrers/%s", repo.RepositoryStr(), subject),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, remote.CheckError(res, http.StatusOK)
+	}
+
+	var index referrersIndex
+	err = json.NewDecoder(res.Body).Decode(&index)
+	if err != nil {
+		return nil, false, fmt.Errorf("malformed referrers index: %s", err)
+	}
+
+	return index.Manifests, true, nil
+}
+
+// addReferrer adds the artifact to the index of the subject's referrers kept
+// under its ReferrersTag.
+func addReferrer(repo name.Repository, artifact v1.Image, subject v1.Hash, t *TokenTransport) error {
+	tag, err := name.NewTag(repo.Name()+":"+ReferrersTag(subject), name.WeakValidation)
+	if err != nil {
+		return err
+	}
+
+	index, err := fetchReferrersIndex(tag, t)
+	if err != nil {
+		return fmt.Errorf("failed to fetch referrers index: %s", err)
+	}
+
+	desc, err := Descriptor(artifact)
+	if err != nil {
+		return err
+	}
+
+	rawManifest, err := artifact.RawManifest()
+	if err != nil {
+		return err
+	}
+
+	var manifest referrersManifest
+	err = json.Unmarshal(rawManifest, &manifest)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range index.Manifests {
+		var existingDesc referrersDescriptor
+		err := json.Unmarshal(existing, &existingDesc)
+		if err == nil && existingDesc.Digest == desc.Digest.String() {
+			// already referred to
+			return nil
+		}
+	}
+
+	rawDesc, err := json.Marshal(referrersDescriptor{
+		MediaType:    desc.MediaType,
+		Digest:       desc.Digest.String(),
+		Size:         desc.Size,
+		ArtifactType: manifest.ArtifactType,
+		Annotations:  manifest.Annotations,
+	})
+	if err != nil {
+		return err
+	}
+
+	index.Manifests = append(index.Manifests, rawDesc)
+
+	raw, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	indexImage, err := partial.CompressedToImage(rawIndex(raw))
+	if err != nil {
+		return err
+	}
+
+	return WriteManifest(tag, indexImage, t)
+}
+
+// fetchReferrersIndex fetches the index under the tag, or returns an empty
+// one if there is none yet.
+func fetchReferrersIndex(tag name.Tag, t *TokenTransport) (referrersIndex, error) {
+	index := referrersIndex{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+	}
+
+	u := url.URL{
+		Scheme: tag.Registry.Scheme(),
+		Host:   tag.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", tag.RepositoryStr(), tag.Identifier()),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return referrersIndex{}, err
+	}
+
+	req.Header.Set("Accept", string(types.OCIImageIndex))
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return referrersIndex{}, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return index, nil
+	}
+
+	err = remote.CheckError(res, http.StatusOK)
+	if err != nil {
+		return referrersIndex{}, err
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&index)
+	if err != nil {
+		return referrersIndex{}, err
+	}
+
+	return index, nil
+}
+
+// artifactImage implements partial.CompressedImageCore for an image built in
+// memory, e.g. a cosign signature image or an OCI artifact.
+type artifactImage struct {
+	rawConfig   []byte
+	rawManifest []byte
+	blobs       map[v1.Hash][]byte
+}
+
+func (image artifactImage) RawConfigFile() ([]byte, error) {
+	return image.rawConfig, nil
+}
+
+func (image artifactImage) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (image artifactImage) RawManifest() ([]byte, error) {
+	return image.rawManifest, nil
+}
+
+func (image artifactImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	blob, found := image.blobs[digest]
+	if !found {
+		return nil, fmt.Errorf("unknown layer %s", digest)
+	}
+
+	return artifactLayer{digest, blob}, nil
+}
+
+type artifactLayer struct {
+	digest v1.Hash
+	blob   []byte
+}
+
+func (layer artifactLayer) Digest() (v1.Hash, error) {
+	return layer.digest, nil
+}
+
+func (layer artifactLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(layer.blob)), nil
+}
+
+func (layer artifactLayer) Size() (int64, error) {
+	return int64(len(layer.blob)), nil
+}
@@ -0,0 +1,494 @@
+package resource_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// fakeRegistry is an in-memory registry, implementing just enough of the
+// distribution API to push to it.
+type fakeRegistry struct {
+	*httptest.Server
+
+	// Referrers enables the referrers API.
+	Referrers bool
+
+	// Patches records the size of each PATCH request's body.
+	Patches []int
+
+	// Mounts counts the blobs mounted from other repositories.
+	Mounts int
+
+	lock      sync.Mutex
+	blobs     map[string][]byte
+	uploads   map[string]*bytes.Buffer
+	manifests map[string]fakeManifest
+	subjects  map[string][]interface{}
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+func newFakeRegistry() *fakeRegistry {
+	registry := &fakeRegistry{
+		blobs:     map[string][]byte{},
+		uploads:   map[string]*bytes.Buffer{},
+		manifests: map[string]fakeManifest{},
+		subjects:  map[string][]interface{}{},
+	}
+
+	registry.Server = httptest.NewServer(http.HandlerFunc(registry.serve))
+
+	return registry
+}
+
+// Host returns the host:port to refer to the registry by.
+func (registry *fakeRegistry) Host() string {
+	return strings.TrimPrefix(registry.URL, "http://")
+}
+
+// Manifest returns the manifest pushed under the repository and tag or
+// digest, if any.
+func (registry *fakeRegistry) Manifest(repo string, ref string) ([]byte, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	manifest, found := registry.manifests[repo+"/"+ref]
+	return manifest.content, found
+}
+
+func (registry *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	defer GinkgoRecover()
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if r.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+
+	for _, kind := range []string{"/blobs/uploads/", "/blobs/", "/manifests/", "/referrers/"} {
+		i := strings.Index(path, kind)
+		if i == -1 {
+			continue
+		}
+
+		repo, id := path[:i], path[i+len(kind):]
+
+		switch kind {
+		case "/blobs/uploads/":
+			registry.upload(w, r, repo, id)
+		case "/blobs/":
+			registry.blob(w, r, repo, id)
+		case "/manifests/":
+			registry.manifest(w, r, repo, id)
+		case "/referrers/":
+			registry.referrers(w, r, repo, id)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (registry *fakeRegistry) upload(w http.ResponseWriter, r *http.Request, repo string, id string) {
+	body, err := ioutil.ReadAll(r.Body)
+	Expect(err).ToNot(HaveOccurred())
+
+	switch r.Method {
+	case http.MethodPost:
+		query := r.URL.Query()
+		if blob, found := registry.blobs[query.Get("from")+"/"+query.Get("mount")]; found {
+			registry.Mounts++
+			registry.blobs[repo+"/"+query.Get("mount")] = blob
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		id = fmt.Sprintf("upload-%d", len(registry.uploads))
+		registry.uploads[id] = bytes.NewBuffer(body)
+	case http.MethodPatch:
+		upload := registry.uploads[id]
+
+		if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
+			Expect(contentRange).To(Equal(fmt.Sprintf("%d-%d", upload.Len(), upload.Len()+len(body)-1)))
+		}
+
+		registry.Patches = append(registry.Patches, len(body))
+		upload.Write(body)
+	case http.MethodPut:
+		upload := registry.uploads[id]
+		upload.Write(body)
+
+		digest, _, err := v1.SHA256(bytes.NewReader(upload.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.URL.Query().Get("digest")).To(Equal(digest.String()))
+
+		registry.blobs[repo+"/"+digest.String()] = upload.Bytes()
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (registry *fakeRegistry) blob(w http.ResponseWriter, r *http.Request, repo string, digest string) {
+	blob, found := registry.blobs[repo+"/"+digest]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		w.Write(blob)
+	}
+}
+
+func (registry *fakeRegistry) manifest(w http.ResponseWriter, r *http.Request, repo string, ref string) {
+	switch r.Method {
+	case http.MethodPut:
+		content, err := ioutil.ReadAll(r.Body)
+		Expect(err).ToNot(HaveOccurred())
+
+		digest, _, err := v1.SHA256(bytes.NewReader(content))
+		Expect(err).ToNot(HaveOccurred())
+
+		manifest := fakeManifest{
+			mediaType: r.Header.Get("Content-Type"),
+			content:   content,
+		}
+
+		registry.manifests[repo+"/"+ref] = manifest
+		registry.manifests[repo+"/"+digest.String()] = manifest
+
+		var artifact struct {
+			ArtifactType string `json:"artifactType"`
+			Subject      *struct {
+				Digest string `json:"digest"`
+			} `json:"subject"`
+		}
+
+		if json.Unmarshal(content, &artifact) == nil && artifact.Subject != nil {
+			subject := repo + "/" + artifact.Subject.Digest
+			registry.subjects[subject] = append(registry.subjects[subject], map[string]interface{}{
+				"mediaType":    manifest.mediaType,
+				"digest":       digest.String(),
+				"size":         len(content),
+				"artifactType": artifact.ArtifactType,
+			})
+		}
+
+		w.Header().Set("Docker-Content-Digest", digest.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		manifest, found := registry.manifests[repo+"/"+ref]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		digest, _, err := v1.SHA256(bytes.NewReader(manifest.content))
+		Expect(err).ToNot(HaveOccurred())
+
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest.String())
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodGet {
+			w.Write(manifest.content)
+		}
+	}
+}
+
+func (registry *fakeRegistry) referrers(w http.ResponseWriter, r *http.Request, repo string, digest string) {
+	if !registry.Referrers {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	manifests := registry.subjects[repo+"/"+digest]
+	if manifests == nil {
+		manifests = []interface{}{}
+	}
+
+	index := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests":     manifests,
+	}
+
+	json.NewEncoder(w).Encode(index)
+}
+
+var _ = Describe("AttachArtifact", func() {
+	var registry *fakeRegistry
+	var repo name.Repository
+	var tr *resource.TokenTransport
+
+	var image v1.Image
+	var subject v1.Descriptor
+	var artifact v1.Image
+
+	BeforeEach(func() {
+		registry = newFakeRegistry()
+
+		var err error
+		repo, err = name.NewRepository(registry.Host()+"/some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr = resource.NewTokenTransport(repo.Registry, authn.Anonymous, http.DefaultTransport, []string{
+			repo.Scope(transport.PushScope),
+		})
+
+		image, err = random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		subject, err = resource.Descriptor(image)
+		Expect(err).ToNot(HaveOccurred())
+
+		artifact, err = resource.NewArtifact("application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`), subject, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		registry.Close()
+	})
+
+	It("should refer to the subject", func() {
+		raw, err := artifact.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		var manifest struct {
+			ArtifactType string `json:"artifactType"`
+			Subject      struct {
+				Digest string `json:"digest"`
+			} `json:"subject"`
+		}
+		Expect(json.Unmarshal(raw, &manifest)).To(Succeed())
+
+		Expect(manifest.ArtifactType).To(Equal("application/spdx+json"))
+		Expect(manifest.Subject.Digest).To(Equal(subject.Digest.String()))
+	})
+
+	Context("when the registry supports the referrers API", func() {
+		BeforeEach(func() {
+			registry.Referrers = true
+		})
+
+		It("should only push the artifact", func() {
+			Expect(resource.AttachArtifact(repo, artifact, subject.Digest, tr)).To(Succeed())
+
+			digest, err := artifact.Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, found := registry.Manifest("some/repo", digest.String())
+			Expect(found).To(BeTrue())
+
+			_, found = registry.Manifest("some/repo", resource.ReferrersTag(subject.Digest))
+			Expect(found).To(BeFalse())
+		})
+	})
+
+	Context("when the registry does not support the referrers API", func() {
+		referrers := func() []map[string]interface{} {
+			raw, found := registry.Manifest("some/repo", resource.ReferrersTag(subject.Digest))
+			Expect(found).To(BeTrue())
+
+			var index struct {
+				Manifests []map[string]interface{} `json:"manifests"`
+			}
+			Expect(json.Unmarshal(raw, &index)).To(Succeed())
+
+			return index.Manifests
+		}
+
+		It("should add the artifact to the referrers index", func() {
+			Expect(resource.AttachArtifact(repo, artifact, subject.Digest, tr)).To(Succeed())
+
+			digest, err := artifact.Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(referrers()).To(ConsistOf(SatisfyAll(
+				HaveKeyWithValue("digest", digest.String()),
+				HaveKeyWithValue("artifactType", "application/spdx+json"),
+			)))
+		})
+
+		It("should keep the existing referrers", func() {
+			Expect(resource.AttachArtifact(repo, artifact, subject.Digest, tr)).To(Succeed())
+
+			other, err := resource.NewArtifact("application/vnd.cyclonedx+json", []byte(`{"bomFormat":"CycloneDX"}`), subject, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resource.AttachArtifact(repo, other, subject.Digest, tr)).To(Succeed())
+			Expect(resource.AttachArtifact(repo, other, subject.Digest, tr)).To(Succeed())
+
+			Expect(referrers()).To(HaveLen(2))
+		})
+	})
+})
+
+var _ = Describe("ListReferrers", func() {
+	var registry *fakeRegistry
+	var repo name.Repository
+	var tr *resource.TokenTransport
+
+	var subject v1.Descriptor
+	var spdx v1.Image
+	var cyclonedx v1.Image
+
+	BeforeEach(func() {
+		registry = newFakeRegistry()
+
+		var err error
+		repo, err = name.NewRepository(registry.Host()+"/some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr = resource.NewTokenTransport(repo.Registry, authn.Anonymous, http.DefaultTransport, []string{
+			repo.Scope(transport.PushScope),
+		})
+
+		image, err := random.Image(1024, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		subject, err = resource.Descriptor(image)
+		Expect(err).ToNot(HaveOccurred())
+
+		spdx, err = resource.NewArtifact("application/spdx+json", []byte(`{"spdxVersion":"SPDX-2.3"}`), subject, map[string]string{
+			resource.OCITitleAnnotation: "sbom.spdx.json",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		cyclonedx, err = resource.NewArtifact("application/vnd.cyclonedx+json", []byte(`{"bomFormat":"CycloneDX"}`), subject, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		registry.Close()
+	})
+
+	attach := func() {
+		Expect(resource.AttachArtifact(repo, spdx, subject.Digest, tr)).To(Succeed())
+		Expect(resource.AttachArtifact(repo, cyclonedx, subject.Digest, tr)).To(Succeed())
+	}
+
+	artifactTypes := func(referrers []resource.Referrer) []string {
+		var types []string
+		for _, referrer := range referrers {
+			types = append(types, referrer.ArtifactType)
+		}
+
+		return types
+	}
+
+	for _, supported := range []bool{true, false} {
+		supported := supported
+
+		Context(fmt.Sprintf("when the registry's support for the referrers API is %t", supported), func() {
+			BeforeEach(func() {
+				registry.Referrers = supported
+			})
+
+			It("should list the subject's referrers", func() {
+				attach()
+
+				referrers, err := resource.ListReferrers(repo, subject.Digest, nil, tr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(artifactTypes(referrers)).To(ConsistOf("application/spdx+json", "application/vnd.cyclonedx+json"))
+
+				digest, err := spdx.Digest()
+				Expect(err).ToNot(HaveOccurred())
+				Expect([]v1.Hash{referrers[0].Digest, referrers[1].Digest}).To(ContainElement(digest))
+
+				encoded, err := json.Marshal(referrers)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(encoded)).To(ContainSubstring(`"digest":"` + digest.String() + `"`))
+			})
+
+			It("should only list referrers of the given artifact types", func() {
+				attach()
+
+				referrers, err := resource.ListReferrers(repo, subject.Digest, []string{"application/spdx+json"}, tr)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(artifactTypes(referrers)).To(Equal([]string{"application/spdx+json"}))
+			})
+
+			It("should list no referrers if there are none", func() {
+				Expect(resource.ListReferrers(repo, subject.Digest, nil, tr)).To(BeEmpty())
+			})
+		})
+	}
+
+	It("should save artifacts' files under their titles", func() {
+		attach()
+
+		dir, err := ioutil.TempDir("", "referrers")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		for _, artifact := range []v1.Image{spdx, cyclonedx} {
+			digest, err := artifact.Digest()
+			Expect(err).ToNot(HaveOccurred())
+
+			ref, err := name.NewDigest(repo.Name()+"@"+digest.String(), name.WeakValidation)
+			Expect(err).ToNot(HaveOccurred())
+
+			remoteArtifact, err := resource.RemoteImage(ref)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resource.SaveArtifact(filepath.Join(dir, resource.ReferrersTag(digest)), remoteArtifact)).To(Succeed())
+		}
+
+		spdxDigest, err := spdx.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, resource.ReferrersTag(spdxDigest), "sbom.spdx.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal(`{"spdxVersion":"SPDX-2.3"}`))
+
+		raw, err := spdx.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		saved, err := ioutil.ReadFile(filepath.Join(dir, resource.ReferrersTag(spdxDigest), "manifest.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(saved).To(Equal(raw))
+
+		cyclonedxDigest, err := cyclonedx.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		cyclonedxManifest, err := cyclonedx.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		content, err = ioutil.ReadFile(filepath.Join(dir, resource.ReferrersTag(cyclonedxDigest), resource.ReferrersTag(cyclonedxManifest.Layers[0].Digest)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal(`{"bomFormat":"CycloneDX"}`))
+	})
+})
@@ -0,0 +1,134 @@
+package resource
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resumeAttempts is the number of times an interrupted download is resumed
+// before giving up.
+const resumeAttempts = 5
+
+// resumeBackOff is the delay before resuming an interrupted download, which is
+// multiplied by the number of attempts so far.
+const resumeBackOff = time.Second
+
+// ResumeTransport resumes downloads that are interrupted partway through with
+// HTTP Range requests, rather than failing and leaving them to be restarted
+// from the beginning.
+type ResumeTransport struct {
+	Inner http.RoundTripper
+
+	// BackOff overrides resumeBackOff, for testing.
+	BackOff time.Duration
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ResumeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.Inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return res, nil
+	}
+
+	if res.StatusCode != http.StatusOK || res.ContentLength <= 0 || res.Header.Get("Accept-Ranges") == "none" {
+		return res, nil
+	}
+
+	res.Body = &resumableBody{
+		transport: t,
+		req:       req,
+		body:      res.Body,
+		size:      res.ContentLength,
+	}
+
+	return res, nil
+}
+
+func (t *ResumeTransport) backOff() time.Duration {
+	if t.BackOff != 0 {
+		return t.BackOff
+	}
+
+	return resumeBackOff
+}
+
+// resumableBody reads a response body, requesting the rest of it if the
+// connection fails before all of it has been read.
+type resumableBody struct {
+	transport *ResumeTransport
+	req       *http.Request
+
+	body     io.ReadCloser
+	size     int64
+	offset   int64
+	attempts int
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+
+	if err == nil || err == io.EOF || b.offset >= b.size {
+		return n, err
+	}
+
+	if b.attempts == resumeAttempts {
+		return n, err
+	}
+
+	b.attempts++
+
+	delay := b.transport.backOff() * time.Duration(b.attempts)
+
+	logrus.Warnf("download of %s interrupted after %d of %d bytes (%s); resuming in %s", b.req.URL.Host, b.offset, b.size, err, delay)
+	time.Sleep(delay)
+
+	resumeErr := b.resume()
+	if resumeErr != nil {
+		logrus.Warnf("failed to resume download: %s", resumeErr)
+		return n, err
+	}
+
+	if n == 0 {
+		// nothing was read before the interruption, so read from the new
+		// response straight away rather than returning an empty read
+		return b.Read(p)
+	}
+
+	return n, nil
+}
+
+func (b *resumableBody) resume() error {
+	b.body.Close()
+
+	req := b.req.Clone(b.req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.offset))
+
+	res, err := b.transport.Inner.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	expected := fmt.Sprintf("bytes %d-", b.offset)
+	if res.StatusCode != http.StatusPartialContent || !strings.HasPrefix(res.Header.Get("Content-Range"), expected) {
+		res.Body.Close()
+		return fmt.Errorf("server did not resume from byte %d (status %d)", b.offset, res.StatusCode)
+	}
+
+	b.body = res.Body
+
+	return nil
+}
+
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,103 @@
+package resource_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ResumeTransport", func() {
+	content := strings.Repeat("0123456789", 1000)
+
+	var server *httptest.Server
+	var ranges []string
+	var interruptions int
+
+	BeforeEach(func() {
+		ranges = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			start := 0
+			if rng := r.Header.Get("Range"); rng != "" {
+				ranges = append(ranges, rng)
+
+				_, err := fmt.Sscanf(rng, "bytes=%d-", &start)
+				Expect(err).ToNot(HaveOccurred())
+
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)-start))
+				w.WriteHeader(http.StatusPartialContent)
+			} else {
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if len(ranges) < interruptions {
+				// send some of the rest and then drop the connection
+				end := start + (len(content)-start)/2
+				w.Write([]byte(content[start:end]))
+				w.(http.Flusher).Flush()
+
+				conn, _, err := w.(http.Hijacker).Hijack()
+				Expect(err).ToNot(HaveOccurred())
+				conn.Close()
+				return
+			}
+
+			w.Write([]byte(content[start:]))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should resume interrupted downloads", func() {
+		interruptions = 2
+
+		client := &http.Client{Transport: &resource.ResumeTransport{
+			Inner:   &http.Transport{},
+			BackOff: time.Millisecond,
+		}}
+
+		res, err := client.Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal(content))
+
+		Expect(ranges).To(Equal([]string{"bytes=5000-", "bytes=7500-"}))
+	})
+
+	It("should not interfere with complete downloads", func() {
+		interruptions = 0
+
+		client := &http.Client{Transport: &resource.ResumeTransport{
+			Inner:   &http.Transport{},
+			BackOff: time.Millisecond,
+		}}
+
+		res, err := client.Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal(content))
+		Expect(ranges).To(BeEmpty())
+	})
+})
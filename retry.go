@@ -0,0 +1,219 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/concourse/retryhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the retry policy.
+const (
+	DefaultRetryAttempts  = 5
+	DefaultRetryBaseDelay = time.Second
+	DefaultRetryMaxDelay  = 30 * time.Second
+)
+
+// DefaultRetryOn are the status codes retried by default.
+var DefaultRetryOn = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy configures how pushes are retried when the registry fails
+// transiently.
+type RetryPolicy struct {
+	// Attempts is the number of times each request or upload is attempted.
+	Attempts int `json:"attempts,omitempty"`
+
+	// BaseDelay is the delay before the first retry, doubled after every
+	// attempt, e.g. 1s.
+	BaseDelay string `json:"base_delay,omitempty"`
+
+	// MaxDelay caps the delay between attempts, e.g. 30s.
+	MaxDelay string `json:"max_delay,omitempty"`
+
+	// RetryOn are the status codes to retry.
+	RetryOn []int `json:"retry_on,omitempty"`
+}
+
+var retries int64
+
+// Retries returns the number of requests and uploads retried so far.
+func Retries() int64 {
+	return atomic.LoadInt64(&retries)
+}
+
+func countRetry() {
+	atomic.AddInt64(&retries, 1)
+}
+
+// RetryableStatusError is returned for a response with a status code to
+// retry to a request which cannot be retried itself, e.g. a blob upload
+// streamed from disk, so that the upload can be retried as a whole.
+type RetryableStatusError struct {
+	StatusCode int
+}
+
+func (err RetryableStatusError) Error() string {
+	return fmt.Sprintf("registry responded with %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+}
+
+// Validate checks that the delays are valid durations.
+func (policy *RetryPolicy) Validate() error {
+	_, _, err := policy.delays()
+	return err
+}
+
+func (policy *RetryPolicy) attempts() int {
+	if policy.Attempts <= 0 {
+		return DefaultRetryAttempts
+	}
+
+	return policy.Attempts
+}
+
+func (policy *RetryPolicy) delays() (time.Duration, time.Duration, error) {
+	base, max := DefaultRetryBaseDelay, DefaultRetryMaxDelay
+
+	if policy.BaseDelay != "" {
+		var err error
+		base, err = time.ParseDuration(policy.BaseDelay)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid base_delay: %s", err)
+		}
+	}
+
+	if policy.MaxDelay != "" {
+		var err error
+		max, err = time.ParseDuration(policy.MaxDelay)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max_delay: %s", err)
+		}
+	}
+
+	return base, max, nil
+}
+
+// delay returns how long to wait after the attempt before the next one.
+func (policy *RetryPolicy) delay(attempt int) time.Duration {
+	base, max, err := policy.delays()
+	if err != nil {
+		// checked by Validate
+		base, max = DefaultRetryBaseDelay, DefaultRetryMaxDelay
+	}
+
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	return jitter(delay)
+}
+
+func (policy *RetryPolicy) retries(statusCode int) bool {
+	codes := policy.RetryOn
+	if len(codes) == 0 {
+		codes = DefaultRetryOn
+	}
+
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Do calls f until it succeeds, the policy's attempts are exhausted, or it
+// fails with an error that isn't transient: one other than a connection
+// failure or a RetryableStatusError.
+func (policy *RetryPolicy) Do(f func() error) error {
+	if policy == nil {
+		return f()
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || !isTransient(err) || attempt >= policy.attempts() {
+			return err
+		}
+
+		delay := policy.delay(attempt)
+		logrus.Warnf("attempt %d of %d failed: %s; retrying in %s", attempt, policy.attempts(), err, delay)
+		countRetry()
+		time.Sleep(delay)
+	}
+}
+
+func isTransient(err error) bool {
+	var statusErr RetryableStatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+
+	return (&retryhttp.DefaultRetryer{}).IsRetryable(err)
+}
+
+// StatusRetryTransport retries requests when the registry responds with one
+// of the policy's status codes. Requests whose body can't be sent again fail
+// with a RetryableStatusError instead, to be retried by RetryPolicy.Do.
+type StatusRetryTransport struct {
+	Inner http.RoundTripper
+
+	Policy *RetryPolicy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *StatusRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rewindable := req.Body == nil || req.GetBody != nil
+
+	for attempt := 1; ; attempt++ {
+		res, err := t.Inner.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !t.Policy.retries(res.StatusCode) {
+			return res, nil
+		}
+
+		if !rewindable {
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			return nil, RetryableStatusError{res.StatusCode}
+		}
+
+		if attempt >= t.Policy.attempts() {
+			return res, nil
+		}
+
+		res.Body.Close()
+
+		delay := t.Policy.delay(attempt)
+		logrus.Warnf("%s %s responded with %s (attempt %d of %d); retrying in %s", req.Method, req.URL, res.Status, attempt, t.Policy.attempts(), delay)
+		countRetry()
+		time.Sleep(delay)
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
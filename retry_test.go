@@ -0,0 +1,139 @@
+package resource_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	policy := &resource.RetryPolicy{
+		Attempts:  3,
+		BaseDelay: "1ms",
+		MaxDelay:  "2ms",
+	}
+
+	respond := func(statusCodes ...int) (http.RoundTripper, *int) {
+		var requests int
+
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			code := statusCodes[requests]
+			requests++
+
+			return &http.Response{
+				StatusCode: code,
+				Status:     http.StatusText(code),
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+				Request:    req,
+			}, nil
+		}), &requests
+	}
+
+	Describe("StatusRetryTransport", func() {
+		It("should retry the policy's status codes", func() {
+			inner, requests := respond(http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusCreated)
+
+			req, err := http.NewRequest(http.MethodPut, "https://registry.example.com/v2/some/repo/manifests/latest", strings.NewReader("{}"))
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := (&resource.StatusRetryTransport{Inner: inner, Policy: policy}).RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusCreated))
+			Expect(*requests).To(Equal(3))
+		})
+
+		It("should give up after the policy's attempts", func() {
+			inner, requests := respond(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+			req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := (&resource.StatusRetryTransport{Inner: inner, Policy: policy}).RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(*requests).To(Equal(3))
+		})
+
+		It("should not retry other status codes", func() {
+			inner, requests := respond(http.StatusNotFound)
+
+			req, err := http.NewRequest(http.MethodGet, "https://registry.example.com/v2/", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := (&resource.StatusRetryTransport{Inner: inner, Policy: policy}).RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(*requests).To(Equal(1))
+		})
+
+		It("should fail requests which cannot be sent again with a RetryableStatusError", func() {
+			inner, _ := respond(http.StatusBadGateway)
+
+			req, err := http.NewRequest(http.MethodPatch, "https://registry.example.com/v2/some/repo/blobs/uploads/some-upload", ioutil.NopCloser(strings.NewReader("blob")))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = (&resource.StatusRetryTransport{Inner: inner, Policy: policy}).RoundTrip(req)
+			Expect(err).To(Equal(resource.RetryableStatusError{StatusCode: http.StatusBadGateway}))
+		})
+	})
+
+	Describe("Do", func() {
+		It("should retry transient failures", func() {
+			var calls int
+			err := policy.Do(func() error {
+				calls++
+				if calls < 3 {
+					return resource.RetryableStatusError{StatusCode: http.StatusServiceUnavailable}
+				}
+
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(calls).To(Equal(3))
+		})
+
+		It("should retry connection failures", func() {
+			var calls int
+			err := policy.Do(func() error {
+				calls++
+				return syscall.ECONNRESET
+			})
+			Expect(err).To(Equal(syscall.ECONNRESET))
+			Expect(calls).To(Equal(3))
+		})
+
+		It("should not retry other failures", func() {
+			var calls int
+			err := policy.Do(func() error {
+				calls++
+				return errors.New("manifest invalid")
+			})
+			Expect(err).To(MatchError("manifest invalid"))
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should call once without a policy", func() {
+			var calls int
+			err := (*resource.RetryPolicy)(nil).Do(func() error {
+				calls++
+				return syscall.ECONNRESET
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("should reject invalid delays", func() {
+			Expect((&resource.RetryPolicy{BaseDelay: "soon"}).Validate()).To(MatchError(ContainSubstring("invalid base_delay")))
+			Expect((&resource.RetryPolicy{MaxDelay: "later"}).Validate()).To(MatchError(ContainSubstring("invalid max_delay")))
+		})
+	})
+})
@@ -0,0 +1,524 @@
+package resource
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// SBOM formats.
+const (
+	SBOMCycloneDX = "cyclonedx"
+	SBOMSPDX      = "spdx"
+)
+
+// sbomMediaTypes are the media types of each SBOM format, i.e. the artifact
+// types of SBOMs attached to images.
+var sbomMediaTypes = map[string]string{
+	SBOMCycloneDX: "application/vnd.cyclonedx+json",
+	SBOMSPDX:      "application/spdx+json",
+}
+
+const (
+	dpkgStatus    = "var/lib/dpkg/status"
+	dpkgStatusDir = "var/lib/dpkg/status.d/"
+	apkInstalled  = "lib/apk/db/installed"
+)
+
+// osReleaseFiles identify the distribution packages are from, in order of
+// preference.
+var osReleaseFiles = []string{"etc/os-release", "usr/lib/os-release"}
+
+// Package is an OS package installed in an image.
+type Package struct {
+	// Type is the package type, i.e. deb or apk.
+	Type         string
+	Name         string
+	Version      string
+	Architecture string
+
+	// Origin is the source package the package was built from, which
+	// advisories usually refer to, if it differs from the package's name.
+	Origin string
+
+	// Distro is the ID of the distribution the package is from, e.g. debian
+	// or alpine, and DistroVersion its release, e.g. 11 or 3.16.2.
+	Distro        string
+	DistroVersion string
+}
+
+// PURL returns the package URL identifying the package.
+func (pkg Package) PURL() string {
+	purl := fmt.Sprintf("pkg:%s/%s/%s@%s", pkg.Type, url.PathEscape(pkg.Distro), url.PathEscape(pkg.Name), url.PathEscape(pkg.Version))
+	if pkg.Architecture != "" {
+		purl += "?arch=" + url.QueryEscape(pkg.Architecture)
+	}
+
+	return purl
+}
+
+// isPackageFile determines whether the path, relative to the root of the
+// filesystem, is one that packages are read from.
+func isPackageFile(name string) bool {
+	if name == dpkgStatus || name == apkInstalled || strings.HasPrefix(name, dpkgStatusDir) {
+		return true
+	}
+
+	for _, file := range osReleaseFiles {
+		if name == file {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LayerPackages returns the packages installed in the filesystem that the
+// layers make up, reading the package databases from the layers themselves.
+func LayerPackages(layers []v1.Layer) ([]Package, error) {
+	files := map[string][]byte{}
+
+	for _, layer := range layers {
+		err := readPackageFiles(layer, files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parsePackages(files), nil
+}
+
+func readPackageFiles(layer v1.Layer, files map[string][]byte) error {
+	blob, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+
+	defer blob.Close()
+
+	r, err := DecompressLayer(blob)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		dir, base := path.Split(name)
+
+		if base == ".wh..wh..opq" {
+			// the layer replaces everything in the directory
+			removeFiles(files, dir)
+			continue
+		}
+
+		if strings.HasPrefix(base, ".wh.") {
+			removed := dir + strings.TrimPrefix(base, ".wh.")
+			delete(files, removed)
+			removeFiles(files, removed+"/")
+			continue
+		}
+
+		if !isPackageFile(name) {
+			continue
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			// e.g. os-release is usually a symlink to the other one
+			delete(files, name)
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		files[name] = content
+	}
+}
+
+func removeFiles(files map[string][]byte, prefix string) {
+	for name := range files {
+		if strings.HasPrefix(name, prefix) {
+			delete(files, name)
+		}
+	}
+}
+
+// DirPackages returns the packages installed in the filesystem at root, e.g. an
+// extracted rootfs. Symlinks aren't followed, as they could point outside of
+// root.
+func DirPackages(root string) ([]Package, error) {
+	files := map[string][]byte{}
+
+	names := append([]string{dpkgStatus, apkInstalled}, osReleaseFiles...)
+
+	statusDir, err := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(dpkgStatusDir)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, info := range statusDir {
+		names = append(names, dpkgStatusDir+info.Name())
+	}
+
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = content
+	}
+
+	return parsePackages(files), nil
+}
+
+func parsePackages(files map[string][]byte) []Package {
+	var distro, distroVersion string
+	for _, name := range osReleaseFiles {
+		if content, found := files[name]; found {
+			distro = osReleaseField(content, "ID")
+			distroVersion = osReleaseField(content, "VERSION_ID")
+			break
+		}
+	}
+
+	var packages []Package
+
+	for name, content := range files {
+		switch {
+		case name == dpkgStatus || strings.HasPrefix(name, dpkgStatusDir):
+			if distro == "" {
+				distro = "debian"
+			}
+
+			packages = append(packages, parseDpkgStatus(content, distro)...)
+
+		case name == apkInstalled:
+			if distro == "" {
+				distro = "alpine"
+			}
+
+			packages = append(packages, parseApkInstalled(content, distro)...)
+		}
+	}
+
+	for i := range packages {
+		packages[i].DistroVersion = distroVersion
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].PURL() < packages[j].PURL()
+	})
+
+	return packages
+}
+
+func osReleaseField(content []byte, field string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, field+"=") {
+			return strings.Trim(strings.TrimPrefix(line, field+"="), `"'`)
+		}
+	}
+
+	return ""
+}
+
+// parseDpkgStatus parses the installed packages from a dpkg status file, in
+// which each package is a paragraph of fields.
+func parseDpkgStatus(content []byte, distro string) []Package {
+	var packages []Package
+
+	for _, paragraph := range strings.Split(string(content), "\n\n") {
+		fields := map[string]string{}
+		for _, line := range strings.Split(paragraph, "\n") {
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				// continuation of a multi-line field
+				continue
+			}
+
+			i := strings.Index(line, ":")
+			if i == -1 {
+				continue
+			}
+
+			fields[line[:i]] = strings.TrimSpace(line[i+1:])
+		}
+
+		if fields["Package"] == "" {
+			continue
+		}
+
+		if status, found := fields["Status"]; found && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+
+		// e.g. "Source: glibc (2.31-13)"
+		origin := strings.Fields(fields["Source"])
+
+		pkg := Package{
+			Type:         "deb",
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Architecture: fields["Architecture"],
+			Distro:       distro,
+		}
+
+		if len(origin) > 0 && origin[0] != pkg.Name {
+			pkg.Origin = origin[0]
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return packages
+}
+
+// parseApkInstalled parses the installed packages from apk's database, in
+// which each package is a paragraph of single-letter fields.
+func parseApkInstalled(content []byte, distro string) []Package {
+	var packages []Package
+
+	var pkg Package
+	add := func() {
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+
+		pkg = Package{Type: "apk", Distro: distro}
+	}
+
+	add()
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			add()
+			continue
+		}
+
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+
+		switch line[0] {
+		case 'P':
+			pkg.Name = line[2:]
+		case 'V':
+			pkg.Version = line[2:]
+		case 'A':
+			pkg.Architecture = line[2:]
+		case 'o':
+			if line[2:] != pkg.Name {
+				pkg.Origin = line[2:]
+			}
+		}
+	}
+
+	add()
+
+	return packages
+}
+
+// SBOMSubject identifies the image an SBOM describes.
+type SBOMSubject struct {
+	Repository string
+	Digest     v1.Hash
+}
+
+// WriteSBOM writes an SBOM listing the image's packages in the given format,
+// i.e. CycloneDX or SPDX JSON.
+func WriteSBOM(w io.Writer, format string, subject SBOMSubject, packages []Package, created time.Time) error {
+	var sbom interface{}
+	switch format {
+	case SBOMCycloneDX:
+		sbom = cycloneDX(subject, packages, created)
+	case SBOMSPDX:
+		sbom = spdx(subject, packages, created)
+	default:
+		return fmt.Errorf("unknown SBOM format %q (supported: %s, %s)", format, SBOMCycloneDX, SBOMSPDX)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sbom)
+}
+
+// SBOMFormat determines the format of an SBOM from its content.
+func SBOMFormat(content []byte) (string, error) {
+	var sbom struct {
+		BOMFormat   string `json:"bomFormat"`
+		SPDXVersion string `json:"spdxVersion"`
+	}
+
+	err := json.Unmarshal(content, &sbom)
+	if err != nil {
+		return "", fmt.Errorf("invalid SBOM: %s", err)
+	}
+
+	switch {
+	case sbom.BOMFormat == "CycloneDX":
+		return SBOMCycloneDX, nil
+	case sbom.SPDXVersion != "":
+		return SBOMSPDX, nil
+	default:
+		return "", fmt.Errorf("unknown SBOM format (supported: CycloneDX or SPDX JSON)")
+	}
+}
+
+// SBOMMediaType returns the media type of the SBOM format.
+func SBOMMediaType(format string) string {
+	return sbomMediaTypes[format]
+}
+
+func cycloneDX(subject SBOMSubject, packages []Package, created time.Time) interface{} {
+	type component struct {
+		Type    string `json:"type"`
+		BOMRef  string `json:"bom-ref,omitempty"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		PURL    string `json:"purl,omitempty"`
+	}
+
+	components := []component{}
+	for _, pkg := range packages {
+		components = append(components, component{
+			Type:    "library",
+			BOMRef:  pkg.PURL(),
+			Name:    pkg.Name,
+			Version: pkg.Version,
+			PURL:    pkg.PURL(),
+		})
+	}
+
+	return map[string]interface{}{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.4",
+		"version":     1,
+		"metadata": map[string]interface{}{
+			"timestamp": created.UTC().Format(time.RFC3339),
+			"component": component{
+				Type:    "container",
+				Name:    subject.Repository,
+				Version: subject.Digest.String(),
+			},
+		},
+		"components": components,
+	}
+}
+
+func spdx(subject SBOMSubject, packages []Package, created time.Time) interface{} {
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+
+	type spdxPackage struct {
+		SPDXID           string        `json:"SPDXID"`
+		Name             string        `json:"name"`
+		VersionInfo      string        `json:"versionInfo"`
+		DownloadLocation string        `json:"downloadLocation"`
+		ExternalRefs     []externalRef `json:"externalRefs,omitempty"`
+	}
+
+	type relationship struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}
+
+	spdxPackages := []spdxPackage{{
+		SPDXID:           "SPDXRef-Image",
+		Name:             subject.Repository,
+		VersionInfo:      subject.Digest.String(),
+		DownloadLocation: "NOASSERTION",
+	}}
+
+	relationships := []relationship{{
+		Element: "SPDXRef-DOCUMENT",
+		Type:    "DESCRIBES",
+		Related: "SPDXRef-Image",
+	}}
+
+	for i, pkg := range packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+
+		spdxPackages = append(spdxPackages, spdxPackage{
+			SPDXID:           id,
+			Name:             pkg.Name,
+			VersionInfo:      pkg.Version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs: []externalRef{{
+				Category: "PACKAGE-MANAGER",
+				Type:     "purl",
+				Locator:  pkg.PURL(),
+			}},
+		})
+
+		relationships = append(relationships, relationship{
+			Element: "SPDXRef-Image",
+			Type:    "CONTAINS",
+			Related: id,
+		})
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              subject.Repository + "@" + subject.Digest.String(),
+		"documentNamespace": "https://concourse-ci.org/spdx/" + subject.Repository + "/" + subject.Digest.String(),
+		"creationInfo": map[string]interface{}{
+			"created":  created.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: registry-image-resource"},
+		},
+		"packages":      spdxPackages,
+		"relationships": relationships,
+	}
+}
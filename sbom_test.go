@@ -0,0 +1,166 @@
+package resource_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+const dpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.31-13
+Description: GNU C Library
+ multi-line description
+
+Package: removed-pkg
+Status: deinstall ok config-files
+Version: 1.0
+
+Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.1-2
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.2.2-r3
+A:x86_64
+
+P:busybox
+V:1.33.1-r3
+A:x86_64
+`
+
+var _ = Describe("SBOM", func() {
+	Describe("DirPackages", func() {
+		var root string
+
+		BeforeEach(func() {
+			var err error
+			root, err = ioutil.TempDir("", "sbom-rootfs")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.MkdirAll(filepath.Join(root, "var", "lib", "dpkg"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(root, "etc"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(root, "var", "lib", "dpkg", "status"), []byte(dpkgStatus), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(root, "etc", "os-release"), []byte("NAME=\"Debian GNU/Linux\"\nID=debian\n"), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(root)).To(Succeed())
+		})
+
+		It("should list the installed dpkg packages", func() {
+			packages, err := resource.DirPackages(root)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(packages).To(Equal([]resource.Package{
+				{Type: "deb", Name: "bash", Version: "5.1-2", Architecture: "amd64", Distro: "debian"},
+				{Type: "deb", Name: "libc6", Version: "2.31-13", Architecture: "amd64", Distro: "debian"},
+			}))
+
+			Expect(packages[0].PURL()).To(Equal("pkg:deb/debian/bash@5.1-2?arch=amd64"))
+		})
+	})
+
+	Describe("LayerPackages", func() {
+		layer := func(files map[string]string) v1.Layer {
+			buf := new(bytes.Buffer)
+			tw := tar.NewWriter(buf)
+			for name, content := range files {
+				Expect(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})).To(Succeed())
+				_, err := tw.Write([]byte(content))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(tw.Close()).To(Succeed())
+
+			l, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return l
+		}
+
+		It("should read the package databases from the layers", func() {
+			packages, err := resource.LayerPackages([]v1.Layer{
+				layer(map[string]string{
+					"etc/os-release":      "ID=alpine\n",
+					"var/lib/dpkg/status": dpkgStatus,
+				}),
+				layer(map[string]string{
+					"./lib/apk/db/installed": apkInstalled,
+					"var/lib/.wh.dpkg":       "",
+				}),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(packages).To(Equal([]resource.Package{
+				{Type: "apk", Name: "busybox", Version: "1.33.1-r3", Architecture: "x86_64", Distro: "alpine"},
+				{Type: "apk", Name: "musl", Version: "1.2.2-r3", Architecture: "x86_64", Distro: "alpine"},
+			}))
+		})
+	})
+
+	Describe("WriteSBOM", func() {
+		subject := resource.SBOMSubject{
+			Repository: "some/repo",
+			Digest:     v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)},
+		}
+
+		packages := []resource.Package{
+			{Type: "apk", Name: "musl", Version: "1.2.2-r3", Architecture: "x86_64", Distro: "alpine"},
+		}
+
+		write := func(format string) map[string]interface{} {
+			buf := new(bytes.Buffer)
+			Expect(resource.WriteSBOM(buf, format, subject, packages, time.Now())).To(Succeed())
+
+			var sbom map[string]interface{}
+			Expect(json.Unmarshal(buf.Bytes(), &sbom)).To(Succeed())
+			return sbom
+		}
+
+		It("should write CycloneDX", func() {
+			sbom := write("cyclonedx")
+			Expect(sbom["bomFormat"]).To(Equal("CycloneDX"))
+			Expect(sbom["components"]).To(ConsistOf(HaveKeyWithValue("purl", "pkg:apk/alpine/musl@1.2.2-r3?arch=x86_64")))
+		})
+
+		It("should write SPDX", func() {
+			sbom := write("spdx")
+			Expect(sbom["spdxVersion"]).To(Equal("SPDX-2.3"))
+			Expect(sbom["packages"]).To(HaveLen(2))
+			Expect(sbom["relationships"]).To(ContainElement(HaveKeyWithValue("relationshipType", "CONTAINS")))
+		})
+
+		It("should reject unknown formats", func() {
+			err := resource.WriteSBOM(ioutil.Discard, "some-format", subject, packages, time.Now())
+			Expect(err).To(MatchError(ContainSubstring(`unknown SBOM format "some-format"`)))
+		})
+	})
+
+	Describe("SBOMFormat", func() {
+		It("should detect the format from the content", func() {
+			Expect(resource.SBOMFormat([]byte(`{"bomFormat":"CycloneDX","specVersion":"1.4"}`))).To(Equal(resource.SBOMCycloneDX))
+			Expect(resource.SBOMFormat([]byte(`{"spdxVersion":"SPDX-2.3"}`))).To(Equal(resource.SBOMSPDX))
+
+			_, err := resource.SBOMFormat([]byte(`{}`))
+			Expect(err).To(MatchError(ContainSubstring("unknown SBOM format")))
+		})
+	})
+})
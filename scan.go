@@ -0,0 +1,397 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Vulnerability severities, from least to most severe.
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// DefaultScanSeverity is the severity at or above which vulnerabilities fail
+// the get by default.
+const DefaultScanSeverity = "HIGH"
+
+// ScanConfig configures scanning the fetched image's OS packages for known
+// vulnerabilities.
+type ScanConfig struct {
+	// Database is the path to an offline vulnerability database: a directory
+	// of OSV advisories in JSON, e.g. an extracted osv.dev ecosystem export.
+	Database string `json:"database"`
+
+	// Severity is the severity at or above which vulnerabilities fail the
+	// get.
+	Severity string `json:"severity,omitempty"`
+
+	// Ignore lists the IDs (or aliases, e.g. CVE IDs) of vulnerabilities to
+	// ignore.
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+// Vulnerability is a vulnerability found in one of an image's packages.
+type Vulnerability struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Severity string   `json:"severity"`
+	Summary  string   `json:"summary,omitempty"`
+	Package  string   `json:"package"`
+	Version  string   `json:"version"`
+	Fixed    string   `json:"fixed,omitempty"`
+}
+
+// osvAdvisory is an advisory in the OSV format. Only the fields needed to
+// match packages are decoded.
+type osvAdvisory struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced   string `json:"introduced"`
+				Fixed        string `json:"fixed"`
+				LastAffected string `json:"last_affected"`
+			} `json:"events"`
+		} `json:"ranges"`
+		Versions          []string `json:"versions"`
+		EcosystemSpecific struct {
+			Severity string `json:"severity"`
+			Urgency  string `json:"urgency"`
+		} `json:"ecosystem_specific"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// Threshold returns the configured severity threshold, normalized.
+func (config *ScanConfig) Threshold() (string, error) {
+	if config.Severity == "" {
+		return DefaultScanSeverity, nil
+	}
+
+	severity := strings.ToUpper(config.Severity)
+	if severityRank(severity) == -1 {
+		return "", fmt.Errorf("unknown severity %q (supported: %s)", config.Severity, strings.Join(severities, ", "))
+	}
+
+	return severity, nil
+}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Scan returns the vulnerabilities in the packages that the database knows
+// of, excluding ignored ones.
+func (config *ScanConfig) Scan(packages []Package) ([]Vulnerability, error) {
+	if config.Database == "" {
+		return nil, fmt.Errorf("no vulnerability database configured")
+	}
+
+	ignored := map[string]bool{}
+	for _, id := range config.Ignore {
+		ignored[id] = true
+	}
+
+	var vulns []Vulnerability
+
+	err := filepath.Walk(config.Database, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var advisory osvAdvisory
+		err = json.Unmarshal(content, &advisory)
+		if err != nil {
+			return fmt.Errorf("invalid advisory %s: %s", path, err)
+		}
+
+		if ignored[advisory.ID] {
+			return nil
+		}
+
+		for _, alias := range advisory.Aliases {
+			if ignored[alias] {
+				return nil
+			}
+		}
+
+		vulns = append(vulns, advisory.match(packages)...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(vulns, func(i, j int) bool {
+		if vulns[i].Package != vulns[j].Package {
+			return vulns[i].Package < vulns[j].Package
+		}
+
+		return vulns[i].ID < vulns[j].ID
+	})
+
+	return vulns, nil
+}
+
+// Exceeding returns the vulnerabilities at or above the severity threshold.
+func Exceeding(vulns []Vulnerability, threshold string) []Vulnerability {
+	var exceeding []Vulnerability
+	for _, vuln := range vulns {
+		if severityRank(vuln.Severity) >= severityRank(threshold) {
+			exceeding = append(exceeding, vuln)
+		}
+	}
+
+	return exceeding
+}
+
+func (advisory osvAdvisory) match(packages []Package) []Vulnerability {
+	var vulns []Vulnerability
+
+	for _, affected := range advisory.Affected {
+		for _, pkg := range packages {
+			if !ecosystemMatches(affected.Package.Ecosystem, pkg) {
+				continue
+			}
+
+			if affected.Package.Name != pkg.Name && affected.Package.Name != pkg.Origin {
+				continue
+			}
+
+			vulnerable, fixed := false, ""
+			for _, version := range affected.Versions {
+				if version == pkg.Version {
+					vulnerable = true
+				}
+			}
+
+			for _, r := range affected.Ranges {
+				if r.Type != "ECOSYSTEM" {
+					continue
+				}
+
+				inRange := false
+				for _, event := range r.Events {
+					switch {
+					case event.Introduced != "":
+						if event.Introduced == "0" || CompareVersions(pkg.Version, event.Introduced) >= 0 {
+							inRange = true
+						}
+					case event.Fixed != "":
+						if inRange && CompareVersions(pkg.Version, event.Fixed) >= 0 {
+							inRange = false
+						} else if inRange {
+							fixed = event.Fixed
+						}
+					case event.LastAffected != "":
+						if inRange && CompareVersions(pkg.Version, event.LastAffected) > 0 {
+							inRange = false
+						}
+					}
+				}
+
+				if inRange {
+					vulnerable = true
+				}
+			}
+
+			if !vulnerable {
+				continue
+			}
+
+			severity := advisory.DatabaseSpecific.Severity
+			if severity == "" {
+				severity = affected.EcosystemSpecific.Severity
+			}
+
+			if severity == "" {
+				severity = affected.EcosystemSpecific.Urgency
+			}
+
+			vulns = append(vulns, Vulnerability{
+				ID:       advisory.ID,
+				Aliases:  advisory.Aliases,
+				Severity: normalizeSeverity(severity),
+				Summary:  advisory.Summary,
+				Package:  pkg.Name,
+				Version:  pkg.Version,
+				Fixed:    fixed,
+			})
+		}
+	}
+
+	return vulns
+}
+
+// normalizeSeverity maps the severities and urgencies used by advisory
+// databases onto severities.
+func normalizeSeverity(severity string) string {
+	switch s := strings.ToUpper(strings.TrimSpace(severity)); s {
+	case "LOW", "MEDIUM", "HIGH", "CRITICAL":
+		return s
+	case "MODERATE":
+		return "MEDIUM"
+	case "UNIMPORTANT", "NEGLIGIBLE", "NOT YET ASSIGNED":
+		return "LOW"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ecosystemMatches determines whether an OSV ecosystem, e.g. Debian:11 or
+// Alpine:v3.16, is the one the package is from.
+func ecosystemMatches(ecosystem string, pkg Package) bool {
+	name := ecosystem
+	release := ""
+	if i := strings.Index(ecosystem, ":"); i != -1 {
+		name, release = ecosystem[:i], ecosystem[i+1:]
+	}
+
+	if !strings.EqualFold(name, pkg.Distro) {
+		return false
+	}
+
+	if release == "" || pkg.DistroVersion == "" {
+		return true
+	}
+
+	release = strings.TrimPrefix(release, "v")
+
+	// Alpine advisories are per minor release, e.g. v3.16 for 3.16.2
+	return pkg.DistroVersion == release || strings.HasPrefix(pkg.DistroVersion, release+".")
+}
+
+// CompareVersions compares two Debian package versions, i.e.
+// [epoch:]upstream[-revision], returning -1, 0, or 1. Alpine package
+// versions, e.g. 1.2.3-r4, compare correctly this way too.
+func CompareVersions(a, b string) int {
+	aEpoch, aUpstream, aRevision := splitVersion(a)
+	bEpoch, bUpstream, bRevision := splitVersion(b)
+
+	if aEpoch != bEpoch {
+		if aEpoch < bEpoch {
+			return -1
+		}
+
+		return 1
+	}
+
+	if c := compareVersionPart(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+
+	return compareVersionPart(aRevision, bRevision)
+}
+
+func splitVersion(version string) (int, string, string) {
+	epoch := 0
+	if i := strings.Index(version, ":"); i != -1 {
+		fmt.Sscanf(version[:i], "%d", &epoch)
+		version = version[i+1:]
+	}
+
+	revision := ""
+	if i := strings.LastIndex(version, "-"); i != -1 {
+		version, revision = version[:i], version[i+1:]
+	}
+
+	return epoch, version, revision
+}
+
+// compareVersionPart compares upstream versions or revisions as dpkg does:
+// alternating runs of non-digits, compared lexically with letters before
+// other characters and ~ before anything, and digits, compared numerically.
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		for (a != "" && !unicode.IsDigit(rune(a[0]))) || (b != "" && !unicode.IsDigit(rune(b[0]))) {
+			ac, bc := versionOrder(a), versionOrder(b)
+			if ac != bc {
+				if ac < bc {
+					return -1
+				}
+
+				return 1
+			}
+
+			a, b = a[1:], b[1:]
+		}
+
+		var aNum, bNum string
+		aNum, a = digits(a)
+		bNum, b = digits(b)
+
+		aNum = strings.TrimLeft(aNum, "0")
+		bNum = strings.TrimLeft(bNum, "0")
+
+		if len(aNum) != len(bNum) {
+			if len(aNum) < len(bNum) {
+				return -1
+			}
+
+			return 1
+		}
+
+		if aNum != bNum {
+			if aNum < bNum {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// versionOrder returns the sort weight of the first character of s, which is
+// 0 at the end of s or at a digit.
+func versionOrder(s string) int {
+	switch {
+	case s == "" || unicode.IsDigit(rune(s[0])):
+		return 0
+	case s[0] == '~':
+		return -1
+	case unicode.IsLetter(rune(s[0])):
+		return int(s[0])
+	default:
+		return int(s[0]) + 256
+	}
+}
+
+func digits(s string) (string, string) {
+	i := 0
+	for i < len(s) && unicode.IsDigit(rune(s[i])) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
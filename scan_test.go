@@ -0,0 +1,124 @@
+package resource_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ScanConfig", func() {
+	var db string
+	var config resource.ScanConfig
+
+	packages := []resource.Package{
+		{Type: "deb", Name: "libc6", Origin: "glibc", Version: "2.31-13", Distro: "debian", DistroVersion: "11"},
+		{Type: "deb", Name: "bash", Version: "5.1-2", Distro: "debian", DistroVersion: "11"},
+	}
+
+	advisory := func(name string, content string) {
+		Expect(ioutil.WriteFile(filepath.Join(db, name), []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		db, err = ioutil.TempDir("", "osv")
+		Expect(err).ToNot(HaveOccurred())
+
+		config = resource.ScanConfig{Database: db}
+
+		advisory("DSA-1.json", `{
+			"id": "DSA-1",
+			"aliases": ["CVE-2021-0001"],
+			"summary": "glibc overflow",
+			"affected": [{
+				"package": {"ecosystem": "Debian:11", "name": "glibc"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "2.31-13+deb11u3"}]}],
+				"ecosystem_specific": {"urgency": "high"}
+			}]
+		}`)
+
+		advisory("DSA-2.json", `{
+			"id": "DSA-2",
+			"affected": [{
+				"package": {"ecosystem": "Debian:11", "name": "bash"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "5.0-1"}]}],
+				"ecosystem_specific": {"urgency": "high"}
+			}]
+		}`)
+
+		advisory("DSA-3.json", `{
+			"id": "DSA-3",
+			"affected": [{
+				"package": {"ecosystem": "Debian:10", "name": "bash"},
+				"versions": ["5.1-2"]
+			}]
+		}`)
+
+		advisory("DSA-4.json", `{
+			"id": "DSA-4",
+			"affected": [{
+				"package": {"ecosystem": "Debian:11", "name": "bash"},
+				"versions": ["5.1-2"],
+				"ecosystem_specific": {"urgency": "low"}
+			}]
+		}`)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(db)).To(Succeed())
+	})
+
+	It("should find vulnerable packages for the distro release", func() {
+		vulns, err := config.Scan(packages)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(vulns).To(Equal([]resource.Vulnerability{
+			{ID: "DSA-4", Severity: "LOW", Package: "bash", Version: "5.1-2"},
+			{ID: "DSA-1", Aliases: []string{"CVE-2021-0001"}, Severity: "HIGH", Summary: "glibc overflow", Package: "libc6", Version: "2.31-13", Fixed: "2.31-13+deb11u3"},
+		}))
+
+		Expect(resource.Exceeding(vulns, "HIGH")).To(HaveLen(1))
+		Expect(resource.Exceeding(vulns, "LOW")).To(HaveLen(2))
+	})
+
+	It("should skip ignored vulnerabilities by ID or alias", func() {
+		config.Ignore = []string{"CVE-2021-0001", "DSA-4"}
+
+		vulns, err := config.Scan(packages)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vulns).To(BeEmpty())
+	})
+
+	It("should default the threshold to HIGH", func() {
+		Expect(config.Threshold()).To(Equal("HIGH"))
+
+		config.Severity = "critical"
+		Expect(config.Threshold()).To(Equal("CRITICAL"))
+
+		config.Severity = "severe"
+		_, err := config.Threshold()
+		Expect(err).To(MatchError(ContainSubstring(`unknown severity "severe"`)))
+	})
+})
+
+var _ = Describe("CompareVersions", func() {
+	It("should order versions as dpkg does", func() {
+		for _, versions := range [][2]string{
+			{"1.9", "1.10"},
+			{"2.31-13", "2.31-13+deb11u3"},
+			{"2.0", "1:1.0"},
+			{"1.0~rc1", "1.0"},
+			{"1.2.2-r3", "1.2.2-r10"},
+		} {
+			Expect(resource.CompareVersions(versions[0], versions[1])).To(Equal(-1), "%s < %s", versions[0], versions[1])
+			Expect(resource.CompareVersions(versions[1], versions[0])).To(Equal(1), "%s > %s", versions[1], versions[0])
+		}
+
+		Expect(resource.CompareVersions("2.31-13", "2.31-13")).To(Equal(0))
+	})
+})
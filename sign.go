@@ -0,0 +1,431 @@
+package resource
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// CosignSimpleSigningMediaType is the media type of cosign signature layers.
+const CosignSimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+// CosignSigning configures signing pushed images with cosign. Exactly one of
+// PrivateKey, KMS, or Keyless must be set.
+type CosignSigning struct {
+	// PrivateKey is the PEM-encoded private key to sign with, either as
+	// generated by `cosign generate-key-pair` or unencrypted.
+	PrivateKey string `json:"private_key,omitempty"`
+
+	// Passphrase decrypts the private key generated by cosign.
+	Passphrase string `json:"passphrase,omitempty"`
+
+	// KMS is the URI of a key in a key management service to sign with, e.g.
+	// awskms:///arn:aws:kms:us-east-1:123456789012:key/some-key-id.
+	KMS string `json:"kms,omitempty"`
+
+	// Keyless signs with an ephemeral key, certified by Fulcio for the
+	// identity of an OIDC token.
+	Keyless *CosignKeylessSigning `json:"keyless,omitempty"`
+}
+
+// encryptedCosignKey is the PEM content of a private key generated by cosign.
+type encryptedCosignKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Validate checks that exactly one way of signing is configured.
+func (signing *CosignSigning) Validate() error {
+	configured := 0
+	for _, isConfigured := range []bool{signing.PrivateKey != "", signing.KMS != "", signing.Keyless != nil} {
+		if isConfigured {
+			configured++
+		}
+	}
+
+	if configured != 1 {
+		return errors.New("exactly one of private_key, kms, or keyless must be configured")
+	}
+
+	return nil
+}
+
+// Signer returns the configured key to sign with. A KMS key is accessed with
+// the source's AWS credentials, if it has any.
+func (signing *CosignSigning) Signer(source *Source) (crypto.Signer, error) {
+	err := signing.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if signing.Keyless != nil {
+		return nil, errors.New("keyless signing has no persistent key")
+	}
+
+	if signing.KMS != "" {
+		return newKMSSigner(signing.KMS, source)
+	}
+
+	return parsePrivateKey(signing.PrivateKey, signing.Passphrase)
+}
+
+func parsePrivateKey(keyPEM string, passphrase string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("invalid private_key: no PEM block found")
+	}
+
+	der := block.Bytes
+
+	switch block.Type {
+	case "ENCRYPTED COSIGN PRIVATE KEY", "ENCRYPTED SIGSTORE PRIVATE KEY":
+		var err error
+		der, err = decryptCosignKey(block.Bytes, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private_key: %s", err)
+		}
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private_key: %s", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// decryptCosignKey decrypts the PKCS #8 key in the content of a PEM block
+// written by cosign: a scrypt-derived key sealed with NaCl's secretbox.
+func decryptCosignKey(content []byte, passphrase string) ([]byte, error) {
+	var encrypted encryptedCosignKey
+	err := json.Unmarshal(content, &encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypted.KDF.Name != "scrypt" || encrypted.Cipher.Name != "nacl/secretbox" {
+		return nil, fmt.Errorf("unsupported encryption %s with %s", encrypted.Cipher.Name, encrypted.KDF.Name)
+	}
+
+	params := encrypted.KDF.Params
+	derived, err := scrypt.Key([]byte(passphrase), encrypted.KDF.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var key [32]byte
+	copy(key[:], derived)
+
+	var nonce [24]byte
+	copy(nonce[:], encrypted.Cipher.Nonce)
+
+	der, ok := secretbox.Open(nil, encrypted.Ciphertext, &nonce, &key)
+	if !ok {
+		return nil, errors.New("wrong passphrase")
+	}
+
+	return der, nil
+}
+
+// kmsSigner signs with an asymmetric AWS KMS key, which never leaves KMS.
+type kmsSigner struct {
+	client    *kms.KMS
+	keyID     string
+	algorithm string
+	public    crypto.PublicKey
+}
+
+// newKMSSigner returns a signer for a key given as
+// awskms://[endpoint]/key-id-or-alias.
+func newKMSSigner(uri string, source *Source) (crypto.Signer, error) {
+	if !strings.HasPrefix(uri, "awskms://") {
+		return nil, fmt.Errorf("unsupported KMS URI %q (supported: awskms://)", uri)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(uri, "awskms://"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid KMS URI %q: expected awskms://[endpoint]/key-id", uri)
+	}
+
+	endpoint, keyID := parts[0], parts[1]
+
+	config := &aws.Config{
+		Region: aws.String(source.AwsRegion),
+	}
+
+	if keyARN, err := arn.Parse(keyID); err == nil {
+		config.Region = aws.String(keyARN.Region)
+	}
+
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if source.AwsAccessKeyId != "" && source.AwsSecretAccessKey != "" {
+		config.Credentials = credentials.NewStaticCredentials(
+			source.AwsAccessKeyId,
+			source.AwsSecretAccessKey,
+			"",
+		)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %s", err)
+	}
+
+	signer := &kmsSigner{
+		client: kms.New(sess),
+		keyID:  keyID,
+	}
+
+	out, err := signer.client.GetPublicKey(&kms.GetPublicKeyInput{
+		KeyId: aws.String(keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key of %s: %s", keyID, err)
+	}
+
+	signer.public, err = x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key of %s: %s", keyID, err)
+	}
+
+	switch signer.public.(type) {
+	case *ecdsa.PublicKey:
+		signer.algorithm = kms.SigningAlgorithmSpecEcdsaSha256
+	case *rsa.PublicKey:
+		signer.algorithm = kms.SigningAlgorithmSpecRsassaPkcs1V15Sha256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", signer.public)
+	}
+
+	return signer, nil
+}
+
+// Public implements crypto.Signer.
+func (signer *kmsSigner) Public() crypto.PublicKey {
+	return signer.public
+}
+
+// Sign implements crypto.Signer, signing a SHA-256 digest.
+func (signer *kmsSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	out, err := signer.client.Sign(&kms.SignInput{
+		KeyId:            aws.String(signer.keyID),
+		Message:          digest,
+		MessageType:      aws.String(kms.MessageTypeDigest),
+		SigningAlgorithm: aws.String(signer.algorithm),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Signature, nil
+}
+
+// CosignSignature signs the image with the digest in the repository, as
+// cosign does, returning the signature to store.
+func CosignSignature(signer crypto.Signer, repo name.Repository, digest v1.Hash) (SignatureLayer, error) {
+	payload, err := cosignPayload(repo, digest)
+	if err != nil {
+		return SignatureLayer{}, err
+	}
+
+	signature, err := sign(signer, payload)
+	if err != nil {
+		return SignatureLayer{}, fmt.Errorf("failed to sign: %s", err)
+	}
+
+	return SignatureLayer{
+		Payload: payload,
+		Annotations: map[string]string{
+			CosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+		},
+	}, nil
+}
+
+// cosignPayload returns the payload cosign signs for the image with the
+// digest in the repository.
+func cosignPayload(repo name.Repository, digest v1.Hash) ([]byte, error) {
+	var simpleSigning struct {
+		Critical struct {
+			Identity struct {
+				DockerReference string `json:"docker-reference"`
+			} `json:"identity"`
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+		Optional map[string]string `json:"optional"`
+	}
+
+	simpleSigning.Critical.Identity.DockerReference = repo.Name()
+	simpleSigning.Critical.Image.DockerManifestDigest = digest.String()
+	simpleSigning.Critical.Type = CosignPayloadType
+
+	return json.Marshal(simpleSigning)
+}
+
+func sign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		// ed25519 signs the message itself
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+
+	hash := sha256.Sum256(payload)
+
+	return signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+// SignatureLayer is a signature to store in a cosign signature image, or an
+// attestation to store in an attestation image.
+type SignatureLayer struct {
+	// MediaType defaults to CosignSimpleSigningMediaType.
+	MediaType   types.MediaType
+	Payload     []byte
+	Annotations map[string]string
+}
+
+// SignatureImage returns the image cosign stores signatures (or attestations)
+// in, with the existing image's signatures, if any, followed by the new ones.
+func SignatureImage(existing v1.Image, signatures ...SignatureLayer) (v1.Image, error) {
+	var layers []SignatureLayer
+
+	if existing != nil {
+		manifest, err := existing.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get existing signature manifest: %s", err)
+		}
+
+		for _, desc := range manifest.Layers {
+			payload, err := readBlob(existing, desc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read existing signature: %s", err)
+			}
+
+			layers = append(layers, SignatureLayer{
+				MediaType:   desc.MediaType,
+				Payload:     payload,
+				Annotations: desc.Annotations,
+			})
+		}
+	}
+
+	image := artifactImage{
+		blobs: map[v1.Hash][]byte{},
+	}
+
+	config := v1.ConfigFile{
+		RootFS: v1.RootFS{Type: "layers"},
+	}
+
+	manifest := v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+	}
+
+	for _, layer := range append(layers, signatures...) {
+		digest, size, err := v1.SHA256(bytes.NewReader(layer.Payload))
+		if err != nil {
+			return nil, err
+		}
+
+		image.blobs[digest] = layer.Payload
+
+		mediaType := layer.MediaType
+		if mediaType == "" {
+			mediaType = CosignSimpleSigningMediaType
+		}
+
+		// signature layers are uncompressed
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, digest)
+
+		manifest.Layers = append(manifest.Layers, v1.Descriptor{
+			MediaType:   mediaType,
+			Size:        size,
+			Digest:      digest,
+			Annotations: layer.Annotations,
+		})
+	}
+
+	var err error
+	image.rawConfig, err = json.Marshal(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(image.rawConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Config = v1.Descriptor{
+		MediaType: types.OCIConfigJSON,
+		Size:      configSize,
+		Digest:    configDigest,
+	}
+
+	image.rawManifest, err = json.Marshal(&manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(image)
+}
+
+// PublicKeyPEM returns the PEM encoding of the signer's public key, e.g. for
+// verifying its signatures.
+func PublicKeyPEM(signer crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
@@ -0,0 +1,139 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("CosignSigning", func() {
+	digest := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+
+	var repo name.Repository
+	var key *ecdsa.PrivateKey
+
+	BeforeEach(func() {
+		var err error
+		repo, err = name.NewRepository("some/repo", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// encrypt encrypts the key as `cosign generate-key-pair` does
+	encrypt := func(passphrase string) string {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		Expect(err).ToNot(HaveOccurred())
+
+		salt := make([]byte, 32)
+		_, err = rand.Read(salt)
+		Expect(err).ToNot(HaveOccurred())
+
+		var nonce [24]byte
+		_, err = rand.Read(nonce[:])
+		Expect(err).ToNot(HaveOccurred())
+
+		derived, err := scrypt.Key([]byte(passphrase), salt, 1024, 8, 1, 32)
+		Expect(err).ToNot(HaveOccurred())
+
+		var secret [32]byte
+		copy(secret[:], derived)
+
+		content, err := json.Marshal(map[string]interface{}{
+			"kdf": map[string]interface{}{
+				"name":   "scrypt",
+				"params": map[string]int{"N": 1024, "r": 8, "p": 1},
+				"salt":   salt,
+			},
+			"cipher": map[string]interface{}{
+				"name":  "nacl/secretbox",
+				"nonce": nonce[:],
+			},
+			"ciphertext": secretbox.Seal(nil, der, &nonce, &secret),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		return string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED COSIGN PRIVATE KEY", Bytes: content}))
+	}
+
+	sign := func(signing resource.CosignSigning, existing v1.Image) (v1.Image, resource.CosignConfig) {
+		signer, err := signing.Signer(&resource.Source{})
+		Expect(err).ToNot(HaveOccurred())
+
+		signature, err := resource.CosignSignature(signer, repo, digest)
+		Expect(err).ToNot(HaveOccurred())
+
+		signatures, err := resource.SignatureImage(existing, signature)
+		Expect(err).ToNot(HaveOccurred())
+
+		publicKey, err := resource.PublicKeyPEM(signer)
+		Expect(err).ToNot(HaveOccurred())
+
+		return signatures, resource.CosignConfig{PublicKey: publicKey}
+	}
+
+	It("should sign with a key generated by cosign", func() {
+		signatures, verifier := sign(resource.CosignSigning{PrivateKey: encrypt("some-passphrase"), Passphrase: "some-passphrase"}, nil)
+		Expect(verifier.Verify(signatures, digest)).To(Succeed())
+
+		Expect(verifier.Verify(signatures, v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)})).ToNot(Succeed())
+	})
+
+	It("should reject the wrong passphrase", func() {
+		_, err := (&resource.CosignSigning{PrivateKey: encrypt("some-passphrase"), Passphrase: "wrong"}).Signer(&resource.Source{})
+		Expect(err).To(MatchError(ContainSubstring("wrong passphrase")))
+	})
+
+	It("should sign with an unencrypted ed25519 key", func() {
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		der, err := x509.MarshalPKCS8PrivateKey(edKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		signatures, verifier := sign(resource.CosignSigning{PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))}, nil)
+		Expect(verifier.Verify(signatures, digest)).To(Succeed())
+	})
+
+	It("should keep the existing signatures", func() {
+		existing, first := sign(resource.CosignSigning{PrivateKey: encrypt("")}, nil)
+
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		signatures, second := sign(resource.CosignSigning{PrivateKey: encrypt("")}, existing)
+
+		manifest, err := signatures.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Layers).To(HaveLen(2))
+
+		Expect(first.Verify(signatures, digest)).To(Succeed())
+		Expect(second.Verify(signatures, digest)).To(Succeed())
+	})
+
+	It("should require exactly one key", func() {
+		_, err := (&resource.CosignSigning{}).Signer(&resource.Source{})
+		Expect(err).To(MatchError("exactly one of private_key, kms, or keyless must be configured"))
+	})
+
+	It("should reject unsupported KMS providers", func() {
+		_, err := (&resource.CosignSigning{KMS: "gcpkms://projects/some-project/locations/global/keyRings/ring/cryptoKeys/key"}).Signer(&resource.Source{})
+		Expect(err).To(MatchError(ContainSubstring("supported: awskms://")))
+	})
+})
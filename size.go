@@ -0,0 +1,77 @@
+package resource
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ImageSize returns the size of the image's compressed layers and config,
+// i.e. how much there is to transfer to pull or push it.
+func ImageSize(img v1.Image) (int64, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+
+	return size, nil
+}
+
+// TotalImageSize returns the size of the image, or of all of the images it
+// refers to, fetched with fetch, if it is an image index.
+func TotalImageSize(img v1.Image, fetch func(v1.Hash) (v1.Image, error)) (int64, error) {
+	isIndex, err := IsIndex(img)
+	if err != nil {
+		return 0, err
+	}
+
+	if !isIndex {
+		return ImageSize(img)
+	}
+
+	manifests, err := IndexManifests(img)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, desc := range manifests {
+		child, err := fetch(desc.Digest)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch %s: %s", desc.Digest, err)
+		}
+
+		size, err := TotalImageSize(child, fetch)
+		if err != nil {
+			return 0, err
+		}
+
+		total += size
+	}
+
+	return total, nil
+}
+
+// CheckImageSize checks that an image of the size, in bytes, is within the
+// source's max_image_size, if any.
+func (source *Source) CheckImageSize(size int64) error {
+	if source.MaxImageSize == "" {
+		return nil
+	}
+
+	max, err := ParseSize(source.MaxImageSize)
+	if err != nil {
+		return fmt.Errorf("invalid max_image_size: %s", err)
+	}
+
+	if size > max {
+		return fmt.Errorf("image of %d bytes exceeds max_image_size of %d bytes", size, max)
+	}
+
+	return nil
+}
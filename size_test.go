@@ -0,0 +1,89 @@
+package resource_test
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Image size", func() {
+	var image v1.Image
+
+	BeforeEach(func() {
+		var err error
+		image, err = random.Image(1024, 2)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should sum the image's layers and config", func() {
+		manifest, err := image.Manifest()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resource.ImageSize(image)).To(Equal(manifest.Config.Size + manifest.Layers[0].Size + manifest.Layers[1].Size))
+	})
+
+	It("should sum the images of an index", func() {
+		amd64 := platformConfigImage{image, &v1.ConfigFile{OS: "linux", Architecture: "amd64"}}
+		arm64 := platformConfigImage{image, &v1.ConfigFile{OS: "linux", Architecture: "arm64"}}
+
+		index, err := resource.NewIndex(map[resource.Platform]v1.Image{
+			{OS: "linux", Architecture: "amd64"}: amd64,
+			{OS: "linux", Architecture: "arm64"}: arm64,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		images := map[v1.Hash]v1.Image{}
+		for _, img := range []v1.Image{amd64, arm64} {
+			digest, err := img.Digest()
+			Expect(err).ToNot(HaveOccurred())
+			images[digest] = img
+		}
+
+		size, err := resource.TotalImageSize(index, func(digest v1.Hash) (v1.Image, error) {
+			img, found := images[digest]
+			if !found {
+				return nil, fmt.Errorf("unknown image %s", digest)
+			}
+
+			return img, nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		amd64Size, err := resource.ImageSize(amd64)
+		Expect(err).ToNot(HaveOccurred())
+
+		arm64Size, err := resource.ImageSize(arm64)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(size).To(Equal(amd64Size + arm64Size))
+	})
+
+	It("should return the size of an image which isn't an index", func() {
+		size, err := resource.TotalImageSize(image, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resource.ImageSize(image)).To(Equal(size))
+	})
+
+	Describe("CheckImageSize", func() {
+		It("should allow any size without max_image_size", func() {
+			Expect((&resource.Source{}).CheckImageSize(1 << 40)).To(Succeed())
+		})
+
+		It("should reject images larger than max_image_size", func() {
+			source := resource.Source{MaxImageSize: "2GB"}
+
+			Expect(source.CheckImageSize(2 << 30)).To(Succeed())
+			Expect(source.CheckImageSize(2<<30 + 1)).To(MatchError("image of 2147483649 bytes exceeds max_image_size of 2147483648 bytes"))
+		})
+
+		It("should reject an invalid max_image_size", func() {
+			source := resource.Source{MaxImageSize: "big"}
+			Expect(source.CheckImageSize(1)).To(MatchError(ContainSubstring("invalid max_image_size")))
+		})
+	})
+})
@@ -0,0 +1,278 @@
+package resource
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// SortByCreationDate orders tags discovered by check by the creation date in
+// their image config.
+const SortByCreationDate = "creation_date"
+
+// TracksTags determines whether check should discover the repository's tags
+// rather than follow a single tag.
+func (source *Source) TracksTags() bool {
+	return source.SemverConstraint != "" ||
+		source.TagRegex != "" ||
+		source.Variant != "" ||
+		source.SortBy != ""
+}
+
+// ValidateSortBy fails if the source's sort_by is not a known order.
+func (source *Source) ValidateSortBy() error {
+	switch source.SortBy {
+	case "", SortByCreationDate:
+		return nil
+	default:
+		return fmt.Errorf("unknown sort_by: %s", source.SortBy)
+	}
+}
+
+// FilterTags returns the tags which should be considered by check, ordered
+// from oldest to newest. Tags which are not ignored are matched against the
+// source's tag_regex, if any, and then ordered by semver if a semver_constraint or variant is
+// configured, or by name otherwise.
+func (source *Source) FilterTags(tags []string) ([]string, error) {
+	tags, err := IgnoreTags(tags, source.IgnoreTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.TagRegex != "" {
+		tags, err = RegexTags(tags, source.TagRegex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if source.SemverConstraint != "" || source.Variant != "" {
+		constraint := source.SemverConstraint
+		if constraint == "" {
+			constraint = "*"
+		}
+
+		return SemverTags(tags, constraint, source.Variant, source.PreReleases)
+	}
+
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+
+	return sorted, nil
+}
+
+// IgnoreTags returns the tags which do not match any of the patterns. Patterns
+// may be exact tags or globs, e.g. buildcache-*.
+func IgnoreTags(tags []string, patterns []string) ([]string, error) {
+	var kept []string
+
+	for _, tag := range tags {
+		ignored := false
+		for _, pattern := range patterns {
+			match, err := path.Match(pattern, tag)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore_tags pattern %q: %s", pattern, err)
+			}
+
+			if match {
+				ignored = true
+				break
+			}
+		}
+
+		if !ignored {
+			kept = append(kept, tag)
+		}
+	}
+
+	return kept, nil
+}
+
+// RegexTags returns the tags which entirely match the regular expression.
+func RegexTags(tags []string, expr string) ([]string, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag regex %q: %s", expr, err)
+	}
+
+	var matching []string
+	for _, tag := range tags {
+		if re.MatchString(tag) {
+			matching = append(matching, tag)
+		}
+	}
+
+	return matching, nil
+}
+
+// SemverTags returns the tags which parse as semantic versions satisfying the
+// constraint, ordered from the lowest version to the highest. Tags which
+// denote the same version (e.g. 1.2 and 1.2.0) are ordered by name.
+//
+// If a variant is given, only tags suffixed with it (e.g. 1.2.3-alpine) are
+// considered, and the suffix is ignored when parsing the version.
+//
+// Pre-release versions (e.g. 2.0.0-rc.1) are only included if preReleases is
+// set, in which case they satisfy the constraint if their release would.
+func SemverTags(tags []string, constraint string, variant string, preReleases bool) ([]string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid semver constraint %q: %s", constraint, err)
+	}
+
+	type semverTag struct {
+		tag     string
+		version *semver.Version
+	}
+
+	var matching []semverTag
+	for _, tag := range tags {
+		version := tag
+		if variant != "" {
+			if !strings.HasSuffix(tag, "-"+variant) {
+				continue
+			}
+
+			version = strings.TrimSuffix(tag, "-"+variant)
+		}
+
+		v, err := semver.NewVersion(version)
+		if err != nil {
+			continue
+		}
+
+		release := v
+		if v.Prerelease() != "" {
+			if !preReleases {
+				continue
+			}
+
+			r, err := v.SetPrerelease("")
+			if err != nil {
+				continue
+			}
+
+			release = &r
+		}
+
+		if !c.Check(release) {
+			continue
+		}
+
+		matching = append(matching, semverTag{tag, v})
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		cmp := matching[i].version.Compare(matching[j].version)
+		if cmp == 0 {
+			return matching[i].tag < matching[j].tag
+		}
+
+		return cmp < 0
+	})
+
+	sorted := make([]string, len(matching))
+	for i, m := range matching {
+		sorted[i] = m.tag
+	}
+
+	return sorted, nil
+}
+
+// SemverAliases returns the alias tags to update when pushing the version
+// tag, given the repository's existing tags: its major.minor and major
+// versions (e.g. 1.4 and 1 for 1.4.2), and latest if latest is set. Each alias
+// is only returned if no existing tag has a higher version that the alias
+// would cover, so that e.g. pushing a patch for an older minor version
+// updates only its major.minor alias.
+//
+// If a variant is given, the tag must be suffixed with it, and so are the
+// aliases, with latest replaced by the variant itself (e.g. 1.4-alpine,
+// 1-alpine, and alpine for 1.4.2-alpine). Pre-release versions have no
+// aliases.
+func SemverAliases(tag string, tags []string, variant string, latest bool) ([]string, error) {
+	version := tag
+	if variant != "" {
+		if !strings.HasSuffix(tag, "-"+variant) {
+			return nil, fmt.Errorf("tag %q does not have the variant suffix %q", tag, "-"+variant)
+		}
+
+		version = strings.TrimSuffix(tag, "-"+variant)
+	}
+
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("tag %q is not a semantic version: %s", tag, err)
+	}
+
+	if v.Prerelease() != "" {
+		return nil, nil
+	}
+
+	prefix := ""
+	if strings.HasPrefix(version, "v") {
+		prefix = "v"
+	}
+
+	suffix := ""
+	if variant != "" {
+		suffix = "-" + variant
+	}
+
+	type candidate struct {
+		alias      string
+		constraint string
+	}
+
+	candidates := []candidate{
+		{
+			alias:      fmt.Sprintf("%s%d.%d%s", prefix, v.Major(), v.Minor(), suffix),
+			constraint: fmt.Sprintf(">= %d.%d.0, < %d.%d.0", v.Major(), v.Minor(), v.Major(), v.Minor()+1),
+		},
+		{
+			alias:      fmt.Sprintf("%s%d%s", prefix, v.Major(), suffix),
+			constraint: fmt.Sprintf(">= %d.0.0, < %d.0.0", v.Major(), v.Major()+1),
+		},
+	}
+
+	if latest {
+		alias := "latest"
+		if variant != "" {
+			alias = variant
+		}
+
+		candidates = append(candidates, candidate{alias, "*"})
+	}
+
+	var aliases []string
+	for _, candidate := range candidates {
+		covered, err := SemverTags(tags, candidate.constraint, variant, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(covered) > 0 {
+			highest := covered[len(covered)-1]
+			if variant != "" {
+				highest = strings.TrimSuffix(highest, "-"+variant)
+			}
+
+			hv, err := semver.NewVersion(highest)
+			if err != nil {
+				return nil, err
+			}
+
+			if hv.GreaterThan(v) {
+				continue
+			}
+		}
+
+		aliases = append(aliases, candidate.alias)
+	}
+
+	return aliases, nil
+}
@@ -0,0 +1,196 @@
+package resource_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("SemverTags", func() {
+	tags := []string{
+		"latest",
+		"2.0.0",
+		"1.10.0",
+		"1.2.0",
+		"1.2",
+		"1.9.3",
+		"v1.3.0",
+		"not-a-version",
+		"0.9.0",
+	}
+
+	It("should return matching tags in semver order", func() {
+		sorted, err := resource.SemverTags(tags, ">=1.2.0 <2.0.0", "", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"1.2", "1.2.0", "v1.3.0", "1.9.3", "1.10.0"}))
+	})
+
+	It("should support alternative constraints", func() {
+		sorted, err := resource.SemverTags(tags, "< 1.0.0 || >= 2", "", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"0.9.0", "2.0.0"}))
+	})
+
+	It("should fail with an invalid constraint", func() {
+		_, err := resource.SemverTags(tags, "not a constraint", "", false)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should exclude pre-releases by default", func() {
+		sorted, err := resource.SemverTags([]string{"2.0.0-rc.1", "1.9.0", "2.0.0"}, ">=1.0.0", "", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"1.9.0", "2.0.0"}))
+	})
+
+	It("should include pre-releases satisfying the constraint when enabled", func() {
+		sorted, err := resource.SemverTags([]string{"2.0.0", "2.0.0-rc.2", "2.0.0-rc.1", "3.0.0-rc.1", "1.9.0"}, ">=1.0.0 <3.0.0", "", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"1.9.0", "2.0.0-rc.1", "2.0.0-rc.2", "2.0.0"}))
+	})
+
+	It("should only consider tags of the given variant", func() {
+		sorted, err := resource.SemverTags([]string{
+			"1.10.0-alpine",
+			"1.2.0",
+			"1.9.0-alpine",
+			"1.9.0-ubuntu",
+			"alpine",
+		}, ">=1.0.0", "alpine", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"1.9.0-alpine", "1.10.0-alpine"}))
+	})
+})
+
+var _ = Describe("RegexTags", func() {
+	It("should return tags entirely matching the expression", func() {
+		matching, err := resource.RegexTags([]string{
+			"nightly-20190101",
+			"nightly-2019010",
+			"nightly-20190101-debug",
+			"latest",
+		}, `nightly-\d{8}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(matching).To(Equal([]string{"nightly-20190101"}))
+	})
+
+	It("should fail with an invalid expression", func() {
+		_, err := resource.RegexTags([]string{"latest"}, `(`)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("IgnoreTags", func() {
+	It("should remove tags matching exact names and globs", func() {
+		kept, err := resource.IgnoreTags([]string{
+			"1.0.0",
+			"latest",
+			"cache",
+			"buildcache-1234",
+			"1.1.0",
+		}, []string{"latest", "cache", "buildcache-*"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(kept).To(Equal([]string{"1.0.0", "1.1.0"}))
+	})
+
+	It("should fail with an invalid glob", func() {
+		_, err := resource.IgnoreTags([]string{"latest"}, []string{"["})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("FilterTags", func() {
+	It("should order regex matches by name", func() {
+		source := resource.Source{TagRegex: `nightly-\d{8}`}
+
+		sorted, err := source.FilterTags([]string{"nightly-20190102", "latest", "nightly-20181231", "nightly-20190101"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"nightly-20181231", "nightly-20190101", "nightly-20190102"}))
+	})
+
+	It("should never return ignored tags", func() {
+		source := resource.Source{SemverConstraint: ">=1.0.0", IgnoreTags: []string{"1.1.*"}}
+
+		sorted, err := source.FilterTags([]string{"1.0.0", "1.1.0", "1.1.1", "1.2.0"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"1.0.0", "1.2.0"}))
+	})
+
+	It("should order regex matches by semver when a constraint is given", func() {
+		source := resource.Source{TagRegex: `\d+\.\d+\.\d+`, SemverConstraint: ">=1.0.0"}
+
+		sorted, err := source.FilterTags([]string{"1.10.0", "1.2", "1.9.0", "0.1.0"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sorted).To(Equal([]string{"1.9.0", "1.10.0"}))
+	})
+})
+
+var _ = Describe("TracksTags", func() {
+	It("should follow a single tag by default", func() {
+		source := resource.Source{Repository: "foo", RawTag: "bar"}
+		Expect(source.TracksTags()).To(BeFalse())
+	})
+
+	It("should discover tags when sorting by creation date", func() {
+		source := resource.Source{Repository: "foo", SortBy: resource.SortByCreationDate}
+		Expect(source.TracksTags()).To(BeTrue())
+	})
+})
+
+var _ = Describe("ValidateSortBy", func() {
+	It("should accept the default and creation_date orders", func() {
+		source := resource.Source{Repository: "foo"}
+		Expect(source.ValidateSortBy()).To(Succeed())
+
+		source.SortBy = resource.SortByCreationDate
+		Expect(source.ValidateSortBy()).To(Succeed())
+	})
+
+	It("should reject unknown orders", func() {
+		source := resource.Source{Repository: "foo", SortBy: "size"}
+		Expect(source.ValidateSortBy()).To(MatchError("unknown sort_by: size"))
+	})
+})
+
+var _ = Describe("SemverAliases", func() {
+	It("should update every alias of the highest version", func() {
+		aliases, err := resource.SemverAliases("1.4.2", []string{"1.3.0", "1.4.1", "1.4.2", "1.4", "1", "latest"}, "", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aliases).To(Equal([]string{"1.4", "1", "latest"}))
+	})
+
+	It("should only update the aliases that no higher version covers", func() {
+		tags := []string{"1.3.5", "1.3.6", "1.4.0", "2.0.0"}
+
+		aliases, err := resource.SemverAliases("1.3.6", tags, "", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aliases).To(Equal([]string{"1.3"}))
+
+		aliases, err = resource.SemverAliases("1.3.5", tags, "", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aliases).To(BeEmpty())
+	})
+
+	It("should update latest only if configured to", func() {
+		aliases, err := resource.SemverAliases("v2.0.0", []string{"v1.0.0", "v2.0.0"}, "", false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aliases).To(Equal([]string{"v2.0", "v2"}))
+	})
+
+	It("should suffix the aliases with the variant", func() {
+		aliases, err := resource.SemverAliases("1.4.2-alpine", []string{"1.4.2-alpine", "1.5.0"}, "alpine", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aliases).To(Equal([]string{"1.4-alpine", "1-alpine", "alpine"}))
+	})
+
+	It("should not alias pre-releases", func() {
+		aliases, err := resource.SemverAliases("2.0.0-rc.1", []string{"1.0.0"}, "", true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(aliases).To(BeEmpty())
+	})
+
+	It("should fail if the tag is not a semantic version", func() {
+		_, err := resource.SemverAliases("stable", nil, "", true)
+		Expect(err).To(MatchError(ContainSubstring(`tag "stable" is not a semantic version`)))
+	})
+})
@@ -0,0 +1,401 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
+)
+
+// tokenRefreshMargin is how long before its expiry a bearer token is
+// considered stale, so that long uploads never start with a token that is
+// about to expire.
+const tokenRefreshMargin = 15 * time.Second
+
+// defaultTokenLifetime is the lifetime assumed for tokens whose response does
+// not specify one, per the registry token spec.
+const defaultTokenLifetime = 60 * time.Second
+
+// writeAttempts is the number of times an image upload is attempted when the
+// registry rejects our credentials partway through.
+const writeAttempts = 3
+
+// TokenTransport authenticates requests to a registry. Unlike the transport
+// built into go-containerregistry, it tracks the lifetime of bearer tokens so
+// that they are refreshed before they expire rather than after a request (and
+// its unrewindable blob body) has already been rejected.
+type TokenTransport struct {
+	inner    http.RoundTripper
+	auth     authn.Authenticator
+	registry name.Registry
+	scopes   []string
+
+	lock      sync.Mutex
+	pinged    bool
+	challenge string
+	realm     string
+	service   string
+	token     string
+	expiresAt time.Time
+}
+
+// NewTokenTransport returns a transport which authenticates requests to the
+// registry with the given scopes, e.g. "repository:foo/bar:push,pull".
+func NewTokenTransport(registry name.Registry, auth authn.Authenticator, inner http.RoundTripper, scopes []string) *TokenTransport {
+	return &TokenTransport{
+		inner:    inner,
+		auth:     auth,
+		registry: registry,
+		scopes:   scopes,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// don't send credentials along to e.g. blob storage we've been redirected to
+	if req.URL.Host != t.registry.RegistryStr() {
+		return t.inner.RoundTrip(req)
+	}
+
+	hdr, err := t.authorization(false)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.send(req, hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && (req.Body == nil || req.GetBody != nil) {
+		res.Body.Close()
+
+		logrus.Debugf("%s %s was unauthorized; re-authenticating", req.Method, req.URL)
+
+		hdr, err = t.authorization(true)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return t.send(req, hdr)
+	}
+
+	return res, nil
+}
+
+// Invalidate discards the current bearer token so that the next request
+// fetches a new one.
+func (t *TokenTransport) Invalidate() {
+	t.lock.Lock()
+	t.token = ""
+	t.lock.Unlock()
+}
+
+func (t *TokenTransport) send(req *http.Request, hdr string) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	if hdr != "" {
+		out.Header.Set("Authorization", hdr)
+	}
+
+	return t.inner.RoundTrip(out)
+}
+
+func (t *TokenTransport) authorization(force bool) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.pinged {
+		err := t.ping()
+		if err != nil {
+			return "", err
+		}
+
+		t.pinged = true
+	}
+
+	switch t.challenge {
+	case "basic":
+		return t.auth.Authorization()
+	case "bearer":
+		if force || t.token == "" || time.Now().Add(tokenRefreshMargin).After(t.expiresAt) {
+			err := t.refresh()
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return "Bearer " + t.token, nil
+	default:
+		return "", nil
+	}
+}
+
+func (t *TokenTransport) ping() error {
+	u := url.URL{
+		Scheme: t.registry.Scheme(),
+		Host:   t.registry.RegistryStr(),
+		Path:   "/v2/",
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		t.challenge = ""
+	case http.StatusUnauthorized:
+		challenge, params := parseChallenge(res.Header.Get("WWW-Authenticate"))
+
+		t.challenge = challenge
+		t.realm = params["realm"]
+		t.service = params["service"]
+
+		if t.challenge == "bearer" && t.realm == "" {
+			return fmt.Errorf("malformed www-authenticate, missing realm: %v", params)
+		}
+
+		if t.service == "" {
+			t.service = t.registry.RegistryStr()
+		}
+	default:
+		return fmt.Errorf("unexpected status pinging registry: %s", res.Status)
+	}
+
+	return nil
+}
+
+func (t *TokenTransport) refresh() error {
+	u, err := url.Parse(t.realm)
+	if err != nil {
+		return err
+	}
+
+	u.RawQuery = url.Values{
+		"scope":   t.scopes,
+		"service": []string{t.service},
+	}.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	basic, err := t.auth.Authorization()
+	if err != nil {
+		return err
+	}
+
+	if basic != "" {
+		req.Header.Set("Authorization", basic)
+	}
+
+	res, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if (res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden) && len(t.scopes) > 1 {
+		// some registries refuse a token outright if any scope is denied,
+		// rather than granting the rest, e.g. for robot accounts without
+		// access to repositories to mount blobs from; the first scope is the
+		// one for the repository itself
+		logrus.Debugf("token for %s refused: %s; retrying with scope %s only", strings.Join(t.scopes, " "), res.Status, t.scopes[0])
+
+		t.scopes = t.scopes[:1]
+
+		return t.refresh()
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch token from %s: %s", u.Host, res.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return err
+	}
+
+	t.token = token.Token
+	if t.token == "" {
+		t.token = token.AccessToken
+	}
+
+	if t.token == "" {
+		return fmt.Errorf("no token in response from %s", u.Host)
+	}
+
+	lifetime := defaultTokenLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+
+	t.expiresAt = time.Now().Add(lifetime)
+
+	logrus.Debugf("fetched token valid for %s", lifetime)
+
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g.
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	challenge := strings.ToLower(parts[0])
+
+	if len(parts) < 2 {
+		return challenge, params
+	}
+
+	// values may be quoted and contain commas, e.g. scope="repo:foo:push,pull"
+	var key, value strings.Builder
+	inKey, inQuotes := true, false
+	flush := func() {
+		if key.Len() > 0 {
+			params[strings.ToLower(strings.TrimSpace(key.String()))] = value.String()
+		}
+
+		key.Reset()
+		value.Reset()
+		inKey = true
+	}
+
+	for _, c := range parts[1] {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ',' && !inQuotes:
+			flush()
+		case c == '=' && inKey:
+			inKey = false
+		case inKey:
+			key.WriteRune(c)
+		default:
+			value.WriteRune(c)
+		}
+	}
+
+	flush()
+
+	return challenge, params
+}
+
+// Write uploads the image to the registry, retrying if the registry rejects
+// our credentials partway through. Blobs which were already uploaded are
+// skipped on subsequent attempts.
+//
+// Image indexes are written by uploading their manifest alone; the images they
+// refer to must already have been written.
+func Write(ref name.Reference, img v1.Image, t *TokenTransport) error {
+	isIndex, err := IsIndex(img)
+	if err != nil {
+		return err
+	}
+
+	if isIndex {
+		return WriteManifest(ref, img, t)
+	}
+
+	img, err = WithProgress(img, "uploading")
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt <= writeAttempts; attempt++ {
+		// authentication is handled by the TokenTransport
+		err = remote.Write(ref, img, authn.Anonymous, t)
+		if err == nil || !isUnauthorized(err) {
+			return err
+		}
+
+		logrus.Warnf("upload was unauthorized (attempt %d of %d): %s", attempt, writeAttempts, err)
+
+		t.Invalidate()
+	}
+
+	return err
+}
+
+// WriteManifest uploads the image's manifest as is, e.g. to tag an image
+// which the repository already has without uploading any of its blobs.
+func WriteManifest(ref name.Reference, img v1.Image, t *TokenTransport) error {
+	raw, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+
+	u := url.URL{
+		Scheme: ref.Context().Registry.Scheme(),
+		Host:   ref.Context().RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", ref.Context().RepositoryStr(), ref.Identifier()),
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", string(mediaType))
+
+	res, err := t.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return remote.CheckError(res, http.StatusOK, http.StatusCreated, http.StatusAccepted)
+}
+
+func isUnauthorized(err error) bool {
+	if rErr, ok := err.(*remote.Error); ok {
+		for _, e := range rErr.Errors {
+			if e.Code == remote.UnauthorizedErrorCode {
+				return true
+			}
+		}
+	}
+
+	return strings.Contains(err.Error(), "unsupported status code 401")
+}
@@ -0,0 +1,174 @@
+package resource_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("TokenTransport", func() {
+	var server *httptest.Server
+	var registry name.Registry
+
+	var expiresIn int
+	var tokensIssued int32
+	var rejectNext int32
+
+	var tr *resource.TokenTransport
+
+	BeforeEach(func() {
+		expiresIn = 300
+		tokensIssued = 0
+		rejectNext = 0
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/token":
+				Expect(r.URL.Query().Get("scope")).To(Equal("repository:some/repo:push,pull"))
+
+				user, pass, ok := r.BasicAuth()
+				Expect(ok).To(BeTrue())
+				Expect(user).To(Equal("user"))
+				Expect(pass).To(Equal("pass"))
+
+				n := atomic.AddInt32(&tokensIssued, 1)
+				fmt.Fprintf(w, `{"token":"token-%d","expires_in":%d}`, n, expiresIn)
+			default:
+				expected := fmt.Sprintf("Bearer token-%d", atomic.LoadInt32(&tokensIssued))
+				if r.Header.Get("Authorization") != expected || atomic.CompareAndSwapInt32(&rejectNext, 1, 0) {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, serverURL(r)))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprint(w, "ok")
+			}
+		}))
+
+		u, err := url.Parse(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		registry, err = name.NewInsecureRegistry(u.Host, name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		tr = resource.NewTokenTransport(
+			registry,
+			&authn.Basic{Username: "user", Password: "pass"},
+			http.DefaultTransport,
+			[]string{"repository:some/repo:push,pull"},
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(path string) {
+		res, err := (&http.Client{Transport: tr}).Get(server.URL + path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		res.Body.Close()
+	}
+
+	It("should reuse a token until it nears expiry", func() {
+		get("/v2/some/repo/tags/list")
+		get("/v2/some/repo/tags/list")
+
+		Expect(atomic.LoadInt32(&tokensIssued)).To(Equal(int32(1)))
+	})
+
+	Context("when tokens are short-lived", func() {
+		BeforeEach(func() {
+			expiresIn = 1
+		})
+
+		It("should refresh the token before each request", func() {
+			get("/v2/some/repo/tags/list")
+			get("/v2/some/repo/tags/list")
+
+			Expect(atomic.LoadInt32(&tokensIssued)).To(Equal(int32(2)))
+		})
+	})
+
+	It("should re-authenticate and retry a rejected request", func() {
+		get("/v2/some/repo/tags/list")
+
+		atomic.StoreInt32(&rejectNext, 1)
+
+		res, err := (&http.Client{Transport: tr}).Post(server.URL+"/v2/some/repo/blobs/uploads/", "text/plain", strings.NewReader("body"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		res.Body.Close()
+
+		Expect(atomic.LoadInt32(&tokensIssued)).To(Equal(int32(2)))
+	})
+})
+
+var _ = Describe("TokenTransport scopes", func() {
+	It("should retry with only the repository's scope if a token is refused for the rest", func() {
+		var requested []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/token":
+				scopes := r.URL.Query()["scope"]
+				requested = append(requested, strings.Join(scopes, " "))
+
+				if len(scopes) > 1 {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				fmt.Fprint(w, `{"token":"some-token"}`)
+			default:
+				if r.Header.Get("Authorization") != "Bearer some-token" {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, serverURL(r)))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+
+				fmt.Fprint(w, "ok")
+			}
+		}))
+
+		defer server.Close()
+
+		u, err := url.Parse(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+
+		registry, err := name.NewInsecureRegistry(u.Host, name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr := resource.NewTokenTransport(
+			registry,
+			&authn.Basic{Username: "org+robot", Password: "some-token"},
+			http.DefaultTransport,
+			[]string{"repository:some/repo:push,pull", "repository:other/repo:pull"},
+		)
+
+		res, err := (&http.Client{Transport: tr}).Get(server.URL + "/v2/some/repo/tags/list")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		res.Body.Close()
+
+		Expect(requested).To(Equal([]string{
+			"repository:some/repo:push,pull repository:other/repo:pull",
+			"repository:some/repo:push,pull",
+		}))
+	})
+})
+
+func serverURL(r *http.Request) string {
+	return "http://" + r.Host
+}
@@ -0,0 +1,102 @@
+package resource
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redactedHeaders are the headers whose values are never logged.
+var redactedHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Amz-Security-Token": true,
+}
+
+// redactedParams are the substrings of query parameters, e.g. those of blob
+// downloads presigned for cloud storage, whose values are never logged.
+var redactedParams = []string{"token", "signature", "credential", "password", "secret", "key"}
+
+// TraceTransport logs every request, its response's status and headers, and
+// how long the response took, at the debug level. Credentials and tokens are
+// redacted.
+type TraceTransport struct {
+	Inner http.RoundTripper
+
+	// Redact are further headers whose values aren't logged, e.g. API keys
+	// added to requests.
+	Redact map[string]bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		return t.Inner.RoundTrip(req)
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"method":          req.Method,
+		"url":             redactURL(req.URL),
+		"request_headers": t.redactHeaders(req.Header),
+	})
+
+	start := time.Now()
+
+	res, err := t.Inner.RoundTrip(req)
+
+	log = log.WithField("duration", time.Since(start).String())
+
+	if err != nil {
+		log.Debugf("%s %s failed: %s", req.Method, redactURL(req.URL), err)
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{
+		"status":           res.StatusCode,
+		"response_headers": t.redactHeaders(res.Header),
+	}).Debugf("%s %s: %s", req.Method, redactURL(req.URL), res.Status)
+
+	return res, nil
+}
+
+func (t *TraceTransport) redactHeaders(header http.Header) map[string]string {
+	redacted := map[string]string{}
+	for key, values := range header {
+		if redactedHeaders[key] || t.Redact[http.CanonicalHeaderKey(key)] {
+			redacted[key] = "REDACTED"
+		} else {
+			redacted[key] = strings.Join(values, ", ")
+		}
+	}
+
+	return redacted
+}
+
+// redactURL returns the URL without its user info or the values of query
+// parameters which may be secrets.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+
+	query := redacted.Query()
+	for param := range query {
+		lower := strings.ToLower(param)
+		for _, secret := range redactedParams {
+			if strings.Contains(lower, secret) {
+				query.Set(param, "REDACTED")
+				break
+			}
+		}
+	}
+
+	if len(query) > 0 {
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.String()
+}
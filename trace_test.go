@@ -0,0 +1,92 @@
+package resource_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("TraceTransport", func() {
+	var server *httptest.Server
+	var logs *bytes.Buffer
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+			w.Header().Set("Set-Cookie", "session=some-session")
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+
+		logs = new(bytes.Buffer)
+		logrus.SetOutput(logs)
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+
+		logrus.SetOutput(os.Stderr)
+		logrus.SetFormatter(&logrus.TextFormatter{})
+		logrus.SetLevel(logrus.InfoLevel)
+	})
+
+	send := func(t http.RoundTripper) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/token?service=registry&access_token=some-token", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer some-token")
+		req.Header.Set("X-Api-Key", "some-key")
+		req.Header.Set("User-Agent", "some-agent")
+
+		res, err := t.RoundTrip(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+	}
+
+	It("should log requests and responses with secrets redacted", func() {
+		logrus.SetLevel(logrus.DebugLevel)
+
+		send(&resource.TraceTransport{
+			Inner:  http.DefaultTransport,
+			Redact: map[string]bool{"X-Api-Key": true},
+		})
+
+		var line struct {
+			Method          string            `json:"method"`
+			URL             string            `json:"url"`
+			Status          int               `json:"status"`
+			Duration        string            `json:"duration"`
+			RequestHeaders  map[string]string `json:"request_headers"`
+			ResponseHeaders map[string]string `json:"response_headers"`
+		}
+		Expect(json.Unmarshal(logs.Bytes(), &line)).To(Succeed())
+
+		Expect(line.Method).To(Equal("GET"))
+		Expect(line.URL).To(Equal(server.URL + "/v2/token?access_token=REDACTED&service=registry"))
+		Expect(line.Status).To(Equal(http.StatusUnauthorized))
+		Expect(line.Duration).ToNot(BeEmpty())
+		Expect(line.RequestHeaders).To(HaveKeyWithValue("Authorization", "REDACTED"))
+		Expect(line.RequestHeaders).To(HaveKeyWithValue("X-Api-Key", "REDACTED"))
+		Expect(line.RequestHeaders).To(HaveKeyWithValue("User-Agent", "some-agent"))
+		Expect(line.ResponseHeaders).To(HaveKeyWithValue("Set-Cookie", "REDACTED"))
+		Expect(line.ResponseHeaders).To(HaveKeyWithValue("Docker-Distribution-Api-Version", "registry/2.0"))
+
+		Expect(logs.String()).ToNot(ContainSubstring("some-token"))
+		Expect(logs.String()).ToNot(ContainSubstring("some-key"))
+	})
+
+	It("should log nothing unless debugging", func() {
+		send(&resource.TraceTransport{
+			Inner: http.DefaultTransport,
+		})
+
+		Expect(logs.String()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,480 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTracingServiceName is the service name spans are exported with by
+// default.
+const DefaultTracingServiceName = "registry-image-resource"
+
+// Tracing configures the export of traces of check, get, and put over OTLP.
+type Tracing struct {
+	// Endpoint is the URL of an OTLP/HTTP receiver, e.g.
+	// https://otel-collector:4318, to which traces are sent at /v1/traces.
+	Endpoint string `json:"endpoint"`
+
+	// Headers are sent with the traces, e.g. for authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// ServiceName is the service.name of the spans.
+	ServiceName string `json:"service_name,omitempty"`
+}
+
+// Validate checks that the endpoint is given.
+func (tracing *Tracing) Validate() error {
+	if tracing.Endpoint == "" {
+		return fmt.Errorf("tracing requires endpoint")
+	}
+
+	return nil
+}
+
+// StepTrace collects the spans of a check, get, or put, and exports them once
+// it finishes.
+type StepTrace struct {
+	tracing *Tracing
+	root    *Span
+
+	lock  sync.Mutex
+	spans []*Span
+
+	once sync.Once
+}
+
+// Span is a timed operation within a step, e.g. a request to the registry.
+type Span struct {
+	trace *StepTrace
+
+	traceID  string
+	spanID   string
+	parentID string
+	kind     int
+
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        string
+
+	lock sync.Mutex
+	done bool
+}
+
+// The kinds of spans, as in OTLP.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+// activeTrace is the trace requests to registries are recorded in.
+var activeTrace *StepTrace
+
+// Start starts the trace of the step, returning it. The step is traced as a
+// child of the trace context in the TRACEPARENT environment variable, e.g. as
+// propagated by Concourse, if given. The trace is exported when Succeeded is
+// called, or as soon as the step fails, i.e. logs an error, as it exits
+// straight after.
+func (tracing *Tracing) Start(step string, getenv func(string) string) *StepTrace {
+	if tracing == nil {
+		return nil
+	}
+
+	trace := &StepTrace{
+		tracing: tracing,
+	}
+
+	traceID, parentID := parseTraceparent(getenv("TRACEPARENT"))
+	if traceID == "" {
+		traceID = randomID(16)
+	}
+
+	trace.root = &Span{
+		trace:    trace,
+		traceID:  traceID,
+		spanID:   randomID(8),
+		parentID: parentID,
+		kind:     spanKindInternal,
+		name:     step,
+		start:    time.Now(),
+	}
+
+	activeTrace = trace
+
+	logrus.AddHook(failureHook(func(message string) {
+		// not logged, as hooks can't log
+		trace.finish(message)
+	}))
+
+	return trace
+}
+
+// Span starts a span of the step with the name, e.g. sign.
+func (trace *StepTrace) Span(name string) *Span {
+	if trace == nil {
+		return nil
+	}
+
+	return trace.span(name, spanKindInternal)
+}
+
+func (trace *StepTrace) span(name string, kind int) *Span {
+	return &Span{
+		trace:    trace,
+		traceID:  trace.root.traceID,
+		spanID:   randomID(8),
+		parentID: trace.root.spanID,
+		kind:     kind,
+		name:     name,
+		start:    time.Now(),
+	}
+}
+
+// SetAttribute sets an attribute of the span, e.g. http.method.
+func (span *Span) SetAttribute(key string, value string) {
+	if span == nil {
+		return
+	}
+
+	span.lock.Lock()
+	defer span.lock.Unlock()
+
+	if span.attributes == nil {
+		span.attributes = map[string]string{}
+	}
+
+	span.attributes[key] = value
+}
+
+// End ends the span, as having failed if err is not nil.
+func (span *Span) End(err error) {
+	if span == nil {
+		return
+	}
+
+	span.lock.Lock()
+	if span.done {
+		span.lock.Unlock()
+		return
+	}
+
+	span.done = true
+	span.end = time.Now()
+	if err != nil {
+		span.err = err.Error()
+	}
+	span.lock.Unlock()
+
+	span.trace.lock.Lock()
+	span.trace.spans = append(span.trace.spans, span)
+	span.trace.lock.Unlock()
+}
+
+// Succeeded ends the step's trace and exports it.
+func (trace *StepTrace) Succeeded() {
+	if trace == nil {
+		return
+	}
+
+	err := trace.finish("")
+	if err != nil {
+		logrus.Warnf("failed to export trace: %s", err)
+	}
+}
+
+// finish ends the root span, as having failed with the message if given, and
+// exports the trace, unless it has been already.
+func (trace *StepTrace) finish(message string) error {
+	var err error
+	trace.once.Do(func() {
+		trace.root.lock.Lock()
+		trace.root.done = true
+		trace.root.end = time.Now()
+		trace.root.err = message
+		trace.root.lock.Unlock()
+
+		trace.lock.Lock()
+		spans := append([]*Span{trace.root}, trace.spans...)
+		trace.lock.Unlock()
+
+		if activeTrace == trace {
+			activeTrace = nil
+		}
+
+		err = trace.export(spans)
+	})
+
+	return err
+}
+
+// export sends the spans to the endpoint, JSON-encoded.
+func (trace *StepTrace) export(spans []*Span) error {
+	serviceName := trace.tracing.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultTracingServiceName
+	}
+
+	var otlpSpans []otlpSpan
+	for _, span := range spans {
+		otlpSpans = append(otlpSpans, span.otlp())
+	}
+
+	payload, err := json.Marshal(otlpTraces{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: otlpAttributes(map[string]string{"service.name": serviceName}),
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: DefaultTracingServiceName},
+						Spans: otlpSpans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(trace.tracing.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	for key, value := range trace.tracing.Headers {
+		req.Header.Set(key, value)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Transport: BaseTransport,
+		Timeout:   10 * time.Second,
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export trace: %s", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("failed to export trace: %s", res.Status)
+	}
+
+	return nil
+}
+
+// SpanTransport records a span for every request while a step is traced:
+// listing tags, fetching and pushing manifests, and transferring blobs. Spans
+// of responses with a body end once it is closed, so that they cover the
+// whole transfer.
+type SpanTransport struct {
+	Inner http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *SpanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := activeTrace
+	if trace == nil {
+		return t.Inner.RoundTrip(req)
+	}
+
+	span := trace.span(spanName(req), spanKindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", redactURL(req.URL))
+	span.SetAttribute("net.peer.name", req.URL.Hostname())
+
+	res, err := t.Inner.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.status_code", fmt.Sprintf("%d", res.StatusCode))
+
+	var statusErr error
+	if res.StatusCode >= 400 {
+		statusErr = fmt.Errorf("%s", res.Status)
+	}
+
+	if res.Body == nil {
+		span.End(statusErr)
+		return res, nil
+	}
+
+	res.Body = &spanBody{ReadCloser: res.Body, span: span, err: statusErr}
+
+	return res, nil
+}
+
+// spanName names the span of the request after what it does.
+func spanName(req *http.Request) string {
+	path := req.URL.Path
+
+	switch {
+	case strings.HasSuffix(path, "/tags/list"):
+		return "list tags"
+	case strings.Contains(path, "/manifests/") && req.Method == http.MethodPut:
+		return "push manifest"
+	case strings.Contains(path, "/manifests/") && req.Method == http.MethodHead:
+		return "resolve manifest"
+	case strings.Contains(path, "/manifests/"):
+		return "fetch manifest"
+	case strings.Contains(path, "/blobs/uploads/"):
+		return "upload blob"
+	case strings.Contains(path, "/blobs/") && req.Method == http.MethodHead:
+		return "check blob"
+	case strings.Contains(path, "/blobs/"):
+		return "download blob"
+	case strings.Contains(path, "/referrers/"):
+		return "list referrers"
+	default:
+		return "HTTP " + req.Method
+	}
+}
+
+// spanBody ends the span once the response body is closed.
+type spanBody struct {
+	io.ReadCloser
+
+	span *Span
+	err  error
+}
+
+func (body *spanBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && body.err == nil {
+		body.err = err
+	}
+
+	return n, err
+}
+
+func (body *spanBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.span.End(body.err)
+	return err
+}
+
+// parseTraceparent returns the trace ID and parent span ID of a W3C trace
+// context, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01, or
+// nothing if it is invalid.
+func parseTraceparent(traceparent string) (string, string) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", ""
+	}
+
+	return parts[1], parts[2]
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (span *Span) otlp() otlpSpan {
+	span.lock.Lock()
+	defer span.lock.Unlock()
+
+	s := otlpSpan{
+		TraceID:           span.traceID,
+		SpanID:            span.spanID,
+		ParentSpanID:      span.parentID,
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: fmt.Sprintf("%d", span.start.UnixNano()),
+		EndTimeUnixNano:   fmt.Sprintf("%d", span.end.UnixNano()),
+		Attributes:        otlpAttributes(span.attributes),
+		Status:            otlpStatus{Code: 1},
+	}
+
+	if span.err != "" {
+		s.Status = otlpStatus{Code: 2, Message: span.err}
+	}
+
+	return s
+}
+
+// The OTLP JSON encoding of traces.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	var otlp []otlpAttribute
+	for key, value := range attributes {
+		attribute := otlpAttribute{Key: key}
+		attribute.Value.StringValue = value
+		otlp = append(otlp, attribute)
+	}
+
+	return otlp
+}
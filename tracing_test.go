@@ -0,0 +1,155 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("Tracing", func() {
+	var registry *httptest.Server
+	var collector *httptest.Server
+	var exports chan *http.Request
+	var payloads chan []byte
+	var tracing *resource.Tracing
+
+	type exportedSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Kind         int    `json:"kind"`
+		Attributes   []struct {
+			Key   string `json:"key"`
+			Value struct {
+				StringValue string `json:"stringValue"`
+			} `json:"value"`
+		} `json:"attributes"`
+		Status struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+
+	exported := func() []exportedSpan {
+		var payload []byte
+		Eventually(payloads).Should(Receive(&payload))
+
+		var traces struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		Expect(json.Unmarshal(payload, &traces)).To(Succeed())
+		Expect(traces.ResourceSpans).To(HaveLen(1))
+		Expect(traces.ResourceSpans[0].ScopeSpans).To(HaveLen(1))
+
+		return traces.ResourceSpans[0].ScopeSpans[0].Spans
+	}
+
+	BeforeEach(func() {
+		registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"tags":["latest"]}`))
+		}))
+
+		exports = make(chan *http.Request, 1)
+		payloads = make(chan []byte, 1)
+		collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			payload, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			exports <- r
+			payloads <- payload
+		}))
+
+		tracing = &resource.Tracing{
+			Endpoint: collector.URL,
+			Headers:  map[string]string{"Authorization": "Bearer some-token"},
+		}
+	})
+
+	AfterEach(func() {
+		registry.Close()
+		collector.Close()
+
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	})
+
+	It("should export spans of the step, its requests, and signing, within the propagated trace", func() {
+		trace := tracing.Start("check", func(name string) string {
+			if name == "TRACEPARENT" {
+				return "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+			}
+
+			return ""
+		})
+
+		req, err := http.NewRequest(http.MethodGet, registry.URL+"/v2/some/repo/tags/list", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		res, err := resource.Spans.RoundTrip(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+
+		span := trace.Span("sign")
+		span.SetAttribute("signer", "cosign")
+		span.End(errors.New("no key"))
+
+		trace.Succeeded()
+
+		var export *http.Request
+		Eventually(exports).Should(Receive(&export))
+		Expect(export.URL.Path).To(Equal("/v1/traces"))
+		Expect(export.Header.Get("Authorization")).To(Equal("Bearer some-token"))
+		Expect(export.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		spans := exported()
+		Expect(spans).To(HaveLen(3))
+
+		root, list, sign := spans[0], spans[1], spans[2]
+		Expect(root.Name).To(Equal("check"))
+		Expect(root.TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(root.ParentSpanID).To(Equal("00f067aa0ba902b7"))
+		Expect(root.Status.Code).To(Equal(1))
+
+		Expect(list.Name).To(Equal("list tags"))
+		Expect(list.TraceID).To(Equal(root.TraceID))
+		Expect(list.ParentSpanID).To(Equal(root.SpanID))
+		Expect(list.Kind).To(Equal(3))
+		Expect(list.Attributes).ToNot(BeEmpty())
+
+		Expect(sign.Name).To(Equal("sign"))
+		Expect(sign.Status.Code).To(Equal(2))
+		Expect(sign.Status.Message).To(Equal("no key"))
+	})
+
+	It("should export the trace as soon as the step fails", func() {
+		tracing.Start("get", func(string) string { return "" })
+
+		logrus.SetOutput(ioutil.Discard)
+		logrus.Error("failed to fetch image")
+		logrus.SetOutput(GinkgoWriter)
+
+		spans := exported()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("get"))
+		Expect(spans[0].TraceID).To(HaveLen(32))
+		Expect(spans[0].ParentSpanID).To(BeEmpty())
+		Expect(spans[0].Status.Code).To(Equal(2))
+		Expect(spans[0].Status.Message).To(Equal("failed to fetch image"))
+	})
+
+	It("should require an endpoint", func() {
+		Expect((&resource.Tracing{}).Validate()).To(MatchError("tracing requires endpoint"))
+	})
+})
@@ -0,0 +1,139 @@
+package resource
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// TransferTransport counts the requests sent, the bytes sent and received,
+// and which blobs are downloaded and uploaded, for reporting in metadata and
+// metrics.
+type TransferTransport struct {
+	Inner http.RoundTripper
+
+	bytes    int64
+	requests int64
+
+	lock        sync.Mutex
+	transferred map[string]bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *TransferTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.requests, 1)
+
+	if req.Body != nil {
+		req = req.Clone(req.Context())
+		req.Body = &countingReader{ReadCloser: req.Body, count: &t.bytes}
+	}
+
+	res, err := t.Inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if digest := transferredBlob(req, res); digest != "" {
+		t.lock.Lock()
+		if t.transferred == nil {
+			t.transferred = map[string]bool{}
+		}
+		t.transferred[digest] = true
+		t.lock.Unlock()
+	}
+
+	res.Body = &countingReader{ReadCloser: res.Body, count: &t.bytes}
+
+	return res, nil
+}
+
+// Bytes returns the number of bytes sent and received so far.
+func (t *TransferTransport) Bytes() int64 {
+	return atomic.LoadInt64(&t.bytes)
+}
+
+// Requests returns the number of requests sent so far.
+func (t *TransferTransport) Requests() int64 {
+	return atomic.LoadInt64(&t.requests)
+}
+
+// Reused returns how many of the layers were neither downloaded nor uploaded,
+// e.g. as they were cached, mounted, or already in the registry.
+func (t *TransferTransport) Reused(layers []v1.Layer) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	reused := 0
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return 0, err
+		}
+
+		if !t.transferred[digest.String()] {
+			reused++
+		}
+	}
+
+	return reused, nil
+}
+
+// Metadata returns the statistics of a pull or push of the layers which took
+// the duration: <phase>_duration, bytes_transferred, layer_count, and
+// layers_reused.
+func (t *TransferTransport) Metadata(phase string, duration time.Duration, layers []v1.Layer) ([]MetadataField, error) {
+	reused, err := t.Reused(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	return []MetadataField{
+		{Name: phase + "_duration", Value: duration.Round(time.Millisecond).String()},
+		{Name: "bytes_transferred", Value: fmt.Sprintf("%d", t.Bytes())},
+		{Name: "layer_count", Value: fmt.Sprintf("%d", len(layers))},
+		{Name: "layers_reused", Value: fmt.Sprintf("%d", reused)},
+	}, nil
+}
+
+// transferredBlob returns the digest of the blob the request downloaded or
+// finished uploading, if any.
+func transferredBlob(req *http.Request, res *http.Response) string {
+	switch {
+	case req.Method == http.MethodGet && res.StatusCode < 400:
+		// including redirects to the blob's storage
+		i := strings.LastIndex(req.URL.Path, "/blobs/")
+		if i == -1 || strings.HasPrefix(req.URL.Path[i:], "/blobs/uploads/") {
+			return ""
+		}
+
+		return req.URL.Path[i+len("/blobs/"):]
+
+	case req.Method == http.MethodPut && res.StatusCode == http.StatusCreated:
+		if !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+			return ""
+		}
+
+		return req.URL.Query().Get("digest")
+
+	default:
+		return ""
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+
+	count *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.count, int64(n))
+	return n, err
+}
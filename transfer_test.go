@@ -0,0 +1,82 @@
+package resource_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("TransferTransport", func() {
+	var server *httptest.Server
+	var transfers *resource.TransferTransport
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+
+			switch r.Method {
+			case http.MethodPut:
+				w.WriteHeader(http.StatusCreated)
+			default:
+				w.Write([]byte("some-blob"))
+			}
+		}))
+
+		transfers = &resource.TransferTransport{
+			Inner: http.DefaultTransport,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	send := func(method string, path string, body string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		Expect(err).ToNot(HaveOccurred())
+
+		res, err := transfers.RoundTrip(req)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = ioutil.ReadAll(res.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+	}
+
+	It("should report the bytes transferred and the layers reused", func() {
+		image, err := random.Image(1024, 3)
+		Expect(err).ToNot(HaveOccurred())
+
+		layers, err := image.Layers()
+		Expect(err).ToNot(HaveOccurred())
+
+		downloaded, err := layers[0].Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		uploaded, err := layers[1].Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		send(http.MethodGet, "/v2/some/repo/blobs/"+downloaded.String(), "")
+		send(http.MethodPatch, "/v2/some/repo/blobs/uploads/some-upload", "some-layer")
+		send(http.MethodPut, "/v2/some/repo/blobs/uploads/some-upload?digest="+uploaded.String(), "")
+
+		Expect(transfers.Bytes()).To(Equal(int64(len("some-blob")*2 + len("some-layer"))))
+
+		metadata, err := transfers.Metadata("push", 1234567*time.Microsecond, layers)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metadata).To(Equal([]resource.MetadataField{
+			{Name: "push_duration", Value: "1.235s"},
+			{Name: "bytes_transferred", Value: "28"},
+			{Name: "layer_count", Value: "3"},
+			{Name: "layers_reused", Value: "1"},
+		}))
+	})
+})
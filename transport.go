@@ -1,25 +1,261 @@
 package resource
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/concourse/retryhttp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
 )
 
+// BaseTransport sends every request sent through RetryTransport. It is
+// configured for the registry by ConfigureTransport.
+var BaseTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// InsecureRegistries talks to the registries configured as insecure by
+// ConfigureTransport.
+var InsecureRegistries = &InsecureTransport{
+	Inner: BaseTransport,
+}
+
+// Transfers counts what is transferred by every request sent through
+// RetryTransport.
+var Transfers = &TransferTransport{
+	Inner: InsecureRegistries,
+}
+
+// Spans records a span for every request sent through RetryTransport while
+// the step is traced.
+var Spans = &SpanTransport{
+	Inner: Transfers,
+}
+
+// Tracer logs every request sent through RetryTransport when debugging.
+var Tracer = &TraceTransport{
+	Inner: Spans,
+}
+
+// ExtraHeaders adds the headers configured by ConfigureTransport to requests
+// to the registry.
+var ExtraHeaders = &HeaderTransport{
+	Inner: Tracer,
+}
+
+// RateLimiter tracks the rate limit reported by the registry for every request
+// sent through RetryTransport.
+var RateLimiter = &RateLimitTransport{
+	Inner: &ManifestTransport{
+		Inner: &ResumeTransport{
+			Inner: ExtraHeaders,
+		},
+	},
+}
+
 var RetryTransport = &retryhttp.RetryRoundTripper{
 	Logger:         &discardLogger{},
 	BackOffFactory: retryhttp.NewExponentialBackOffFactory(10 * time.Minute),
-	RoundTripper:   http.DefaultTransport,
+	RoundTripper:   RateLimiter,
 	Retryer:        &retryhttp.DefaultRetryer{},
 }
 
-// discardLogger is an inert logger.
+// HeaderTransport adds headers to the requests to the hosts, e.g. those an
+// API gateway in front of the registry requires.
+type HeaderTransport struct {
+	Inner http.RoundTripper
+
+	// Hosts are the hosts to send the headers to, e.g. registry.example.com.
+	Hosts map[string]bool
+
+	Headers map[string]string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.Headers) == 0 || !t.Hosts[req.URL.Host] {
+		return t.Inner.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for key, value := range t.Headers {
+		if req.Header.Get(key) == "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	return t.Inner.RoundTrip(req)
+}
+
+// ConfigureTransport configures BaseTransport for talking to the source's
+// registry, trusting its ca_certs in addition to the system's, and presenting
+// its client certificate if any. Requests are sent through the source's
+// proxies, or else those of the environment, and with its extra_headers,
+// timeouts, and concurrency limit. If the source is insecure, the registry
+// and its mirrors are configured as InsecureRegistries.
+func (source *Source) ConfigureTransport() error {
+	if source.ConnectTimeout != "" {
+		timeout, err := time.ParseDuration(source.ConnectTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid connect_timeout: %s", err)
+		}
+
+		BaseTransport.DialContext = (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		BaseTransport.TLSHandshakeTimeout = timeout
+	}
+
+	if source.ResponseHeaderTimeout != "" {
+		timeout, err := time.ParseDuration(source.ResponseHeaderTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid response_header_timeout: %s", err)
+		}
+
+		BaseTransport.ResponseHeaderTimeout = timeout
+	}
+
+	if source.MaxConcurrency < 0 {
+		return fmt.Errorf("invalid max_concurrency %d", source.MaxConcurrency)
+	}
+
+	if source.MaxConcurrency > 0 {
+		// idle connections are reused, so requests beyond the limit wait for
+		// one to be free
+		BaseTransport.MaxConnsPerHost = source.MaxConcurrency
+	}
+
+	if source.HTTPProxy != "" || source.HTTPSProxy != "" || source.NoProxy != "" {
+		proxies := httpproxy.FromEnvironment()
+
+		if source.HTTPProxy != "" {
+			proxies.HTTPProxy = source.HTTPProxy
+		}
+
+		if source.HTTPSProxy != "" {
+			proxies.HTTPSProxy = source.HTTPSProxy
+		}
+
+		if source.NoProxy != "" {
+			proxies.NoProxy = source.NoProxy
+		}
+
+		proxyFunc := proxies.ProxyFunc()
+		BaseTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	if len(source.CACerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for i, cert := range source.CACerts {
+			if !pool.AppendCertsFromPEM([]byte(cert)) {
+				return fmt.Errorf("ca_certs[%d] contains no PEM-encoded certificates", i)
+			}
+		}
+
+		tlsConfig(BaseTransport).RootCAs = pool
+	}
+
+	if source.ClientCert != "" || source.ClientKey != "" {
+		if source.ClientCert == "" || source.ClientKey == "" {
+			return fmt.Errorf("client_cert and client_key must be given together")
+		}
+
+		cert, err := tls.X509KeyPair([]byte(source.ClientCert), []byte(source.ClientKey))
+		if err != nil {
+			return fmt.Errorf("invalid client_cert or client_key: %s", err)
+		}
+
+		tlsConfig(BaseTransport).Certificates = []tls.Certificate{cert}
+	}
+
+	if len(source.ExtraHeaders) == 0 && !source.Insecure {
+		return nil
+	}
+
+	repo, err := name.NewRepository(source.Repository, name.WeakValidation)
+	if err != nil {
+		return fmt.Errorf("invalid repository: %s", err)
+	}
+
+	if len(source.ExtraHeaders) > 0 {
+		// not to mirrors or redirected blob downloads, so as not to leak
+		// credentials
+		ExtraHeaders.Hosts = map[string]bool{repo.RegistryStr(): true}
+		ExtraHeaders.Headers = source.ExtraHeaders
+
+		// e.g. API keys
+		Tracer.Redact = map[string]bool{}
+		for key := range source.ExtraHeaders {
+			Tracer.Redact[http.CanonicalHeaderKey(key)] = true
+		}
+	}
+
+	if source.Insecure {
+		hosts := map[string]bool{repo.RegistryStr(): true}
+		for _, mirror := range source.RegistryMirrors {
+			hosts[mirrorHost(mirror)] = true
+		}
+
+		for host := range hosts {
+			logrus.Warnf("INSECURE: not verifying the TLS certificate of %s, and allowing plain HTTP", host)
+		}
+
+		insecure := BaseTransport.Clone()
+		tlsConfig(insecure).InsecureSkipVerify = true
+
+		InsecureRegistries.Insecure = insecure
+		InsecureRegistries.Hosts = hosts
+	}
+
+	return nil
+}
+
+// Timeout returns how long check, get, or put may take altogether before
+// failing, or 0 if they may take as long as they take.
+func (source *Source) Timeout() (time.Duration, error) {
+	if source.OperationTimeout == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(source.OperationTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid operation_timeout: %s", err)
+	}
+
+	return timeout, nil
+}
+
+// tlsConfig returns the transport's TLS config, setting one if it has none.
+func tlsConfig(t *http.Transport) *tls.Config {
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+
+	return t.TLSClientConfig
+}
+
+// discardLogger is an inert logger, but for counting the retries it logs.
 type discardLogger struct{}
 
-func (*discardLogger) Debug(string, ...lager.Data)                  {}
-func (*discardLogger) Info(string, ...lager.Data)                   {}
+func (*discardLogger) Debug(string, ...lager.Data) {}
+func (*discardLogger) Info(action string, _ ...lager.Data) {
+	if action == "retrying" {
+		countRetry()
+	}
+}
 func (*discardLogger) Error(string, error, ...lager.Data)           {}
 func (*discardLogger) Fatal(string, error, ...lager.Data)           {}
 func (*discardLogger) RegisterSink(lager.Sink)                      {}
@@ -0,0 +1,367 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// selfSignedCert generates a PEM-encoded certificate and key for clients.
+func selfSignedCert() ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "some-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+var _ = Describe("ConfigureTransport", func() {
+	var server *httptest.Server
+	var clientCert, clientKey []byte
+
+	serverCA := func() string {
+		return string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: server.Certificate().Raw,
+		}))
+	}
+
+	BeforeEach(func() {
+		clientCert, clientKey = selfSignedCert()
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+
+		resource.BaseTransport.CloseIdleConnections()
+
+		resource.BaseTransport.TLSClientConfig = nil
+		resource.InsecureRegistries.Hosts = nil
+	})
+
+	get := func() error {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		res, err := resource.InsecureRegistries.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+
+		return res.Body.Close()
+	}
+
+	It("should trust the ca_certs", func() {
+		server.StartTLS()
+
+		Expect(get()).To(MatchError(ContainSubstring("certificate")))
+
+		source := resource.Source{
+			CACerts: []string{serverCA()},
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		Expect(get()).To(Succeed())
+	})
+
+	It("should present the client certificate", func() {
+		clients := x509.NewCertPool()
+		Expect(clients.AppendCertsFromPEM(clientCert)).To(BeTrue())
+
+		server.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clients,
+		}
+		server.StartTLS()
+
+		source := resource.Source{
+			CACerts: []string{serverCA()},
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		Expect(get()).ToNot(Succeed())
+
+		source.ClientCert = string(clientCert)
+		source.ClientKey = string(clientKey)
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		Expect(get()).To(Succeed())
+	})
+
+	Context("when insecure", func() {
+		var source resource.Source
+
+		BeforeEach(func() {
+			source = resource.Source{
+				Repository: server.Listener.Addr().String() + "/some/repo",
+				Insecure:   true,
+			}
+		})
+
+		It("should not verify the registry's certificate", func() {
+			server.StartTLS()
+
+			Expect(source.ConfigureTransport()).To(Succeed())
+			Expect(get()).To(Succeed())
+		})
+
+		It("should still verify the certificates of other servers", func() {
+			server.StartTLS()
+
+			source.Repository = "registry.example.com/some/repo"
+
+			Expect(source.ConfigureTransport()).To(Succeed())
+			Expect(get()).To(MatchError(ContainSubstring("certificate")))
+		})
+
+		It("should fall back to plain HTTP", func() {
+			server.Start()
+
+			Expect(source.ConfigureTransport()).To(Succeed())
+
+			req, err := http.NewRequest(http.MethodGet, "https://"+server.Listener.Addr().String()+"/v2/", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				res, err := resource.InsecureRegistries.RoundTrip(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				Expect(res.Body.Close()).To(Succeed())
+			}
+		})
+	})
+
+	Describe("proxies", func() {
+		AfterEach(func() {
+			resource.BaseTransport.Proxy = http.ProxyFromEnvironment
+		})
+
+		proxy := func(url string) string {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			proxyURL, err := resource.BaseTransport.Proxy(req)
+			Expect(err).ToNot(HaveOccurred())
+
+			if proxyURL == nil {
+				return ""
+			}
+
+			return proxyURL.String()
+		}
+
+		It("should send requests through the proxies, except to no_proxy hosts", func() {
+			source := resource.Source{
+				HTTPProxy:  "http://proxy.example.com:3128",
+				HTTPSProxy: "http://secure-proxy.example.com:3128",
+				NoProxy:    "registry.internal,.corp.example.com",
+			}
+
+			Expect(source.ConfigureTransport()).To(Succeed())
+
+			Expect(proxy("https://registry.example.com/v2/")).To(Equal("http://secure-proxy.example.com:3128"))
+			Expect(proxy("https://some-bucket.s3.amazonaws.com/some-blob")).To(Equal("http://secure-proxy.example.com:3128"))
+			Expect(proxy("http://registry.example.com/v2/")).To(Equal("http://proxy.example.com:3128"))
+			Expect(proxy("https://registry.internal/v2/")).To(BeEmpty())
+			Expect(proxy("https://mirror.corp.example.com/v2/")).To(BeEmpty())
+		})
+
+		It("should send requests to the proxy", func() {
+			var proxied []string
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proxied = append(proxied, r.URL.Host)
+			})
+
+			server.Start()
+
+			source := resource.Source{
+				HTTPProxy: server.URL,
+			}
+
+			Expect(source.ConfigureTransport()).To(Succeed())
+
+			req, err := http.NewRequest(http.MethodGet, "http://registry.example.com/v2/", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err := resource.BaseTransport.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Body.Close()).To(Succeed())
+
+			Expect(proxied).To(Equal([]string{"registry.example.com"}))
+		})
+	})
+
+	It("should add the extra_headers to requests to the registry", func() {
+		var headers http.Header
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = r.Header
+		})
+
+		server.Start()
+
+		source := resource.Source{
+			Repository: server.Listener.Addr().String() + "/some/repo",
+			ExtraHeaders: map[string]string{
+				"X-JFrog-Art-Api": "some-api-key",
+				"X-Route":         "some-route",
+			},
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		defer func() { resource.ExtraHeaders.Headers = nil }()
+
+		send := func(url string) {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("X-Route", "set-by-request")
+
+			res, err := resource.ExtraHeaders.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.Body.Close()).To(Succeed())
+		}
+
+		send(server.URL + "/v2/")
+		Expect(headers.Get("X-JFrog-Art-Api")).To(Equal("some-api-key"))
+		Expect(headers.Get("X-Route")).To(Equal("set-by-request"))
+
+		// e.g. a blob download redirected to storage
+		send("http://localhost:" + strings.Split(server.Listener.Addr().String(), ":")[1] + "/some-blob")
+		Expect(headers.Get("X-JFrog-Art-Api")).To(BeEmpty())
+	})
+
+	It("should give up on responses slower than the response_header_timeout", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Second)
+		})
+
+		server.Start()
+
+		source := resource.Source{
+			ConnectTimeout:        "5s",
+			ResponseHeaderTimeout: "100ms",
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		defer func() { resource.BaseTransport.ResponseHeaderTimeout = 0 }()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = resource.BaseTransport.RoundTrip(req)
+		Expect(err).To(MatchError(ContainSubstring("timeout awaiting response headers")))
+	})
+
+	It("should limit the connections to each host to max_concurrency", func() {
+		concurrent, max := 0, 0
+		var lock sync.Mutex
+
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			concurrent++
+			if concurrent > max {
+				max = concurrent
+			}
+			lock.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			lock.Lock()
+			concurrent--
+			lock.Unlock()
+		})
+
+		server.Start()
+
+		source := resource.Source{
+			MaxConcurrency: 2,
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		defer func() { resource.BaseTransport.MaxConnsPerHost = 0 }()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/", nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				res, err := resource.BaseTransport.RoundTrip(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.Body.Close()).To(Succeed())
+			}()
+		}
+
+		wg.Wait()
+
+		Expect(max).To(Equal(2))
+	})
+
+	It("should reject invalid timeouts", func() {
+		source := resource.Source{
+			ConnectTimeout: "soon",
+		}
+
+		Expect(source.ConfigureTransport()).To(MatchError(ContainSubstring("invalid connect_timeout")))
+
+		source = resource.Source{
+			OperationTimeout: "10",
+		}
+
+		_, err := source.Timeout()
+		Expect(err).To(MatchError(ContainSubstring("invalid operation_timeout")))
+	})
+
+	It("should require both client_cert and client_key", func() {
+		source := resource.Source{
+			ClientCert: string(clientCert),
+		}
+
+		Expect(source.ConfigureTransport()).To(MatchError("client_cert and client_key must be given together"))
+	})
+
+	It("should reject ca_certs without certificates", func() {
+		source := resource.Source{
+			CACerts: []string{"nope"},
+		}
+
+		Expect(source.ConfigureTransport()).To(MatchError(ContainSubstring("ca_certs[0] contains no PEM-encoded certificates")))
+	})
+})
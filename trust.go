@@ -0,0 +1,197 @@
+package resource
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/simonshyu/notary-gcr/trust"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/utils"
+)
+
+// DefaultDelegationRole is the delegation role Docker signs into.
+const DefaultDelegationRole = "targets/releases"
+
+// ContentTrustDelegation configures a delegation role to sign pushed images
+// under, with its key, instead of the repository key.
+type ContentTrustDelegation struct {
+	// Role is the delegation role, e.g. targets/releases. Only roles
+	// delegated by the targets role itself are supported.
+	Role string `json:"role"`
+
+	// KeyID is the ID of the delegation key, as listed by `notary key list`.
+	KeyID string `json:"key_id"`
+
+	// Key is the PEM-encoded private key of the delegation.
+	Key string `json:"key"`
+
+	// Passphrase decrypts the delegation key.
+	Passphrase string `json:"passphrase"`
+}
+
+// RoleName returns the delegation role, defaulting to targets/releases.
+func (delegation ContentTrustDelegation) RoleName() (data.RoleName, error) {
+	role := delegation.Role
+	if role == "" {
+		role = DefaultDelegationRole
+	}
+
+	roleName := data.RoleName(role)
+	if roleName.Parent() != data.CanonicalTargetsRole {
+		return "", fmt.Errorf("invalid delegation role %q: must be delegated by targets, e.g. %s", role, DefaultDelegationRole)
+	}
+
+	return roleName, nil
+}
+
+// withFiles returns the configuration with the key material given by the
+// *_file fields read from them, e.g. secrets mounted into the worker. They
+// take precedence over the values given directly.
+func (ct *ContentTrust) withFiles() (*ContentTrust, error) {
+	resolved := *ct
+
+	for _, field := range []struct {
+		name  string
+		path  string
+		value *string
+	}{
+		{"repository_key", ct.RepositoryKeyFile, &resolved.RepositoryKey},
+		{"repository_passphrase", ct.RepositoryPassphraseFile, &resolved.RepositoryPassphrase},
+		{"tls_key", ct.TLSKeyFile, &resolved.TLSKey},
+		{"tls_cert", ct.TLSCertFile, &resolved.TLSCert},
+	} {
+		value, err := readFileOr(field.path, *field.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", field.name, err)
+		}
+
+		*field.value = value
+	}
+
+	return &resolved, nil
+}
+
+// writeDelegationKeys writes the delegation keys to the notary private key
+// directory. The keys are re-encrypted with the repository passphrase, as it
+// is the only one notary-gcr gives notary for keys other than the root key.
+func (ct *ContentTrust) writeDelegationKeys(privateDir string) error {
+	for _, delegation := range ct.Delegations {
+		role, err := delegation.RoleName()
+		if err != nil {
+			return err
+		}
+
+		if delegation.KeyID == "" {
+			// the signing key is only needed for pushing
+			continue
+		}
+
+		key, err := utils.ParsePEMPrivateKey([]byte(delegation.Key), delegation.Passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt key of delegation %s: %s", role, err)
+		}
+
+		pemKey, err := utils.ConvertPrivateKeyToPKCS8(key, role, "", ct.RepositoryPassphrase)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(filepath.Join(privateDir, delegation.KeyID+".key"), pemKey, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// trustedRoles returns the roles whose signatures are trusted: the
+// configured delegations, or else targets/releases, as Docker does, and the
+// targets role itself.
+func (ct *ContentTrust) trustedRoles() ([]data.RoleName, error) {
+	var roles []data.RoleName
+	for _, delegation := range ct.Delegations {
+		role, err := delegation.RoleName()
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if len(roles) == 0 {
+		roles = append(roles, trust.ReleasesRole)
+	}
+
+	return append(roles, data.CanonicalTargetsRole), nil
+}
+
+// VerifyDigest verifies that the digest the registry serves for the tag is the
+// one signed in the notary server's trust data for it.
+func (ct *ContentTrust) VerifyDigest(ref name.Tag, auth authn.Authenticator, digest string) error {
+	roles, err := ct.trustedRoles()
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "content-trust")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	configDir, err := ct.PrepareConfigDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to prepare notary-config-dir: %s", err)
+	}
+
+	config, err := trust.ParseConfig(configDir)
+	if err != nil {
+		return fmt.Errorf("failed to parse notary config: %s", err)
+	}
+
+	registry := ref.Context().Registry
+	notaryRepo, err := trust.GetNotaryRepository(ref, auth, &registry, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to notary server: %s", err)
+	}
+
+	target, err := notaryRepo.GetTargetByName(ref.TagStr(), roles...)
+	if err != nil {
+		return fmt.Errorf("failed to get trust data: %s", trust.NotaryError(ref.Name(), err))
+	}
+
+	// targets may be found in roles delegated by the trusted ones, which
+	// aren't trusted themselves
+	trusted := false
+	var names []string
+	for _, role := range roles {
+		names = append(names, role.String())
+		if target.Role == role {
+			trusted = true
+		}
+	}
+
+	if !trusted {
+		return fmt.Errorf("%s is only signed by %s, not %s", ref, target.Role, strings.Join(names, " or "))
+	}
+
+	hash, found := target.Hashes["sha256"]
+	if !found {
+		return fmt.Errorf("no sha256 hash signed for %s", ref)
+	}
+
+	signed := "sha256:" + hex.EncodeToString(hash)
+	if signed != digest {
+		return fmt.Errorf("registry serves %s for %s, but %s is signed", digest, ref, signed)
+	}
+
+	return nil
+}
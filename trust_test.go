@@ -0,0 +1,285 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/theupdateframework/notary/tuf"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/theupdateframework/notary/tuf/testutils"
+	"github.com/theupdateframework/notary/tuf/utils"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ContentTrust", func() {
+	var dir string
+	var keyPEM []byte
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "content-trust")
+		Expect(err).ToNot(HaveOccurred())
+
+		key, err := utils.GenerateECDSAKey(rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		keyPEM, err = utils.ConvertPrivateKeyToPKCS8(key, "some-signer", "", "some-delegation-passphrase")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should read key material from files", func() {
+		files := map[string]string{
+			"repository-key":        "some-repository-key",
+			"repository-passphrase": "some-repository-passphrase\n",
+			"tls-key":               "some-tls-key",
+			"tls-cert":              "some-tls-cert",
+		}
+
+		for file, content := range files {
+			Expect(ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0600)).To(Succeed())
+		}
+
+		ct := resource.ContentTrust{
+			Server:                   "https://notary.example.com",
+			RepositoryKeyID:          "some-key-id",
+			RepositoryKey:            "overridden",
+			RepositoryKeyFile:        filepath.Join(dir, "repository-key"),
+			RepositoryPassphraseFile: filepath.Join(dir, "repository-passphrase"),
+			TLSKeyFile:               filepath.Join(dir, "tls-key"),
+			TLSCertFile:              filepath.Join(dir, "tls-cert"),
+		}
+
+		configDir, err := ct.PrepareConfigDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		for file, expected := range map[string]string{
+			filepath.Join("trust", "private", "some-key-id.key"):      "some-repository-key",
+			filepath.Join("tls", "notary.example.com", "client.key"):  "some-tls-key",
+			filepath.Join("tls", "notary.example.com", "client.cert"): "some-tls-cert",
+		} {
+			content, err := ioutil.ReadFile(filepath.Join(configDir, file))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(expected))
+		}
+
+		config, err := ioutil.ReadFile(filepath.Join(configDir, "gcr-config.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config).To(MatchJSON(`{
+			"server_url": "https://notary.example.com",
+			"root_passphrase": "",
+			"repository_passphrase": "some-repository-passphrase"
+		}`))
+	})
+
+	It("should fail when a key file is missing", func() {
+		ct := resource.ContentTrust{
+			TLSKeyFile: "/does/not/exist",
+		}
+
+		_, err := ct.PrepareConfigDir(dir)
+		Expect(err).To(MatchError(ContainSubstring("failed to read tls_key")))
+	})
+
+	Context("with delegations", func() {
+		It("should write the keys encrypted with the repository passphrase", func() {
+			ct := resource.ContentTrust{
+				Server:               "https://notary.example.com",
+				RepositoryPassphrase: "some-repository-passphrase",
+				Delegations: []resource.ContentTrustDelegation{{
+					KeyID:      "some-key-id",
+					Key:        string(keyPEM),
+					Passphrase: "some-delegation-passphrase",
+				}},
+			}
+
+			configDir, err := ct.PrepareConfigDir(dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			written, err := ioutil.ReadFile(filepath.Join(configDir, "trust", "private", "some-key-id.key"))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = utils.ParsePEMPrivateKey(written, "some-repository-passphrase")
+			Expect(err).ToNot(HaveOccurred())
+
+			role, _, err := utils.ExtractPrivateKeyAttributes(written)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(data.RoleName("targets/releases")))
+		})
+
+		It("should reject the wrong passphrase", func() {
+			ct := resource.ContentTrust{
+				Delegations: []resource.ContentTrustDelegation{{
+					KeyID:      "some-key-id",
+					Key:        string(keyPEM),
+					Passphrase: "wrong",
+				}},
+			}
+
+			_, err := ct.PrepareConfigDir(dir)
+			Expect(err).To(MatchError(ContainSubstring("failed to decrypt key of delegation targets/releases")))
+		})
+	})
+
+	Describe("VerifyDigest", func() {
+		var server *httptest.Server
+		var tufRepo *tuf.Repo
+		var meta map[data.RoleName][]byte
+
+		var ct resource.ContentTrust
+		var tag name.Tag
+
+		signed := "sha256:" + hex.EncodeToString(sha256Sum("some-image"))
+		served := "sha256:" + hex.EncodeToString(sha256Sum("some-other-image"))
+
+		BeforeEach(func() {
+			// the registry must not look local, as notary only skips verifying
+			// the notary server's certificate for registries spoken to over TLS
+			listener, err := net.Listen("tcp", "127.0.0.2:0")
+			Expect(err).ToNot(HaveOccurred())
+
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					return
+				}
+
+				file := strings.TrimSuffix(r.URL.Path[strings.Index(r.URL.Path, "/_trust/tuf/")+len("/_trust/tuf/"):], ".json")
+
+				// consistent names carry the version or checksum after the role
+				content, found := meta[data.RoleName(file)]
+				if !found && strings.Contains(file, ".") {
+					content, found = meta[data.RoleName(file[:strings.LastIndex(file, ".")])]
+				}
+
+				if !found {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				w.Write(content)
+			}))
+			server.Listener.Close()
+			server.Listener = listener
+			server.StartTLS()
+
+			host := strings.TrimPrefix(server.URL, "https://")
+
+			// notary-gcr names the trusted collection after the registry
+			tufRepo, _, err = testutils.EmptyRepo(data.GUN(host), "targets/releases", "targets/other")
+			Expect(err).ToNot(HaveOccurred())
+
+			tag, err = name.NewTag(host+"/some/repo:latest", name.WeakValidation)
+			Expect(err).ToNot(HaveOccurred())
+
+			cert, key := clientCertificate()
+			ct = resource.ContentTrust{
+				Server:  server.URL,
+				TLSCert: cert,
+				TLSKey:  key,
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		sign := func(role data.RoleName, digest string) {
+			hash, err := hex.DecodeString(strings.TrimPrefix(digest, "sha256:"))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = tufRepo.AddTargets(role, data.Files{
+				"latest": data.FileMeta{Length: 1, Hashes: data.Hashes{"sha256": hash}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			meta, err = testutils.SignAndSerialize(tufRepo)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		It("should accept the digest signed for the tag", func() {
+			sign("targets/releases", signed)
+
+			Expect(ct.VerifyDigest(tag, authn.Anonymous, signed)).To(Succeed())
+		})
+
+		It("should reject other digests", func() {
+			sign("targets/releases", signed)
+
+			err := ct.VerifyDigest(tag, authn.Anonymous, served)
+			Expect(err).To(MatchError(ContainSubstring("registry serves " + served)))
+			Expect(err).To(MatchError(ContainSubstring(signed + " is signed")))
+		})
+
+		It("should reject digests only signed by untrusted roles", func() {
+			sign("targets/other", signed)
+
+			err := ct.VerifyDigest(tag, authn.Anonymous, signed)
+			Expect(err).To(MatchError(ContainSubstring("is only signed by targets/other, not targets/releases or targets")))
+		})
+	})
+
+	Describe("ContentTrustDelegation", func() {
+		It("should accept custom delegations", func() {
+			role, err := resource.ContentTrustDelegation{Role: "targets/some-team"}.RoleName()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role).To(Equal(data.RoleName("targets/some-team")))
+		})
+
+		It("should reject roles not delegated by targets", func() {
+			_, err := resource.ContentTrustDelegation{Role: "targets/some-team/nested"}.RoleName()
+			Expect(err).To(MatchError(ContainSubstring("must be delegated by targets")))
+		})
+	})
+})
+
+func sha256Sum(content string) []byte {
+	sum := sha256.Sum256([]byte(content))
+	return sum[:]
+}
+
+// clientCertificate returns a self-signed TLS client certificate and its key,
+// as PEM, for the notary server.
+func clientCertificate() (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "some-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	der, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
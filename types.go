@@ -8,6 +8,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 const DefaultTag = "latest"
@@ -15,12 +19,92 @@ const DefaultTag = "latest"
 type Source struct {
 	Repository string `json:"repository"`
 	RawTag     Tag    `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+
+	SemverConstraint string   `json:"semver_constraint,omitempty"`
+	TagRegex         string   `json:"tag_regex,omitempty"`
+	Variant          string   `json:"variant,omitempty"`
+	PreReleases      bool     `json:"pre_releases,omitempty"`
+	SortBy           string   `json:"sort_by,omitempty"`
+	IgnoreTags       []string `json:"ignore_tags,omitempty"`
+	TagPageSize      int      `json:"tag_page_size,omitempty"`
+
+	LabelFilters map[string]string `json:"label_filters,omitempty"`
+
+	WebhookHint bool `json:"webhook_hint,omitempty"`
+
+	RegistryMirrors []string `json:"registry_mirrors,omitempty"`
+
+	AllowedRegistries []string `json:"allowed_registries,omitempty"`
+
+	CACerts    []string `json:"ca_certs,omitempty"`
+	ClientCert string   `json:"client_cert,omitempty"`
+	ClientKey  string   `json:"client_key,omitempty"`
+	Insecure   bool     `json:"insecure,omitempty"`
+
+	HTTPProxy  string `json:"http_proxy,omitempty"`
+	HTTPSProxy string `json:"https_proxy,omitempty"`
+	NoProxy    string `json:"no_proxy,omitempty"`
+
+	ExtraHeaders map[string]string `json:"extra_headers,omitempty"`
+
+	ConnectTimeout        string `json:"connect_timeout,omitempty"`
+	ResponseHeaderTimeout string `json:"response_header_timeout,omitempty"`
+	OperationTimeout      string `json:"operation_timeout,omitempty"`
+
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+
+	Platform *Platform `json:"platform,omitempty"`
+
+	CacheDir     string `json:"cache_dir,omitempty"`
+	CacheMaxSize string `json:"cache_max_size,omitempty"`
+
+	InitialDigest string `json:"initial_digest,omitempty"`
+	InitialTag    string `json:"initial_tag,omitempty"`
 
 	Username     string        `json:"username,omitempty"`
 	Password     string        `json:"password,omitempty"`
+	UsernameFile string        `json:"username_file,omitempty"`
+	PasswordFile string        `json:"password_file,omitempty"`
 	ContentTrust *ContentTrust `json:"content_trust,omitempty"`
+	Cosign       *CosignConfig `json:"cosign,omitempty"`
+
+	Attestations *AttestationPolicy `json:"attestations,omitempty"`
+
+	Policy *PolicyConfig `json:"policy,omitempty"`
 
-	Debug bool `json:"debug,omitempty"`
+	MaxImageSize string `json:"max_image_size,omitempty"`
+	MaxAge       string `json:"max_age,omitempty"`
+
+	AwsAccessKeyId     string `json:"aws_access_key_id,omitempty"`
+	AwsSecretAccessKey string `json:"aws_secret_access_key,omitempty"`
+	AwsRegion          string `json:"aws_region,omitempty"`
+	AwsRoleArn         string `json:"aws_role_arn,omitempty"`
+	AwsSessionName     string `json:"aws_session_name,omitempty"`
+	AwsExternalId      string `json:"aws_external_id,omitempty"`
+
+	GcloudServiceAccountKey  string `json:"gcloud_service_account_key,omitempty"`
+	UseGcpDefaultCredentials bool   `json:"use_gcp_default_credentials,omitempty"`
+
+	AzureClientId     string `json:"azure_client_id,omitempty"`
+	AzureClientSecret string `json:"azure_client_secret,omitempty"`
+	AzureTenantId     string `json:"azure_tenant_id,omitempty"`
+
+	CredentialHelper string `json:"credential_helper,omitempty"`
+	DockerConfigJSON string `json:"docker_config_json,omitempty"`
+	GithubToken      string `json:"github_token,omitempty"`
+
+	Vault  *VaultConfig  `json:"vault,omitempty"`
+	Quay   *QuayConfig   `json:"quay,omitempty"`
+	Harbor *HarborConfig `json:"harbor,omitempty"`
+
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	Metrics *Metrics `json:"metrics,omitempty"`
+	Tracing *Tracing `json:"tracing,omitempty"`
+
+	Debug     bool   `json:"debug,omitempty"`
+	LogFormat string `json:"log_format,omitempty"`
 }
 
 type ContentTrust struct {
@@ -30,6 +114,13 @@ type ContentTrust struct {
 	RepositoryPassphrase string `json:"repository_passphrase"`
 	TLSKey               string `json:"tls_key"`
 	TLSCert              string `json:"tls_cert"`
+
+	RepositoryKeyFile        string `json:"repository_key_file"`
+	RepositoryPassphraseFile string `json:"repository_passphrase_file"`
+	TLSKeyFile               string `json:"tls_key_file"`
+	TLSCertFile              string `json:"tls_cert_file"`
+
+	Delegations []ContentTrustDelegation `json:"delegations"`
 }
 
 /* Create notary config directory with following structure
@@ -43,8 +134,13 @@ type ContentTrust struct {
 		└── client.key
 */
 func (ct *ContentTrust) PrepareConfigDir(src string) (string, error) {
+	ct, err := ct.withFiles()
+	if err != nil {
+		return "", err
+	}
+
 	configDir := filepath.Join(src, ".notary")
-	err := os.Mkdir(configDir, os.ModePerm)
+	err = os.Mkdir(configDir, os.ModePerm)
 	if err != nil {
 		return "", err
 	}
@@ -71,8 +167,16 @@ func (ct *ContentTrust) PrepareConfigDir(src string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	repoKey := fmt.Sprintf("%s.key", ct.RepositoryKeyID)
-	err = ioutil.WriteFile(filepath.Join(privateDir, repoKey), []byte(ct.RepositoryKey), 0600)
+	if ct.RepositoryKeyID != "" {
+		// the signing key is only needed for pushing
+		repoKey := fmt.Sprintf("%s.key", ct.RepositoryKeyID)
+		err = ioutil.WriteFile(filepath.Join(privateDir, repoKey), []byte(ct.RepositoryKey), 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = ct.writeDelegationKeys(privateDir)
 	if err != nil {
 		return "", err
 	}
@@ -107,6 +211,164 @@ func (source *Source) Tag() string {
 	return DefaultTag
 }
 
+// PinDigest moves a digest given as part of the repository, e.g.
+// foo/bar@sha256:..., to the digest field, and validates it.
+func (source *Source) PinDigest() error {
+	if i := strings.Index(source.Repository, "@"); i != -1 {
+		digest := source.Repository[i+1:]
+		if source.Digest != "" && source.Digest != digest {
+			return fmt.Errorf("repository digest %s conflicts with digest %s", digest, source.Digest)
+		}
+
+		source.Repository = source.Repository[:i]
+		source.Digest = digest
+	}
+
+	if source.Digest != "" {
+		_, err := v1.NewHash(source.Digest)
+		if err != nil {
+			return fmt.Errorf("invalid digest %q: %s", source.Digest, err)
+		}
+	}
+
+	return nil
+}
+
+// PinnedVersion returns the only version of a digest-pinned source, or nil if
+// the source is not pinned.
+func (source *Source) PinnedVersion() *Version {
+	if source.Digest == "" {
+		return nil
+	}
+
+	return &Version{
+		Digest: source.Digest,
+	}
+}
+
+// Authenticator returns the credentials to use when talking to the registry.
+// Cloud provider credentials take precedence over a static username and
+// password.
+func (source *Source) Authenticator() (authn.Authenticator, error) {
+	if (source.AwsAccessKeyId != "" && source.AwsSecretAccessKey != "") || source.AwsRoleArn != "" {
+		return NewECRAuthenticator(source)
+	}
+
+	if source.GcloudServiceAccountKey != "" {
+		return NewGcloudAuthenticator(source.GcloudServiceAccountKey)
+	}
+
+	if source.UseGcpDefaultCredentials {
+		return NewGcloudMetadataAuthenticator(), nil
+	}
+
+	if source.AzureClientId != "" && source.AzureClientSecret != "" && source.AzureTenantId != "" {
+		return NewACRAuthenticator(source)
+	}
+
+	if source.CredentialHelper != "" {
+		return NewCredentialHelperAuthenticator(source.CredentialHelper, source.Repository)
+	}
+
+	if source.DockerConfigJSON != "" {
+		return NewDockerConfigAuthenticator(source.DockerConfigJSON, source.Repository)
+	}
+
+	if source.GithubToken != "" {
+		return githubTokenAuthenticator(source.Repository, source.GithubToken), nil
+	}
+
+	if source.Vault != nil {
+		username, password, err := source.Vault.Credentials()
+		if err != nil {
+			return nil, err
+		}
+
+		return &authn.Basic{
+			Username: username,
+			Password: password,
+		}, nil
+	}
+
+	if source.Harbor != nil && (source.Harbor.RobotAccount != "" || source.Harbor.RobotSecret != "") {
+		username, password, err := source.Harbor.Credentials()
+		if err != nil {
+			return nil, err
+		}
+
+		return &authn.Basic{
+			Username: username,
+			Password: password,
+		}, nil
+	}
+
+	if source.Quay != nil {
+		username, password, err := source.Quay.Credentials()
+		if err != nil {
+			return nil, err
+		}
+
+		return &authn.Basic{
+			Username: username,
+			Password: password,
+		}, nil
+	}
+
+	username, err := readFileOr(source.UsernameFile, source.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read username: %s", err)
+	}
+
+	password, err := readFileOr(source.PasswordFile, source.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %s", err)
+	}
+
+	if username != "" && password != "" {
+		return &authn.Basic{
+			Username: username,
+			Password: password,
+		}, nil
+	}
+
+	return authn.Anonymous, nil
+}
+
+// readFileOr returns the trimmed contents of the file at path, or value if no
+// path is given.
+func readFileOr(path string, value string) (string, error) {
+	if path == "" {
+		return value, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// InitialVersion returns the seed version check should emit when there are no
+// images to report, or nil if none is configured.
+func (source *Source) InitialVersion() *Version {
+	if source.InitialDigest == "" {
+		return nil
+	}
+
+	return &Version{
+		Tag:    source.InitialTag,
+		Digest: source.InitialDigest,
+	}
+}
+
+// IsInitialVersion determines whether the version is the configured seed
+// version, which does not refer to an actual image.
+func (source *Source) IsInitialVersion(version Version) bool {
+	initial := source.InitialVersion()
+	return initial != nil && initial.Digest == version.Digest
+}
+
 func (source *Source) Metadata() []MetadataField {
 	return []MetadataField{
 		MetadataField{
@@ -138,8 +400,15 @@ type Tag string
 
 // UnmarshalJSON accepts numeric and string values.
 func (tag *Tag) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err == nil {
+		*tag = Tag(s)
+		return nil
+	}
+
 	var n json.Number
-	err := json.Unmarshal(b, &n)
+	err = json.Unmarshal(b, &n)
 	if err != nil {
 		return err
 	}
@@ -149,7 +418,11 @@ func (tag *Tag) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// Version identifies an image by its digest, along with the tag it was
+// discovered through. Versions emitted by older releases of the resource only
+// carry a digest.
 type Version struct {
+	Tag    string `json:"tag,omitempty"`
 	Digest string `json:"digest"`
 }
 
@@ -159,7 +432,68 @@ type MetadataField struct {
 }
 
 type GetParams struct {
-	RawFormat string `json:"format"`
+	RawFormat      string    `json:"format"`
+	SkipDownload   bool      `json:"skip_download"`
+	AdditionalTags []string  `json:"additional_tags"`
+	Platform       *Platform `json:"platform"`
+	AllPlatforms   bool      `json:"all_platforms"`
+
+	StripSetuid     bool  `json:"strip_setuid"`
+	SkipDeviceFiles *bool `json:"skip_device_files"`
+	PreserveXattrs  bool  `json:"preserve_xattrs"`
+
+	MaxConcurrentDownloads int `json:"max_concurrent_downloads"`
+
+	MaxLayerSize string `json:"max_layer_size"`
+	MaxTotalSize string `json:"max_total_size"`
+
+	GenerateSBOM string      `json:"generate_sbom"`
+	Scan         *ScanConfig `json:"scan"`
+
+	Referrers *ReferrersParams `json:"referrers"`
+}
+
+// DefaultConcurrentDownloads is the number of layers downloaded at a time by
+// default.
+const DefaultConcurrentDownloads = 3
+
+// DownloadConcurrency returns the number of layers to download at a time.
+func (p GetParams) DownloadConcurrency() int {
+	if p.MaxConcurrentDownloads == 0 {
+		return DefaultConcurrentDownloads
+	}
+
+	return p.MaxConcurrentDownloads
+}
+
+// SizeLimits returns the maximum size in bytes of each layer and of all of the
+// layers, uncompressed, when extracting the rootfs. 0 means no limit.
+func (p GetParams) SizeLimits() (int64, int64, error) {
+	var layer, total int64
+
+	if p.MaxLayerSize != "" {
+		var err error
+		layer, err = ParseSize(p.MaxLayerSize)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max_layer_size: %s", err)
+		}
+	}
+
+	if p.MaxTotalSize != "" {
+		var err error
+		total, err = ParseSize(p.MaxTotalSize)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max_total_size: %s", err)
+		}
+	}
+
+	return layer, total, nil
+}
+
+// SkipsDeviceFiles determines whether device files should be left out of the
+// rootfs, which is the default, as they can't be created in a user namespace.
+func (p GetParams) SkipsDeviceFiles() bool {
+	return p.SkipDeviceFiles == nil || *p.SkipDeviceFiles
 }
 
 func (p GetParams) Format() string {
@@ -171,16 +505,167 @@ func (p GetParams) Format() string {
 }
 
 type PutParams struct {
-	Image          string `json:"image"`
-	AdditionalTags string `json:"additional_tags"`
+	Image          string            `json:"image"`
+	Images         map[string]string `json:"images"`
+	CopyFrom       *Source           `json:"copy_from"`
+	DigestFile     string            `json:"digest_file"`
+	AdditionalTags AdditionalTags    `json:"additional_tags"`
+
+	Files          []ArtifactFile `json:"files"`
+	ArtifactType   string         `json:"artifact_type"`
+	ArtifactConfig *ArtifactFile  `json:"artifact_config"`
+
+	AdditionalRepositories AdditionalRepositories `json:"additional_repositories"`
+
+	RecompressZstd bool                   `json:"recompress_zstd"`
+	PushByDigest   bool                   `json:"push_by_digest"`
+	TagFile        string                 `json:"tag_file"`
+	TagPrefix      string                 `json:"tag_prefix"`
+	TagSuffix      string                 `json:"tag_suffix"`
+	BumpAliases    bool                   `json:"bump_aliases"`
+	BumpLatest     bool                   `json:"bump_latest"`
+	Labels         map[string]ValueOrFile `json:"labels"`
+	Annotations    map[string]ValueOrFile `json:"annotations"`
+
+	AddBuildMetadataLabels bool   `json:"add_build_metadata_labels"`
+	BuildMetadataGit       string `json:"build_metadata_git"`
+
+	Cosign       *CosignSigning `json:"cosign"`
+	SBOM         string         `json:"sbom"`
+	GenerateSBOM string         `json:"generate_sbom"`
+
+	Provenance         string `json:"provenance"`
+	GenerateProvenance bool   `json:"generate_provenance"`
+
+	Attach []Attachment `json:"attach"`
+
+	UploadChunkSize      string   `json:"upload_chunk_size"`
+	MaxConcurrentUploads int      `json:"max_concurrent_uploads"`
+	MountFrom            []string `json:"mount_from"`
+
+	Compression      string `json:"compression"`
+	CompressionLevel int    `json:"compression_level"`
+
+	OutputPath string `json:"output_path"`
+
+	CreateRepository   bool                   `json:"create_repository"`
+	RepositorySettings *ECRRepositorySettings `json:"repository_settings"`
+
+	OnImmutableConflict string `json:"on_immutable_conflict"`
+	OnPushFailure       string `json:"on_push_failure"`
+}
+
+// UploadConcurrency returns the number of blobs to upload at a time.
+func (p PutParams) UploadConcurrency() int {
+	if p.MaxConcurrentUploads == 0 {
+		return DefaultConcurrentUploads
+	}
+
+	return p.MaxConcurrentUploads
+}
+
+// ChunkSize returns the size in bytes of the chunks to upload blobs in, or 0
+// to upload them in one request.
+func (p PutParams) ChunkSize() (int64, error) {
+	if p.UploadChunkSize == "" {
+		return 0, nil
+	}
+
+	size, err := ParseSize(p.UploadChunkSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload_chunk_size: %s", err)
+	}
+
+	return size, nil
+}
+
+// PlatformImages returns the paths of the images to push for each platform in
+// multi-arch mode, i.e. when images is given rather than image, copy_from, or
+// digest_file.
+func (p PutParams) PlatformImages() (map[Platform]string, error) {
+	given := 0
+	for _, isGiven := range []bool{p.Image != "", len(p.Images) > 0, p.CopyFrom != nil, p.DigestFile != "", len(p.Files) > 0} {
+		if isGiven {
+			given++
+		}
+	}
+
+	if given == 0 {
+		return nil, fmt.Errorf("no image specified")
+	}
+
+	if given > 1 {
+		return nil, fmt.Errorf("image, images, copy_from, digest_file, and files are mutually exclusive")
+	}
+
+	if (p.ArtifactType != "" || p.ArtifactConfig != nil) && len(p.Files) == 0 {
+		return nil, fmt.Errorf("artifact_type and artifact_config require files")
+	}
+
+	images := map[Platform]string{}
+	for key, path := range p.Images {
+		platform, err := ParsePlatform(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, found := images[platform]; found {
+			return nil, fmt.Errorf("multiple images given for %s", platform)
+		}
+
+		images[platform] = path
+	}
+
+	return images, nil
+}
+
+// ParseTag returns the tag to push the image under: the contents of the
+// tag_file if given, or else the source's tag, with the tag_prefix and
+// tag_suffix added.
+func (p *PutParams) ParseTag(src string, source Source) (string, error) {
+	tag := source.Tag()
+
+	if p.TagFile != "" {
+		filepath := filepath.Join(src, p.TagFile)
+
+		content, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read file at %q: %s", filepath, err)
+		}
+
+		tag = strings.TrimSpace(string(content))
+		if tag == "" {
+			return "", fmt.Errorf("tag file %q is empty", filepath)
+		}
+	}
+
+	return p.TagPrefix + tag + p.TagSuffix, nil
+}
+
+// ParseDigest returns the digest given in the digest_file, i.e. that of the
+// image in the repository to tag.
+func (p *PutParams) ParseDigest(src string) (v1.Hash, error) {
+	filepath := filepath.Join(src, p.DigestFile)
+
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("failed to read file at %q: %s", filepath, err)
+	}
+
+	digest, err := v1.NewHash(strings.TrimSpace(string(content)))
+	if err != nil {
+		return v1.Hash{}, fmt.Errorf("invalid digest in %q: %s", filepath, err)
+	}
+
+	return digest, nil
 }
 
 func (p *PutParams) ParseTags(src string) ([]string, error) {
-	if p.AdditionalTags == "" {
-		return nil, nil
+	if p.AdditionalTags.File == "" {
+		return p.AdditionalTags.Tags, nil
 	}
 
-	filepath := filepath.Join(src, p.AdditionalTags)
+	filepath := filepath.Join(src, p.AdditionalTags.File)
 
 	content, err := ioutil.ReadFile(filepath)
 	if err != nil {
@@ -189,3 +674,156 @@ func (p *PutParams) ParseTags(src string) ([]string, error) {
 
 	return strings.Fields(string(content)), nil
 }
+
+// AdditionalTags are given either as the path to a file containing
+// whitespace-separated tags, or as a list of tags.
+type AdditionalTags struct {
+	File string
+	Tags []string
+}
+
+// UnmarshalJSON accepts a string, i.e. a file path, or a list of tags.
+func (tags *AdditionalTags) UnmarshalJSON(b []byte) error {
+	var file string
+	err := json.Unmarshal(b, &file)
+	if err == nil {
+		*tags = AdditionalTags{File: file}
+		return nil
+	}
+
+	var list []string
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		return fmt.Errorf("additional_tags must be a file path or a list of tags")
+	}
+
+	*tags = AdditionalTags{Tags: list}
+
+	return nil
+}
+
+// MarshalJSON marshals the file path, or else the list of tags.
+func (tags AdditionalTags) MarshalJSON() ([]byte, error) {
+	if tags.Tags != nil {
+		return json.Marshal(tags.Tags)
+	}
+
+	return json.Marshal(tags.File)
+}
+
+// ParseRepositories returns the additional repositories to push the image to.
+// Repositories given by name use the source's credentials if they're in the
+// same registry, and none otherwise.
+func (p *PutParams) ParseRepositories(src string, source Source) ([]Source, error) {
+	names := p.AdditionalRepositories.Names
+	if p.AdditionalRepositories.File != "" {
+		filepath := filepath.Join(src, p.AdditionalRepositories.File)
+
+		content, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file at %q: %s", filepath, err)
+		}
+
+		names = strings.Fields(string(content))
+	}
+
+	primary, err := name.NewRepository(source.Repository, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []Source
+	for _, repository := range names {
+		repo, err := name.NewRepository(repository, name.WeakValidation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid additional repository %q: %s", repository, err)
+		}
+
+		additional := Source{Repository: repository}
+		if repo.RegistryStr() == primary.RegistryStr() {
+			additional = source
+			additional.Repository = repository
+		}
+
+		repos = append(repos, additional)
+	}
+
+	for _, additional := range p.AdditionalRepositories.Sources {
+		_, err := name.NewRepository(additional.Repository, name.WeakValidation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid additional repository %q: %s", additional.Repository, err)
+		}
+
+		repos = append(repos, additional)
+	}
+
+	return repos, nil
+}
+
+// AdditionalRepositories are given either as the path to a file containing
+// whitespace-separated repositories, or as a list of repositories, each either
+// a name or a source with its own credentials.
+type AdditionalRepositories struct {
+	File    string
+	Names   []string
+	Sources []Source
+}
+
+// UnmarshalJSON accepts a string, i.e. a file path, or a list of names and
+// sources.
+func (repos *AdditionalRepositories) UnmarshalJSON(b []byte) error {
+	var file string
+	err := json.Unmarshal(b, &file)
+	if err == nil {
+		*repos = AdditionalRepositories{File: file}
+		return nil
+	}
+
+	var list []json.RawMessage
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		return fmt.Errorf("additional_repositories must be a file path or a list of repositories")
+	}
+
+	*repos = AdditionalRepositories{}
+	for _, entry := range list {
+		var repository string
+		if json.Unmarshal(entry, &repository) == nil {
+			repos.Names = append(repos.Names, repository)
+			continue
+		}
+
+		var source Source
+		err = json.Unmarshal(entry, &source)
+		if err != nil {
+			return fmt.Errorf("additional_repositories must be names or sources: %s", err)
+		}
+
+		if source.Repository == "" {
+			return fmt.Errorf("additional_repositories require repository")
+		}
+
+		repos.Sources = append(repos.Sources, source)
+	}
+
+	return nil
+}
+
+// MarshalJSON marshals the file path, or else the names followed by the
+// sources.
+func (repos AdditionalRepositories) MarshalJSON() ([]byte, error) {
+	if repos.File != "" {
+		return json.Marshal(repos.File)
+	}
+
+	list := []interface{}{}
+	for _, repository := range repos.Names {
+		list = append(list, repository)
+	}
+
+	for _, source := range repos.Sources {
+		list = append(list, source)
+	}
+
+	return json.Marshal(list)
+}
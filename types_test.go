@@ -2,7 +2,12 @@ package resource_test
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -64,3 +69,399 @@ var _ = Describe("Source", func() {
 		Expect(json).To(MatchJSON(`{"repository":"foo","tag":"0"}`))
 	})
 })
+
+var _ = Describe("Authenticator", func() {
+	It("should be anonymous when no credentials are configured", func() {
+		source := resource.Source{Repository: "foo"}
+
+		auth, err := source.Authenticator()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(authn.Anonymous))
+	})
+
+	It("should use basic auth with a username and password", func() {
+		source := resource.Source{Repository: "foo", Username: "user", Password: "pass"}
+
+		auth, err := source.Authenticator()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(&authn.Basic{Username: "user", Password: "pass"}))
+	})
+
+	It("should read the username and password from files", func() {
+		dir, err := ioutil.TempDir("", "credentials")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		usernameFile := filepath.Join(dir, "username")
+		Expect(ioutil.WriteFile(usernameFile, []byte("user\n"), 0600)).To(Succeed())
+
+		passwordFile := filepath.Join(dir, "password")
+		Expect(ioutil.WriteFile(passwordFile, []byte("pass\n"), 0600)).To(Succeed())
+
+		source := resource.Source{
+			Repository:   "foo",
+			UsernameFile: usernameFile,
+			PasswordFile: passwordFile,
+		}
+
+		auth, err := source.Authenticator()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auth).To(Equal(&authn.Basic{Username: "user", Password: "pass"}))
+	})
+
+	It("should fail when the password file is missing", func() {
+		source := resource.Source{
+			Repository:   "foo",
+			Username:     "user",
+			PasswordFile: "/does/not/exist",
+		}
+
+		_, err := source.Authenticator()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail with a malformed gcloud service account key", func() {
+		source := resource.Source{Repository: "gcr.io/foo/bar", GcloudServiceAccountKey: "{"}
+
+		_, err := source.Authenticator()
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("InitialVersion", func() {
+	It("should not have a seed version by default", func() {
+		source := resource.Source{Repository: "foo"}
+
+		Expect(source.InitialVersion()).To(BeNil())
+		Expect(source.IsInitialVersion(resource.Version{Digest: "sha256:abc"})).To(BeFalse())
+	})
+
+	It("should seed the configured digest and tag", func() {
+		source := resource.Source{Repository: "foo", InitialDigest: "sha256:abc", InitialTag: "initial"}
+
+		Expect(source.InitialVersion()).To(Equal(&resource.Version{Tag: "initial", Digest: "sha256:abc"}))
+		Expect(source.IsInitialVersion(resource.Version{Digest: "sha256:abc"})).To(BeTrue())
+		Expect(source.IsInitialVersion(resource.Version{Digest: "sha256:def"})).To(BeFalse())
+	})
+})
+
+var _ = Describe("PinDigest", func() {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	It("should not pin a digest by default", func() {
+		source := resource.Source{Repository: "foo/bar"}
+
+		Expect(source.PinDigest()).To(Succeed())
+		Expect(source.PinnedVersion()).To(BeNil())
+	})
+
+	It("should pin a digest given with the repository", func() {
+		source := resource.Source{Repository: "foo/bar@" + digest}
+
+		Expect(source.PinDigest()).To(Succeed())
+		Expect(source.Repository).To(Equal("foo/bar"))
+		Expect(source.PinnedVersion()).To(Equal(&resource.Version{Digest: digest}))
+	})
+
+	It("should pin the digest field", func() {
+		source := resource.Source{Repository: "foo/bar", Digest: digest}
+
+		Expect(source.PinDigest()).To(Succeed())
+		Expect(source.PinnedVersion()).To(Equal(&resource.Version{Digest: digest}))
+	})
+
+	It("should fail with conflicting digests", func() {
+		source := resource.Source{Repository: "foo/bar@" + digest, Digest: "sha256:" + strings.Repeat("b", 64)}
+
+		Expect(source.PinDigest()).ToNot(Succeed())
+	})
+
+	It("should fail with an invalid digest", func() {
+		source := resource.Source{Repository: "foo/bar", Digest: "sha256:nope"}
+
+		Expect(source.PinDigest()).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("SizeLimits", func() {
+	It("should not limit sizes by default", func() {
+		layer, total, err := resource.GetParams{}.SizeLimits()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layer).To(BeZero())
+		Expect(total).To(BeZero())
+	})
+
+	It("should parse the limits with units", func() {
+		layer, total, err := resource.GetParams{MaxLayerSize: "512MB", MaxTotalSize: "2GB"}.SizeLimits()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(layer).To(Equal(int64(512 << 20)))
+		Expect(total).To(Equal(int64(2 << 30)))
+	})
+
+	It("should fail with an invalid limit", func() {
+		_, _, err := resource.GetParams{MaxTotalSize: "lots"}.SizeLimits()
+		Expect(err).To(MatchError(ContainSubstring("invalid max_total_size")))
+	})
+})
+
+var _ = Describe("ChunkSize", func() {
+	It("should upload blobs in one request by default", func() {
+		size, err := resource.PutParams{}.ChunkSize()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(size).To(BeZero())
+	})
+
+	It("should parse the size with units", func() {
+		size, err := resource.PutParams{UploadChunkSize: "512MB"}.ChunkSize()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(size).To(Equal(int64(512 << 20)))
+	})
+
+	It("should fail with an invalid size", func() {
+		_, err := resource.PutParams{UploadChunkSize: "lots"}.ChunkSize()
+		Expect(err).To(MatchError(ContainSubstring("invalid upload_chunk_size")))
+	})
+})
+
+var _ = Describe("PlatformImages", func() {
+	It("should parse the platform of each image", func() {
+		images, err := resource.PutParams{Images: map[string]string{"amd64": "amd64/image.tar", "linux/arm/v7": "armv7"}}.PlatformImages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(Equal(map[resource.Platform]string{
+			{OS: "linux", Architecture: "amd64"}:              "amd64/image.tar",
+			{OS: "linux", Architecture: "arm", Variant: "v7"}: "armv7",
+		}))
+	})
+
+	It("should have no images when pushing a single image", func() {
+		images, err := resource.PutParams{Image: "image.tar"}.PlatformImages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(BeEmpty())
+	})
+
+	It("should require exactly one image", func() {
+		_, err := resource.PutParams{}.PlatformImages()
+		Expect(err).To(MatchError("no image specified"))
+
+		_, err = resource.PutParams{Image: "image.tar", Images: map[string]string{"amd64": "image.tar"}}.PlatformImages()
+		Expect(err).To(MatchError("image, images, copy_from, digest_file, and files are mutually exclusive"))
+	})
+
+	It("should have no images when copying an image", func() {
+		images, err := resource.PutParams{CopyFrom: &resource.Source{Repository: "some/repo"}}.PlatformImages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(BeEmpty())
+
+		_, err = resource.PutParams{Image: "image.tar", CopyFrom: &resource.Source{Repository: "some/repo"}}.PlatformImages()
+		Expect(err).To(MatchError("image, images, copy_from, digest_file, and files are mutually exclusive"))
+	})
+
+	It("should have no images when tagging an existing image", func() {
+		images, err := resource.PutParams{DigestFile: "image/digest"}.PlatformImages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(BeEmpty())
+	})
+
+	It("should have no images when pushing an artifact", func() {
+		images, err := resource.PutParams{Files: []resource.ArtifactFile{{Path: "chart.tgz"}}, ArtifactType: "application/vnd.example"}.PlatformImages()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(images).To(BeEmpty())
+
+		_, err = resource.PutParams{Image: "image.tar", Files: []resource.ArtifactFile{{Path: "chart.tgz"}}}.PlatformImages()
+		Expect(err).To(MatchError("image, images, copy_from, digest_file, and files are mutually exclusive"))
+
+		_, err = resource.PutParams{Image: "image.tar", ArtifactType: "application/vnd.example"}.PlatformImages()
+		Expect(err).To(MatchError("artifact_type and artifact_config require files"))
+	})
+
+	It("should reject multiple images for a platform", func() {
+		_, err := resource.PutParams{Images: map[string]string{"amd64": "a", "linux/amd64": "b"}}.PlatformImages()
+		Expect(err).To(MatchError("multiple images given for linux/amd64"))
+	})
+})
+
+var _ = Describe("ParseDigest", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "digest-file")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should read the digest from the file", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "digest"), []byte("sha256:"+strings.Repeat("a", 64)+"\n"), 0644)).To(Succeed())
+
+		digest, err := (&resource.PutParams{DigestFile: "digest"}).ParseDigest(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(digest.Hex).To(Equal(strings.Repeat("a", 64)))
+	})
+
+	It("should reject an invalid digest", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "digest"), []byte("latest"), 0644)).To(Succeed())
+
+		_, err := (&resource.PutParams{DigestFile: "digest"}).ParseDigest(dir)
+		Expect(err).To(MatchError(ContainSubstring("invalid digest in")))
+	})
+})
+
+var _ = Describe("AdditionalTags", func() {
+	parse := func(params string) []string {
+		var p resource.PutParams
+		Expect(json.Unmarshal([]byte(params), &p)).To(Succeed())
+
+		tags, err := p.ParseTags("")
+		Expect(err).ToNot(HaveOccurred())
+		return tags
+	}
+
+	It("should accept a list of tags", func() {
+		Expect(parse(`{"additional_tags":["latest","1.2.3"]}`)).To(Equal([]string{"latest", "1.2.3"}))
+	})
+
+	It("should accept the path to a file of tags", func() {
+		dir, err := ioutil.TempDir("", "additional-tags")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer os.RemoveAll(dir)
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "tags"), []byte("latest\n1.2.3 1.2\n"), 0644)).To(Succeed())
+
+		var p resource.PutParams
+		Expect(json.Unmarshal([]byte(`{"additional_tags":"tags"}`), &p)).To(Succeed())
+
+		tags, err := p.ParseTags(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tags).To(Equal([]string{"latest", "1.2.3", "1.2"}))
+	})
+
+	It("should have no tags by default", func() {
+		Expect(parse(`{}`)).To(BeEmpty())
+	})
+
+	It("should reject other values", func() {
+		var p resource.PutParams
+		err := json.Unmarshal([]byte(`{"additional_tags":{"latest":true}}`), &p)
+		Expect(err).To(MatchError(ContainSubstring("additional_tags must be a file path or a list of tags")))
+	})
+})
+
+var _ = Describe("AdditionalRepositories", func() {
+	source := resource.Source{
+		Repository: "registry.example.com/team/app",
+		Username:   "some-username",
+		Password:   "some-password",
+	}
+
+	parse := func(params string) []resource.Source {
+		var p resource.PutParams
+		Expect(json.Unmarshal([]byte(params), &p)).To(Succeed())
+
+		repos, err := p.ParseRepositories("", source)
+		Expect(err).ToNot(HaveOccurred())
+		return repos
+	}
+
+	It("should use the source's credentials for repositories in the same registry", func() {
+		repos := parse(`{"additional_repositories":["registry.example.com/team/mirror"]}`)
+		Expect(repos).To(HaveLen(1))
+		Expect(repos[0].Repository).To(Equal("registry.example.com/team/mirror"))
+		Expect(repos[0].Username).To(Equal("some-username"))
+		Expect(repos[0].Password).To(Equal("some-password"))
+	})
+
+	It("should use no credentials for repositories in other registries", func() {
+		Expect(parse(`{"additional_repositories":["other.example.com/team/app"]}`)).To(Equal([]resource.Source{
+			{Repository: "other.example.com/team/app"},
+		}))
+	})
+
+	It("should accept repositories with their own credentials", func() {
+		repos := parse(`{"additional_repositories":[{"repository":"123456789012.dkr.ecr.eu-west-1.amazonaws.com/app","aws_access_key_id":"some-key","aws_secret_access_key":"some-secret"}]}`)
+		Expect(repos).To(Equal([]resource.Source{
+			{
+				Repository:         "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app",
+				AwsAccessKeyId:     "some-key",
+				AwsSecretAccessKey: "some-secret",
+			},
+		}))
+	})
+
+	It("should accept the path to a file of repositories", func() {
+		dir, err := ioutil.TempDir("", "additional-repositories")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer os.RemoveAll(dir)
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "repositories"), []byte("other.example.com/api\nother.example.com/web\n"), 0644)).To(Succeed())
+
+		var p resource.PutParams
+		Expect(json.Unmarshal([]byte(`{"additional_repositories":"repositories"}`), &p)).To(Succeed())
+
+		repos, err := p.ParseRepositories(dir, source)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(repos).To(Equal([]resource.Source{
+			{Repository: "other.example.com/api"},
+			{Repository: "other.example.com/web"},
+		}))
+	})
+
+	It("should have no repositories by default", func() {
+		Expect(parse(`{}`)).To(BeEmpty())
+	})
+
+	It("should reject sources without a repository", func() {
+		var p resource.PutParams
+		err := json.Unmarshal([]byte(`{"additional_repositories":[{"username":"some-username"}]}`), &p)
+		Expect(err).To(MatchError(ContainSubstring("additional_repositories require repository")))
+	})
+
+	It("should reject invalid repositories", func() {
+		var p resource.PutParams
+		Expect(json.Unmarshal([]byte(`{"additional_repositories":["Not/A/Valid:Repository"]}`), &p)).To(Succeed())
+
+		_, err := p.ParseRepositories("", source)
+		Expect(err).To(MatchError(ContainSubstring("invalid additional repository")))
+	})
+})
+
+var _ = Describe("ParseTag", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tag-file")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "version"), []byte("1.2.3\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should default to the source's tag", func() {
+		tag, err := (&resource.PutParams{}).ParseTag(dir, resource.Source{RawTag: "stable"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tag).To(Equal("stable"))
+	})
+
+	It("should read the tag from the tag file, with the prefix and suffix", func() {
+		params := resource.PutParams{TagFile: "version", TagPrefix: "v", TagSuffix: "-alpine"}
+
+		tag, err := params.ParseTag(dir, resource.Source{RawTag: "stable"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tag).To(Equal("v1.2.3-alpine"))
+	})
+
+	It("should reject an empty tag file", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "version"), []byte("\n"), 0644)).To(Succeed())
+
+		_, err := (&resource.PutParams{TagFile: "version"}).ParseTag(dir, resource.Source{})
+		Expect(err).To(MatchError(ContainSubstring("is empty")))
+	})
+})
@@ -0,0 +1,144 @@
+package resource
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultConcurrentUploads is the number of blobs uploaded at a time by
+// default, as for Docker.
+const DefaultConcurrentUploads = 5
+
+// UploadLimitTransport limits the number of blobs uploaded at a time.
+// go-containerregistry uploads all of an image's layers at once, which can
+// overwhelm the registry or the network for images with many layers.
+type UploadLimitTransport struct {
+	Inner http.RoundTripper
+
+	MaxConcurrent int
+
+	init  sync.Once
+	slots chan struct{}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *UploadLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch || t.MaxConcurrent <= 0 || !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		return t.Inner.RoundTrip(req)
+	}
+
+	t.init.Do(func() {
+		t.slots = make(chan struct{}, t.MaxConcurrent)
+	})
+
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	defer func() { <-t.slots }()
+
+	return t.Inner.RoundTrip(req)
+}
+
+// ChunkedUploadTransport splits blob uploads, which go-containerregistry
+// streams in a single PATCH request, into PATCH requests of at most ChunkSize
+// bytes, e.g. for proxies which reject large request bodies.
+type ChunkedUploadTransport struct {
+	Inner http.RoundTripper
+
+	ChunkSize int64
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ChunkedUploadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch || req.Body == nil || t.ChunkSize <= 0 || !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		return t.Inner.RoundTrip(req)
+	}
+
+	defer req.Body.Close()
+
+	// chunks are sent before the next is read, so two buffers suffice
+	buffers := [2][]byte{make([]byte, t.ChunkSize), make([]byte, t.ChunkSize)}
+
+	chunk, err := readChunk(req.Body, buffers[0])
+	if err != nil {
+		return nil, err
+	}
+
+	location := req.URL
+	offset := int64(0)
+
+	for i := 1; ; i++ {
+		res, err := t.send(req, location, chunk, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusAccepted {
+			// fail as the monolithic upload would have
+			return res, nil
+		}
+
+		next, err := readChunk(req.Body, buffers[i%2])
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+
+		if len(next) == 0 {
+			// the location to commit the upload to is taken from the last
+			// response
+			return res, nil
+		}
+
+		res.Body.Close()
+
+		nextLocation, err := url.Parse(res.Header.Get("Location"))
+		if err != nil || res.Header.Get("Location") == "" {
+			return nil, fmt.Errorf("invalid Location for next chunk: %q", res.Header.Get("Location"))
+		}
+
+		location = location.ResolveReference(nextLocation)
+		offset += int64(len(chunk))
+		chunk = next
+	}
+}
+
+func (t *ChunkedUploadTransport) send(req *http.Request, location *url.URL, chunk []byte, offset int64) (*http.Response, error) {
+	chunkReq, err := http.NewRequest(http.MethodPatch, location.String(), bytes.NewReader(chunk))
+	if err != nil {
+		return nil, err
+	}
+
+	chunkReq = chunkReq.WithContext(req.Context())
+
+	for key, values := range req.Header {
+		chunkReq.Header[key] = values
+	}
+
+	chunkReq.Header.Set("Content-Type", "application/octet-stream")
+
+	if len(chunk) > 0 {
+		chunkReq.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+	}
+
+	return t.Inner.RoundTrip(chunkReq)
+}
+
+// readChunk reads the next chunk of the body into buf, returning an empty
+// chunk when it has been read entirely.
+func readChunk(body io.Reader, buf []byte) ([]byte, error) {
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	return buf[:n], err
+}
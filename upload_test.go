@@ -0,0 +1,133 @@
+package resource_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("ChunkedUploadTransport", func() {
+	var registry *fakeRegistry
+	var ref name.Tag
+
+	BeforeEach(func() {
+		registry = newFakeRegistry()
+
+		var err error
+		ref, err = name.NewTag(registry.Host()+"/some/repo:latest", name.WeakValidation)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		registry.Close()
+	})
+
+	push := func(chunkSize int64) {
+		image, err := random.Image(4096, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		tr := resource.NewTokenTransport(ref.Registry, authn.Anonymous, &resource.ChunkedUploadTransport{
+			Inner:     http.DefaultTransport,
+			ChunkSize: chunkSize,
+		}, []string{ref.Scope(transport.PushScope)})
+
+		// the registry verifies the digest of each blob as it is committed
+		Expect(resource.Write(ref, image, tr)).To(Succeed())
+
+		_, found := registry.Manifest("some/repo", "latest")
+		Expect(found).To(BeTrue())
+	}
+
+	It("should upload blobs in chunks", func() {
+		push(1000)
+
+		// the layer, at least 4096 bytes, followed by the config
+		Expect(len(registry.Patches)).To(BeNumerically(">", 5))
+		for _, size := range registry.Patches {
+			Expect(size).To(BeNumerically("<=", 1000))
+		}
+	})
+
+	It("should upload blobs smaller than a chunk in one request", func() {
+		push(1 << 20)
+
+		Expect(registry.Patches).To(HaveLen(2))
+	})
+})
+
+// roundTripperFunc implements http.RoundTripper with a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var _ = Describe("UploadLimitTransport", func() {
+	var lock sync.Mutex
+	var inFlight, maxInFlight int
+
+	var limiter *resource.UploadLimitTransport
+
+	BeforeEach(func() {
+		inFlight, maxInFlight = 0, 0
+
+		limiter = &resource.UploadLimitTransport{
+			Inner: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				lock.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				lock.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				lock.Lock()
+				inFlight--
+				lock.Unlock()
+
+				return httptest.NewRecorder().Result(), nil
+			}),
+			MaxConcurrent: 2,
+		}
+	})
+
+	send := func(method string, path string) {
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				req, err := http.NewRequest(method, "https://registry.example.com"+path, nil)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = limiter.RoundTrip(req)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+		}
+
+		wg.Wait()
+	}
+
+	It("should limit the number of blobs uploaded at a time", func() {
+		send(http.MethodPatch, "/v2/some/repo/blobs/uploads/some-upload")
+		Expect(maxInFlight).To(Equal(2))
+	})
+
+	It("should not limit other requests", func() {
+		send(http.MethodHead, "/v2/some/repo/blobs/sha256:abc")
+		Expect(maxInFlight).To(BeNumerically(">", 2))
+	})
+})
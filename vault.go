@@ -0,0 +1,207 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// vaultTokenRefreshMargin is how long before its expiry a cached Vault token
+// is considered stale.
+const vaultTokenRefreshMargin = time.Minute
+
+// VaultConfig configures fetching registry credentials from a Vault secret,
+// logging in via AppRole.
+type VaultConfig struct {
+	Address   string `json:"address"`
+	AuthMount string `json:"auth_mount,omitempty"`
+	RoleID    string `json:"role_id"`
+	SecretID  string `json:"secret_id,omitempty"`
+
+	Path        string `json:"path"`
+	UsernameKey string `json:"username_key,omitempty"`
+	PasswordKey string `json:"password_key,omitempty"`
+}
+
+type vaultToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Credentials reads the username and password from the configured secret.
+// Both KV version 1 and 2 secrets engines are supported.
+func (config *VaultConfig) Credentials() (string, string, error) {
+	token, cached, err := config.token()
+	if err != nil {
+		return "", "", err
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+
+	path := "/v1/" + strings.TrimPrefix(config.Path, "/")
+
+	err = config.request(http.MethodGet, path, token, nil, &secret)
+	if statusErr, ok := err.(vaultStatusError); ok && cached && statusErr.StatusCode == http.StatusForbidden {
+		// the cached token was revoked before its lease expired
+		logrus.Debug("cached vault token was rejected; logging in again")
+
+		err = os.Remove(config.tokenCachePath())
+		if err != nil {
+			return "", "", fmt.Errorf("failed to remove cached vault token: %s", err)
+		}
+
+		token, _, err = config.token()
+		if err != nil {
+			return "", "", err
+		}
+
+		err = config.request(http.MethodGet, path, token, nil, &secret)
+	}
+
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read secret %s: %s", config.Path, err)
+	}
+
+	data := secret.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			// kv v2 nests the secret's data alongside its metadata
+			data = inner
+		}
+	}
+
+	usernameKey := config.UsernameKey
+	if usernameKey == "" {
+		usernameKey = "username"
+	}
+
+	passwordKey := config.PasswordKey
+	if passwordKey == "" {
+		passwordKey = "password"
+	}
+
+	username, _ := data[usernameKey].(string)
+	password, _ := data[passwordKey].(string)
+
+	if username == "" || password == "" {
+		return "", "", fmt.Errorf("secret %s does not contain %q and %q", config.Path, usernameKey, passwordKey)
+	}
+
+	return username, password, nil
+}
+
+// token returns a Vault token, reusing one cached by an earlier invocation
+// (e.g. a previous check) if it has not yet expired, and whether it did.
+func (config *VaultConfig) token() (string, bool, error) {
+	cachePath := config.tokenCachePath()
+
+	var cached vaultToken
+	content, err := ioutil.ReadFile(cachePath)
+	if err == nil && json.Unmarshal(content, &cached) == nil {
+		if time.Now().Add(vaultTokenRefreshMargin).Before(cached.ExpiresAt) {
+			logrus.Debug("using cached vault token")
+			return cached.Token, true, nil
+		}
+	}
+
+	mount := config.AuthMount
+	if mount == "" {
+		mount = "approle"
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+
+	err = config.request(http.MethodPost, "/v1/auth/"+mount+"/login", "", map[string]string{
+		"role_id":   config.RoleID,
+		"secret_id": config.SecretID,
+	}, &login)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to log in to vault: %s", err)
+	}
+
+	token := vaultToken{
+		Token:     login.Auth.ClientToken,
+		ExpiresAt: time.Now().Add(time.Duration(login.Auth.LeaseDuration) * time.Second),
+	}
+
+	content, err = json.Marshal(token)
+	if err == nil {
+		err = ioutil.WriteFile(cachePath, content, 0600)
+	}
+
+	if err != nil {
+		logrus.Warnf("failed to cache vault token: %s", err)
+	}
+
+	return token.Token, false, nil
+}
+
+// tokenCachePath returns the path the token is cached at, keyed by the
+// credentials it was obtained with, so that rotating them logs in again.
+func (config *VaultConfig) tokenCachePath() string {
+	key := sha256.Sum256([]byte(config.Address + "\x00" + config.AuthMount + "\x00" + config.RoleID + "\x00" + config.SecretID))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("registry-image-resource-vault-%x.json", key[:8]))
+}
+
+func (config *VaultConfig) request(method string, path string, token string, body interface{}, dest interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(config.Address, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	// BaseTransport, so that Vault is reachable with the source's ca_certs
+	// and proxies
+	client := &http.Client{Transport: BaseTransport}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return vaultStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// vaultStatusError is returned for unexpected Vault response statuses.
+type vaultStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (err vaultStatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %s", err.Status)
+}
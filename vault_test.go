@@ -0,0 +1,183 @@
+package resource_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+var _ = Describe("VaultConfig", func() {
+	var server *httptest.Server
+	var logins int
+	var validToken string
+
+	var tmpDir string
+	var oldTmpDir string
+
+	var config *resource.VaultConfig
+
+	BeforeEach(func() {
+		logins = 0
+		validToken = "some-token"
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			switch r.URL.Path {
+			case "/v1/auth/approle/login":
+				var body map[string]string
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				Expect(body).To(Equal(map[string]string{"role_id": "some-role", "secret_id": config.SecretID}))
+
+				logins++
+				fmt.Fprintf(w, `{"auth":{"client_token":%q,"lease_duration":3600}}`, validToken)
+			case "/v1/secret/data/registry":
+				if r.Header.Get("X-Vault-Token") != validToken {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				fmt.Fprint(w, `{"data":{"data":{"username":"user","password":"pass"},"metadata":{"version":1}}}`)
+			case "/v1/kv/registry":
+				Expect(r.Header.Get("X-Vault-Token")).To(Equal("some-token"))
+				fmt.Fprint(w, `{"data":{"user":"v1-user","token":"v1-pass"}}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		var err error
+		tmpDir, err = ioutil.TempDir("", "vault-cache")
+		Expect(err).ToNot(HaveOccurred())
+
+		oldTmpDir = os.Getenv("TMPDIR")
+		os.Setenv("TMPDIR", tmpDir)
+
+		config = &resource.VaultConfig{
+			Address:  server.URL,
+			RoleID:   "some-role",
+			SecretID: "some-secret",
+			Path:     "secret/data/registry",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Setenv("TMPDIR", oldTmpDir)
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("should read credentials from a kv v2 secret", func() {
+		username, password, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("pass"))
+	})
+
+	It("should read credentials from a kv v1 secret with custom keys", func() {
+		config.Path = "kv/registry"
+		config.UsernameKey = "user"
+		config.PasswordKey = "token"
+
+		username, password, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(username).To(Equal("v1-user"))
+		Expect(password).To(Equal("v1-pass"))
+	})
+
+	It("should reuse the token across invocations", func() {
+		_, _, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, _, err = config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(logins).To(Equal(1))
+	})
+
+	It("should log in again when the cached token has been revoked", func() {
+		_, _, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+
+		validToken = "some-other-token"
+
+		username, _, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(username).To(Equal("user"))
+		Expect(logins).To(Equal(2))
+
+		_, _, err = config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logins).To(Equal(2))
+	})
+
+	It("should fail when a fresh token is rejected", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/auth/approle/login" {
+				logins++
+				fmt.Fprint(w, `{"auth":{"client_token":"some-token","lease_duration":3600}}`)
+				return
+			}
+
+			w.WriteHeader(http.StatusForbidden)
+		})
+
+		_, _, err := config.Credentials()
+		Expect(err).To(MatchError(ContainSubstring("403 Forbidden")))
+		Expect(logins).To(Equal(1))
+	})
+
+	It("should log in again when the secret ID is rotated", func() {
+		_, _, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+
+		config.SecretID = "some-rotated-secret"
+
+		_, _, err = config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logins).To(Equal(2))
+	})
+
+	It("should trust the source's ca_certs", func() {
+		tlsServer := httptest.NewTLSServer(server.Config.Handler)
+		defer tlsServer.Close()
+
+		config.Address = tlsServer.URL
+
+		_, _, err := config.Credentials()
+		Expect(err).To(MatchError(ContainSubstring("certificate")))
+
+		source := resource.Source{
+			CACerts: []string{string(pem.EncodeToMemory(&pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: tlsServer.Certificate().Raw,
+			}))},
+		}
+
+		Expect(source.ConfigureTransport()).To(Succeed())
+		defer func() {
+			resource.BaseTransport.CloseIdleConnections()
+			resource.BaseTransport.TLSClientConfig = nil
+		}()
+
+		username, _, err := config.Credentials()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(username).To(Equal("user"))
+	})
+
+	It("should fail when the secret does not contain credentials", func() {
+		config.Path = "kv/registry"
+
+		_, _, err := config.Credentials()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,180 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// DecompressLayer returns the tar stream of a layer blob, which may be
+// gzip-compressed, zstd-compressed, or uncompressed. The compression is
+// detected from the blob's content rather than its media type, since not
+// every registry reports the +zstd media types.
+func DecompressLayer(blob io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(blob)
+
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		// decoding a stream at a time keeps memory use bounded
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+
+		return zr.IOReadCloser(), nil
+
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
+
+// isZstd determines whether the content read by open is zstd-compressed.
+func isZstd(open func() (io.ReadCloser, error)) (bool, error) {
+	r, err := open()
+	if err != nil {
+		return false, err
+	}
+
+	defer r.Close()
+
+	magic := make([]byte, len(zstdMagic))
+
+	_, err = io.ReadFull(r, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(magic, zstdMagic), nil
+}
+
+// ZstdLayers returns the diff IDs of the image's zstd-compressed layers.
+//
+// Docker archives have no way of recording a layer's media type, so a
+// zstd-compressed layer loaded from one looks like an uncompressed layer whose
+// content happens to be zstd-compressed.
+func ZstdLayers(image v1.Image) ([]v1.Hash, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffIDs []v1.Hash
+	for _, layer := range layers {
+		compressed, err := isZstd(layer.Uncompressed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer: %s", err)
+		}
+
+		if !compressed {
+			continue
+		}
+
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	return diffIDs, nil
+}
+
+// RecompressZstd returns the image with its zstd-compressed layers (see
+// ZstdLayers) decompressed, so that they are gzip-compressed when pushed.
+//
+// The image config is kept as is, as its diff IDs already refer to the
+// uncompressed layers.
+func RecompressZstd(image v1.Image) (v1.Image, error) {
+	return partial.UncompressedToImage(recompressedImage{image})
+}
+
+// recompressedImage implements partial.UncompressedImageCore.
+type recompressedImage struct {
+	image v1.Image
+}
+
+func (image recompressedImage) RawConfigFile() ([]byte, error) {
+	return image.image.RawConfigFile()
+}
+
+func (image recompressedImage) MediaType() (types.MediaType, error) {
+	return image.image.MediaType()
+}
+
+func (image recompressedImage) LayerByDiffID(diffID v1.Hash) (partial.UncompressedLayer, error) {
+	layer, err := image.image.LayerByDiffID(diffID)
+	if err != nil {
+		return nil, err
+	}
+
+	return recompressedLayer{layer}, nil
+}
+
+// recompressedLayer implements partial.UncompressedLayer.
+type recompressedLayer struct {
+	layer v1.Layer
+}
+
+func (layer recompressedLayer) DiffID() (v1.Hash, error) {
+	return layer.layer.DiffID()
+}
+
+func (layer recompressedLayer) Uncompressed() (io.ReadCloser, error) {
+	r, err := layer.layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := DecompressLayer(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return readCloser{tr, closers{tr, r}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// closers closes each of its io.Closers in order.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		err := c.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
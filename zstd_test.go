@@ -0,0 +1,153 @@
+package resource_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	resource "github.com/concourse/registry-image-resource"
+)
+
+// zstdImage is an image loaded from a docker archive whose only layer is
+// zstd-compressed.
+type zstdImage struct {
+	diffID v1.Hash
+	blob   []byte
+}
+
+func (image zstdImage) RawConfigFile() ([]byte, error) {
+	return json.Marshal(v1.ConfigFile{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS: v1.RootFS{
+			Type:    "layers",
+			DiffIDs: []v1.Hash{image.diffID},
+		},
+	})
+}
+
+func (image zstdImage) MediaType() (types.MediaType, error) {
+	return types.DockerManifestSchema2, nil
+}
+
+func (image zstdImage) LayerByDiffID(diffID v1.Hash) (partial.UncompressedLayer, error) {
+	return image, nil
+}
+
+func (image zstdImage) DiffID() (v1.Hash, error) {
+	return image.diffID, nil
+}
+
+func (image zstdImage) Uncompressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(image.blob)), nil
+}
+
+var _ = Describe("zstd layers", func() {
+	var layerTar []byte
+	var diffID v1.Hash
+
+	BeforeEach(func() {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		Expect(tw.WriteHeader(&tar.Header{Name: "some-file", Mode: 0644, Size: 5})).To(Succeed())
+		_, err := tw.Write([]byte("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tw.Close()).To(Succeed())
+
+		layerTar = buf.Bytes()
+
+		diffID, _, err = v1.SHA256(bytes.NewReader(layerTar))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	compress := func(content []byte) []byte {
+		buf := new(bytes.Buffer)
+		zw, err := zstd.NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = zw.Write(content)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(zw.Close()).To(Succeed())
+		return buf.Bytes()
+	}
+
+	Describe("DecompressLayer", func() {
+		decompress := func(blob []byte) []byte {
+			r, err := resource.DecompressLayer(bytes.NewReader(blob))
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Close()
+
+			content, err := ioutil.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			return content
+		}
+
+		It("should decompress zstd layers", func() {
+			Expect(decompress(compress(layerTar))).To(Equal(layerTar))
+		})
+
+		It("should decompress gzip layers", func() {
+			buf := new(bytes.Buffer)
+			gw := gzip.NewWriter(buf)
+			_, err := gw.Write(layerTar)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gw.Close()).To(Succeed())
+
+			Expect(decompress(buf.Bytes())).To(Equal(layerTar))
+		})
+
+		It("should pass uncompressed layers through", func() {
+			Expect(decompress(layerTar)).To(Equal(layerTar))
+		})
+	})
+
+	Describe("RecompressZstd", func() {
+		var image v1.Image
+
+		BeforeEach(func() {
+			var err error
+			image, err = partial.UncompressedToImage(zstdImage{
+				diffID: diffID,
+				blob:   compress(layerTar),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should find the zstd layers", func() {
+			Expect(resource.ZstdLayers(image)).To(Equal([]v1.Hash{diffID}))
+		})
+
+		It("should push the layers gzip-compressed", func() {
+			recompressed, err := resource.RecompressZstd(image)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resource.ZstdLayers(recompressed)).To(BeEmpty())
+
+			layers, err := recompressed.Layers()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(layers).To(HaveLen(1))
+
+			Expect(layers[0].DiffID()).To(Equal(diffID))
+
+			blob, err := layers[0].Compressed()
+			Expect(err).ToNot(HaveOccurred())
+			defer blob.Close()
+
+			gr, err := gzip.NewReader(blob)
+			Expect(err).ToNot(HaveOccurred())
+
+			content, err := ioutil.ReadAll(gr)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(Equal(layerTar))
+		})
+	})
+})